@@ -153,6 +153,10 @@ func (app *App) Start(fns ...func() error) error {
 		app.waitSignals()
 		app.status = Running
 		xlog.Infof("easy-ngo start success!")
+		//上线，执行服务注册等插件
+		if err := app.Online(); err != nil {
+			xlog.Errorf("easy-ngo online error[%s]", err.Error())
+		}
 		if err := <-app.cycle.Wait(); err != nil {
 			xlog.Errorf("easy-ngo shutdown with error[%s]", err.Error())
 			return
@@ -163,8 +167,7 @@ func (app *App) Start(fns ...func() error) error {
 }
 
 func (app *App) startPlugins() error {
-	app.smu.Lock()
-	defer app.smu.Unlock()
+	// server的Serve会一直阻塞，这里不能持有smu，否则waitSignals、Online等会被阻塞
 	var eg errgroup.Group
 	var ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
 	go func() {
@@ -173,6 +176,7 @@ func (app *App) startPlugins() error {
 	}()
 	fs := GetFns(Starting)
 	for _, f := range fs {
+		f := f
 		eg.Go(func() (err error) {
 			err = f(ctx)
 			return
@@ -191,8 +195,53 @@ func (app *App) waitSignals() {
 	})
 }
 
+// Online 执行上线插件（例如服务注册），之后App开始接收流量。Start成功后会自动调用
+func (app *App) Online() error {
+	app.smu.Lock()
+	defer app.smu.Unlock()
+	if app.status == Online {
+		return nil
+	}
+	if err := app.runFns(Online); err != nil {
+		return err
+	}
+	app.status = Online
+	return nil
+}
+
+// Offline 执行下线插件（例如服务注销），之后App不再接收新的流量，但不会停止server。
+// 可以在优雅下线或者摘流量时调用，Shutdown时会自动调用
+func (app *App) Offline() error {
+	app.smu.Lock()
+	defer app.smu.Unlock()
+	if app.status != Online {
+		return nil
+	}
+	if err := app.runFns(Offline); err != nil {
+		return err
+	}
+	app.status = Offline
+	return nil
+}
+
+func (app *App) runFns(status Status) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	fs := GetFns(status)
+	for i := range fs {
+		if err := fs[i](ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (app *App) Shutdown() (err error) {
 	app.stopOnce.Do(func() {
+		//先下线，避免停止过程中还有流量进入
+		if err := app.Offline(); err != nil {
+			xlog.Errorf("easy-ngo offline error[%s]", err.Error())
+		}
 		var eg errgroup.Group
 		var ctx, _ = context.WithTimeout(context.Background(), 3*time.Second)
 		app.stopped <- struct{}{}
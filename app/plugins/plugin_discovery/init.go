@@ -0,0 +1,80 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugindiscovery
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/NetEase-Media/easy-ngo/app"
+	pluginxzk "github.com/NetEase-Media/easy-ngo/app/plugins/plugin_xzk"
+	"github.com/NetEase-Media/easy-ngo/config"
+	"github.com/NetEase-Media/easy-ngo/discovery"
+	"github.com/NetEase-Media/easy-ngo/discovery/contrib/xgrpc"
+	"github.com/NetEase-Media/easy-ngo/registry/contrib/xzookeeper"
+)
+
+func init() {
+	app.RegisterPlugin(app.Initialize, Initialize)
+	app.RegisterPlugin(app.Stopping, Close)
+}
+
+func Initialize(ctx context.Context) error {
+	c := discovery.DefaultConfig()
+	if err := config.UnmarshalKey("discovery", c); err != nil {
+		return err
+	}
+	if !c.Enabled {
+		return nil
+	}
+	var d discovery.Discovery
+	switch c.Type {
+	case "zookeeper":
+		zc := xzookeeper.DefaultConfig()
+		if err := config.UnmarshalKey("discovery.zookeeper", zc); err != nil {
+			return err
+		}
+		cli := pluginxzk.GetZKClientByKey(zc.ZkName)
+		if cli == nil {
+			return fmt.Errorf("zk client %s not found", zc.ZkName)
+		}
+		d = xzookeeper.New(cli, zc)
+	case "static":
+		d = discovery.NewStatic(c.Static)
+	case "file":
+		f, err := discovery.NewFile(c.File, c.FileReloadInterval)
+		if err != nil {
+			return err
+		}
+		d = f
+	default:
+		return fmt.Errorf("unsupported discovery type %s", c.Type)
+	}
+	r, err := discovery.NewResolver(d, c)
+	if err != nil {
+		return err
+	}
+	discovery.WithResolver(r)
+	// grpc客户端可以直接使用ngo:///{服务名}作为target
+	xgrpc.Register(d)
+	return nil
+}
+
+func Close(ctx context.Context) error {
+	if r := discovery.GetResolver(); r != nil {
+		r.Close()
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pluginregistry
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/NetEase-Media/easy-ngo/app"
+	pluginxzk "github.com/NetEase-Media/easy-ngo/app/plugins/plugin_xzk"
+	"github.com/NetEase-Media/easy-ngo/config"
+	"github.com/NetEase-Media/easy-ngo/registry"
+	"github.com/NetEase-Media/easy-ngo/registry/contrib/xzookeeper"
+	"github.com/NetEase-Media/easy-ngo/server"
+	"github.com/NetEase-Media/easy-ngo/xlog"
+)
+
+var (
+	registryConfig *registry.Config
+	instances      []*registry.ServiceInstance
+)
+
+func init() {
+	app.RegisterPlugin(app.Initialize, Initialize)
+	app.RegisterPlugin(app.Online, Register)
+	app.RegisterPlugin(app.Offline, Deregister)
+}
+
+func Initialize(ctx context.Context) error {
+	c := registry.DefaultConfig()
+	if err := config.UnmarshalKey("registry", c); err != nil {
+		return err
+	}
+	registryConfig = c
+	if !c.Enabled {
+		return nil
+	}
+	if c.Name == "" {
+		return fmt.Errorf("registry service name can not be empty")
+	}
+	switch c.Type {
+	case "zookeeper":
+		zc := xzookeeper.DefaultConfig()
+		if err := config.UnmarshalKey("registry.zookeeper", zc); err != nil {
+			return err
+		}
+		cli := pluginxzk.GetZKClientByKey(zc.ZkName)
+		if cli == nil {
+			return fmt.Errorf("zk client %s not found", zc.ZkName)
+		}
+		registry.WithVendor(xzookeeper.New(cli, zc))
+	default:
+		return fmt.Errorf("unsupported registry type %s", c.Type)
+	}
+	return nil
+}
+
+// Register 将当前进程中所有server注册到注册中心
+func Register(ctx context.Context) error {
+	if registryConfig == nil || !registryConfig.Enabled {
+		return nil
+	}
+	ins, err := registry.Instances(registryConfig, server.Endpoints())
+	if err != nil {
+		return err
+	}
+	for _, i := range ins {
+		if err := registry.Register(ctx, i); err != nil {
+			return err
+		}
+		xlog.Infof("register service %s %s://%s", i.Name, i.Scheme, i.Endpoint())
+	}
+	instances = ins
+	return nil
+}
+
+// Deregister 注销已注册的实例
+func Deregister(ctx context.Context) error {
+	var lastErr error
+	for _, i := range instances {
+		if err := registry.Deregister(ctx, i); err != nil {
+			xlog.Errorf("deregister service %s %s failed: %v", i.Name, i.Endpoint(), err)
+			lastErr = err
+			continue
+		}
+		xlog.Infof("deregister service %s %s://%s", i.Name, i.Scheme, i.Endpoint())
+	}
+	instances = nil
+	return lastErr
+}
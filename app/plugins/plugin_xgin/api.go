@@ -63,3 +63,12 @@ func OPTIONS(relativePath string, handler gin.HandlerFunc) error {
 	s.Engine.OPTIONS(relativePath, handler)
 	return nil
 }
+
+func WebSocket(relativePath string, handler xgin.WebSocketHandler) error {
+	s.WebSocket(relativePath, handler)
+	return nil
+}
+
+func WebSocketHub() *xgin.WebSocketHub {
+	return s.WebSocketHub()
+}
@@ -0,0 +1,244 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcache
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/xlog"
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrNotFound 缓存和数据源中都不存在
+var ErrNotFound = errors.New("xcache: not found")
+
+// LoadFunc 缓存未命中时从数据源加载，数据不存在时返回ErrNotFound
+type LoadFunc func(ctx context.Context) (interface{}, error)
+
+// Cache 多级缓存
+type Cache interface {
+	// Get 依次读取每一级缓存，命中后回填上层缓存，并将值反序列化到value。未命中时返回ErrNotFound
+	Get(ctx context.Context, key string, value interface{}) error
+
+	// Set 写入所有层级的缓存
+	Set(ctx context.Context, key string, value interface{}) error
+
+	// Delete 删除所有层级的缓存
+	Delete(ctx context.Context, key string) error
+
+	// GetOrLoad 缓存未命中时调用loader加载并写入缓存，同一个key的并发加载只会执行一次
+	GetOrLoad(ctx context.Context, key string, value interface{}, loader LoadFunc) error
+}
+
+// Level 缓存的一级
+type Level struct {
+	Tier Tier
+
+	// 写入该层级的过期时间，为0时不过期
+	TTL time.Duration
+}
+
+// 写入tier的值带有一个字节的标记，区分正常值和空值
+const (
+	flagNegative byte = 0
+	flagValue    byte = 1
+)
+
+// MultiCache 由多个层级组成的缓存，层级按照从近到远的顺序排列，例如 本地LRU → redis
+type MultiCache struct {
+	config *Config
+	levels []Level
+	stats  []*tierStats
+	group  singleflight.Group
+}
+
+// New 创建多级缓存
+func New(config *Config, levels ...Level) (*MultiCache, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if err := checkConfig(config); err != nil {
+		return nil, err
+	}
+	if len(levels) == 0 {
+		return nil, errors.New("xcache: no cache levels")
+	}
+	c := &MultiCache{
+		config: config,
+		levels: levels,
+		stats:  make([]*tierStats, len(levels)),
+	}
+	for i, lvl := range levels {
+		if lvl.Tier == nil {
+			return nil, errors.New("xcache: nil cache tier")
+		}
+		c.stats[i] = newTierStats(config, lvl.Tier.Name())
+	}
+	return c, nil
+}
+
+func (c *MultiCache) Get(ctx context.Context, key string, value interface{}) error {
+	data, err := c.get(ctx, key)
+	if errors.Is(err, errMiss) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return c.decode(data, value)
+}
+
+func (c *MultiCache) Set(ctx context.Context, key string, value interface{}) error {
+	data, err := c.config.Serializer.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return c.set(ctx, key, append([]byte{flagValue}, data...), len(c.levels))
+}
+
+func (c *MultiCache) Delete(ctx context.Context, key string) error {
+	var firstErr error
+	// 从远到近删除，避免删除过程中近层被远层的旧值回填
+	for i := len(c.levels) - 1; i >= 0; i-- {
+		if err := c.levels[i].Tier.Delete(ctx, key); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (c *MultiCache) GetOrLoad(ctx context.Context, key string, value interface{}, loader LoadFunc) error {
+	data, err := c.get(ctx, key)
+	if err == nil {
+		return c.decode(data, value)
+	}
+	if !errors.Is(err, errMiss) {
+		return err
+	}
+
+	v, err, _ := c.group.Do(key, func() (interface{}, error) {
+		// 等待期间其他实例可能已经写入远层缓存
+		if data, err := c.get(ctx, key); err == nil || !errors.Is(err, errMiss) {
+			return data, err
+		}
+		v, err := loader(ctx)
+		if errors.Is(err, ErrNotFound) {
+			if c.config.NegativeTTL > 0 {
+				c.set(ctx, key, []byte{flagNegative}, len(c.levels))
+			}
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := c.config.Serializer.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		data = append([]byte{flagValue}, data...)
+		if err := c.set(ctx, key, data, len(c.levels)); err != nil {
+			xlog.Warnf("xcache: %s set %s failed: %v", c.config.Name, key, err)
+		}
+		return data, nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.decode(v.([]byte), value)
+}
+
+// errMiss 所有层级都未命中，和ErrNotFound（命中了空值）区分
+var errMiss = errors.New("xcache: miss")
+
+// get 依次读取每一级，返回带标记的值。tier出错时记录日志并继续读取下一级
+func (c *MultiCache) get(ctx context.Context, key string) ([]byte, error) {
+	for i, lvl := range c.levels {
+		data, err := lvl.Tier.Get(ctx, key)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				c.stats[i].miss()
+			} else {
+				c.stats[i].error()
+				xlog.Warnf("xcache: %s get %s from %s failed: %v", c.config.Name, key, lvl.Tier.Name(), err)
+			}
+			continue
+		}
+		if len(data) == 0 {
+			c.stats[i].miss()
+			continue
+		}
+		c.stats[i].hit()
+		if i > 0 {
+			c.set(ctx, key, data, i)
+		}
+		if data[0] == flagNegative {
+			return nil, ErrNotFound
+		}
+		return data, nil
+	}
+	return nil, errMiss
+}
+
+// set 写入前n级缓存，从远到近写入
+func (c *MultiCache) set(ctx context.Context, key string, data []byte, n int) error {
+	var firstErr error
+	for i := n - 1; i >= 0; i-- {
+		lvl := c.levels[i]
+		ttl := lvl.TTL
+		if data[0] == flagNegative {
+			if c.config.NegativeTTL <= 0 {
+				continue
+			}
+			if ttl <= 0 || c.config.NegativeTTL < ttl {
+				ttl = c.config.NegativeTTL
+			}
+		}
+		if err := lvl.Tier.Set(ctx, key, data, c.jitter(ttl)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (c *MultiCache) decode(data []byte, value interface{}) error {
+	if data[0] == flagNegative {
+		return ErrNotFound
+	}
+	return c.config.Serializer.Unmarshal(data[1:], value)
+}
+
+// jitter 在过期时间上增加随机抖动，避免大量key同时过期
+func (c *MultiCache) jitter(ttl time.Duration) time.Duration {
+	if ttl <= 0 || c.config.Jitter <= 0 {
+		return ttl
+	}
+	d := float64(ttl) * (1 + c.config.Jitter*(rand.Float64()*2-1))
+	if d < 1 {
+		d = 1
+	}
+	return time.Duration(d)
+}
+
+// Stats 返回每一级的命中统计
+func (c *MultiCache) Stats() []TierStats {
+	stats := make([]TierStats, len(c.stats))
+	for i, s := range c.stats {
+		stats[i] = s.snapshot()
+	}
+	return stats
+}
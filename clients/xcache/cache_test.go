@@ -0,0 +1,189 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/clients/xredis"
+	"github.com/NetEase-Media/easy-ngo/xlog"
+	"github.com/NetEase-Media/easy-ngo/xlog/contrib/xstdout"
+	miniredisv2 "github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type user struct {
+	ID   int
+	Name string
+}
+
+func newTestCache(t *testing.T, config *Config) (*MultiCache, *LocalTier, *miniredisv2.Miniredis) {
+	xlog.WithVendor(xstdout.New())
+	s := miniredisv2.RunT(t)
+	client := xredis.NewClient(&xredis.Config{Name: "cache", Addr: []string{s.Addr()}})
+	t.Cleanup(func() { client.Close() })
+	local := NewLocalTier(100)
+	c, err := New(config, Level{Tier: local, TTL: time.Minute}, Level{Tier: NewRedisTier(client), TTL: time.Hour})
+	assert.NoError(t, err)
+	return c, local, s
+}
+
+func TestMultiCache(t *testing.T) {
+	ctx := context.Background()
+	c, local, s := newTestCache(t, nil)
+
+	var u user
+	assert.Equal(t, ErrNotFound, c.Get(ctx, "u1", &u))
+	assert.NoError(t, c.Set(ctx, "u1", user{ID: 1, Name: "a"}))
+	assert.True(t, s.Exists("u1"))
+	ttl := s.TTL("u1")
+	assert.True(t, ttl >= 54*time.Minute && ttl <= 66*time.Minute)
+
+	// 本地缓存被清除后从redis读取并回填
+	local.Delete(ctx, "u1")
+	assert.NoError(t, c.Get(ctx, "u1", &u))
+	assert.Equal(t, user{ID: 1, Name: "a"}, u)
+	assert.Equal(t, 1, local.Len())
+
+	assert.NoError(t, c.Delete(ctx, "u1"))
+	assert.False(t, s.Exists("u1"))
+	assert.Equal(t, 0, local.Len())
+
+	stats := c.Stats()
+	assert.Equal(t, "local", stats[0].Tier)
+	assert.Equal(t, uint64(0), stats[0].Hits)
+	assert.Equal(t, uint64(1), stats[1].Hits)
+	assert.Equal(t, 0.5, stats[1].HitRatio())
+}
+
+func TestMultiCache_GetOrLoad(t *testing.T) {
+	ctx := context.Background()
+	c, _, s := newTestCache(t, nil)
+
+	var loads int32
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(50 * time.Millisecond)
+		return user{ID: 2, Name: "b"}, nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var u user
+			assert.NoError(t, c.GetOrLoad(ctx, "u2", &u, loader))
+			assert.Equal(t, user{ID: 2, Name: "b"}, u)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), loads)
+	assert.True(t, s.Exists("u2"))
+
+	var u user
+	assert.NoError(t, c.GetOrLoad(ctx, "u2", &u, loader))
+	assert.Equal(t, int32(1), loads)
+
+	// 加载失败时不写入缓存
+	boom := errors.New("boom")
+	err := c.GetOrLoad(ctx, "u3", &u, func(ctx context.Context) (interface{}, error) {
+		return nil, boom
+	})
+	assert.Equal(t, boom, err)
+	assert.False(t, s.Exists("u3"))
+}
+
+func TestMultiCache_Negative(t *testing.T) {
+	ctx := context.Background()
+	config := DefaultConfig()
+	config.NegativeTTL = 10 * time.Second
+	config.Jitter = 0
+	c, _, s := newTestCache(t, config)
+
+	var loads int32
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		return nil, ErrNotFound
+	}
+	var u user
+	assert.Equal(t, ErrNotFound, c.GetOrLoad(ctx, "missing", &u, loader))
+	assert.Equal(t, ErrNotFound, c.GetOrLoad(ctx, "missing", &u, loader))
+	assert.Equal(t, ErrNotFound, c.Get(ctx, "missing", &u))
+	assert.Equal(t, int32(1), loads)
+	assert.Equal(t, 10*time.Second, s.TTL("missing"))
+
+	config.NegativeTTL = 0
+	c, _, s = newTestCache(t, config)
+	assert.Equal(t, ErrNotFound, c.GetOrLoad(ctx, "missing", &u, loader))
+	assert.False(t, s.Exists("missing"))
+}
+
+func TestMultiCache_TierError(t *testing.T) {
+	ctx := context.Background()
+	c, local, s := newTestCache(t, nil)
+	assert.NoError(t, c.Set(ctx, "u1", user{ID: 1}))
+
+	// redis不可用时依然可以使用本地缓存和数据源
+	s.Close()
+	var u user
+	assert.NoError(t, c.Get(ctx, "u1", &u))
+	local.Delete(ctx, "u1")
+	assert.NoError(t, c.GetOrLoad(ctx, "u1", &u, func(ctx context.Context) (interface{}, error) {
+		return user{ID: 1, Name: "db"}, nil
+	}))
+	assert.Equal(t, "db", u.Name)
+	assert.True(t, c.Stats()[1].Errors > 0)
+}
+
+func TestLocalTier(t *testing.T) {
+	ctx := context.Background()
+	local := NewLocalTier(2)
+	local.Set(ctx, "a", []byte("1"), 0)
+	local.Set(ctx, "b", []byte("2"), 0)
+	local.Get(ctx, "a")
+	local.Set(ctx, "c", []byte("3"), 0)
+	_, err := local.Get(ctx, "b")
+	assert.Equal(t, ErrNotFound, err)
+	v, err := local.Get(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("1"), v)
+
+	local.Set(ctx, "d", []byte("4"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	_, err = local.Get(ctx, "d")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestSerializer(t *testing.T) {
+	for _, s := range []Serializer{JSONSerializer{}, GobSerializer{}} {
+		data, err := s.Marshal(user{ID: 1, Name: "a"})
+		assert.NoError(t, err)
+		var u user
+		assert.NoError(t, s.Unmarshal(data, &u))
+		assert.Equal(t, user{ID: 1, Name: "a"}, u)
+	}
+}
+
+func TestMemcacheExpire(t *testing.T) {
+	now := time.Unix(1000, 0)
+	assert.Equal(t, 1, memcacheExpire(time.Millisecond, now))
+	assert.Equal(t, 2, memcacheExpire(1500*time.Millisecond, now))
+	assert.Equal(t, 1000+31*24*3600, memcacheExpire(31*24*time.Hour, now))
+}
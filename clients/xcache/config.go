@@ -0,0 +1,58 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcache
+
+import (
+	"errors"
+	"time"
+)
+
+type Config struct {
+	// 缓存名称，用于日志和指标
+	Name string
+
+	// 值的序列化方式，默认JSON
+	Serializer Serializer
+
+	// 数据源中不存在的key的缓存时间，为0时不缓存空值
+	NegativeTTL time.Duration
+
+	// 过期时间的随机抖动比例（0-1），避免大量key同时过期
+	Jitter float64
+
+	EnableMetrics bool
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Name:        "default",
+		Serializer:  JSONSerializer{},
+		NegativeTTL: time.Minute,
+		Jitter:      0.1,
+	}
+}
+
+func checkConfig(config *Config) error {
+	if config.Name == "" {
+		return errors.New("xcache: empty cache name")
+	}
+	if config.Serializer == nil {
+		config.Serializer = JSONSerializer{}
+	}
+	if config.Jitter < 0 || config.Jitter >= 1 {
+		return errors.New("xcache: jitter must be in [0, 1)")
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcache
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/NetEase-Media/easy-ngo/xmetrics"
+)
+
+var (
+	metricTierRequests = "cache_tier_requests_total"
+
+	LABELCACHE  = "cache"
+	LABELTIER   = "tier"
+	LABELRESULT = "result"
+
+	metricsOnce  sync.Once
+	tierRequests xmetrics.Counter
+)
+
+const (
+	resultHit   = "hit"
+	resultMiss  = "miss"
+	resultError = "error"
+)
+
+// TierStats 一个层级的命中统计
+type TierStats struct {
+	Tier   string
+	Hits   uint64
+	Misses uint64
+	Errors uint64
+}
+
+// HitRatio 返回命中率，出错的请求记为未命中
+func (s TierStats) HitRatio() float64 {
+	total := s.Hits + s.Misses + s.Errors
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+type tierStats struct {
+	tier   string
+	hits   uint64
+	misses uint64
+	errors uint64
+
+	hitCounter   xmetrics.Counter
+	missCounter  xmetrics.Counter
+	errorCounter xmetrics.Counter
+}
+
+func newTierStats(config *Config, tier string) *tierStats {
+	s := &tierStats{tier: tier}
+	provider := xmetrics.GetProvider()
+	if !config.EnableMetrics || provider == nil {
+		return s
+	}
+	metricsOnce.Do(func() {
+		tierRequests = provider.NewCounter(metricTierRequests, LABELCACHE, LABELTIER, LABELRESULT)
+	})
+	s.hitCounter = tierRequests.With(LABELCACHE, config.Name, LABELTIER, tier, LABELRESULT, resultHit)
+	s.missCounter = tierRequests.With(LABELCACHE, config.Name, LABELTIER, tier, LABELRESULT, resultMiss)
+	s.errorCounter = tierRequests.With(LABELCACHE, config.Name, LABELTIER, tier, LABELRESULT, resultError)
+	return s
+}
+
+func (s *tierStats) hit() {
+	atomic.AddUint64(&s.hits, 1)
+	if s.hitCounter != nil {
+		s.hitCounter.Inc()
+	}
+}
+
+func (s *tierStats) miss() {
+	atomic.AddUint64(&s.misses, 1)
+	if s.missCounter != nil {
+		s.missCounter.Inc()
+	}
+}
+
+func (s *tierStats) error() {
+	atomic.AddUint64(&s.errors, 1)
+	if s.errorCounter != nil {
+		s.errorCounter.Inc()
+	}
+}
+
+func (s *tierStats) snapshot() TierStats {
+	return TierStats{
+		Tier:   s.tier,
+		Hits:   atomic.LoadUint64(&s.hits),
+		Misses: atomic.LoadUint64(&s.misses),
+		Errors: atomic.LoadUint64(&s.errors),
+	}
+}
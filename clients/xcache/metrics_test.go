@@ -0,0 +1,67 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/xmetrics"
+	"github.com/NetEase-Media/easy-ngo/xmetrics/contrib/xprometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func counterValue(labels map[string]string) float64 {
+	mfs, _ := prometheus.DefaultGatherer.Gather()
+	for _, mf := range mfs {
+		if mf.GetName() != metricTierRequests {
+			continue
+		}
+	next:
+		for _, m := range mf.GetMetric() {
+			for _, lp := range m.GetLabel() {
+				if v, ok := labels[lp.GetName()]; ok && v != lp.GetValue() {
+					continue next
+				}
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestMetrics(t *testing.T) {
+	xmetrics.WithVendor(xprometheus.NewProvider(xprometheus.DefaultConfig()))
+	defer xmetrics.WithVendor(nil)
+
+	ctx := context.Background()
+	config := DefaultConfig()
+	config.Name = fmt.Sprintf("metrics-%d", time.Now().UnixNano())
+	config.EnableMetrics = true
+	c, err := New(config, Level{Tier: NewLocalTier(10), TTL: time.Minute})
+	assert.NoError(t, err)
+
+	var v string
+	assert.Equal(t, ErrNotFound, c.Get(ctx, "k", &v))
+	assert.NoError(t, c.Set(ctx, "k", "v"))
+	assert.NoError(t, c.Get(ctx, "k", &v))
+	assert.NoError(t, c.Get(ctx, "k", &v))
+
+	assert.Equal(t, 2.0, counterValue(map[string]string{LABELCACHE: config.Name, LABELTIER: "local", LABELRESULT: resultHit}))
+	assert.Equal(t, 1.0, counterValue(map[string]string{LABELCACHE: config.Name, LABELTIER: "local", LABELRESULT: resultMiss}))
+}
@@ -0,0 +1,205 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/clients/xmemcache"
+	"github.com/NetEase-Media/easy-ngo/clients/xredis"
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/go-redis/redis/v8"
+)
+
+// Tier 缓存的一个层级，保存序列化后的值，未命中时返回ErrNotFound
+type Tier interface {
+	Name() string
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// LocalTier 进程内的LRU缓存
+type LocalTier struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type localEntry struct {
+	key      string
+	value    []byte
+	expireAt time.Time
+}
+
+// NewLocalTier 创建进程内缓存，maxEntries为最大条目数，超出后淘汰最久未使用的条目
+func NewLocalTier(maxEntries int) *LocalTier {
+	return &LocalTier{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+func (t *LocalTier) Name() string {
+	return "local"
+}
+
+func (t *LocalTier) Get(_ context.Context, key string) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	el, ok := t.entries[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	e := el.Value.(*localEntry)
+	if !e.expireAt.IsZero() && time.Now().After(e.expireAt) {
+		t.remove(el)
+		return nil, ErrNotFound
+	}
+	t.lru.MoveToFront(el)
+	return e.value, nil
+}
+
+func (t *LocalTier) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if el, ok := t.entries[key]; ok {
+		e := el.Value.(*localEntry)
+		e.value = value
+		e.expireAt = expireAt
+		t.lru.MoveToFront(el)
+		return nil
+	}
+	t.entries[key] = t.lru.PushFront(&localEntry{key: key, value: value, expireAt: expireAt})
+	for t.maxEntries > 0 && t.lru.Len() > t.maxEntries {
+		t.remove(t.lru.Back())
+	}
+	return nil
+}
+
+func (t *LocalTier) Delete(_ context.Context, key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if el, ok := t.entries[key]; ok {
+		t.remove(el)
+	}
+	return nil
+}
+
+// Len 返回缓存的条目数，包含已过期但还未清理的条目
+func (t *LocalTier) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lru.Len()
+}
+
+func (t *LocalTier) remove(el *list.Element) {
+	t.lru.Remove(el)
+	delete(t.entries, el.Value.(*localEntry).key)
+}
+
+// RedisTier 使用xredis作为缓存层级
+type RedisTier struct {
+	client xredis.Redis
+}
+
+func NewRedisTier(client xredis.Redis) *RedisTier {
+	return &RedisTier{client: client}
+}
+
+func (t *RedisTier) Name() string {
+	return "redis"
+}
+
+func (t *RedisTier) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := t.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (t *RedisTier) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return t.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (t *RedisTier) Delete(ctx context.Context, key string) error {
+	return t.client.Del(ctx, key).Err()
+}
+
+// MemcacheTier 使用xmemcache作为缓存层级
+type MemcacheTier struct {
+	client *xmemcache.MemcacheProxy
+}
+
+func NewMemcacheTier(client *xmemcache.MemcacheProxy) *MemcacheTier {
+	return &MemcacheTier{client: client}
+}
+
+func (t *MemcacheTier) Name() string {
+	return "memcache"
+}
+
+func (t *MemcacheTier) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := t.client.Get(ctx, key)
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data), nil
+}
+
+func (t *MemcacheTier) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return t.client.Set(ctx, key, string(value))
+	}
+	return t.client.SetWithExpire(ctx, key, string(value), memcacheExpire(ttl, time.Now()))
+}
+
+func (t *MemcacheTier) Delete(ctx context.Context, key string) error {
+	err := t.client.Delete(ctx, key)
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil
+	}
+	return err
+}
+
+// memcacheMaxRelativeExpire memcache的过期时间超过30天时被当作unix时间戳
+const memcacheMaxRelativeExpire = 30 * 24 * time.Hour
+
+// memcacheExpire 将过期时间转换为memcache的秒数，不足1秒按1秒计算
+func memcacheExpire(ttl time.Duration, now time.Time) int {
+	if ttl > memcacheMaxRelativeExpire {
+		return int(now.Add(ttl).Unix())
+	}
+	seconds := int((ttl + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
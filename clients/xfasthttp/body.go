@@ -0,0 +1,233 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ProgressFunc 传输进度回调，transferred为已传输的字节数，total未知时为-1
+type ProgressFunc func(transferred, total int64)
+
+type formFile struct {
+	field    string
+	filename string
+	reader   io.Reader
+	path     string
+}
+
+// AddFormFile 增加一个上传文件，请求体会以multipart/form-data格式流式发送，
+// 使用AddWWWForm或SetWWWForm写入的数据作为普通字段一起发送
+func (df *DataFlow) AddFormFile(field, filename string, r io.Reader) *DataFlow {
+	df.files = append(df.files, formFile{field: field, filename: filename, reader: r})
+	return df
+}
+
+// AddFormFilePath 增加一个上传文件，文件在发送时才会打开
+func (df *DataFlow) AddFormFilePath(field, path string) *DataFlow {
+	df.files = append(df.files, formFile{field: field, filename: filepath.Base(path), path: path})
+	return df
+}
+
+// SetBodyStream 从r中流式读取请求体，size未知时传-1，此时使用chunked编码发送。
+// 流式请求体只能读取一次，所以不会重试
+func (df *DataFlow) SetBodyStream(r io.Reader, size int) *DataFlow {
+	df.bodyStream = r
+	df.bodySize = size
+	return df
+}
+
+// BindWriter 将回复的body流式写入w，不会把整个body读入内存，适用于下载大文件。
+// 与其他Bind方法同时使用时只写入w
+func (df *DataFlow) BindWriter(w io.Writer) *DataFlow {
+	df.bodyWriter = w
+	return df
+}
+
+// UploadProgress 注册上传进度回调
+func (df *DataFlow) UploadProgress(f ProgressFunc) *DataFlow {
+	df.uploadProgress = f
+	return df
+}
+
+// DownloadProgress 注册下载进度回调，需要与BindWriter一起使用
+func (df *DataFlow) DownloadProgress(f ProgressFunc) *DataFlow {
+	df.downloadProgress = f
+	return df
+}
+
+// processBody 设置multipart和流式请求体，并按需统计上传进度
+func (df *DataFlow) processBody() {
+	r, size := df.bodyStream, df.bodySize
+	if len(df.files) > 0 {
+		r, size = df.multipart(), -1
+	}
+	if r == nil {
+		if df.uploadProgress == nil {
+			return
+		}
+		// 普通请求体也转换为流，才能统计上传进度
+		body := append([]byte(nil), df.req.Body()...)
+		r, size = bytes.NewReader(body), len(body)
+	}
+	if df.uploadProgress != nil {
+		r = &progressReader{r: r, total: int64(size), progress: df.uploadProgress}
+	}
+	df.req.SetBodyStream(r, size)
+}
+
+// multipart 通过管道将表单字段和文件以multipart格式写入请求体
+func (df *DataFlow) multipart() io.Reader {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	df.req.Header.SetMultipartFormBoundary(mw.Boundary())
+
+	fields, files := df.wwwForm, df.files
+	go func() {
+		pw.CloseWithError(writeMultipart(mw, fields, files))
+	}()
+	return pr
+}
+
+func writeMultipart(mw *multipart.Writer, fields WWWForm, files []formFile) error {
+	for k, values := range fields {
+		for _, v := range values {
+			if err := mw.WriteField(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	for _, f := range files {
+		if err := writeFormFile(mw, f); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+func writeFormFile(mw *multipart.Writer, f formFile) error {
+	r := f.reader
+	if f.path != "" {
+		file, err := os.Open(f.path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	w, err := mw.CreateFormFile(f.field, f.filename)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// streamBody 日志中流式body的占位符，读取流式body会消耗掉数据，所以不打印
+const streamBody = "(stream)"
+
+// requestBody 返回用于打印日志的请求体
+func requestBody(req *fasthttp.Request) string {
+	if req.IsBodyStream() {
+		return streamBody
+	}
+	return string(req.Body())
+}
+
+// responseBody 返回用于打印日志的回复body
+func responseBody(res *fasthttp.Response) string {
+	if res.IsBodyStream() {
+		return streamBody
+	}
+	return string(res.Body())
+}
+
+type progressReader struct {
+	r        io.Reader
+	n        int64
+	total    int64
+	progress ProgressFunc
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.progress(atomic.AddInt64(&p.n, int64(n)), p.total)
+	}
+	return n, err
+}
+
+// Close 请求发送完成或取消时由fasthttp调用，关闭管道可以让写入multipart的协程退出
+func (p *progressReader) Close() error {
+	if c, ok := p.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// contextWriter 在ctx结束时中止写入，用于在下载过程中响应取消
+type contextWriter struct {
+	ctx      context.Context
+	w        io.Writer
+	n        int64
+	total    int64
+	progress ProgressFunc
+}
+
+func (w *contextWriter) Write(b []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := w.w.Write(b)
+	w.n += int64(n)
+	if w.progress != nil {
+		w.progress(w.n, w.total)
+	}
+	return n, err
+}
+
+// writeBody 将回复的body流式写入绑定的writer
+func (df *DataFlow) writeBody(ctx context.Context, res *fasthttp.Response) error {
+	w := &contextWriter{
+		ctx:      ctx,
+		w:        df.bodyWriter,
+		total:    int64(res.Header.ContentLength()),
+		progress: df.downloadProgress,
+	}
+	if w.total < 0 {
+		w.total = -1
+	}
+	encoding := res.Header.ContentEncoding()
+	if !df.decompress || len(encoding) == 0 {
+		return res.BodyWriteTo(w)
+	}
+
+	// 解压后的长度未知
+	w.total = -1
+	r, err := decompressReader(string(encoding), responseBodyReader(res))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
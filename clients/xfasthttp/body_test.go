@@ -0,0 +1,167 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataFlowMultipart(t *testing.T) {
+	var field, file1, file2, name2 string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		field = r.FormValue("name")
+		f, _, _ := r.FormFile("file1")
+		b, _ := io.ReadAll(f)
+		file1 = string(b)
+		f, h, _ := r.FormFile("file2")
+		b, _ = io.ReadAll(f)
+		file2, name2 = string(b), h.Filename
+	}))
+	defer s.Close()
+
+	path := filepath.Join(t.TempDir(), "upload.txt")
+	assert.Nil(t, os.WriteFile(path, []byte("from disk"), 0644))
+
+	var uploaded int64
+	code, err := newTestHttpClient().Post(s.URL).AddWWWForm("name", "ngo").
+		AddFormFile("file1", "a.txt", strings.NewReader("from reader")).
+		AddFormFilePath("file2", path).
+		UploadProgress(func(transferred, total int64) {
+			assert.Equal(t, int64(-1), total)
+			atomic.StoreInt64(&uploaded, transferred)
+		}).
+		Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ngo", field)
+	assert.Equal(t, "from reader", file1)
+	assert.Equal(t, "from disk", file2)
+	assert.Equal(t, "upload.txt", name2)
+	assert.True(t, atomic.LoadInt64(&uploaded) > 0)
+}
+
+func TestDataFlowMultipartMissingFile(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	_, err := newTestHttpClient().Post(s.URL).AddFormFilePath("file", "/not/exist").Do(context.Background())
+	assert.NotNil(t, err)
+}
+
+func TestDataFlowBodyStream(t *testing.T) {
+	var hits int32
+	var body []byte
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+
+	var progress []int64
+	code, err := newTestHttpClient().Put(s.URL).SetBodyStream(strings.NewReader("streaming body"), -1).
+		UploadProgress(func(transferred, total int64) {
+			progress = append(progress, transferred)
+		}).
+		Retry(DefaultRetryPolicy()).
+		Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "streaming body", string(body))
+	// 流式请求体不重试
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+	assert.Equal(t, int64(len("streaming body")), progress[len(progress)-1])
+}
+
+func TestDataFlowUploadProgressBytes(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer s.Close()
+
+	var transferred, total int64
+	_, err := newTestHttpClient().Post(s.URL).SetBody([]byte("0123456789")).UploadProgress(func(n, t int64) {
+		transferred, total = n, t
+	}).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), transferred)
+	assert.Equal(t, int64(10), total)
+}
+
+func TestDataFlowBindWriter(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1<<17)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+	}))
+	defer s.Close()
+
+	var buf bytes.Buffer
+	var transferred, total int64
+	code, err := newTestHttpClient().Get(s.URL).BindWriter(&buf).DownloadProgress(func(n, t int64) {
+		transferred, total = n, t
+	}).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, data, buf.Bytes())
+	assert.Equal(t, int64(len(data)), transferred)
+	assert.Equal(t, int64(len(data)), total)
+}
+
+// cancelWriter 第一次写入后取消ctx
+type cancelWriter struct {
+	cancel context.CancelFunc
+	n      int
+}
+
+func (w *cancelWriter) Write(b []byte) (int, error) {
+	w.n += len(b)
+	w.cancel()
+	return len(b), nil
+}
+
+func TestDataFlowBindWriterCancel(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 100; i++ {
+			w.Write(bytes.Repeat([]byte("x"), 1024))
+			w.(http.Flusher).Flush()
+			time.Sleep(time.Millisecond)
+		}
+	}))
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &cancelWriter{cancel: cancel}
+	_, err := newTestHttpClient().Get(s.URL).BindWriter(w).Do(ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.Less(t, w.n, 100*1024)
+}
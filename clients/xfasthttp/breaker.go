@@ -0,0 +1,316 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/xlog"
+	"github.com/NetEase-Media/easy-ngo/xmetrics"
+)
+
+var (
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+)
+
+var (
+	metricBreakerState       = "http_client_circuit_breaker_state"
+	metricBreakerTransitions = "http_client_circuit_breaker_transitions_total"
+
+	breakerMetricsOnce sync.Once
+	breakerState       xmetrics.Gauge
+	breakerTransitions xmetrics.Counter
+)
+
+// CircuitBreakerConfig 熔断配置。窗口内请求数达到MinRequests后，错误率或慢调用比例超过阈值则打开熔断，
+// 经过OpenTimeout后进入半开状态，放行HalfOpenRequests个探测请求，全部成功则关闭熔断，否则重新打开
+type CircuitBreakerConfig struct {
+	// 是否开启熔断
+	Enabled bool
+
+	// 按host+path熔断，默认按host熔断。path使用和监控相同的规则归一化，避免带有id的path产生过多的熔断器
+	PerRoute bool
+
+	// 滑动窗口时长
+	Window time.Duration
+
+	// 滑动窗口的桶数，越多统计越平滑
+	Buckets int
+
+	// 窗口内的最少请求数，少于该值时不熔断
+	MinRequests int
+
+	// 错误率阈值（0-1），连接错误和5XX记为错误
+	ErrorRateThreshold float64
+
+	// 慢调用阈值，为0时不统计慢调用
+	SlowCallDuration time.Duration
+
+	// 慢调用比例阈值（0-1）
+	SlowCallRateThreshold float64
+
+	// 熔断打开后等待多久进入半开状态
+	OpenTimeout time.Duration
+
+	// 半开状态放行的探测请求数，小于等于0时使用默认值
+	HalfOpenRequests int
+}
+
+func DefaultCircuitBreakerConfig() CircuitBreakerConfig {
+	return CircuitBreakerConfig{
+		Window:                10 * time.Second,
+		Buckets:               10,
+		MinRequests:           20,
+		ErrorRateThreshold:    0.5,
+		SlowCallRateThreshold: 0.8,
+		OpenTimeout:           5 * time.Second,
+		HalfOpenRequests:      5,
+	}
+}
+
+type BreakerState int32
+
+const (
+	StateClosed BreakerState = iota
+	StateOpen
+	StateHalfOpen
+)
+
+var (
+	breakerStateNames = map[BreakerState]string{
+		StateClosed:   "closed",
+		StateOpen:     "open",
+		StateHalfOpen: "half_open",
+	}
+)
+
+func (s BreakerState) String() string {
+	if name := breakerStateNames[s]; name != "" {
+		return name
+	}
+	return "unknown"
+}
+
+type bucket struct {
+	start    time.Time
+	total    int
+	failures int
+	slow     int
+}
+
+// CircuitBreaker 是一个基于滑动窗口统计的熔断器，可以并发使用
+type CircuitBreaker struct {
+	name   string
+	config *CircuitBreakerConfig
+
+	mu       sync.Mutex
+	state    BreakerState
+	openedAt time.Time
+	buckets  []bucket
+	// 半开状态下已放行和已成功的探测请求数
+	probes    int
+	successes int
+}
+
+func NewCircuitBreaker(name string, config *CircuitBreakerConfig) *CircuitBreaker {
+	c := *config
+	def := DefaultCircuitBreakerConfig()
+	if c.Window <= 0 {
+		c.Window = def.Window
+	}
+	// 为0时半开状态不放行任何请求，熔断器无法恢复
+	if c.HalfOpenRequests <= 0 {
+		c.HalfOpenRequests = def.HalfOpenRequests
+	}
+	n := c.Buckets
+	// 保证每个桶的时长大于0
+	if n <= 0 || c.Window < time.Duration(n) {
+		n = 1
+	}
+	cb := &CircuitBreaker{
+		name:    name,
+		config:  &c,
+		buckets: make([]bucket, n),
+	}
+	if breakerState != nil {
+		breakerState.With("name", name).Set(float64(StateClosed))
+	}
+	return cb
+}
+
+// State 返回熔断器当前的状态
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.tryHalfOpen(time.Now())
+	return cb.state
+}
+
+// Allow 判断请求是否可以通过，熔断打开时返回ErrCircuitOpen。通过后必须调用Report
+func (cb *CircuitBreaker) Allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.tryHalfOpen(time.Now())
+	switch cb.state {
+	case StateOpen:
+		return ErrCircuitOpen
+	case StateHalfOpen:
+		if cb.probes >= cb.config.HalfOpenRequests {
+			return ErrCircuitOpen
+		}
+		cb.probes++
+	}
+	return nil
+}
+
+// Release 请求被取消时代替Report调用，不统计结果，只归还半开状态下占用的探测名额
+func (cb *CircuitBreaker) Release() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == StateHalfOpen && cb.probes > cb.successes {
+		cb.probes--
+	}
+}
+
+// Report 上报请求结果
+func (cb *CircuitBreaker) Report(failed bool, duration time.Duration) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	now := time.Now()
+	switch cb.state {
+	case StateHalfOpen:
+		// 慢调用的探测请求同样记为失败
+		if failed || (cb.config.SlowCallDuration > 0 && duration >= cb.config.SlowCallDuration) {
+			cb.transit(StateOpen, now)
+			return
+		}
+		cb.successes++
+		if cb.successes >= cb.config.HalfOpenRequests {
+			cb.transit(StateClosed, now)
+		}
+		return
+	case StateOpen:
+		// 熔断打开前放行的请求，结果不再统计
+		return
+	}
+
+	b := cb.current(now)
+	b.total++
+	if failed {
+		b.failures++
+	}
+	slow := cb.config.SlowCallDuration > 0 && duration >= cb.config.SlowCallDuration
+	if slow {
+		b.slow++
+	}
+	if !failed && !slow {
+		return
+	}
+	total, failures, slows := cb.sum(now)
+	if total < cb.config.MinRequests {
+		return
+	}
+	if float64(failures)/float64(total) >= cb.config.ErrorRateThreshold ||
+		(cb.config.SlowCallDuration > 0 && float64(slows)/float64(total) >= cb.config.SlowCallRateThreshold) {
+		cb.transit(StateOpen, now)
+	}
+}
+
+func (cb *CircuitBreaker) bucketDuration() time.Duration {
+	return cb.config.Window / time.Duration(len(cb.buckets))
+}
+
+// current 返回当前时间所在的桶，过期的桶会被重置
+func (cb *CircuitBreaker) current(now time.Time) *bucket {
+	d := cb.bucketDuration()
+	start := now.Truncate(d)
+	b := &cb.buckets[int(start.UnixNano()/int64(d))%len(cb.buckets)]
+	if !b.start.Equal(start) {
+		*b = bucket{start: start}
+	}
+	return b
+}
+
+func (cb *CircuitBreaker) sum(now time.Time) (total, failures, slow int) {
+	for _, b := range cb.buckets {
+		if now.Sub(b.start) >= cb.config.Window {
+			continue
+		}
+		total += b.total
+		failures += b.failures
+		slow += b.slow
+	}
+	return
+}
+
+func (cb *CircuitBreaker) tryHalfOpen(now time.Time) {
+	if cb.state == StateOpen && now.Sub(cb.openedAt) >= cb.config.OpenTimeout {
+		cb.transit(StateHalfOpen, now)
+	}
+}
+
+func (cb *CircuitBreaker) transit(to BreakerState, now time.Time) {
+	from := cb.state
+	cb.state = to
+	cb.probes = 0
+	cb.successes = 0
+	switch to {
+	case StateOpen:
+		cb.openedAt = now
+	case StateClosed:
+		for i := range cb.buckets {
+			cb.buckets[i] = bucket{}
+		}
+	}
+	xlog.Warnf("circuit breaker %s changed from %s to %s", cb.name, from, to)
+	if breakerState != nil {
+		breakerState.With("name", cb.name).Set(float64(to))
+		breakerTransitions.With("name", cb.name, "from", from.String(), "to", to.String()).Inc()
+	}
+}
+
+// circuitBreakers 按host或路由管理熔断器
+type circuitBreakers struct {
+	config    *CircuitBreakerConfig
+	normalize func(path string) string
+	breakers  sync.Map
+}
+
+func newCircuitBreakers(config *CircuitBreakerConfig, normalize func(path string) string) *circuitBreakers {
+	if !config.Enabled {
+		return nil
+	}
+	if provider := xmetrics.GetProvider(); provider != nil {
+		breakerMetricsOnce.Do(func() {
+			breakerState = provider.NewGauge(metricBreakerState, "name")
+			breakerTransitions = provider.NewCounter(metricBreakerTransitions, "name", "from", "to")
+		})
+	}
+	return &circuitBreakers{config: config, normalize: normalize}
+}
+
+func (cbs *circuitBreakers) get(host, path string) *CircuitBreaker {
+	name := host
+	if cbs.config.PerRoute {
+		name = host + cbs.normalize(path)
+	}
+	if cb, ok := cbs.breakers.Load(name); ok {
+		return cb.(*CircuitBreaker)
+	}
+	cb, _ := cbs.breakers.LoadOrStore(name, NewCircuitBreaker(name, cbs.config))
+	return cb.(*CircuitBreaker)
+}
@@ -0,0 +1,226 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBreakerConfig() *CircuitBreakerConfig {
+	c := DefaultCircuitBreakerConfig()
+	c.Enabled = true
+	c.MinRequests = 4
+	c.OpenTimeout = 50 * time.Millisecond
+	c.HalfOpenRequests = 2
+	return &c
+}
+
+func TestCircuitBreakerErrorRate(t *testing.T) {
+	cb := NewCircuitBreaker("test", newTestBreakerConfig())
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, cb.Allow())
+		cb.Report(true, time.Millisecond)
+	}
+	// 未达到最少请求数，不熔断
+	assert.Equal(t, StateClosed, cb.State())
+	assert.Nil(t, cb.Allow())
+	cb.Report(false, time.Millisecond)
+	assert.Nil(t, cb.Allow())
+	cb.Report(true, time.Millisecond)
+	assert.Equal(t, StateOpen, cb.State())
+	assert.Equal(t, ErrCircuitOpen, cb.Allow())
+
+	// 半开状态只放行有限的探测请求，失败后重新打开
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Nil(t, cb.Allow())
+	assert.Nil(t, cb.Allow())
+	assert.Equal(t, ErrCircuitOpen, cb.Allow())
+	cb.Report(true, time.Millisecond)
+	assert.Equal(t, StateOpen, cb.State())
+
+	// 探测请求全部成功后关闭
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, cb.Allow())
+		cb.Report(false, time.Millisecond)
+	}
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestCircuitBreakerSlowCall(t *testing.T) {
+	c := newTestBreakerConfig()
+	c.SlowCallDuration = 100 * time.Millisecond
+	c.SlowCallRateThreshold = 0.5
+	cb := NewCircuitBreaker("slow", c)
+	for i := 0; i < 2; i++ {
+		cb.Report(false, time.Millisecond)
+		cb.Report(false, time.Second)
+	}
+	assert.Equal(t, StateOpen, cb.State())
+}
+
+func TestCircuitBreakerWindowExpire(t *testing.T) {
+	c := newTestBreakerConfig()
+	c.Window = 40 * time.Millisecond
+	c.Buckets = 4
+	cb := NewCircuitBreaker("window", c)
+	for i := 0; i < 3; i++ {
+		cb.Report(true, time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	cb.Report(true, time.Millisecond)
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestDataFlowCircuitBreak(t *testing.T) {
+	var hits int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer s.Close()
+
+	c := DefaultConfig()
+	c.CircuitBreaker = *newTestBreakerConfig()
+	client, _ := New(c)
+	for i := 0; i < 4; i++ {
+		code, err := client.Get(s.URL).Do(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusInternalServerError, code)
+	}
+
+	_, err := client.Get(s.URL).Do(context.Background())
+	assert.Equal(t, ErrCircuitOpen, err)
+	fallback := false
+	_, err = client.Get(s.URL).CircuitBreak(func() error {
+		fallback = true
+		return nil
+	}).Do(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, fallback)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&hits))
+}
+
+func TestCircuitBreakerRelease(t *testing.T) {
+	cb := NewCircuitBreaker("release", newTestBreakerConfig())
+	for i := 0; i < 4; i++ {
+		cb.Report(true, time.Millisecond)
+	}
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, StateHalfOpen, cb.State())
+
+	// 被取消的探测请求不计入结果，并归还探测名额
+	assert.Nil(t, cb.Allow())
+	assert.Nil(t, cb.Allow())
+	cb.Release()
+	cb.Release()
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Nil(t, cb.Allow())
+	assert.Nil(t, cb.Allow())
+	assert.Equal(t, ErrCircuitOpen, cb.Allow())
+}
+
+func TestDataFlowCircuitBreakCanceledProbe(t *testing.T) {
+	var slow int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&slow) == 1 {
+			time.Sleep(200 * time.Millisecond)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer s.Close()
+
+	c := DefaultConfig()
+	c.CircuitBreaker = *newTestBreakerConfig()
+	client, _ := New(c)
+	for i := 0; i < 4; i++ {
+		_, _ = client.Get(s.URL).Do(context.Background())
+	}
+	_, err := client.Get(s.URL).Do(context.Background())
+	assert.Equal(t, ErrCircuitOpen, err)
+
+	// 半开状态的探测请求被取消，熔断器保持半开，不会被关闭
+	time.Sleep(60 * time.Millisecond)
+	atomic.StoreInt32(&slow, 1)
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		_, err = client.Get(s.URL).Do(ctx)
+		assert.ErrorIs(t, err, context.Canceled)
+	}
+	cb := client.breakers.get(s.Listener.Addr().String(), "/")
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Nil(t, cb.Allow())
+}
+
+func TestCircuitBreakerInvalidWindow(t *testing.T) {
+	for _, window := range []time.Duration{0, 5} {
+		c := newTestBreakerConfig()
+		c.Window = window
+		cb := NewCircuitBreaker("window", c)
+		assert.NotPanics(t, func() {
+			cb.Report(true, time.Millisecond)
+		})
+	}
+}
+
+func TestCircuitBreakerHalfOpenSlowCall(t *testing.T) {
+	c := newTestBreakerConfig()
+	c.SlowCallDuration = 100 * time.Millisecond
+	cb := NewCircuitBreaker("half-open-slow", c)
+	for i := 0; i < 4; i++ {
+		cb.Report(true, time.Millisecond)
+	}
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, StateHalfOpen, cb.State())
+	assert.Nil(t, cb.Allow())
+	cb.Report(false, time.Second)
+	assert.Equal(t, StateOpen, cb.State())
+}
+
+func TestCircuitBreakerInvalidHalfOpenRequests(t *testing.T) {
+	c := newTestBreakerConfig()
+	c.HalfOpenRequests = 0
+	cb := NewCircuitBreaker("half-open-requests", c)
+	for i := 0; i < 4; i++ {
+		cb.Report(true, time.Millisecond)
+	}
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < DefaultCircuitBreakerConfig().HalfOpenRequests; i++ {
+		assert.Nil(t, cb.Allow())
+		cb.Report(false, time.Millisecond)
+	}
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestCircuitBreakersPerRoute(t *testing.T) {
+	c := newTestBreakerConfig()
+	c.PerRoute = true
+	m := DefaultMetricsConfig()
+	cbs := newCircuitBreakers(c, m.normalizePath)
+	cb := cbs.get("example.com", "/users/1")
+	assert.Same(t, cb, cbs.get("example.com", "/users/2"))
+	assert.Equal(t, "example.com/users/{id}", cb.name)
+	assert.NotSame(t, cb, cbs.get("example.com", "/orders/1"))
+}
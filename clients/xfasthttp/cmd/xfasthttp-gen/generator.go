@@ -0,0 +1,207 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	xfasthttpImport = "github.com/NetEase-Media/easy-ngo/clients/xfasthttp"
+)
+
+// 生成代码中使用的局部变量名，参数不能与之重名
+var reserved = map[string]bool{
+	"c":   true,
+	"df":  true,
+	"out": true,
+	"err": true,
+}
+
+func generate(pkg *pkgInfo, services []*service) ([]byte, error) {
+	var body bytes.Buffer
+	imports := map[string]string{
+		"strings":   "strings",
+		"xfasthttp": xfasthttpImport,
+	}
+	for name, path := range pkg.imports {
+		imports[name] = path
+	}
+	for _, svc := range services {
+		if err := generateService(&body, svc, imports); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by xfasthttp-gen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", pkg.Name)
+	paths := make([]string, 0, len(imports))
+	aliases := make(map[string]string)
+	for name, path := range imports {
+		paths = append(paths, path)
+		aliases[path] = name
+	}
+	// 标准库在前，其他包在后
+	sort.Slice(paths, func(i, j int) bool {
+		si, sj := isStdlib(paths[i]), isStdlib(paths[j])
+		if si != sj {
+			return si
+		}
+		return paths[i] < paths[j]
+	})
+	buf.WriteString("import (\n")
+	for i, path := range paths {
+		if i > 0 && isStdlib(paths[i-1]) && !isStdlib(path) {
+			buf.WriteString("\n")
+		}
+		if name := aliases[path]; name != defaultImportName(path) {
+			fmt.Fprintf(&buf, "\t%s %s\n", name, strconv.Quote(path))
+			continue
+		}
+		fmt.Fprintf(&buf, "\t%s\n", strconv.Quote(path))
+	}
+	buf.WriteString(")\n\n")
+	buf.Write(body.Bytes())
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code failed: %w\n%s", err, buf.String())
+	}
+	return src, nil
+}
+
+func isStdlib(path string) bool {
+	return !strings.Contains(strings.SplitN(path, "/", 2)[0], ".")
+}
+
+func defaultImportName(path string) string {
+	base := versionSuffixReg.ReplaceAllString(path, "")
+	for i := len(base) - 1; i >= 0; i-- {
+		if base[i] == '/' {
+			return base[i+1:]
+		}
+	}
+	return base
+}
+
+func generateService(w *bytes.Buffer, svc *service, imports map[string]string) error {
+	client := svc.Name + "Client"
+	fmt.Fprintf(w, "// %s 是%s基于xfasthttp的实现\n", client, svc.Name)
+	fmt.Fprintf(w, "type %s struct {\n\tclient *xfasthttp.Xfasthttp\n\tbaseURL string\n}\n\n", client)
+	fmt.Fprintf(w, "var _ %s = (*%s)(nil)\n\n", svc.Name, client)
+	fmt.Fprintf(w, "// New%s 创建%s的客户端，baseURL可以是http地址或者ngo://service形式的服务发现地址\n", client, svc.Name)
+	fmt.Fprintf(w, "func New%s(client *xfasthttp.Xfasthttp, baseURL string) *%s {\n", client, client)
+	fmt.Fprintf(w, "\treturn &%s{client: client, baseURL: strings.TrimSuffix(baseURL, \"/\")}\n}\n\n", client)
+
+	for _, m := range svc.Methods {
+		if err := generateMethod(w, client, m, imports); err != nil {
+			return fmt.Errorf("%s.%s: %w", svc.Name, m.Name, err)
+		}
+	}
+	return nil
+}
+
+func generateMethod(w *bytes.Buffer, client string, m *method, imports map[string]string) error {
+	ctx := "context.Background()"
+	var sig bytes.Buffer
+	for i, pa := range m.Params {
+		if reserved[pa.Name] {
+			return fmt.Errorf("parameter name %s is reserved", pa.Name)
+		}
+		if i > 0 {
+			sig.WriteString(", ")
+		}
+		fmt.Fprintf(&sig, "%s %s", pa.Name, pa.Type)
+		if pa.Kind == kindContext {
+			ctx = pa.Name
+		}
+	}
+	if ctx == "context.Background()" {
+		imports["context"] = "context"
+	}
+	result := "error"
+	if m.Result != "" {
+		result = "(" + m.Result + ", error)"
+	}
+
+	for _, line := range m.Doc {
+		if line == "" {
+			w.WriteString("//\n")
+			continue
+		}
+		fmt.Fprintf(w, "// %s\n", line)
+	}
+	fmt.Fprintf(w, "func (c *%s) %s(%s) %s {\n", client, m.Name, sig.String(), result)
+	fmt.Fprintf(w, "\tdf := c.client.%s(c.baseURL + %s)\n", m.HTTPMethod, pathExpr(m.Path))
+	for _, pa := range m.Params {
+		switch pa.Kind {
+		case kindQuery:
+			writeParam(w, pa, "df.AddQuery")
+		case kindHeader:
+			writeParam(w, pa, "df.AddHeaderKV")
+		case kindForm:
+			writeParam(w, pa, "df.AddWWWForm")
+		case kindBody:
+			fmt.Fprintf(w, "\tdf.SetJson(%s)\n", pa.Name)
+		}
+	}
+	if m.Result == "" {
+		fmt.Fprintf(w, "\treturn xfasthttp.Invoke(%s, df, nil)\n}\n\n", ctx)
+		return nil
+	}
+	fmt.Fprintf(w, "\tvar out %s\n", m.Result)
+	fmt.Fprintf(w, "\terr := xfasthttp.Invoke(%s, df, &out)\n", ctx)
+	fmt.Fprintf(w, "\treturn out, err\n}\n\n")
+	return nil
+}
+
+func writeParam(w *bytes.Buffer, pa *param, fn string) {
+	key := strconv.Quote(pa.Key)
+	if pa.Slice {
+		fmt.Fprintf(w, "\tfor _, v := range %s {\n\t\t%s(%s, xfasthttp.FormatParam(v))\n\t}\n", pa.Name, fn, key)
+		return
+	}
+	fmt.Fprintf(w, "\t%s(%s, xfasthttp.FormatParam(%s))\n", fn, key, pa.Name)
+}
+
+// pathExpr 将path模板转换为字符串拼接表达式
+func pathExpr(path string) string {
+	var parts []string
+	last := 0
+	for _, loc := range pathParamReg.FindAllStringSubmatchIndex(path, -1) {
+		if loc[0] > last {
+			parts = append(parts, strconv.Quote(path[last:loc[0]]))
+		}
+		parts = append(parts, "xfasthttp.PathParam("+path[loc[2]:loc[3]]+")")
+		last = loc[1]
+	}
+	if last < len(path) || len(parts) == 0 {
+		parts = append(parts, strconv.Quote(path[last:]))
+	}
+	var buf bytes.Buffer
+	for i, p := range parts {
+		if i > 0 {
+			buf.WriteString(" + ")
+		}
+		buf.WriteString(p)
+	}
+	return buf.String()
+}
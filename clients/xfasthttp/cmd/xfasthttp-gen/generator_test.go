@@ -0,0 +1,61 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGenerateExample 保证internal/example中提交的代码是最新的生成结果
+func TestGenerateExample(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "api_client.go")
+	assert.Nil(t, run("internal/example", []string{"UserAPI"}, output))
+	generated, err := os.ReadFile(output)
+	assert.Nil(t, err)
+	expected, err := os.ReadFile("internal/example/api_client.go")
+	assert.Nil(t, err)
+	assert.Equal(t, string(expected), string(generated))
+}
+
+func TestPathExpr(t *testing.T) {
+	assert.Equal(t, `"/"`, pathExpr("/"))
+	assert.Equal(t, `"/users/" + xfasthttp.PathParam(id)`, pathExpr("/users/{id}"))
+	assert.Equal(t, `"/a/" + xfasthttp.PathParam(a) + "-" + xfasthttp.PathParam(b) + "/c"`, pathExpr("/a/{a}-{b}/c"))
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		src  string
+	}{
+		{"missing method", "type API interface {\n\tGet(id int) error\n}"},
+		{"unknown path param", "type API interface {\n\t// @GET /users/{uid}\n\tGet(id int) error\n}"},
+		{"unknown annotation", "type API interface {\n\t// @GET /users\n\t// @Cookie id\n\tGet(id int) error\n}"},
+		{"reserved name", "type API interface {\n\t// @GET /users\n\tGet(df int) error\n}"},
+		{"bad result", "type API interface {\n\t// @GET /users\n\tGet(id int) int\n}"},
+		{"two bodies", "type API interface {\n\t// @POST /users\n\t// @Body a\n\t// @Form b\n\tGet(a, b int) error\n}"},
+		{"not interface", "type API struct{}"},
+	}
+	for _, c := range cases {
+		dir := t.TempDir()
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, "api.go"), []byte("package api\n\n"+c.src+"\n"), 0644))
+		err := run(dir, []string{"API"}, "")
+		assert.NotNil(t, err, c.name)
+	}
+}
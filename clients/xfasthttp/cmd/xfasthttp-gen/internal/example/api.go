@@ -0,0 +1,51 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package example 是xfasthttp-gen的示例和测试用例
+package example
+
+import (
+	"context"
+	"time"
+)
+
+//go:generate go run github.com/NetEase-Media/easy-ngo/clients/xfasthttp/cmd/xfasthttp-gen -type UserAPI -output api_client.go
+
+type User struct {
+	ID      int64     `json:"id"`
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+}
+
+type UserAPI interface {
+	// GetUser 查询用户
+	// @GET /users/{id}
+	// @Header token X-Token
+	GetUser(ctx context.Context, id int64, verbose bool, token string) (*User, error)
+
+	// @GET /users
+	// @Query tags tag
+	ListUsers(ctx context.Context, tags []string, since time.Time) ([]User, error)
+
+	// @POST /users
+	// @Body user
+	CreateUser(ctx context.Context, user *User) (*User, error)
+
+	// @PUT /users/{id}/name
+	// @Form name
+	Rename(ctx context.Context, id int64, name string) error
+
+	// @DELETE /users/{id}
+	DeleteUser(id int64) error
+}
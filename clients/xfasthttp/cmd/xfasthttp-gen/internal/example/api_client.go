@@ -0,0 +1,64 @@
+// Code generated by xfasthttp-gen. DO NOT EDIT.
+
+package example
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/clients/xfasthttp"
+)
+
+// UserAPIClient 是UserAPI基于xfasthttp的实现
+type UserAPIClient struct {
+	client  *xfasthttp.Xfasthttp
+	baseURL string
+}
+
+var _ UserAPI = (*UserAPIClient)(nil)
+
+// NewUserAPIClient 创建UserAPI的客户端，baseURL可以是http地址或者ngo://service形式的服务发现地址
+func NewUserAPIClient(client *xfasthttp.Xfasthttp, baseURL string) *UserAPIClient {
+	return &UserAPIClient{client: client, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// GetUser 查询用户
+func (c *UserAPIClient) GetUser(ctx context.Context, id int64, verbose bool, token string) (*User, error) {
+	df := c.client.Get(c.baseURL + "/users/" + xfasthttp.PathParam(id))
+	df.AddQuery("verbose", xfasthttp.FormatParam(verbose))
+	df.AddHeaderKV("X-Token", xfasthttp.FormatParam(token))
+	var out *User
+	err := xfasthttp.Invoke(ctx, df, &out)
+	return out, err
+}
+
+func (c *UserAPIClient) ListUsers(ctx context.Context, tags []string, since time.Time) ([]User, error) {
+	df := c.client.Get(c.baseURL + "/users")
+	for _, v := range tags {
+		df.AddQuery("tag", xfasthttp.FormatParam(v))
+	}
+	df.AddQuery("since", xfasthttp.FormatParam(since))
+	var out []User
+	err := xfasthttp.Invoke(ctx, df, &out)
+	return out, err
+}
+
+func (c *UserAPIClient) CreateUser(ctx context.Context, user *User) (*User, error) {
+	df := c.client.Post(c.baseURL + "/users")
+	df.SetJson(user)
+	var out *User
+	err := xfasthttp.Invoke(ctx, df, &out)
+	return out, err
+}
+
+func (c *UserAPIClient) Rename(ctx context.Context, id int64, name string) error {
+	df := c.client.Put(c.baseURL + "/users/" + xfasthttp.PathParam(id) + "/name")
+	df.AddWWWForm("name", xfasthttp.FormatParam(name))
+	return xfasthttp.Invoke(ctx, df, nil)
+}
+
+func (c *UserAPIClient) DeleteUser(id int64) error {
+	df := c.client.Delete(c.baseURL + "/users/" + xfasthttp.PathParam(id))
+	return xfasthttp.Invoke(context.Background(), df, nil)
+}
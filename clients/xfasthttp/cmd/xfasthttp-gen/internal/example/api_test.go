@@ -0,0 +1,108 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package example
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/clients/xfasthttp"
+	"github.com/NetEase-Media/easy-ngo/server/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeBody(w http.ResponseWriter, code int, body *protocol.HttpBody) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *UserAPIClient {
+	s := httptest.NewServer(handler)
+	t.Cleanup(s.Close)
+	client, _ := xfasthttp.New(xfasthttp.DefaultConfig())
+	return NewUserAPIClient(client, s.URL+"/")
+}
+
+func TestGetUser(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "/users/7", r.URL.Path)
+		assert.Equal(t, "true", r.URL.Query().Get("verbose"))
+		assert.Equal(t, "secret", r.Header.Get("X-Token"))
+		writeBody(w, http.StatusOK, &protocol.HttpBody{Data: User{ID: 7, Name: "ngo"}})
+	})
+	user, err := c.GetUser(context.Background(), 7, true, "secret")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), user.ID)
+	assert.Equal(t, "ngo", user.Name)
+}
+
+func TestListUsers(t *testing.T) {
+	since := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, []string{"a", "b"}, r.URL.Query()["tag"])
+		assert.Equal(t, "2022-01-02T03:04:05Z", r.URL.Query().Get("since"))
+		writeBody(w, http.StatusOK, &protocol.HttpBody{Data: []User{{ID: 1}, {ID: 2}}})
+	})
+	users, err := c.ListUsers(context.Background(), []string{"a", "b"}, since)
+	assert.Nil(t, err)
+	assert.Len(t, users, 2)
+}
+
+func TestCreateUser(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var u User
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&u))
+		u.ID = 100
+		writeBody(w, http.StatusOK, &protocol.HttpBody{Data: u})
+	})
+	user, err := c.CreateUser(context.Background(), &User{Name: "new"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), user.ID)
+	assert.Equal(t, "new", user.Name)
+}
+
+func TestRenameError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/users/1/name", r.URL.Path)
+		assert.Nil(t, r.ParseForm())
+		assert.Equal(t, "n", r.PostForm.Get("name"))
+		writeBody(w, http.StatusBadRequest, &protocol.HttpBody{Code: protocol.ParamsNotValid, Message: "bad name"})
+	})
+	err := c.Rename(context.Background(), 1, "n")
+	var e *protocol.Error
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, protocol.ParamsNotValid, e.Code)
+	assert.Equal(t, "bad name", e.Err.Error())
+}
+
+func TestDeleteUserStatusError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodDelete, r.Method)
+		w.WriteHeader(http.StatusBadGateway)
+		io.WriteString(w, "bad gateway")
+	})
+	err := c.DeleteUser(1)
+	var e *xfasthttp.StatusError
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, http.StatusBadGateway, e.StatusCode)
+}
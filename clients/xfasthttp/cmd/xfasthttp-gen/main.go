@@ -0,0 +1,93 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// xfasthttp-gen 根据带注解的Go接口生成基于xfasthttp的http客户端。
+//
+// 在接口所在的文件中添加：
+//
+//	//go:generate go run github.com/NetEase-Media/easy-ngo/clients/xfasthttp/cmd/xfasthttp-gen -type UserAPI
+//
+// 接口方法的注释中使用以下注解描述请求：
+//
+//	@GET /users/{id}          请求方法和path模板，{id}绑定到同名参数，支持GET、POST、PUT、DELETE、PATCH
+//	@Query verbose [key]      参数作为url query，key默认为参数名。未注解的参数默认作为query
+//	@Header token X-Token     参数作为header
+//	@Form name [key]          参数作为x-www-form-urlencoded字段
+//	@Body user                参数作为json body
+//
+// 方法的第一个参数可以是context.Context，返回值为error或(T, error)。
+// 回复按照protocol.HttpBody解析，data写入T，业务错误码不为0时返回*protocol.Error
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of interface names; must be set")
+	output    = flag.String("output", "", "output file name; default srcdir/<type>_client.go")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage of xfasthttp-gen:\n")
+	fmt.Fprintf(os.Stderr, "\txfasthttp-gen -type T [-output file] [directory]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if *typeNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+	if err := run(dir, strings.Split(*typeNames, ","), *output); err != nil {
+		fmt.Fprintf(os.Stderr, "xfasthttp-gen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir string, names []string, output string) error {
+	pkg, err := parsePackage(dir)
+	if err != nil {
+		return err
+	}
+	services := make([]*service, 0, len(names))
+	for _, name := range names {
+		svc, err := pkg.service(strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+		services = append(services, svc)
+	}
+	src, err := generate(pkg, services)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		output = strings.ToLower(names[0]) + "_client.go"
+	}
+	if !filepath.IsAbs(output) {
+		output = filepath.Join(dir, output)
+	}
+	return os.WriteFile(output, src, 0644)
+}
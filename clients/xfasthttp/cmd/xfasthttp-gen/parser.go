@@ -0,0 +1,306 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	httpMethods = map[string]string{
+		"GET":    "Get",
+		"POST":   "Post",
+		"PUT":    "Put",
+		"DELETE": "Delete",
+		"PATCH":  "Patch",
+	}
+
+	pathParamReg     = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+	versionSuffixReg = regexp.MustCompile(`/v[0-9]+$`)
+)
+
+type paramKind int
+
+const (
+	kindQuery paramKind = iota
+	kindPath
+	kindHeader
+	kindForm
+	kindBody
+	kindContext
+)
+
+type param struct {
+	Name string
+	Type string
+	Kind paramKind
+	// query、header和form的key
+	Key string
+	// 切片类型的query和form参数会添加多个值
+	Slice bool
+}
+
+type method struct {
+	Name string
+	// 接口方法中除注解以外的注释
+	Doc        []string
+	HTTPMethod string
+	Path       string
+	Params     []*param
+	// 返回值中data的类型，只返回error时为空
+	Result string
+}
+
+type service struct {
+	Name    string
+	Methods []*method
+}
+
+type pkgInfo struct {
+	Name  string
+	fset  *token.FileSet
+	files []*ast.File
+	// 类型表达式中引用的包
+	imports map[string]string
+}
+
+func parsePackage(dir string) (*pkgInfo, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expected 1 package in %s, found %d", dir, len(pkgs))
+	}
+	info := &pkgInfo{fset: fset, imports: make(map[string]string)}
+	for name, pkg := range pkgs {
+		info.Name = name
+		for _, f := range pkg.Files {
+			info.files = append(info.files, f)
+		}
+	}
+	return info, nil
+}
+
+// service 查找接口定义并解析注解
+func (p *pkgInfo) service(name string) (*service, error) {
+	for _, f := range p.files {
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if ts.Name.Name != name {
+					continue
+				}
+				it, ok := ts.Type.(*ast.InterfaceType)
+				if !ok {
+					return nil, fmt.Errorf("%s is not an interface", name)
+				}
+				return p.parseInterface(f, name, it)
+			}
+		}
+	}
+	return nil, fmt.Errorf("interface %s not found", name)
+}
+
+func (p *pkgInfo) parseInterface(f *ast.File, name string, it *ast.InterfaceType) (*service, error) {
+	svc := &service{Name: name}
+	for _, field := range it.Methods.List {
+		ft, ok := field.Type.(*ast.FuncType)
+		if !ok || len(field.Names) == 0 {
+			return nil, fmt.Errorf("%s: embedded interfaces are not supported", name)
+		}
+		m, err := p.parseMethod(f, field.Names[0].Name, field.Doc, ft)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", name, field.Names[0].Name, err)
+		}
+		svc.Methods = append(svc.Methods, m)
+	}
+	return svc, nil
+}
+
+func (p *pkgInfo) parseMethod(f *ast.File, name string, doc *ast.CommentGroup, ft *ast.FuncType) (*method, error) {
+	m := &method{Name: name}
+	params := make(map[string]*param)
+	for i, field := range ft.Params.List {
+		if len(field.Names) == 0 {
+			return nil, fmt.Errorf("parameters must be named")
+		}
+		if _, ok := field.Type.(*ast.Ellipsis); ok {
+			return nil, fmt.Errorf("variadic parameters are not supported")
+		}
+		typ := p.typeString(f, field.Type)
+		for _, n := range field.Names {
+			pa := &param{Name: n.Name, Type: typ, Key: n.Name, Slice: isSlice(field.Type)}
+			if i == 0 && typ == "context.Context" {
+				pa.Kind = kindContext
+			}
+			m.Params = append(m.Params, pa)
+			params[n.Name] = pa
+		}
+	}
+
+	if err := m.parseAnnotations(doc, params); err != nil {
+		return nil, err
+	}
+
+	results := ft.Results
+	if results == nil || len(results.List) == 0 || len(results.List) > 2 {
+		return nil, fmt.Errorf("must return error or (T, error)")
+	}
+	last := results.List[len(results.List)-1]
+	if ident, ok := last.Type.(*ast.Ident); !ok || ident.Name != "error" || len(last.Names) > 1 {
+		return nil, fmt.Errorf("last result must be error")
+	}
+	if len(results.List) == 2 {
+		if len(results.List[0].Names) > 1 {
+			return nil, fmt.Errorf("must return error or (T, error)")
+		}
+		m.Result = p.typeString(f, results.List[0].Type)
+	}
+	return m, nil
+}
+
+func (m *method) parseAnnotations(doc *ast.CommentGroup, params map[string]*param) error {
+	if doc == nil {
+		return fmt.Errorf("missing http method annotation")
+	}
+	for _, c := range doc.List {
+		line := strings.TrimSpace(strings.TrimPrefix(c.Text, "//"))
+		if !strings.HasPrefix(line, "@") {
+			m.Doc = append(m.Doc, line)
+			continue
+		}
+		fields := strings.Fields(line[1:])
+		if len(fields) == 0 {
+			continue
+		}
+		tag := strings.ToUpper(fields[0])
+		if hm, ok := httpMethods[tag]; ok {
+			if len(fields) != 2 {
+				return fmt.Errorf("usage: @%s /path", tag)
+			}
+			m.HTTPMethod, m.Path = hm, fields[1]
+			continue
+		}
+		kinds := map[string]paramKind{"QUERY": kindQuery, "HEADER": kindHeader, "FORM": kindForm, "BODY": kindBody}
+		kind, ok := kinds[tag]
+		if !ok {
+			return fmt.Errorf("unknown annotation @%s", fields[0])
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return fmt.Errorf("usage: @%s param [key]", fields[0])
+		}
+		pa, ok := params[fields[1]]
+		if !ok {
+			return fmt.Errorf("@%s: unknown parameter %s", fields[0], fields[1])
+		}
+		pa.Kind = kind
+		if len(fields) == 3 {
+			pa.Key = fields[2]
+		}
+	}
+	if m.HTTPMethod == "" {
+		return fmt.Errorf("missing http method annotation")
+	}
+	for len(m.Doc) > 0 && m.Doc[len(m.Doc)-1] == "" {
+		m.Doc = m.Doc[:len(m.Doc)-1]
+	}
+
+	for _, match := range pathParamReg.FindAllStringSubmatch(m.Path, -1) {
+		pa, ok := params[match[1]]
+		if !ok {
+			return fmt.Errorf("path parameter %s not found", match[1])
+		}
+		pa.Kind = kindPath
+	}
+	if bodies := m.countKind(kindBody); bodies > 1 || (bodies == 1 && m.countKind(kindForm) > 0) {
+		return fmt.Errorf("@Body can not be used with other @Body or @Form parameters")
+	}
+	return nil
+}
+
+func (m *method) countKind(kind paramKind) int {
+	n := 0
+	for _, pa := range m.Params {
+		if pa.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+func isSlice(expr ast.Expr) bool {
+	at, ok := expr.(*ast.ArrayType)
+	if !ok || at.Len != nil {
+		return false
+	}
+	// []byte按字符串处理
+	ident, ok := at.Elt.(*ast.Ident)
+	return !ok || ident.Name != "byte"
+}
+
+// typeString 返回类型表达式的源码，并记录其中引用的包
+func (p *pkgInfo) typeString(f *ast.File, expr ast.Expr) string {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if ident, ok := sel.X.(*ast.Ident); ok {
+			if path := importPath(f, ident.Name); path != "" {
+				p.imports[ident.Name] = path
+			}
+		}
+		return false
+	})
+	var buf bytes.Buffer
+	printer.Fprint(&buf, p.fset, expr)
+	return buf.String()
+}
+
+// importPath 返回文件中名称为name的import的路径
+func importPath(f *ast.File, name string) string {
+	for _, imp := range f.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		if imp.Name != nil {
+			if imp.Name.Name == name {
+				return path
+			}
+			continue
+		}
+		// 忽略版本后缀，例如github.com/go-redis/redis/v8
+		base := versionSuffixReg.ReplaceAllString(path, "")
+		if base == name || strings.HasSuffix(base, "/"+name) {
+			return path
+		}
+	}
+	return ""
+}
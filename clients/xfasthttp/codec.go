@@ -0,0 +1,263 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"strings"
+	"sync"
+
+	"github.com/NetEase-Media/easy-ngo/xlog"
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeXML      = "application/xml"
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeMsgpack  = "application/msgpack"
+	ContentTypeForm     = "application/x-www-form-urlencoded"
+)
+
+// Codec 负责请求和回复body的编解码
+type Codec interface {
+	// ContentType 编码后请求使用的Content-Type
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	JSONCodec     Codec = jsonCodec{}
+	XMLCodec      Codec = xmlCodec{}
+	ProtobufCodec Codec = protobufCodec{}
+	MsgpackCodec  Codec = msgpackCodec{}
+	FormCodec     Codec = formCodec{}
+)
+
+var codecs = struct {
+	sync.RWMutex
+	m map[string]Codec
+}{
+	m: map[string]Codec{
+		ContentTypeJSON:             JSONCodec,
+		"text/json":                 JSONCodec,
+		ContentTypeXML:              XMLCodec,
+		"text/xml":                  XMLCodec,
+		ContentTypeProtobuf:         ProtobufCodec,
+		"application/protobuf":      ProtobufCodec,
+		"application/x-protobuffer": ProtobufCodec,
+		ContentTypeMsgpack:          MsgpackCodec,
+		"application/x-msgpack":     MsgpackCodec,
+		ContentTypeForm:             FormCodec,
+	},
+}
+
+// RegisterCodec 注册Content-Type对应的Codec，已注册的会被覆盖。contentTypes为空时使用c.ContentType()
+func RegisterCodec(c Codec, contentTypes ...string) {
+	if len(contentTypes) == 0 {
+		contentTypes = []string{c.ContentType()}
+	}
+	codecs.Lock()
+	defer codecs.Unlock()
+	for _, t := range contentTypes {
+		codecs.m[strings.ToLower(t)] = c
+	}
+}
+
+// GetCodec 根据Content-Type选择Codec，忽略charset等参数。没有注册时，+json和+xml后缀分别使用JSON和XML，否则返回nil
+func GetCodec(contentType string) Codec {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	}
+	mediaType = strings.ToLower(mediaType)
+	codecs.RLock()
+	c := codecs.m[mediaType]
+	codecs.RUnlock()
+	if c != nil {
+		return c
+	}
+	switch {
+	case strings.HasSuffix(mediaType, "+json"):
+		return JSONCodec
+	case strings.HasSuffix(mediaType, "+xml"):
+		return XMLCodec
+	}
+	return nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// xmlCodec 按照文档声明的encoding解码，所以不需要事先转换字符集
+type xmlCodec struct{}
+
+func (xmlCodec) ContentType() string {
+	return ContentTypeXML
+}
+
+func (xmlCodec) Marshal(v interface{}) ([]byte, error) {
+	return xml.Marshal(v)
+}
+
+func (xmlCodec) Unmarshal(data []byte, v interface{}) error {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.CharsetReader = charsetReader
+	return d.Decode(v)
+}
+
+func (xmlCodec) decodesCharset() bool {
+	return true
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf: %T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+func (protobufCodec) binary() bool {
+	return true
+}
+
+// msgpackHandle 结构体字段的名字依次取codec和json标签
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.WriteExt = true
+	h.RawToString = true
+	return h
+}()
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string {
+	return ContentTypeMsgpack
+}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var b []byte
+	err := codec.NewEncoderBytes(&b, msgpackHandle).Encode(v)
+	return b, err
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, msgpackHandle).Decode(v)
+}
+
+func (msgpackCodec) binary() bool {
+	return true
+}
+
+// needsCharsetConversion 二进制格式以及自行处理编码的格式，解码前不转换字符集
+func needsCharsetConversion(c Codec) bool {
+	if b, ok := c.(interface{ binary() bool }); ok && b.binary() {
+		return false
+	}
+	if d, ok := c.(interface{ decodesCharset() bool }); ok && d.decodesCharset() {
+		return false
+	}
+	return true
+}
+
+// SetBodyCodec 使用c编码body作为请求体，并设置对应的Content-Type
+func (df *DataFlow) SetBodyCodec(c Codec, body interface{}) *DataFlow {
+	b, err := c.Marshal(body)
+	if err != nil {
+		df.Err = err
+		xlog.Errorf("encoding body failed: %s", err.Error())
+		return df
+	}
+
+	df.req.SetBody(b)
+	df.req.Header.SetContentType(c.ContentType())
+	return df
+}
+
+// SetXML 设置请求体，格式是xml
+func (df *DataFlow) SetXML(body interface{}) *DataFlow {
+	return df.SetBodyCodec(XMLCodec, body)
+}
+
+// SetProtobuf 设置请求体，格式是protobuf
+func (df *DataFlow) SetProtobuf(msg proto.Message) *DataFlow {
+	return df.SetBodyCodec(ProtobufCodec, msg)
+}
+
+// SetMsgpack 设置请求体，格式是msgpack
+func (df *DataFlow) SetMsgpack(body interface{}) *DataFlow {
+	return df.SetBodyCodec(MsgpackCodec, body)
+}
+
+// Bind 将body与obj绑定，按照回复的Content-Type选择Codec解码，无法识别时按照json解析
+// 注意obj必须是一个指针
+func (df *DataFlow) Bind(obj interface{}) *DataFlow {
+	return df.BindCodec(nil, obj)
+}
+
+// BindCodec 将body与obj绑定，使用c解码
+func (df *DataFlow) BindCodec(c Codec, obj interface{}) *DataFlow {
+	df.bodyBindType = typeCodec
+	df.bodyCodec = c
+	df.bodyObject = obj
+	return df
+}
+
+// BindXML 将xml结构对象与body绑定
+func (df *DataFlow) BindXML(obj interface{}) *DataFlow {
+	return df.BindCodec(XMLCodec, obj)
+}
+
+// BindProtobuf 将protobuf消息与body绑定
+func (df *DataFlow) BindProtobuf(msg proto.Message) *DataFlow {
+	return df.BindCodec(ProtobufCodec, msg)
+}
+
+// BindMsgpack 将msgpack结构对象与body绑定
+func (df *DataFlow) BindMsgpack(obj interface{}) *DataFlow {
+	return df.BindCodec(MsgpackCodec, obj)
+}
@@ -0,0 +1,192 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type codecUser struct {
+	XMLName xml.Name `xml:"user" json:"-" form:"-"`
+	ID      int      `xml:"id" json:"id" form:"id"`
+	Name    string   `xml:"name" json:"name" form:"name"`
+	Tags    []string `xml:"tag" json:"tags" form:"tag"`
+}
+
+func TestGetCodec(t *testing.T) {
+	assert.Equal(t, JSONCodec, GetCodec("application/json; charset=utf-8"))
+	assert.Equal(t, JSONCodec, GetCodec("application/problem+json"))
+	assert.Equal(t, XMLCodec, GetCodec("text/xml;charset=gbk"))
+	assert.Equal(t, XMLCodec, GetCodec("application/atom+xml"))
+	assert.Equal(t, ProtobufCodec, GetCodec("application/x-protobuf"))
+	assert.Equal(t, MsgpackCodec, GetCodec("application/x-msgpack"))
+	assert.Equal(t, FormCodec, GetCodec("application/x-www-form-urlencoded"))
+	assert.Nil(t, GetCodec("text/plain"))
+
+	RegisterCodec(JSONCodec, "text/x-test-json")
+	assert.Equal(t, JSONCodec, GetCodec("text/x-test-json"))
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+	in := codecUser{ID: 1, Name: "a", Tags: []string{"x", "y"}}
+	for _, c := range []Codec{JSONCodec, XMLCodec, MsgpackCodec, FormCodec} {
+		b, err := c.Marshal(&in)
+		assert.Nil(t, err, c.ContentType())
+		var out codecUser
+		assert.Nil(t, c.Unmarshal(b, &out), c.ContentType())
+		out.XMLName = xml.Name{}
+		assert.Equal(t, in, out, c.ContentType())
+	}
+
+	b, err := ProtobufCodec.Marshal(wrapperspb.String("a"))
+	assert.Nil(t, err)
+	msg := &wrapperspb.StringValue{}
+	assert.Nil(t, ProtobufCodec.Unmarshal(b, msg))
+	assert.Equal(t, "a", msg.GetValue())
+	_, err = ProtobufCodec.Marshal(in)
+	assert.Error(t, err)
+}
+
+func TestFormCodec_Maps(t *testing.T) {
+	b, err := FormCodec.Marshal(map[string]string{"a": "1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "a=1", string(b))
+
+	var m map[string]string
+	assert.Nil(t, FormCodec.Unmarshal([]byte("a=1&b=2&b=3"), &m))
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, m)
+	var v url.Values
+	assert.Nil(t, FormCodec.Unmarshal([]byte("b=2&b=3"), &v))
+	assert.Equal(t, []string{"2", "3"}, v["b"])
+}
+
+func TestDataFlow_Codec(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+		w.Write(body)
+	}))
+	defer s.Close()
+	c := newTestHttpClient()
+	in := codecUser{ID: 1, Name: "a", Tags: []string{"x"}}
+
+	var out codecUser
+	_, err := c.Post(s.URL).SetXML(&in).Bind(&out).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "a", out.Name)
+
+	out = codecUser{}
+	_, err = c.Post(s.URL).SetMsgpack(&in).Bind(&out).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, in, out)
+
+	out = codecUser{}
+	_, err = c.Post(s.URL).SetBodyCodec(FormCodec, &in).BindCodec(FormCodec, &out).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, in, out)
+
+	msg := &wrapperspb.StringValue{}
+	_, err = c.Post(s.URL).SetProtobuf(wrapperspb.String("a")).BindProtobuf(msg).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "a", msg.GetValue())
+}
+
+func TestDataFlow_CharsetGBK(t *testing.T) {
+	gbk := func(s string) []byte {
+		b, _ := simplifiedchinese.GBK.NewEncoder().Bytes([]byte(s))
+		return b
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/xml" {
+			w.Header().Set("Content-Type", "text/xml; charset=gbk")
+			w.Write(gbk(`<?xml version="1.0" encoding="GBK"?><user><name>成功</name></user>`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-www-form-urlencoded; charset=GBK")
+		w.Write(gbk("name=成功"))
+	}))
+	defer s.Close()
+	c := newTestHttpClient()
+
+	var out codecUser
+	_, err := c.Get(s.URL + "/xml").Bind(&out).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "成功", out.Name)
+
+	out = codecUser{}
+	_, err = c.Get(s.URL + "/form").Bind(&out).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "成功", out.Name)
+}
+
+func TestDataFlow_Decompress(t *testing.T) {
+	body := []byte(`{"a":"compressed"}`)
+	compress := map[string]func(io.Writer) io.WriteCloser{
+		"gzip":    func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"deflate": func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+		"br":      func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) },
+	}
+	var acceptEncoding string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		encoding := r.URL.Query().Get("encoding")
+		var buf bytes.Buffer
+		zw := compress[encoding](&buf)
+		zw.Write(body)
+		zw.Close()
+		w.Header().Set("Content-Encoding", encoding)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(buf.Bytes())
+	}))
+	defer s.Close()
+
+	config := DefaultConfig()
+	config.Compression = true
+	c, _ := New(config)
+	for encoding := range compress {
+		var res testJsonBody
+		_, err := c.Get(s.URL).AddQuery("encoding", encoding).BindJson(&res).Do(context.Background())
+		assert.Nil(t, err, encoding)
+		assert.Equal(t, "compressed", res.A, encoding)
+
+		var w bytes.Buffer
+		_, err = c.Get(s.URL).AddQuery("encoding", encoding).BindWriter(&w).Do(context.Background())
+		assert.Nil(t, err, encoding)
+		assert.Equal(t, body, w.Bytes(), encoding)
+	}
+	assert.Equal(t, acceptEncoding, "gzip, deflate, br")
+
+	config = DefaultConfig()
+	config.DisableDecompression = true
+	c, _ = New(config)
+	var raw []byte
+	_, err := c.Get(s.URL).AddQuery("encoding", "gzip").BindBytes(&raw).Do(context.Background())
+	assert.Nil(t, err)
+	assert.NotEqual(t, body, raw)
+}
@@ -62,6 +62,33 @@ type Config struct {
 
 	// 等待空闲连接的最大时间。默认情况不等待，如果没有空闲连接返回ErrNoFreeConns错误。
 	MaxConnWaitTimeout time.Duration
+
+	// 建立连接的配置，包括代理和DNS缓存
+	Dial DialConfig
+
+	// 按照host覆盖的配置，key为host:port或host
+	Hosts map[string]HostConfig
+
+	// 是否开启监控，需要先设置xmetrics的Provider
+	EnableMetrics bool
+
+	// 监控配置
+	Metrics MetricsConfig
+
+	// 是否开启链路追踪
+	EnableTracer bool
+
+	// 熔断配置
+	CircuitBreaker CircuitBreakerConfig
+
+	// 重试预算配置，对所有使用DataFlow.Retry的请求生效
+	RetryBudget RetryBudgetConfig
+
+	// 请求时携带Accept-Encoding: gzip, deflate, br，请求已设置时不覆盖
+	Compression bool
+
+	// 不自动解压回复，绑定的body保持Content-Encoding编码后的数据
+	DisableDecompression bool
 }
 
 func DefaultConfig() *Config {
@@ -76,5 +103,9 @@ func DefaultConfig() *Config {
 		WriteBufferSize:           4096,
 		ReadTimeout:               time.Second * 60,
 		WriteTimeout:              time.Second * 60,
+		Dial:                      DefaultDialConfig(),
+		Metrics:                   DefaultMetricsConfig(),
+		CircuitBreaker:            DefaultCircuitBreakerConfig(),
+		RetryBudget:               DefaultRetryBudgetConfig(),
 	}
 }
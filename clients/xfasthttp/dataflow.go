@@ -15,9 +15,11 @@
 package xfasthttp
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/textproto"
 	"net/url"
 	"regexp"
@@ -26,7 +28,7 @@ import (
 	"time"
 
 	"github.com/NetEase-Media/easy-ngo/xlog"
-	"github.com/djimenez/iconv-go"
+	"github.com/NetEase-Media/easy-ngo/xtracer"
 	"github.com/valyala/fasthttp"
 )
 
@@ -39,6 +41,7 @@ const (
 	typeString
 	typeBytes
 	typeJson
+	typeCodec
 )
 
 var (
@@ -59,15 +62,23 @@ type DoFunc func(*DataFlow, context.Context) (int, error)
 // DataFlow 是核心数据结构，用来保存http请求的中间状态数据，并负责发送请求和解析回复。
 type DataFlow struct {
 	// req 保存请求的中间属性。在Do执行后内存会被释放，不可再使用！
-	req    *fasthttp.Request
-	client *fasthttp.Client
-	header H
-	query  Query
+	req        *fasthttp.Request
+	transport  Transport
+	breakers   *circuitBreakers
+	budget     *retryBudget
+	latencies  *latencyTrackers
+	tracer     xtracer.Tracer
+	metrics    *clientMetrics
+	decompress bool
+	header     H
+	query      Query
 
 	wwwForm         WWWForm       // 使用AddWWWFrom或SetWWWForm写入的数据
 	timeout         time.Duration // 单次请求的超时时间
 	degradeCallback func() error  // 降级回调函数
 	cbCallback      func() error  // 熔断回调
+	hashKey         string        // 服务发现一致性哈希使用的key
+	retry           *RetryPolicy  // 重试策略
 
 	// 绑定回复的http body
 	// TODO: 因为只可能使用其中一种，可以考虑用interface保存，使用时再转换
@@ -77,10 +88,24 @@ type DataFlow struct {
 	bodyString   *string
 	bodyBytes    *[]byte
 	bodyJson     interface{}
+	bodyObject   interface{} // 使用Codec解码的对象
+	bodyCodec    Codec       // 为空时按照回复的Content-Type选择
 
 	// 绑定请求的http header
 	headerBinder H
 
+	files            []formFile   // 使用AddFormFile写入的上传文件
+	bodyStream       io.Reader    // 流式请求体
+	bodySize         int          // 流式请求体的大小，未知时为-1
+	bodyWriter       io.Writer    // 流式写入回复的body
+	uploadProgress   ProgressFunc // 上传进度回调
+	downloadProgress ProgressFunc // 下载进度回调
+
+	// 收到回复后、解析body前调用
+	responseHooks []func(*fasthttp.Response)
+
+	processed bool // query和form是否已写入请求
+
 	Err error
 
 	do1 DoFunc
@@ -88,14 +113,19 @@ type DataFlow struct {
 
 func newDataFlow(c *Xfasthttp) *DataFlow {
 	df := &DataFlow{
-		client: c.client,
-	}
-	df.do1 = func(df1 *DataFlow, c context.Context) (int, error) {
-		return df1.doInternal()
+		transport:  c.transport,
+		breakers:   c.breakers,
+		budget:     c.budget,
+		latencies:  c.latencies,
+		tracer:     c.tracer,
+		metrics:    c.metrics,
+		decompress: c.decompress,
 	}
+	df.do1 = c.do
 	return df
 }
 
+// WrapDoFunc 为单个请求增加拦截器，在客户端拦截器的外层执行
 func (df *DataFlow) WrapDoFunc(f func(DoFunc) DoFunc) {
 	df.do1 = f(df.do1)
 }
@@ -241,10 +271,14 @@ func (df *DataFlow) BindInt(i *int) *DataFlow {
 	return df
 }
 
-// processRequest 将请求缓存解析并写入到request中
+// processRequest 将请求缓存解析并写入到request中，只处理一次
 func (df *DataFlow) processRequest() {
-	// 注意www-form的优先级大于其他
-	if df.wwwForm != nil {
+	if df.processed {
+		return
+	}
+	df.processed = true
+	// 注意www-form的优先级大于其他，有上传文件时作为multipart的字段发送
+	if df.wwwForm != nil && len(df.files) == 0 {
 		df.req.SetBodyString(df.wwwForm.Encode())
 		df.req.Header.SetContentType("application/x-www-form-urlencoded")
 	}
@@ -253,11 +287,13 @@ func (df *DataFlow) processRequest() {
 	if df.query != nil {
 		df.req.URI().SetQueryString(df.query.Encode())
 	}
+
+	df.processBody()
 }
 
 // processResponse 解析回复，存储到绑定的变量中
-func (df *DataFlow) processResponse(res *fasthttp.Response) error {
-	xlog.Debugf("http recv response header\n%s\n body\n%s", &res.Header, string(res.Body()))
+func (df *DataFlow) processResponse(ctx context.Context, res *fasthttp.Response) error {
+	xlog.Debugf("http recv response header\n%s\n body\n%s", &res.Header, responseBody(res))
 
 	if err := df.encodeHeader(&res.Header); err != nil {
 		return err
@@ -268,7 +304,9 @@ func (df *DataFlow) processResponse(res *fasthttp.Response) error {
 		return df.degradeCallback()
 	}
 
-	// 如果是5XX则记录熔断错误
+	if df.bodyWriter != nil {
+		return df.writeBody(ctx, res)
+	}
 
 	if err := df.encodeBody(res); err != nil {
 		return err
@@ -277,21 +315,54 @@ func (df *DataFlow) processResponse(res *fasthttp.Response) error {
 	return nil
 }
 
-// send 根据当前状态选择发送请求
-func (df *DataFlow) send(res *fasthttp.Response) (err error) {
-	xlog.Debugf("http send request header {%s} body {%s}", df.req.Header.String(), string(df.req.Body()))
+// send 根据当前状态选择发送请求。ctx设置了deadline时，超时时间取deadline和Timeout中较早的一个，
+// ctx结束时立即返回ctx.Err()
+func (df *DataFlow) send(ctx context.Context, req *fasthttp.Request, res *fasthttp.Response) error {
+	xlog.Debugf("http send request header {%s} body {%s}", req.Header.String(), requestBody(req))
 
+	transport := df.transport
+	deadline, _ := ctx.Deadline()
 	if df.timeout != 0 {
-		return df.client.DoTimeout(df.req, res, df.timeout)
+		if d := time.Now().Add(df.timeout); deadline.IsZero() || d.Before(deadline) {
+			deadline = d
+		}
+	}
+	// 流式的请求体和回复无法复制，直接发送，此时ctx的取消在读写body时生效
+	if ctx.Done() == nil || req.IsBodyStream() || res.StreamBody {
+		return transport.RoundTrip(req, res, deadline)
 	}
 
-	// 回复可能有重定向，所以使用DoRedirects发送请求
-	return df.client.DoRedirects(df.req, res, defaultMaxRedirectsCount)
+	// fasthttp不支持取消请求，所以在独立的协程中发送。ctx结束时请求可能还未完成，由该协程负责释放请求和回复
+	r := fasthttp.AcquireRequest()
+	req.CopyTo(r)
+	w := fasthttp.AcquireResponse()
+	ch := make(chan error, 1)
+	go func() {
+		ch <- transport.RoundTrip(r, w, deadline)
+	}()
+	select {
+	case err := <-ch:
+		w.CopyTo(res)
+		fasthttp.ReleaseRequest(r)
+		fasthttp.ReleaseResponse(w)
+		return err
+	case <-ctx.Done():
+		go func() {
+			<-ch
+			fasthttp.ReleaseRequest(r)
+			fasthttp.ReleaseResponse(w)
+		}()
+		return ctx.Err()
+	}
 }
 
-// Do 调用fasthttp client发送请求，并解析回复数据
-// 注意一旦使用后DataFlow将不可再使用
 func (df *DataFlow) doInternal() (statusCode int, err error) {
+	return df.doContext(context.Background())
+}
+
+// doContext 调用fasthttp client发送请求，并解析回复数据
+// 注意一旦使用后DataFlow将不可再使用
+func (df *DataFlow) doContext(ctx context.Context) (statusCode int, err error) {
 	// var stats *httpclient.StatsHolder
 	defer func() {
 		// df.release()
@@ -303,38 +374,116 @@ func (df *DataFlow) doInternal() (statusCode int, err error) {
 	}
 
 	df.processRequest()
+	ctx, span := df.startSpan(ctx)
+	defer func() {
+		endSpan(span, statusCode, err)
+	}()
 	res := fasthttp.AcquireResponse()
 
 	defer func() {
 		fasthttp.ReleaseResponse(res)
 	}()
 
-	if err = df.send(res); err != nil {
+	// 服务发现会改写请求地址，需要提前记录
+	var labels requestLabels
+	if df.metrics != nil {
+		labels = newRequestLabels(df.req)
+	}
+	err = df.roundTrip(ctx, res)
+	if df.metrics != nil {
+		df.metrics.record(labels, res.StatusCode(), err)
+	}
+	if err == ErrCircuitOpen && df.cbCallback != nil {
+		err = df.cbCallback()
+	}
+	if err != nil {
 		return
 	}
 
+	for _, hook := range df.responseHooks {
+		hook(res)
+	}
 	statusCode = res.StatusCode()
-	err = df.processResponse(res)
+	err = df.processResponse(ctx, res)
 	return
 }
 
+// Do 发送请求并解析回复。query和form在执行拦截器之前写入请求
 func (df *DataFlow) Do(ctx context.Context) (statusCode int, err error) {
+	if df.Err == nil {
+		df.processRequest()
+	}
 	return df.do1(df, ctx)
 }
 
+// Request 返回将要发送的请求，只能在Do返回之前使用
+func (df *DataFlow) Request() *fasthttp.Request {
+	return df.req
+}
+
+// OnResponse 注册回复的回调函数，在收到回复后、解析body前调用，回调返回后回复会被释放
+func (df *DataFlow) OnResponse(f func(res *fasthttp.Response)) *DataFlow {
+	df.responseHooks = append(df.responseHooks, f)
+	return df
+}
+
 // reset 清理对象，防止重复使用。如果使用sync.Pool必须调用。
 func (df *DataFlow) reset() {
+	if df.req != nil {
+		// 请求未发送时关闭流式请求体，让写入multipart的协程退出
+		df.req.CloseBodyStream()
+	}
 	df.req = nil
-	df.client = nil
+	df.transport = nil
+	df.breakers = nil
+	df.budget = nil
+	df.latencies = nil
+	df.tracer = nil
+	df.metrics = nil
+	df.decompress = false
+	df.bodyObject = nil
+	df.bodyCodec = nil
 	df.header = nil
 	df.query = nil
 	df.wwwForm = nil
 	df.headerBinder = nil
+	df.responseHooks = nil
+	df.processed = false
+	df.files = nil
+	df.bodyStream = nil
+	df.bodyWriter = nil
+	df.uploadProgress = nil
+	df.downloadProgress = nil
 	df.degradeCallback = nil
 	df.cbCallback = nil
+	df.hashKey = ""
+	df.retry = nil
 	df.Err = nil
 }
 
+// CircuitBreak 注册熔断回调函数，熔断打开时不发送请求，直接返回回调的结果
+func (df *DataFlow) CircuitBreak(f func() error) *DataFlow {
+	df.cbCallback = f
+	return df
+}
+
+// circuitBreaker 熔断按照服务发现之前的host统计，同一服务的所有实例共用一个熔断器
+func (df *DataFlow) circuitBreaker() *CircuitBreaker {
+	if df.breakers == nil {
+		return nil
+	}
+	uri := df.req.URI()
+	return df.breakers.get(string(uri.Host()), string(uri.Path()))
+}
+
+// isCircuitBreakingFailure 连接错误、5XX以及上游代理熔断都记为熔断错误。被取消的请求不调用该函数
+func isCircuitBreakingFailure(res *fasthttp.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return res.StatusCode() >= fasthttp.StatusInternalServerError || len(res.Header.Peek(HeaderKeyCircuitbreaking)) > 0
+}
+
 // Degrade 注册降级回调函数
 func (df *DataFlow) Degrade(f func() error) *DataFlow {
 	df.degradeCallback = f
@@ -343,11 +492,15 @@ func (df *DataFlow) Degrade(f func() error) *DataFlow {
 
 // encodeBody 将回复中的body写入绑定的对象中
 func (df *DataFlow) encodeBody(res *fasthttp.Response) (err error) {
-	body := res.Body()
-	switch df.bodyBindType {
-	case typeNil:
+	if df.bodyBindType == typeNil {
 		return
+	}
+	body, err := df.decodedBody(res)
+	if err != nil {
+		return err
+	}
 
+	switch df.bodyBindType {
 	case typeInt:
 		if df.bodyInt == nil {
 			err = fmt.Errorf("empty int binder")
@@ -377,18 +530,7 @@ func (df *DataFlow) encodeBody(res *fasthttp.Response) (err error) {
 			err = fmt.Errorf("empty string binder")
 			break
 		}
-
-		if charset := getCharset(string(res.Header.ContentType())); !strings.EqualFold(charset, "utf-8") {
-			output, e := iconv.ConvertString(string(body), charset, "utf-8")
-			if e != nil {
-				xlog.Errorf("convert string %s from %s to %s error: %v", charset, "utf-8", e)
-				*df.bodyString = string(body)
-				return
-			}
-			*df.bodyString = output
-		} else {
-			*df.bodyString = string(body)
-		}
+		*df.bodyString = string(convertCharset(body, responseCharset(res)))
 
 	case typeBytes:
 		if df.bodyBytes == nil {
@@ -403,21 +545,21 @@ func (df *DataFlow) encodeBody(res *fasthttp.Response) (err error) {
 		copy(*df.bodyBytes, body)
 
 	case typeJson:
-		if res.Header.ContentType() == nil {
-			*df.bodyString = string(body)
-		}
-
-		if charset := getCharset(string(res.Header.ContentType())); !strings.EqualFold(charset, "utf-8") {
-			output, e := iconv.ConvertString(string(body), charset, "utf-8")
-			if e != nil {
-				xlog.Errorf("convert string %s from %s to %s error: %v", charset, "utf-8", e)
-				err = json.Unmarshal(body, df.bodyJson)
-				return
+		err = json.Unmarshal(convertCharset(body, responseCharset(res)), df.bodyJson)
+
+	case typeCodec:
+		c := df.bodyCodec
+		if c == nil {
+			// 无法识别的Content-Type按照json解析
+			if c = GetCodec(string(res.Header.ContentType())); c == nil {
+				c = JSONCodec
 			}
-			err = json.Unmarshal([]byte(output), df.bodyJson)
-		} else {
-			err = json.Unmarshal(body, df.bodyJson)
 		}
+		if needsCharsetConversion(c) {
+			body = convertCharset(body, responseCharset(res))
+		}
+		err = c.Unmarshal(body, df.bodyObject)
+
 	default:
 		panic(fmt.Sprintf("wrong bind type %d", df.bodyBindType))
 	}
@@ -425,6 +567,29 @@ func (df *DataFlow) encodeBody(res *fasthttp.Response) (err error) {
 	return
 }
 
+// decodedBody 返回解压后的body
+func (df *DataFlow) decodedBody(res *fasthttp.Response) ([]byte, error) {
+	encoding := res.Header.ContentEncoding()
+	if !df.decompress || len(encoding) == 0 {
+		return res.Body(), nil
+	}
+	r, err := decompressReader(string(encoding), bytes.NewReader(res.Body()))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// convertCharset 将body从charset转换为UTF-8，转换失败时返回原始数据
+func convertCharset(body []byte, charset string) []byte {
+	output, err := decodeCharset(body, charset)
+	if err != nil {
+		xlog.Errorf("convert body from %s to utf-8 error: %v", charset, err)
+		return body
+	}
+	return output
+}
+
 // getCharset 从contentType中获取编码
 func getCharset(contentType string) string {
 	contentType = strings.TrimSpace(strings.ToLower(contentType))
@@ -0,0 +1,233 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func pipe(a, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		io.Copy(a, b)
+		a.Close()
+		wg.Done()
+	}()
+	go func() {
+		io.Copy(b, a)
+		b.Close()
+		wg.Done()
+	}()
+	wg.Wait()
+}
+
+// newTestHTTPProxy 支持CONNECT的http代理，要求使用Basic认证
+func newTestHTTPProxy(t *testing.T, connects *int32) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := parseProxyAuth(r); !ok || user != "u" || pass != "p" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		atomic.AddInt32(connects, 1)
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			target.Close()
+			return
+		}
+		go pipe(conn, target)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func parseProxyAuth(r *http.Request) (string, string, bool) {
+	auth := r.Header.Get("Proxy-Authorization")
+	if auth == "" {
+		return "", "", false
+	}
+	r.Header.Set("Authorization", auth)
+	return r.BasicAuth()
+}
+
+// newTestSocksProxy 只支持无认证CONNECT的socks5代理
+func newTestSocksProxy(t *testing.T, connects *int32) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				buf := make([]byte, 262)
+				// 问候：版本、方法数量、方法列表
+				if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+					conn.Close()
+					return
+				}
+				io.ReadFull(conn, buf[:buf[1]])
+				conn.Write([]byte{5, 0})
+				// 请求：版本、命令、保留、地址类型
+				io.ReadFull(conn, buf[:4])
+				var host string
+				switch buf[3] {
+				case 1:
+					io.ReadFull(conn, buf[:4])
+					host = net.IP(buf[:4]).String()
+				case 3:
+					io.ReadFull(conn, buf[:1])
+					n := int(buf[0])
+					io.ReadFull(conn, buf[:n])
+					host = string(buf[:n])
+				}
+				io.ReadFull(conn, buf[:2])
+				port := binary.BigEndian.Uint16(buf[:2])
+				target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+				if err != nil {
+					conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					conn.Close()
+					return
+				}
+				atomic.AddInt32(connects, 1)
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				pipe(conn, target)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func newTestEchoServer(t *testing.T) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestDial_HTTPProxy(t *testing.T) {
+	var connects int32
+	proxy := newTestHTTPProxy(t, &connects)
+	target := newTestEchoServer(t)
+
+	config := DefaultConfig()
+	config.Dial.Proxy = "http://u:p@" + proxy.Listener.Addr().String()
+	c, err := New(config)
+	assert.Nil(t, err)
+	defer c.Close()
+	var s string
+	_, err = c.Get(target.URL).BindString(&s).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", s)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&connects))
+
+	config.Dial.Proxy = "http://" + proxy.Listener.Addr().String()
+	c, _ = New(config)
+	defer c.Close()
+	_, err = c.Get(target.URL).Do(context.Background())
+	assert.True(t, errors.Is(err, ErrProxyConnect))
+}
+
+func TestDial_SocksProxy(t *testing.T) {
+	var connects int32
+	proxy := newTestSocksProxy(t, &connects)
+	target := newTestEchoServer(t)
+
+	config := DefaultConfig()
+	config.Dial.Proxy = "socks5://" + proxy
+	c, err := New(config)
+	assert.Nil(t, err)
+	defer c.Close()
+	var s string
+	_, err = c.Get(target.URL).BindString(&s).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", s)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&connects))
+}
+
+func TestDial_Hosts(t *testing.T) {
+	var connects int32
+	proxy := newTestHTTPProxy(t, &connects)
+	proxied := newTestEchoServer(t)
+	direct := newTestEchoServer(t)
+
+	config := DefaultConfig()
+	config.Hosts = map[string]HostConfig{
+		proxied.Listener.Addr().String(): {Proxy: "http://u:p@" + proxy.Listener.Addr().String()},
+	}
+	c, err := New(config)
+	assert.Nil(t, err)
+	defer c.Close()
+
+	status, err := c.Get(direct.URL).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.EqualValues(t, 0, atomic.LoadInt32(&connects))
+
+	status, err = c.Get(proxied.URL).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&connects))
+}
+
+func TestDial_DNSCacheAndLocalAddr(t *testing.T) {
+	target := newTestEchoServer(t)
+	_, port, _ := net.SplitHostPort(target.Listener.Addr().String())
+
+	config := DefaultConfig()
+	config.Dial.DNSCache.Enabled = true
+	config.Dial.LocalAddr = "127.0.0.1"
+	c, err := New(config)
+	assert.Nil(t, err)
+	defer c.Close()
+	var lookups int32
+	c.dns.lookupIP = func(_ context.Context, host string) ([]net.IP, error) {
+		atomic.AddInt32(&lookups, 1)
+		return []net.IP{net.ParseIP("::1"), net.ParseIP("127.0.0.1")}, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		// 关闭连接，保证每次请求都重新拨号
+		status, err := c.Get("http://backend.test:"+port).AddHeaderKV("Connection", "close").Do(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, status)
+	}
+	assert.EqualValues(t, 1, atomic.LoadInt32(&lookups))
+
+	config.Dial.LocalAddr = "not an ip"
+	_, err = New(config)
+	assert.Error(t, err)
+}
@@ -0,0 +1,68 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"errors"
+
+	"github.com/NetEase-Media/easy-ngo/discovery"
+	"github.com/valyala/fasthttp"
+)
+
+// SchemeDiscovery 是通过服务发现调用的url协议，例如ngo://user-service/path，
+// host部分为服务名，发送前会替换为负载均衡选出的实例地址
+const SchemeDiscovery = "ngo"
+
+var errServerError = errors.New("server error")
+
+// HashKey 设置一致性哈希使用的key，只在服务发现使用consistent_hash策略时生效
+func (df *DataFlow) HashKey(key string) *DataFlow {
+	df.hashKey = key
+	return df
+}
+
+// resolve 如果是服务发现的url，选择一个实例并改写请求地址。未使用服务发现时返回nil
+func (df *DataFlow) resolve(req *fasthttp.Request) (*discovery.Instance, error) {
+	uri := req.URI()
+	if string(uri.Scheme()) != SchemeDiscovery {
+		return nil, nil
+	}
+	ins, err := discovery.Pick(string(uri.Host()), df.hashKey)
+	if err != nil {
+		return nil, err
+	}
+	scheme := ins.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	uri.SetScheme(scheme)
+	uri.SetHost(ins.Endpoint())
+	return ins, nil
+}
+
+// done 上报请求结果，连接错误和5XX都记为失败。被取消的请求不上报结果
+func done(ins *discovery.Instance, statusCode int, err error) {
+	if ins == nil {
+		return
+	}
+	if isCanceled(err) {
+		ins.Release()
+		return
+	}
+	if err == nil && statusCode >= 500 {
+		err = errServerError
+	}
+	ins.Done(err)
+}
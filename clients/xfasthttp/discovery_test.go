@@ -0,0 +1,57 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/NetEase-Media/easy-ngo/discovery"
+	"github.com/NetEase-Media/easy-ngo/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataFlowDiscovery(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path + "?" + r.URL.RawQuery))
+	}))
+	defer s.Close()
+	u, _ := url.Parse(s.URL)
+	host, port, _ := net.SplitHostPort(u.Host)
+	p, _ := strconv.Atoi(port)
+
+	r, err := discovery.NewResolver(discovery.NewStatic(map[string][]*registry.ServiceInstance{
+		"user-service": {{Name: "user-service", Address: host, Port: p}},
+	}), discovery.DefaultConfig())
+	assert.Nil(t, err)
+	discovery.WithResolver(r)
+	defer discovery.WithResolver(nil)
+
+	var body string
+	code, err := newTestHttpClient().Get("ngo://user-service/users").AddQuery("id", "1").HashKey("1").BindString(&body).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "/users?id=1", body)
+	instances, _ := r.Instances("user-service")
+	assert.Equal(t, int64(0), instances[0].Inflight())
+
+	_, err = newTestHttpClient().Get("ngo://order-service/orders").Do(context.Background())
+	assert.Equal(t, discovery.ErrNoInstance, err)
+}
@@ -0,0 +1,213 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/xlog"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultDNSCacheTTL         = time.Minute
+	defaultDNSCacheIdleTimeout = 10 * time.Minute
+	defaultDNSLookupTimeout    = 3 * time.Second
+)
+
+// DNSCacheConfig DNS缓存配置。缓存在后台定期刷新，刷新失败时继续使用上一次的解析结果
+type DNSCacheConfig struct {
+	// 是否开启DNS缓存
+	Enabled bool
+
+	// 解析结果的有效期，默认1分钟
+	TTL time.Duration
+
+	// 后台刷新的间隔，默认为TTL的一半。即将过期的记录会被提前刷新
+	RefreshInterval time.Duration
+
+	// 超过该时间未使用的记录不再刷新并删除，默认10分钟
+	IdleTimeout time.Duration
+
+	// 单次解析的超时时间，默认3秒
+	LookupTimeout time.Duration
+}
+
+func DefaultDNSCacheConfig() DNSCacheConfig {
+	return DNSCacheConfig{
+		TTL:             defaultDNSCacheTTL,
+		RefreshInterval: defaultDNSCacheTTL / 2,
+		IdleTimeout:     defaultDNSCacheIdleTimeout,
+		LookupTimeout:   defaultDNSLookupTimeout,
+	}
+}
+
+// dnsEntry 创建后ips和expires不再修改，刷新时替换为新的记录
+type dnsEntry struct {
+	ips     []net.IP
+	expires time.Time
+	used    int64 // 最后一次使用的时间，UnixNano
+}
+
+// dnsCache 带有效期的DNS缓存，由同一个客户端的所有连接共用
+type dnsCache struct {
+	config   DNSCacheConfig
+	lookupIP func(ctx context.Context, host string) ([]net.IP, error)
+
+	mu      sync.RWMutex
+	entries map[string]*dnsEntry
+	group   singleflight.Group
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newDNSCache(c *DNSCacheConfig) *dnsCache {
+	config := *c
+	if config.TTL <= 0 {
+		config.TTL = defaultDNSCacheTTL
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = config.TTL / 2
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = defaultDNSCacheIdleTimeout
+	}
+	if config.LookupTimeout <= 0 {
+		config.LookupTimeout = defaultDNSLookupTimeout
+	}
+	d := &dnsCache{
+		config: config,
+		lookupIP: func(ctx context.Context, host string) ([]net.IP, error) {
+			return net.DefaultResolver.LookupIP(ctx, "ip", host)
+		},
+		entries: make(map[string]*dnsEntry),
+		stop:    make(chan struct{}),
+	}
+	go d.watch()
+	return d
+}
+
+// lookup 返回host的IP地址。记录过期时同步解析，解析失败时使用过期的记录
+func (d *dnsCache) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	d.mu.RLock()
+	e := d.entries[host]
+	d.mu.RUnlock()
+	now := time.Now()
+	if e != nil {
+		atomic.StoreInt64(&e.used, now.UnixNano())
+		if now.Before(e.expires) {
+			return e.ips, nil
+		}
+	}
+
+	ch := d.group.DoChan(host, func() (interface{}, error) {
+		return d.resolve(host)
+	})
+	select {
+	case r := <-ch:
+		if r.Err != nil {
+			if e != nil {
+				xlog.Warnf("lookup %s failed, using stale addresses: %v", host, r.Err)
+				return e.ips, nil
+			}
+			return nil, r.Err
+		}
+		return r.Val.([]net.IP), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// resolve 解析host并更新缓存
+func (d *dnsCache) resolve(host string) ([]net.IP, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.LookupTimeout)
+	defer cancel()
+	ips, err := d.lookupIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	now := time.Now()
+	e := &dnsEntry{
+		ips:     ips,
+		expires: now.Add(d.config.TTL),
+		used:    now.UnixNano(),
+	}
+	d.mu.Lock()
+	if old := d.entries[host]; old != nil {
+		e.used = atomic.LoadInt64(&old.used)
+	}
+	d.entries[host] = e
+	d.mu.Unlock()
+	return ips, nil
+}
+
+// watch 定期刷新即将过期的记录，并删除长时间未使用的记录
+func (d *dnsCache) watch() {
+	ticker := time.NewTicker(d.config.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.refresh()
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+func (d *dnsCache) refresh() {
+	now := time.Now()
+	var hosts []string
+	d.mu.Lock()
+	for host, e := range d.entries {
+		if now.Sub(time.Unix(0, atomic.LoadInt64(&e.used))) > d.config.IdleTimeout {
+			delete(d.entries, host)
+			continue
+		}
+		if e.expires.Before(now.Add(d.config.RefreshInterval)) {
+			hosts = append(hosts, host)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, host := range hosts {
+		host := host
+		_, err, _ := d.group.Do(host, func() (interface{}, error) {
+			return d.resolve(host)
+		})
+		if err != nil {
+			xlog.Warnf("refresh dns of %s failed: %v", host, err)
+		}
+	}
+}
+
+func (d *dnsCache) close() {
+	d.stopOnce.Do(func() {
+		close(d.stop)
+	})
+}
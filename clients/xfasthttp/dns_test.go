@@ -0,0 +1,124 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDNSCache(ttl time.Duration, lookup func(host string) ([]net.IP, error)) *dnsCache {
+	d := newDNSCache(&DNSCacheConfig{TTL: ttl, RefreshInterval: time.Hour, IdleTimeout: time.Hour})
+	d.lookupIP = func(_ context.Context, host string) ([]net.IP, error) {
+		return lookup(host)
+	}
+	return d
+}
+
+func TestDNSCache_Lookup(t *testing.T) {
+	var calls int32
+	var fail atomic.Value
+	fail.Store(false)
+	d := newTestDNSCache(50*time.Millisecond, func(host string) ([]net.IP, error) {
+		atomic.AddInt32(&calls, 1)
+		if fail.Load().(bool) {
+			return nil, errors.New("dns down")
+		}
+		return []net.IP{net.ParseIP("10.0.0.1")}, nil
+	})
+	defer d.close()
+
+	ips, err := d.lookup(context.Background(), "example.test")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.1", ips[0].String())
+	_, _ = d.lookup(context.Background(), "example.test")
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+
+	// IP地址不需要解析
+	ips, err = d.lookup(context.Background(), "127.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1", ips[0].String())
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+
+	// 过期后重新解析，失败时使用过期的记录
+	time.Sleep(60 * time.Millisecond)
+	fail.Store(true)
+	ips, err = d.lookup(context.Background(), "example.test")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.1", ips[0].String())
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+
+	_, err = d.lookup(context.Background(), "other.test")
+	assert.Error(t, err)
+}
+
+func TestDNSCache_Refresh(t *testing.T) {
+	var ip atomic.Value
+	ip.Store("10.0.0.1")
+	d := newTestDNSCache(time.Hour, func(host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP(ip.Load().(string))}, nil
+	})
+	defer d.close()
+
+	_, err := d.lookup(context.Background(), "example.test")
+	assert.Nil(t, err)
+	_, err = d.lookup(context.Background(), "idle.test")
+	assert.Nil(t, err)
+
+	// 即将过期的记录被刷新，长时间未使用的记录被删除
+	ip.Store("10.0.0.2")
+	d.mu.Lock()
+	d.entries["example.test"].expires = time.Now()
+	d.entries["idle.test"].used = time.Now().Add(-2 * time.Hour).UnixNano()
+	d.mu.Unlock()
+	d.refresh()
+
+	ips, err := d.lookup(context.Background(), "example.test")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.2", ips[0].String())
+	d.mu.RLock()
+	_, ok := d.entries["idle.test"]
+	d.mu.RUnlock()
+	assert.False(t, ok)
+}
+
+func TestDNSCache_ConcurrentRefresh(t *testing.T) {
+	d := newTestDNSCache(time.Millisecond, func(host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("10.0.0.1")}, nil
+	})
+	defer d.close()
+	_, err := d.lookup(context.Background(), "example.test")
+	assert.Nil(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			d.refresh()
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		ips, err := d.lookup(context.Background(), "example.test")
+		assert.Nil(t, err)
+		assert.Equal(t, "10.0.0.1", ips[0].String())
+	}
+	<-done
+}
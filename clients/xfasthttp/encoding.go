@@ -0,0 +1,86 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/valyala/fasthttp"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+func isUTF8(charset string) bool {
+	return charset == "" || strings.EqualFold(charset, "utf-8") || strings.EqualFold(charset, "utf8")
+}
+
+// decodeCharset 将charset编码的数据转换为UTF-8，支持WHATWG定义的编码名，例如gbk、gb18030、big5
+func decodeCharset(b []byte, charset string) ([]byte, error) {
+	if isUTF8(charset) {
+		return b, nil
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, err
+	}
+	return enc.NewDecoder().Bytes(b)
+}
+
+// charsetReader 将charset编码的数据流转换为UTF-8，用于xml.Decoder.CharsetReader
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	if isUTF8(charset) {
+		return input, nil
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, err
+	}
+	return transform.NewReader(input, enc.NewDecoder()), nil
+}
+
+// responseCharset 返回回复的charset，没有声明时为utf-8
+func responseCharset(res *fasthttp.Response) string {
+	return getCharset(string(res.Header.ContentType()))
+}
+
+// decompressReader 按照Content-Encoding解压数据流
+func decompressReader(encoding string, r io.Reader) (io.Reader, error) {
+	switch strings.ToLower(encoding) {
+	case "", "identity":
+		return r, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		return zlib.NewReader(r)
+	case "br":
+		return brotli.NewReader(r), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", fasthttp.ErrContentEncodingUnsupported, encoding)
+	}
+}
+
+// responseBodyReader 返回回复body的读取流，流式回复直接读取连接
+func responseBodyReader(res *fasthttp.Response) io.Reader {
+	if res.IsBodyStream() {
+		return res.BodyStream()
+	}
+	return bytes.NewReader(res.Body())
+}
@@ -0,0 +1,194 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// formCodec x-www-form-urlencoded格式，支持url.Values、map[string]string、map[string][]string，
+// 以及使用form标签命名字段的结构体，没有标签时使用字段名
+type formCodec struct{}
+
+func (formCodec) ContentType() string {
+	return ContentTypeForm
+}
+
+func (formCodec) Marshal(v interface{}) ([]byte, error) {
+	values, err := formValues(v)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(values.Encode()), nil
+}
+
+func (formCodec) Unmarshal(data []byte, v interface{}) error {
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return err
+	}
+	switch out := v.(type) {
+	case *url.Values:
+		*out = values
+		return nil
+	case *map[string][]string:
+		*out = values
+		return nil
+	case *map[string]string:
+		m := make(map[string]string, len(values))
+		for k := range values {
+			m[k] = values.Get(k)
+		}
+		*out = m
+		return nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("form: unsupported type %T", v)
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		name, ok := formFieldName(rt.Field(i))
+		if !ok {
+			continue
+		}
+		vs, ok := values[name]
+		if !ok || len(vs) == 0 {
+			continue
+		}
+		if err := setFormField(rv.Field(i), vs); err != nil {
+			return fmt.Errorf("form: field %s: %w", rt.Field(i).Name, err)
+		}
+	}
+	return nil
+}
+
+func formValues(v interface{}) (url.Values, error) {
+	switch in := v.(type) {
+	case url.Values:
+		return in, nil
+	case map[string][]string:
+		return in, nil
+	case map[string]string:
+		values := make(url.Values, len(in))
+		for k, s := range in {
+			values.Set(k, s)
+		}
+		return values, nil
+	case map[string]interface{}:
+		values := make(url.Values, len(in))
+		for k, s := range in {
+			values.Set(k, fmt.Sprint(s))
+		}
+		return values, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("form: unsupported type %T", v)
+	}
+	values := make(url.Values)
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		name, ok := formFieldName(rt.Field(i))
+		if !ok {
+			continue
+		}
+		f := rv.Field(i)
+		if f.Kind() == reflect.Slice && f.Type().Elem().Kind() != reflect.Uint8 {
+			for j := 0; j < f.Len(); j++ {
+				values.Add(name, fmt.Sprint(f.Index(j).Interface()))
+			}
+			continue
+		}
+		values.Set(name, fmt.Sprint(f.Interface()))
+	}
+	return values, nil
+}
+
+// formFieldName 返回字段在表单中的名字，忽略未导出和标签为-的字段
+func formFieldName(f reflect.StructField) (string, bool) {
+	if f.PkgPath != "" {
+		return "", false
+	}
+	tag := f.Tag.Get("form")
+	if tag == "-" {
+		return "", false
+	}
+	if name := strings.SplitN(tag, ",", 2)[0]; name != "" {
+		return name, true
+	}
+	return f.Name, true
+}
+
+func setFormField(f reflect.Value, vs []string) error {
+	if f.Kind() == reflect.Slice && f.Type().Elem().Kind() != reflect.Uint8 {
+		s := reflect.MakeSlice(f.Type(), len(vs), len(vs))
+		for i, v := range vs {
+			if err := setFormValue(s.Index(i), v); err != nil {
+				return err
+			}
+		}
+		f.Set(s)
+		return nil
+	}
+	return setFormValue(f, vs[0])
+}
+
+func setFormValue(f reflect.Value, v string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(v)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(v, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(v, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(v, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	case reflect.Slice:
+		// []byte
+		f.SetBytes([]byte(v))
+	default:
+		return fmt.Errorf("unsupported kind %s", f.Kind())
+	}
+	return nil
+}
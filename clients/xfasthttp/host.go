@@ -0,0 +1,116 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// HostConfig 单个host的客户端配置，零值的字段使用全局配置
+type HostConfig struct {
+	MaxConnsPerHost     int
+	MaxIdleConnDuration time.Duration
+	MaxConnDuration     time.Duration
+	ReadTimeout         time.Duration
+	WriteTimeout        time.Duration
+	MaxResponseBodySize int
+	MaxConnWaitTimeout  time.Duration
+
+	// 建立连接的超时时间
+	DialTimeout time.Duration
+
+	// 代理地址，为direct时不使用全局配置的代理
+	Proxy string
+}
+
+// newClient 根据全局配置和host配置创建fasthttp.Client，h为空时只使用全局配置
+func newClient(c *Config, h *HostConfig, dns *dnsCache) (*fasthttp.Client, error) {
+	client := &fasthttp.Client{
+		Name:                      c.Name,
+		NoDefaultUserAgentHeader:  c.NoDefaultUserAgentHeader,
+		TLSConfig:                 c.TLSConfig, // TODO: TLS系列配置需要另外分离
+		MaxConnsPerHost:           c.MaxConnsPerHost,
+		MaxIdleConnDuration:       c.MaxIdleConnDuration,
+		MaxConnDuration:           c.MaxConnDuration,
+		MaxIdemponentCallAttempts: c.MaxIdemponentCallAttempts,
+		ReadBufferSize:            c.ReadBufferSize,
+		WriteBufferSize:           c.WriteBufferSize,
+		ReadTimeout:               c.ReadTimeout,
+		WriteTimeout:              c.WriteTimeout,
+		MaxResponseBodySize:       c.MaxResponseBodySize,
+		MaxConnWaitTimeout:        c.MaxConnWaitTimeout,
+	}
+	dial := c.Dial
+	if h != nil {
+		overrideInt(&client.MaxConnsPerHost, h.MaxConnsPerHost)
+		overrideDuration(&client.MaxIdleConnDuration, h.MaxIdleConnDuration)
+		overrideDuration(&client.MaxConnDuration, h.MaxConnDuration)
+		overrideDuration(&client.ReadTimeout, h.ReadTimeout)
+		overrideDuration(&client.WriteTimeout, h.WriteTimeout)
+		overrideInt(&client.MaxResponseBodySize, h.MaxResponseBodySize)
+		overrideDuration(&client.MaxConnWaitTimeout, h.MaxConnWaitTimeout)
+		overrideDuration(&dial.Timeout, h.DialTimeout)
+		if h.Proxy != "" {
+			dial.Proxy = h.Proxy
+		}
+	}
+	// 没有特殊配置时使用fasthttp默认的dialer
+	if !dial.custom() && dns == nil {
+		client.DialDualStack = dial.DualStack
+		return client, nil
+	}
+	d, err := newDialer(&dial, dns)
+	if err != nil {
+		return nil, err
+	}
+	client.Dial = d.Dial
+	return client, nil
+}
+
+func overrideInt(dst *int, v int) {
+	if v != 0 {
+		*dst = v
+	}
+}
+
+func overrideDuration(dst *time.Duration, v time.Duration) {
+	if v != 0 {
+		*dst = v
+	}
+}
+
+// hostTransport 按照请求的host选择客户端。使用服务发现时host为解析后的实例地址
+type hostTransport struct {
+	clients map[string]*fasthttp.Client // key为host:port或host
+	def     Transport
+}
+
+func (t *hostTransport) RoundTrip(req *fasthttp.Request, res *fasthttp.Response, deadline time.Time) error {
+	host := strings.ToLower(string(req.URI().Host()))
+	client, ok := t.clients[host]
+	if !ok {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			client, ok = t.clients[h]
+		}
+	}
+	if !ok {
+		return t.def.RoundTrip(req, res, deadline)
+	}
+	return (&clientTransport{client: client}).RoundTrip(req, res, deadline)
+}
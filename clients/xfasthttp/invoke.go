@@ -0,0 +1,63 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/server/protocol"
+)
+
+// StatusError 回复的http状态码不是2XX，且body中没有业务错误码
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected http status code %d", e.StatusCode)
+}
+
+// Invoke 发送请求，并将protocol.HttpBody格式的回复中的data解析到out，out为nil时忽略data。
+// 回复中的业务错误码不为0时返回*protocol.Error，供生成的客户端代码使用
+func Invoke(ctx context.Context, df *DataFlow, out interface{}) error {
+	body := protocol.HttpBody{Data: out}
+	statusCode, err := df.BindJson(&body).Do(ctx)
+	if e := body.GetError(); e != nil {
+		return e
+	}
+	if statusCode != 0 && (statusCode < 200 || statusCode > 299) {
+		return &StatusError{StatusCode: statusCode}
+	}
+	return err
+}
+
+// PathParam 将参数格式化并转义为url path的一部分
+func PathParam(v interface{}) string {
+	return url.PathEscape(FormatParam(v))
+}
+
+// FormatParam 将query、header和form参数格式化为字符串，时间使用RFC3339格式
+func FormatParam(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,257 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/xlog"
+	"github.com/NetEase-Media/easy-ngo/xmetrics"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	metricRequestTotal    = "http_client_request_total"
+	metricRequestDuration = "http_client_request_duration"
+	metricOpenConnections = "http_client_open_connections"
+	metricIdleConnections = "http_client_idle_connections"
+	metricPendingRequests = "http_client_pending_requests"
+
+	LABELCLIENT = "client"
+	LABELHOST   = "host"
+	LABELMETHOD = "method"
+	LABELPATH   = "path"
+	LABELSTATUS = "status"
+
+	clientMetricsOnce sync.Once
+	// 创建直方图时使用的分桶
+	clientBucket    xmetrics.Bucket
+	requestTotal    xmetrics.Counter
+	requestDuration xmetrics.Histogram
+	openConnections xmetrics.Gauge
+	idleConnections xmetrics.Gauge
+	pendingRequests xmetrics.Gauge
+)
+
+var (
+	uuidReg  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	hexReg   = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+	digitReg = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// MetricsConfig 客户端监控配置
+type MetricsConfig struct {
+	// 请求耗时（毫秒）的直方图分桶，Count小于1时使用默认分桶。
+	// 直方图在进程内只创建一次，使用第一个开启监控的客户端的分桶，之后的客户端配置不同的分桶不会生效
+	Bucket xmetrics.Bucket
+
+	// 连接池指标的采集间隔
+	PoolInterval time.Duration
+
+	// path最多保留的层级数，超出部分用*代替，防止监控的label过多
+	MaxPathSegments int
+
+	// 自定义path归一化函数，为空时使用NormalizePath
+	PathNormalizer func(path string) string `mapstructure:"-"`
+}
+
+func DefaultMetricsConfig() MetricsConfig {
+	return MetricsConfig{
+		Bucket: xmetrics.Bucket{
+			Start:  1,
+			Factor: 2,
+			Count:  15,
+		},
+		PoolInterval:    10 * time.Second,
+		MaxPathSegments: 5,
+	}
+}
+
+// NormalizePath 将path中的数字、UUID和长十六进制串替换为{id}，并截断过深的层级
+func NormalizePath(path string, maxSegments int) string {
+	if path == "" || path == "/" {
+		return "/"
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if maxSegments > 0 && len(segments) > maxSegments {
+		segments = append(segments[:maxSegments], "*")
+	}
+	for i, s := range segments {
+		if digitReg.MatchString(s) || uuidReg.MatchString(s) || hexReg.MatchString(s) {
+			segments[i] = "{id}"
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// statusClass 将状态码归类为2xx、4xx等，请求失败时为error
+func statusClass(statusCode int, err error) string {
+	if err != nil || statusCode < 100 || statusCode > 599 {
+		return "error"
+	}
+	return string(rune('0'+statusCode/100)) + "xx"
+}
+
+// clientMetrics 记录单个客户端的请求指标，并定时采集每个host的连接池状态
+type clientMetrics struct {
+	name   string
+	config *MetricsConfig
+
+	mu    sync.Mutex
+	hosts map[string]*fasthttp.HostClient
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func newClientMetrics(name string, config *MetricsConfig) *clientMetrics {
+	provider := xmetrics.GetProvider()
+	if provider == nil {
+		return nil
+	}
+	bucket := config.bucket()
+	clientMetricsOnce.Do(func() {
+		clientBucket = bucket
+		requestTotal = provider.NewCounter(metricRequestTotal, LABELCLIENT, LABELHOST, LABELMETHOD, LABELPATH, LABELSTATUS)
+		requestDuration = provider.NewHistogram(metricRequestDuration, exponentialBuckets(bucket.Start, bucket.Factor, bucket.Count),
+			LABELCLIENT, LABELHOST, LABELMETHOD, LABELPATH, LABELSTATUS)
+		openConnections = provider.NewGauge(metricOpenConnections, LABELCLIENT, LABELHOST)
+		idleConnections = provider.NewGauge(metricIdleConnections, LABELCLIENT, LABELHOST)
+		pendingRequests = provider.NewGauge(metricPendingRequests, LABELCLIENT, LABELHOST)
+	})
+	if bucket != clientBucket {
+		xlog.Warnf("http client %s metrics bucket %+v ignored, using %+v", name, bucket, clientBucket)
+	}
+	m := &clientMetrics{
+		name:   name,
+		config: config,
+		hosts:  make(map[string]*fasthttp.HostClient),
+		stop:   make(chan struct{}),
+	}
+	if config.PoolInterval > 0 {
+		go m.watch(config.PoolInterval)
+	}
+	return m
+}
+
+// bucket 返回实际使用的分桶
+func (c *MetricsConfig) bucket() xmetrics.Bucket {
+	if c.Bucket.Count < 1 {
+		return DefaultMetricsConfig().Bucket
+	}
+	return c.Bucket
+}
+
+// check 检查分桶配置，避免创建客户端时panic
+func (c *MetricsConfig) check() error {
+	if b := c.bucket(); b.Start <= 0 || b.Factor <= 1 {
+		return fmt.Errorf("invalid http client metrics bucket %+v", c.Bucket)
+	}
+	return nil
+}
+
+func exponentialBuckets(start, factor float64, count int) []float64 {
+	if count < 1 || start <= 0 || factor <= 1 {
+		panic("invalid http client metrics bucket")
+	}
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// configureClient 作为fasthttp.Client.ConfigureClient，记录每个host的连接池
+func (m *clientMetrics) configureClient(hc *fasthttp.HostClient) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hosts[hc.Addr] = hc
+	return nil
+}
+
+// requestLabels 请求开始时的监控label
+type requestLabels struct {
+	host   string
+	method string
+	path   string
+	start  time.Time
+}
+
+func newRequestLabels(req *fasthttp.Request) requestLabels {
+	uri := req.URI()
+	return requestLabels{
+		host:   string(uri.Host()),
+		method: string(req.Header.Method()),
+		path:   string(uri.Path()),
+		start:  time.Now(),
+	}
+}
+
+// normalizePath 按照配置归一化path，监控和按路由熔断使用相同的规则
+func (c *MetricsConfig) normalizePath(path string) string {
+	if c.PathNormalizer != nil {
+		return c.PathNormalizer(path)
+	}
+	return NormalizePath(path, c.MaxPathSegments)
+}
+
+func (m *clientMetrics) record(labels requestLabels, statusCode int, err error) {
+	path := m.config.normalizePath(labels.path)
+	status := statusClass(statusCode, err)
+	requestTotal.With(LABELCLIENT, m.name, LABELHOST, labels.host, LABELMETHOD, labels.method, LABELPATH, path, LABELSTATUS, status).Inc()
+	requestDuration.With(LABELCLIENT, m.name, LABELHOST, labels.host, LABELMETHOD, labels.method, LABELPATH, path, LABELSTATUS, status).
+		Observe(float64(time.Since(labels.start)) / float64(time.Millisecond))
+}
+
+// collect 采集连接池状态，空闲连接数等于打开的连接数减去正在执行的请求数
+func (m *clientMetrics) collect() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for addr, hc := range m.hosts {
+		open := hc.ConnsCount()
+		pending := hc.PendingRequests()
+		idle := open - pending
+		if idle < 0 {
+			idle = 0
+		}
+		openConnections.With(LABELCLIENT, m.name, LABELHOST, addr).Set(float64(open))
+		idleConnections.With(LABELCLIENT, m.name, LABELHOST, addr).Set(float64(idle))
+		pendingRequests.With(LABELCLIENT, m.name, LABELHOST, addr).Set(float64(pending))
+	}
+}
+
+func (m *clientMetrics) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.collect()
+		}
+	}
+}
+
+func (m *clientMetrics) close() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+}
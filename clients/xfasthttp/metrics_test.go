@@ -0,0 +1,124 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NetEase-Media/easy-ngo/xmetrics"
+	"github.com/NetEase-Media/easy-ngo/xmetrics/contrib/xprometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizePath(t *testing.T) {
+	assert.Equal(t, "/", NormalizePath("", 5))
+	assert.Equal(t, "/users/{id}/orders", NormalizePath("/users/12345/orders", 5))
+	assert.Equal(t, "/items/{id}", NormalizePath("/items/6f1c2a3e-1b2c-4d5e-8f90-123456789abc", 5))
+	assert.Equal(t, "/blob/{id}", NormalizePath("/blob/0123456789abcdef0123", 5))
+	assert.Equal(t, "/a/b/*", NormalizePath("/a/b/c/d", 2))
+}
+
+func TestStatusClass(t *testing.T) {
+	assert.Equal(t, "2xx", statusClass(200, nil))
+	assert.Equal(t, "5xx", statusClass(503, nil))
+	assert.Equal(t, "error", statusClass(0, nil))
+	assert.Equal(t, "error", statusClass(200, errors.New("failed")))
+}
+
+func findMetric(name string, labels map[string]string) *dto.Metric {
+	mfs, _ := prometheus.DefaultGatherer.Gather()
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+	next:
+		for _, m := range mf.GetMetric() {
+			for _, lp := range m.GetLabel() {
+				if v, ok := labels[lp.GetName()]; ok && v != lp.GetValue() {
+					continue next
+				}
+			}
+			return m
+		}
+	}
+	return nil
+}
+
+func TestClientMetrics(t *testing.T) {
+	xmetrics.WithVendor(xprometheus.NewProvider(xprometheus.DefaultConfig()))
+	defer xmetrics.WithVendor(nil)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer s.Close()
+
+	c := DefaultConfig()
+	c.Name = "metrics"
+	c.EnableMetrics = true
+	c.Metrics.PoolInterval = 0
+	client, _ := New(c)
+	defer client.Close()
+	for i := 0; i < 3; i++ {
+		_, err := client.Get(s.URL + "/users/" + string(rune('1'+i))).Do(context.Background())
+		assert.Nil(t, err)
+	}
+
+	labels := map[string]string{
+		LABELCLIENT: "metrics",
+		LABELMETHOD: "GET",
+		LABELPATH:   "/users/{id}",
+		LABELSTATUS: "4xx",
+	}
+	m := findMetric(metricRequestTotal, labels)
+	assert.NotNil(t, m)
+	assert.Equal(t, 3.0, m.GetCounter().GetValue())
+	m = findMetric(metricRequestDuration, labels)
+	assert.NotNil(t, m)
+	assert.Equal(t, uint64(3), m.GetHistogram().GetSampleCount())
+
+	client.metrics.collect()
+	m = findMetric(metricOpenConnections, map[string]string{LABELCLIENT: "metrics"})
+	assert.NotNil(t, m)
+	assert.Equal(t, 1.0, m.GetGauge().GetValue())
+	m = findMetric(metricIdleConnections, map[string]string{LABELCLIENT: "metrics"})
+	assert.NotNil(t, m)
+	assert.Equal(t, 1.0, m.GetGauge().GetValue())
+}
+
+func TestClientMetricsInvalidBucket(t *testing.T) {
+	for _, b := range []xmetrics.Bucket{
+		{Start: 0, Factor: 2, Count: 10},
+		{Start: 1, Factor: 1, Count: 10},
+	} {
+		c := DefaultConfig()
+		c.EnableMetrics = true
+		c.Metrics.Bucket = b
+		_, err := New(c)
+		assert.NotNil(t, err)
+	}
+
+	// Count小于1时使用默认分桶
+	c := DefaultConfig()
+	c.Metrics.Bucket = xmetrics.Bucket{}
+	assert.Nil(t, c.Metrics.check())
+	assert.Equal(t, DefaultMetricsConfig().Bucket, c.Metrics.bucket())
+}
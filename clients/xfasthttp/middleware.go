@@ -0,0 +1,215 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/xlog"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	HeaderKeyAuthorization = "Authorization"
+	HeaderKeyTimestamp     = "X-Timestamp"
+	HeaderKeyContentSHA256 = "X-Content-SHA256"
+
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// Middleware 客户端拦截器，next为后续的调用链。拦截器中请求的query和form已经写入请求，
+// 可以通过DataFlow.Request读取和修改最终发送的请求，不调用next可以直接返回模拟的结果
+type Middleware func(next DoFunc) DoFunc
+
+// Use 注册客户端拦截器，对之后创建的所有DataFlow生效，先注册的在外层。需要在发送请求前调用，不可并发调用
+func (f *Xfasthttp) Use(middlewares ...Middleware) {
+	f.middlewares = append(f.middlewares, middlewares...)
+	f.do = f.chain()
+}
+
+// chain 将拦截器和实际发送请求的函数组合成调用链
+func (f *Xfasthttp) chain() DoFunc {
+	do := DoFunc(func(df *DataFlow, ctx context.Context) (int, error) {
+		return df.doContext(ctx)
+	})
+	for i := len(f.middlewares) - 1; i >= 0; i-- {
+		do = f.middlewares[i](do)
+	}
+	return do
+}
+
+// DebugLog 以debug级别打印请求和回复，body超过maxBody字节时截断，maxBody为0时只打印body长度，小于0时不截断
+func DebugLog(maxBody int) Middleware {
+	return func(next DoFunc) DoFunc {
+		return func(df *DataFlow, ctx context.Context) (int, error) {
+			req := df.Request()
+			method, uri := string(req.Header.Method()), req.URI().String()
+			body := streamBody
+			if !req.IsBodyStream() {
+				body = truncate(req.Body(), maxBody)
+			}
+			xlog.Debugf("http client request %s %s header {%s} body {%s}",
+				method, uri, strings.TrimSpace(req.Header.String()), body)
+			df.OnResponse(func(res *fasthttp.Response) {
+				body := streamBody
+				if !res.IsBodyStream() {
+					body = truncate(res.Body(), maxBody)
+				}
+				xlog.Debugf("http client response %s %s status %d body {%s}", method, uri, res.StatusCode(), body)
+			})
+			start := time.Now()
+			code, err := next(df, ctx)
+			if err != nil {
+				xlog.Debugf("http client request %s %s failed after %s: %v", method, uri, time.Since(start), err)
+			}
+			return code, err
+		}
+	}
+}
+
+func truncate(b []byte, max int) string {
+	if max < 0 || len(b) <= max {
+		return string(b)
+	}
+	return string(b[:max]) + "...(" + strconv.Itoa(len(b)) + " bytes)"
+}
+
+// StaticHeaders 为每个请求设置固定的header，请求中已经设置的header不会被覆盖
+func StaticHeaders(h H) Middleware {
+	return func(next DoFunc) DoFunc {
+		return func(df *DataFlow, ctx context.Context) (int, error) {
+			req := df.Request()
+			for k, arr := range h {
+				if len(req.Header.Peek(k)) > 0 {
+					continue
+				}
+				for _, v := range arr {
+					req.Header.Add(k, v)
+				}
+			}
+			return next(df, ctx)
+		}
+	}
+}
+
+// Bearer 使用固定的token设置Authorization: Bearer <token>
+func Bearer(token string) Middleware {
+	return BearerFunc(func(context.Context) (string, error) {
+		return token, nil
+	})
+}
+
+// BearerFunc 每次请求时调用f获取token，适用于需要定时刷新的token
+func BearerFunc(f func(ctx context.Context) (string, error)) Middleware {
+	return func(next DoFunc) DoFunc {
+		return func(df *DataFlow, ctx context.Context) (int, error) {
+			token, err := f(ctx)
+			if err != nil {
+				return 0, err
+			}
+			df.Request().Header.Set(HeaderKeyAuthorization, "Bearer "+token)
+			return next(df, ctx)
+		}
+	}
+}
+
+// HMACConfig 请求签名配置
+type HMACConfig struct {
+	// 密钥标识，服务端据此查找密钥
+	KeyID string
+
+	// 签名密钥
+	Secret []byte
+
+	// 参与签名的header，名称不区分大小写
+	SignedHeaders []string
+
+	// 签名使用的哈希算法，默认sha256
+	Hash func() hash.Hash
+}
+
+// HMACSign 使用HMAC对请求签名。签名内容依次为method、path、排序后的query、X-Timestamp、body的sha256
+// （流式请求体为UNSIGNED-PAYLOAD）和
+// SignedHeaders中的header，各部分以换行分隔，签名结果以
+// Authorization: HMAC keyId=<KeyID>,signedHeaders=<h1;h2>,signature=<base64>的形式发送
+func HMACSign(config HMACConfig) Middleware {
+	if config.Hash == nil {
+		config.Hash = sha256.New
+	}
+	headers := make([]string, 0, len(config.SignedHeaders))
+	for _, h := range config.SignedHeaders {
+		headers = append(headers, strings.ToLower(h))
+	}
+	return func(next DoFunc) DoFunc {
+		return func(df *DataFlow, ctx context.Context) (int, error) {
+			req := df.Request()
+			req.Header.Set(HeaderKeyTimestamp, strconv.FormatInt(time.Now().Unix(), 10))
+			req.Header.Set(HeaderKeyContentSHA256, contentSHA256(req))
+
+			mac := hmac.New(config.Hash, config.Secret)
+			mac.Write([]byte(StringToSign(req, headers)))
+			req.Header.Set(HeaderKeyAuthorization, "HMAC keyId="+config.KeyID+
+				",signedHeaders="+strings.Join(headers, ";")+
+				",signature="+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+			return next(df, ctx)
+		}
+	}
+}
+
+// contentSHA256 返回body的sha256，流式请求体无法提前计算，使用UNSIGNED-PAYLOAD
+func contentSHA256(req *fasthttp.Request) string {
+	if req.IsBodyStream() {
+		return UnsignedPayload
+	}
+	sum := sha256.Sum256(req.Body())
+	return hex.EncodeToString(sum[:])
+}
+
+// StringToSign 返回HMACSign的签名内容，服务端可以用来校验签名
+func StringToSign(req *fasthttp.Request, signedHeaders []string) string {
+	uri := req.URI()
+	args := make([]string, 0)
+	uri.QueryArgs().VisitAll(func(key, value []byte) {
+		args = append(args, string(key)+"="+string(value))
+	})
+	sort.Strings(args)
+
+	var b strings.Builder
+	b.Write(req.Header.Method())
+	b.WriteByte('\n')
+	b.Write(uri.Path())
+	b.WriteByte('\n')
+	b.WriteString(strings.Join(args, "&"))
+	b.WriteByte('\n')
+	b.Write(req.Header.Peek(HeaderKeyTimestamp))
+	b.WriteByte('\n')
+	b.Write(req.Header.Peek(HeaderKeyContentSHA256))
+	for _, h := range signedHeaders {
+		b.WriteByte('\n')
+		b.WriteString(strings.ToLower(h))
+		b.WriteByte(':')
+		b.Write(req.Header.Peek(h))
+	}
+	return b.String()
+}
@@ -0,0 +1,122 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+func record(name string, calls *[]string) Middleware {
+	return func(next DoFunc) DoFunc {
+		return func(df *DataFlow, ctx context.Context) (int, error) {
+			*calls = append(*calls, name)
+			return next(df, ctx)
+		}
+	}
+}
+
+// capture 不发送请求，记录最终的请求后直接返回
+func capture(req *fasthttp.Request) Middleware {
+	return func(next DoFunc) DoFunc {
+		return func(df *DataFlow, ctx context.Context) (int, error) {
+			df.Request().CopyTo(req)
+			return http.StatusOK, nil
+		}
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var calls []string
+	client, _ := New(DefaultConfig())
+	client.Use(record("a", &calls), record("b", &calls))
+	client.Use(record("c", &calls), capture(&fasthttp.Request{}))
+
+	df := client.Get("http://localhost/")
+	df.WrapDoFunc(func(next DoFunc) DoFunc {
+		return record("df", &calls)(next)
+	})
+	code, err := df.Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"df", "a", "b", "c"}, calls)
+}
+
+func TestStaticHeadersAndBearer(t *testing.T) {
+	var auth, app, trace string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, app, trace = r.Header.Get("Authorization"), r.Header.Get("X-App"), r.Header.Get("X-Trace")
+	}))
+	defer s.Close()
+
+	client, _ := New(DefaultConfig())
+	client.Use(StaticHeaders(H{"X-App": {"ngo"}, "X-Trace": {"static"}}), Bearer("token"), DebugLog(16))
+	_, err := client.Get(s.URL).AddHeaderKV("X-Trace", "custom").Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer token", auth)
+	assert.Equal(t, "ngo", app)
+	assert.Equal(t, "custom", trace)
+}
+
+func TestDebugLogNoLimit(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("response body"))
+	}))
+	defer s.Close()
+
+	client, _ := New(DefaultConfig())
+	client.Use(DebugLog(-1))
+	var body string
+	assert.NotPanics(t, func() {
+		_, err := client.Post(s.URL).SetBody([]byte("request body")).BindString(&body).Do(context.Background())
+		assert.Nil(t, err)
+	})
+	assert.Equal(t, "response body", body)
+}
+
+func TestHMACSign(t *testing.T) {
+	req := &fasthttp.Request{}
+	client, _ := New(DefaultConfig())
+	client.Use(HMACSign(HMACConfig{
+		KeyID:         "k1",
+		Secret:        []byte("secret"),
+		SignedHeaders: []string{"X-App"},
+	}), capture(req))
+	_, err := client.Post("http://localhost/api?b=2&a=1").
+		AddHeaderKV("X-App", "ngo").SetBody([]byte("body")).Do(context.Background())
+	assert.Nil(t, err)
+
+	assert.Equal(t, "b=2&a=1", string(req.URI().QueryString()))
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(StringToSign(req, []string{"x-app"})))
+	expected := "HMAC keyId=k1,signedHeaders=x-app,signature=" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	assert.Equal(t, expected, string(req.Header.Peek(HeaderKeyAuthorization)))
+	assert.Contains(t, StringToSign(req, []string{"x-app"}), "POST\n/api\na=1&b=2\n")
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "abc", truncate([]byte("abc"), 3))
+	assert.Equal(t, "ab...(3 bytes)", truncate([]byte("abc"), 2))
+	assert.Equal(t, "...(3 bytes)", truncate([]byte("abc"), 0))
+	assert.Equal(t, "abc", truncate([]byte("abc"), -1))
+}
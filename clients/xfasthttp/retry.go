@@ -0,0 +1,493 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ErrorClass 是请求错误的分类，用于配置哪些错误需要重试
+type ErrorClass int
+
+const (
+	// ErrorClassTimeout 连接或读写超时
+	ErrorClassTimeout ErrorClass = iota
+	// ErrorClassConnection 连接被拒绝、重置或提前关闭
+	ErrorClassConnection
+	// ErrorClassOther 其他错误
+	ErrorClassOther
+)
+
+// RetryPolicy 请求的重试策略，通过DataFlow.Retry设置
+type RetryPolicy struct {
+	// 最大尝试次数，包含第一次请求
+	MaxAttempts int
+
+	// 第一次重试前的等待时间，之后每次乘以Multiplier，最大不超过MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+
+	// 等待时间的随机抖动比例（0-1），避免大量请求同时重试
+	Jitter float64
+
+	// 需要重试的http状态码
+	RetryOn []int
+
+	// 需要重试的错误类型
+	RetryOnErrors []ErrorClass
+
+	// 是否重试POST、PATCH等非幂等请求，默认只重试GET、HEAD、PUT、DELETE、OPTIONS
+	RetryNonIdempotent bool
+
+	// 对冲请求配置，为空时不开启
+	Hedging *HedgingPolicy
+}
+
+// HedgingPolicy 对冲请求配置。GET请求在延迟超过历史延迟的分位值后，并发发送额外的请求，使用最先成功的结果
+type HedgingPolicy struct {
+	// 延迟分位值（0-1），默认0.95
+	Percentile float64
+
+	// 发送对冲请求前的最小等待时间，统计样本不足时也使用该值
+	MinDelay time.Duration
+
+	// 最多额外发送的请求数，默认1
+	MaxHedges int
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 50 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		RetryOn: []int{
+			fasthttp.StatusBadGateway,
+			fasthttp.StatusServiceUnavailable,
+			fasthttp.StatusGatewayTimeout,
+		},
+		RetryOnErrors: []ErrorClass{ErrorClassTimeout, ErrorClassConnection},
+	}
+}
+
+// backoff 返回第n次重试前的等待时间，n从0开始
+func (p *RetryPolicy) backoff(n int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	d := float64(p.InitialBackoff) * math.Pow(multiplier, float64(n))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(d)
+}
+
+// retryable 判断请求结果是否需要重试
+func (p *RetryPolicy) retryable(req *fasthttp.Request, statusCode int, err error) bool {
+	if !p.RetryNonIdempotent && !isIdempotent(req) {
+		return false
+	}
+	if err != nil {
+		if errors.Is(err, ErrCircuitOpen) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return false
+		}
+		class := classifyError(err)
+		for _, c := range p.RetryOnErrors {
+			if c == class {
+				return true
+			}
+		}
+		return false
+	}
+	return p.retryableStatus(statusCode)
+}
+
+func (p *RetryPolicy) retryableStatus(statusCode int) bool {
+	for _, code := range p.RetryOn {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+func isIdempotent(req *fasthttp.Request) bool {
+	return req.Header.IsGet() || req.Header.IsHead() || req.Header.IsPut() ||
+		req.Header.IsDelete() || req.Header.IsOptions()
+}
+
+func classifyError(err error) ErrorClass {
+	var netErr net.Error
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, fasthttp.ErrTimeout), errors.Is(err, fasthttp.ErrDialTimeout),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorClassTimeout
+	case errors.Is(err, fasthttp.ErrConnectionClosed), errors.Is(err, fasthttp.ErrNoFreeConns),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.As(err, &opErr):
+		return ErrorClassConnection
+	}
+	return ErrorClassOther
+}
+
+// sleepContext 等待d时间，ctx结束时提前返回错误
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// RetryBudgetConfig 客户端级别的重试预算，限制重试请求占正常请求的比例，防止下游故障时重试放大流量
+type RetryBudgetConfig struct {
+	// 是否开启重试预算
+	Enabled bool
+
+	// 重试请求数与正常请求数的最大比例
+	Ratio float64
+
+	// 请求量很小时，每秒至少允许的重试次数
+	MinRetriesPerSecond int
+
+	// 统计窗口时长
+	Window time.Duration
+}
+
+func DefaultRetryBudgetConfig() RetryBudgetConfig {
+	return RetryBudgetConfig{
+		Enabled:             true,
+		Ratio:               0.2,
+		MinRetriesPerSecond: 10,
+		Window:              10 * time.Second,
+	}
+}
+
+const retryBudgetBuckets = 10
+
+type budgetBucket struct {
+	start    time.Time
+	requests int
+	retries  int
+}
+
+// retryBudget 按滑动窗口统计请求数和重试数
+type retryBudget struct {
+	config *RetryBudgetConfig
+
+	mu      sync.Mutex
+	buckets [retryBudgetBuckets]budgetBucket
+}
+
+func newRetryBudget(config *RetryBudgetConfig) *retryBudget {
+	if !config.Enabled || config.Window <= 0 {
+		return nil
+	}
+	return &retryBudget{config: config}
+}
+
+func (b *retryBudget) current(now time.Time) *budgetBucket {
+	d := b.config.Window / retryBudgetBuckets
+	start := now.Truncate(d)
+	bk := &b.buckets[int(start.UnixNano()/int64(d))%retryBudgetBuckets]
+	if !bk.start.Equal(start) {
+		*bk = budgetBucket{start: start}
+	}
+	return bk
+}
+
+// deposit 记录一次正常请求
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.current(time.Now()).requests++
+}
+
+// withdraw 申请一次重试，超出预算时返回false
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	var requests, retries int
+	for _, bk := range b.buckets {
+		if now.Sub(bk.start) >= b.config.Window {
+			continue
+		}
+		requests += bk.requests
+		retries += bk.retries
+	}
+	limit := b.config.Ratio*float64(requests) + float64(b.config.MinRetriesPerSecond)*b.config.Window.Seconds()
+	if float64(retries) >= limit {
+		return false
+	}
+	b.current(now).retries++
+	return true
+}
+
+const (
+	latencySamples = 1024
+	// 样本数少于该值时不使用分位值
+	latencyMinSamples = 100
+)
+
+// latencyTracker 记录最近的请求延迟，用于计算对冲请求的等待时间
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples [latencySamples]time.Duration
+	count   int
+	next    int
+	sorted  []time.Duration
+	// 新增的样本数，超过一定数量后才重新排序
+	dirty int
+}
+
+func (t *latencyTracker) record(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.samples[t.next] = d
+	t.next = (t.next + 1) % latencySamples
+	if t.count < latencySamples {
+		t.count++
+	}
+	t.dirty++
+}
+
+// percentile 返回分位值，样本不足时返回0
+func (t *latencyTracker) percentile(p float64) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.count < latencyMinSamples {
+		return 0
+	}
+	if t.sorted == nil || t.dirty >= latencyMinSamples {
+		t.sorted = append(t.sorted[:0], t.samples[:t.count]...)
+		sort.Slice(t.sorted, func(i, j int) bool { return t.sorted[i] < t.sorted[j] })
+		t.dirty = 0
+	}
+	i := int(p * float64(len(t.sorted)))
+	if i >= len(t.sorted) {
+		i = len(t.sorted) - 1
+	}
+	return t.sorted[i]
+}
+
+// latencyTrackers 按host记录延迟
+type latencyTrackers struct {
+	trackers sync.Map
+}
+
+func (ts *latencyTrackers) get(host string) *latencyTracker {
+	if t, ok := ts.trackers.Load(host); ok {
+		return t.(*latencyTracker)
+	}
+	t, _ := ts.trackers.LoadOrStore(host, &latencyTracker{})
+	return t.(*latencyTracker)
+}
+
+// Retry 设置请求的重试策略，传入nil时不重试
+func (df *DataFlow) Retry(policy *RetryPolicy) *DataFlow {
+	df.retry = policy
+	return df
+}
+
+type attemptResult struct {
+	res *fasthttp.Response
+	err error
+}
+
+// roundTrip 按照重试策略发送请求，结果写入res
+func (df *DataFlow) roundTrip(ctx context.Context, res *fasthttp.Response) error {
+	policy := df.retry
+	// 流式请求体只能读取一次，不重试
+	if policy == nil || df.req.IsBodyStream() {
+		return df.attempt(ctx, df.circuitBreaker(), df.req, res)
+	}
+	if df.budget != nil {
+		df.budget.deposit()
+	}
+	for n := 0; ; n++ {
+		res.Reset()
+		err := df.hedge(ctx, res)
+		if n+1 >= policy.MaxAttempts || !policy.retryable(df.req, res.StatusCode(), err) {
+			return err
+		}
+		if df.budget != nil && !df.budget.withdraw() {
+			return err
+		}
+		if werr := sleepContext(ctx, policy.backoff(n)); werr != nil {
+			return err
+		}
+	}
+}
+
+// hedge 发送一次请求，开启对冲时在等待超过延迟分位值后发送额外的请求，使用最先成功的结果
+func (df *DataFlow) hedge(ctx context.Context, res *fasthttp.Response) error {
+	cb := df.circuitBreaker()
+	hp := df.retry.Hedging
+	if hp == nil || !df.req.Header.IsGet() || df.bodyWriter != nil {
+		req := df.copyRequest()
+		defer fasthttp.ReleaseRequest(req)
+		return df.attempt(ctx, cb, req, res)
+	}
+
+	host := string(df.req.URI().Host())
+	tracker := df.latencies.get(host)
+	percentile := hp.Percentile
+	if percentile <= 0 {
+		percentile = 0.95
+	}
+	delay := tracker.percentile(percentile)
+	if delay < hp.MinDelay {
+		delay = hp.MinDelay
+	}
+	maxHedges := hp.MaxHedges
+	if maxHedges <= 0 {
+		maxHedges = 1
+	}
+
+	// 返回后取消还未完成的请求，释放连接和半开状态的探测名额
+	hctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan attemptResult, maxHedges+1)
+	launched, pending := 0, 0
+	launch := func() {
+		// 请求在当前协程中复制，避免并发读写df.req
+		req := df.copyRequest()
+		launched++
+		pending++
+		go func() {
+			defer fasthttp.ReleaseRequest(req)
+			r := fasthttp.AcquireResponse()
+			start := time.Now()
+			err := df.attempt(hctx, cb, req, r)
+			if err == nil {
+				tracker.record(time.Since(start))
+			}
+			results <- attemptResult{res: r, err: err}
+		}()
+	}
+	// 返回前释放还未完成的请求的结果
+	defer func() {
+		if pending == 0 {
+			return
+		}
+		go func(n int) {
+			for i := 0; i < n; i++ {
+				fasthttp.ReleaseResponse((<-results).res)
+			}
+		}(pending)
+	}()
+
+	var last attemptResult
+	defer func() {
+		if last.res != nil {
+			fasthttp.ReleaseResponse(last.res)
+		}
+	}()
+
+	launch()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			if launched <= maxHedges {
+				launch()
+				timer.Reset(delay)
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil && !df.retry.retryableStatus(r.res.StatusCode()) {
+				r.res.CopyTo(res)
+				fasthttp.ReleaseResponse(r.res)
+				return nil
+			}
+			if last.res != nil {
+				fasthttp.ReleaseResponse(last.res)
+			}
+			last = r
+			if pending == 0 {
+				// 所有请求都失败，使用最后一个结果
+				r.res.CopyTo(res)
+				return r.err
+			}
+		}
+	}
+}
+
+func (df *DataFlow) copyRequest() *fasthttp.Request {
+	req := fasthttp.AcquireRequest()
+	df.req.CopyTo(req)
+	return req
+}
+
+// attempt 经过熔断和服务发现后发送一次请求
+func (df *DataFlow) attempt(ctx context.Context, cb *CircuitBreaker, req *fasthttp.Request, res *fasthttp.Response) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if cb != nil {
+		if err := cb.Allow(); err != nil {
+			return err
+		}
+	}
+	ins, err := df.resolve(req)
+	if err != nil {
+		if cb != nil {
+			cb.Report(true, 0)
+		}
+		return err
+	}
+	res.StreamBody = df.bodyWriter != nil
+	start := time.Now()
+	err = df.send(ctx, req, res)
+	done(ins, res.StatusCode(), err)
+	if cb != nil {
+		// 被取消的请求（如对冲请求中较慢的一个）不代表下游的状态，只归还半开状态的探测名额
+		if isCanceled(err) {
+			cb.Release()
+		} else {
+			cb.Report(isCircuitBreakingFailure(res, err), time.Since(start))
+		}
+	}
+	return err
+}
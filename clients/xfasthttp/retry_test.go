@@ -0,0 +1,174 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := DefaultRetryPolicy()
+	p.Jitter = 0
+	assert.Equal(t, 50*time.Millisecond, p.backoff(0))
+	assert.Equal(t, 100*time.Millisecond, p.backoff(1))
+	assert.Equal(t, time.Second, p.backoff(10))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.backoff(1)
+		assert.True(t, d >= 50*time.Millisecond && d <= 150*time.Millisecond)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	b := newRetryBudget(&RetryBudgetConfig{
+		Enabled:             true,
+		Ratio:               0.5,
+		MinRetriesPerSecond: 0,
+		Window:              time.Second,
+	})
+	for i := 0; i < 4; i++ {
+		b.deposit()
+	}
+	assert.True(t, b.withdraw())
+	assert.True(t, b.withdraw())
+	assert.False(t, b.withdraw())
+}
+
+func newFlakyServer(failures int32, status int) (*httptest.Server, *int32) {
+	var hits int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	return s, &hits
+}
+
+func TestDataFlowRetry(t *testing.T) {
+	s, hits := newFlakyServer(2, http.StatusServiceUnavailable)
+	defer s.Close()
+
+	client, _ := New(DefaultConfig())
+	p := DefaultRetryPolicy()
+	p.InitialBackoff = time.Millisecond
+	var body string
+	code, err := client.Get(s.URL).Retry(p).BindString(&body).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", body)
+	assert.Equal(t, int32(3), atomic.LoadInt32(hits))
+}
+
+func TestDataFlowRetryNonIdempotent(t *testing.T) {
+	s, hits := newFlakyServer(1, http.StatusServiceUnavailable)
+	defer s.Close()
+
+	client, _ := New(DefaultConfig())
+	p := DefaultRetryPolicy()
+	p.InitialBackoff = time.Millisecond
+	code, err := client.Post(s.URL).Retry(p).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(hits))
+}
+
+func TestDataFlowRetryContext(t *testing.T) {
+	s, hits := newFlakyServer(10, http.StatusServiceUnavailable)
+	defer s.Close()
+
+	client, _ := New(DefaultConfig())
+	p := DefaultRetryPolicy()
+	p.MaxAttempts = 10
+	p.InitialBackoff = time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	code, _ := client.Get(s.URL).Retry(p).Do(ctx)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(hits))
+}
+
+func TestDataFlowHedging(t *testing.T) {
+	var hits int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 第一个请求很慢，对冲请求很快返回
+		if atomic.AddInt32(&hits, 1) == 1 {
+			time.Sleep(time.Second)
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer s.Close()
+
+	client, _ := New(DefaultConfig())
+	p := DefaultRetryPolicy()
+	p.Hedging = &HedgingPolicy{MinDelay: 20 * time.Millisecond}
+	var body string
+	start := time.Now()
+	code, err := client.Get(s.URL).Retry(p).BindString(&body).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", body)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+}
+
+func TestDataFlowHedgingCancelLoser(t *testing.T) {
+	var hits, failing int32 = 0, 1
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// 第一个探测请求很慢，对冲请求很快返回
+		if atomic.AddInt32(&hits, 1) == 1 {
+			time.Sleep(time.Second)
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer s.Close()
+
+	c := DefaultConfig()
+	c.CircuitBreaker = *newTestBreakerConfig()
+	client, _ := New(c)
+	for i := 0; i < 4; i++ {
+		_, _ = client.Get(s.URL).Do(context.Background())
+	}
+	cb := client.breakers.get(s.Listener.Addr().String(), "/")
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, StateHalfOpen, cb.State())
+	atomic.StoreInt32(&failing, 0)
+
+	p := DefaultRetryPolicy()
+	p.Hedging = &HedgingPolicy{MinDelay: 20 * time.Millisecond}
+	code, err := client.Get(s.URL).Retry(p).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+
+	// 较慢的请求被取消后归还探测名额，不需要等到它完成
+	assert.Eventually(t, func() bool {
+		return cb.Allow() == nil
+	}, 200*time.Millisecond, 10*time.Millisecond)
+}
@@ -0,0 +1,89 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"context"
+	"errors"
+
+	"github.com/NetEase-Media/easy-ngo/xtracer"
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.14.0"
+	"go.opentelemetry.io/otel/semconv/v1.14.0/httpconv"
+)
+
+const tracerName = "xfasthttp"
+
+// headerCarrier 将fasthttp的请求header适配为propagation.TextMapCarrier
+type headerCarrier struct {
+	header *fasthttp.RequestHeader
+}
+
+func (c headerCarrier) Get(key string) string {
+	return string(c.header.Peek(key))
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c.header.Set(key, value)
+}
+
+func (c headerCarrier) Keys() []string {
+	var keys []string
+	c.header.VisitAll(func(key, _ []byte) {
+		keys = append(keys, string(key))
+	})
+	return keys
+}
+
+// startSpan 创建client span，并将trace信息以W3C traceparent和baggage header注入到请求中。
+// 未开启tracer时只注入ctx中已有的trace信息
+func (df *DataFlow) startSpan(ctx context.Context) (context.Context, xtracer.Span) {
+	var span xtracer.Span
+	if df.tracer != nil {
+		method := string(df.req.Header.Method())
+		uri := df.req.URI()
+		ctx, span = df.tracer.Start(ctx, "HTTP "+method,
+			xtracer.WithSpanKind(xtracer.SpanKindClient),
+			xtracer.WithAttributes(
+				semconv.HTTPMethodKey.String(method),
+				semconv.HTTPURLKey.String(uri.String()),
+				semconv.NetPeerNameKey.String(string(uri.Host())),
+			),
+		)
+	}
+	xtracer.GetTextMapPropagator().Inject(ctx, headerCarrier{header: &df.req.Header})
+	return ctx, span
+}
+
+// endSpan 记录请求结果并结束span
+func endSpan(span xtracer.Span, statusCode int, err error) {
+	if span == nil {
+		return
+	}
+	defer span.End()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(statusCode))
+	span.SetStatus(httpconv.ClientStatus(statusCode))
+}
+
+// isCanceled 判断是否是调用方主动取消的请求，这类请求不计入熔断和异常实例统计
+func isCanceled(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
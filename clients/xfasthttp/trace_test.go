@@ -0,0 +1,98 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/xtracer"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.14.0"
+)
+
+func newSlowServer(d time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(d)
+		w.Write([]byte("ok"))
+	}))
+}
+
+func TestDataFlowContextCancel(t *testing.T) {
+	s := newSlowServer(time.Second)
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := newTestHttpClient().Get(s.URL).Do(ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestDataFlowContextDeadline(t *testing.T) {
+	s := newSlowServer(time.Second)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := newTestHttpClient().Get(s.URL).Timeout(10 * time.Second).Do(ctx)
+	assert.NotNil(t, err)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestDataFlowTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	xtracer.SetTracerProvider(provider)
+	xtracer.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	defer xtracer.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+
+	var traceparent, bag string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		bag = r.Header.Get("baggage")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+
+	c := DefaultConfig()
+	c.EnableTracer = true
+	client, _ := New(c)
+	member, _ := baggage.NewMember("user", "ngo")
+	b, _ := baggage.New(member)
+	ctx := baggage.ContextWithBaggage(context.Background(), b)
+	code, err := client.Get(s.URL).Do(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "HTTP GET", span.Name())
+	assert.Equal(t, codes.Error, span.Status().Code)
+	assert.Contains(t, span.Attributes(), semconv.HTTPStatusCodeKey.Int(http.StatusServiceUnavailable))
+	assert.Contains(t, traceparent, span.SpanContext().TraceID().String())
+	assert.Equal(t, "user=ngo", bag)
+}
@@ -0,0 +1,62 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttp
+
+import (
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Transport 负责实际发送请求，默认使用fasthttp.Client。替换为模拟实现后可以在测试中不依赖真实的服务
+type Transport interface {
+	// RoundTrip 发送请求并将回复写入res，deadline为零值时不超时
+	RoundTrip(req *fasthttp.Request, res *fasthttp.Response, deadline time.Time) error
+}
+
+// TransportFunc 将函数适配为Transport
+type TransportFunc func(req *fasthttp.Request, res *fasthttp.Response, deadline time.Time) error
+
+func (f TransportFunc) RoundTrip(req *fasthttp.Request, res *fasthttp.Response, deadline time.Time) error {
+	return f(req, res, deadline)
+}
+
+type clientTransport struct {
+	client *fasthttp.Client
+}
+
+// NewTransport 返回使用fasthttp.Client发送请求的Transport
+func NewTransport(client *fasthttp.Client) Transport {
+	return &clientTransport{client: client}
+}
+
+func (t *clientTransport) RoundTrip(req *fasthttp.Request, res *fasthttp.Response, deadline time.Time) error {
+	if !deadline.IsZero() {
+		return t.client.DoDeadline(req, res, deadline)
+	}
+
+	// 回复可能有重定向，所以使用DoRedirects发送请求
+	return t.client.DoRedirects(req, res, defaultMaxRedirectsCount)
+}
+
+// SetTransport 替换发送请求的Transport，对之后创建的DataFlow生效
+func (f *Xfasthttp) SetTransport(t Transport) {
+	f.transport = t
+}
+
+// Transport 返回当前使用的Transport
+func (f *Xfasthttp) Transport() Transport {
+	return f.transport
+}
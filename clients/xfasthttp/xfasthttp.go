@@ -15,36 +15,93 @@
 package xfasthttp
 
 import (
+	"strings"
+
+	"github.com/NetEase-Media/easy-ngo/xtracer"
 	"github.com/valyala/fasthttp"
 )
 
 const (
 	// 最大重定向次数
 	defaultMaxRedirectsCount = 16
+	acceptEncoding           = "gzip, deflate, br"
 )
 
 type Xfasthttp struct {
-	client *fasthttp.Client
+	client      *fasthttp.Client
+	hostClients map[string]*fasthttp.Client // 按照host覆盖配置的客户端
+	dns         *dnsCache
+	transport   Transport
+	breakers    *circuitBreakers
+	budget      *retryBudget
+	latencies   *latencyTrackers
+	tracer      xtracer.Tracer
+	metrics     *clientMetrics
+
+	decompress bool // 是否按照Content-Encoding解压回复
+
+	middlewares []Middleware
+	do          DoFunc // 包含所有拦截器的调用链
 }
 
 func New(c *Config) (*Xfasthttp, error) {
-	client := &fasthttp.Client{
-		Name:                      c.Name,
-		NoDefaultUserAgentHeader:  c.NoDefaultUserAgentHeader,
-		TLSConfig:                 c.TLSConfig, // TODO: TLS系列配置需要另外分离
-		MaxConnsPerHost:           c.MaxConnsPerHost,
-		MaxIdleConnDuration:       c.MaxIdleConnDuration,
-		MaxConnDuration:           c.MaxConnDuration,
-		MaxIdemponentCallAttempts: c.MaxIdemponentCallAttempts,
-		ReadBufferSize:            c.ReadBufferSize,
-		WriteBufferSize:           c.WriteBufferSize,
-		ReadTimeout:               c.ReadTimeout,
-		WriteTimeout:              c.WriteTimeout,
-		MaxResponseBodySize:       c.MaxResponseBodySize,
-		MaxConnWaitTimeout:        c.MaxConnWaitTimeout,
+	// 熔断、重试预算等会持有配置的指针，复制一份避免调用方修改或复用配置
+	config := *c
+	c = &config
+	if c.EnableMetrics {
+		if err := c.Metrics.check(); err != nil {
+			return nil, err
+		}
+	}
+	var dns *dnsCache
+	if c.Dial.DNSCache.Enabled {
+		dns = newDNSCache(&c.Dial.DNSCache)
+	}
+	client, err := newClient(c, nil, dns)
+	if err != nil {
+		return nil, err
+	}
+	transport := NewTransport(client)
+	hostClients := make(map[string]*fasthttp.Client, len(c.Hosts))
+	for host, h := range c.Hosts {
+		h := h
+		hc, err := newClient(c, &h, dns)
+		if err != nil {
+			if dns != nil {
+				dns.close()
+			}
+			return nil, err
+		}
+		hostClients[strings.ToLower(host)] = hc
+	}
+	if len(hostClients) > 0 {
+		transport = &hostTransport{clients: hostClients, def: transport}
 	}
 	fhttp := &Xfasthttp{
-		client: client,
+		client:      client,
+		hostClients: hostClients,
+		dns:         dns,
+		transport:   transport,
+		breakers:    newCircuitBreakers(&c.CircuitBreaker, c.Metrics.normalizePath),
+		budget:      newRetryBudget(&c.RetryBudget),
+		latencies:   &latencyTrackers{},
+		decompress:  !c.DisableDecompression,
+	}
+	if c.Compression {
+		fhttp.middlewares = append(fhttp.middlewares, StaticHeaders(H{fasthttp.HeaderAcceptEncoding: {acceptEncoding}}))
+	}
+	fhttp.do = fhttp.chain()
+	if c.EnableMetrics {
+		fhttp.metrics = newClientMetrics(c.Name, &c.Metrics)
+		if fhttp.metrics != nil {
+			client.ConfigureClient = fhttp.metrics.configureClient
+			for _, hc := range hostClients {
+				hc.ConfigureClient = fhttp.metrics.configureClient
+			}
+		}
+	}
+	if c.EnableTracer {
+		fhttp.tracer = xtracer.GetTracer(tracerName)
 	}
 	return fhttp, nil
 }
@@ -81,6 +138,15 @@ func (f *Xfasthttp) Patch(url string) *DataFlow {
 
 func (f *Xfasthttp) Close() {
 	f.client.CloseIdleConnections()
+	for _, hc := range f.hostClients {
+		hc.CloseIdleConnections()
+	}
+	if f.dns != nil {
+		f.dns.close()
+	}
+	if f.metrics != nil {
+		f.metrics.close()
+	}
 }
 
 func (f *Xfasthttp) newDataFlow() *DataFlow {
@@ -20,8 +20,8 @@ import (
 	"net/http/httptest"
 	"testing"
 
-	"github.com/djimenez/iconv-go"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/htmlindex"
 )
 
 func TestGet(t *testing.T) {
@@ -110,9 +110,11 @@ func TestCharset(t *testing.T) {
 		c := r.URL.Query()["charset"]
 		if c != nil || len(c) > 0 {
 			w.Header().Set("Content-Type", "application/json;charset="+c[0])
-			output, err := iconv.ConvertString(string(body), "utf-8", c[0])
+			enc, err := htmlindex.Get(c[0])
 			assert.Nil(t, err)
-			_, err = w.Write([]byte(output))
+			output, err := enc.NewEncoder().Bytes(body)
+			assert.Nil(t, err)
+			_, err = w.Write(output)
 			assert.Nil(t, err)
 		} else {
 			_, err := w.Write(body)
@@ -0,0 +1,236 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttptest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/NetEase-Media/easy-ngo/clients/xfasthttp"
+)
+
+// RecordEnv 设置为非空时，NewRecorder创建的Recorder处于录制模式，用于更新golden文件
+const RecordEnv = "XFASTHTTPTEST_RECORD"
+
+// Mode Recorder的工作模式
+type Mode int
+
+const (
+	// ModeReplay 只从golden文件回放，文件不存在时请求失败
+	ModeReplay Mode = iota
+	// ModeRecord 发送真实请求，并将回复写入golden文件
+	ModeRecord
+	// ModeAuto golden文件存在时回放，否则发送真实请求并录制
+	ModeAuto
+)
+
+const bodyEncodingBase64 = "base64"
+
+// golden golden文件的内容
+type golden struct {
+	Request  goldenRequest  `json:"request"`
+	Response goldenResponse `json:"response"`
+}
+
+type goldenRequest struct {
+	Method       string `json:"method"`
+	URL          string `json:"url"`
+	Body         string `json:"body,omitempty"`
+	BodyEncoding string `json:"bodyEncoding,omitempty"`
+}
+
+type goldenResponse struct {
+	Status       int         `json:"status"`
+	Header       http.Header `json:"header,omitempty"`
+	Body         string      `json:"body,omitempty"`
+	BodyEncoding string      `json:"bodyEncoding,omitempty"`
+}
+
+func encodeBody(b []byte) (string, string) {
+	if utf8.Valid(b) {
+		return string(b), ""
+	}
+	return base64.StdEncoding.EncodeToString(b), bodyEncodingBase64
+}
+
+func decodeBody(s, encoding string) ([]byte, error) {
+	if encoding == bodyEncodingBase64 {
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return []byte(s), nil
+}
+
+// Recorder 录制和回放请求的Transport。每个请求按方法、URL和请求体对应一个golden文件，
+// 录制后的测试不再依赖真实的服务
+type Recorder struct {
+	dir  string
+	mode Mode
+	next xfasthttp.Transport
+}
+
+// NewRecorder 创建保存golden文件到dir的Recorder，录制时通过next发送真实请求，next为空时使用默认的fasthttp.Client。
+// 环境变量XFASTHTTPTEST_RECORD非空时为录制模式，否则为回放模式
+func NewRecorder(dir string, next xfasthttp.Transport) *Recorder {
+	if next == nil {
+		next = xfasthttp.NewTransport(&fasthttp.Client{})
+	}
+	mode := ModeReplay
+	if os.Getenv(RecordEnv) != "" {
+		mode = ModeRecord
+	}
+	return &Recorder{dir: dir, mode: mode, next: next}
+}
+
+// SetMode 设置工作模式
+func (r *Recorder) SetMode(mode Mode) *Recorder {
+	r.mode = mode
+	return r
+}
+
+// Mode 返回当前的工作模式
+func (r *Recorder) Mode() Mode {
+	return r.mode
+}
+
+// Path 返回请求对应的golden文件路径
+func (r *Recorder) Path(req *fasthttp.Request) string {
+	method, uri := string(req.Header.Method()), req.URI()
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write(uri.FullURI())
+	h.Write([]byte{0})
+	h.Write(normalizeBody(req))
+	sum := hex.EncodeToString(h.Sum(nil))[:12]
+
+	name := sanitize(method + "_" + string(uri.Host()) + string(uri.Path()))
+	if len(name) > 80 {
+		name = name[:80]
+	}
+	return filepath.Join(r.dir, name+"_"+sum+".json")
+}
+
+// multipartBoundary 替换multipart请求中随机生成的boundary，保证同样的请求对应同一个golden文件
+const multipartBoundary = "xfasthttptest-boundary"
+
+// normalizeBody 返回用于计算文件名的请求体，multipart请求的boundary被替换为固定值
+func normalizeBody(req *fasthttp.Request) []byte {
+	body := req.Body()
+	mediaType, params, err := mime.ParseMediaType(string(req.Header.ContentType()))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return body
+	}
+	return bytes.ReplaceAll(body, []byte(params["boundary"]), []byte(multipartBoundary))
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+func (r *Recorder) RoundTrip(req *fasthttp.Request, res *fasthttp.Response, deadline time.Time) error {
+	// 流式的请求体只能读取一次，先读出来用于计算文件名和发送
+	if req.IsBodyStream() {
+		req.SetBody(req.Body())
+	}
+	file := r.Path(req)
+	switch r.mode {
+	case ModeReplay:
+		return r.replay(file, res)
+	case ModeAuto:
+		if _, err := os.Stat(file); err == nil {
+			return r.replay(file, res)
+		}
+	}
+	return r.record(file, req, res, deadline)
+}
+
+func (r *Recorder) replay(file string, res *fasthttp.Response) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("xfasthttptest: no golden file for request, run with %s=1 to record: %w", RecordEnv, err)
+	}
+	var g golden
+	if err := json.Unmarshal(b, &g); err != nil {
+		return fmt.Errorf("xfasthttptest: invalid golden file %s: %w", file, err)
+	}
+	body, err := decodeBody(g.Response.Body, g.Response.BodyEncoding)
+	if err != nil {
+		return fmt.Errorf("xfasthttptest: invalid golden file %s: %w", file, err)
+	}
+	res.Reset()
+	res.SetStatusCode(g.Response.Status)
+	for k, vs := range g.Response.Header {
+		for _, v := range vs {
+			res.Header.Add(k, v)
+		}
+	}
+	res.SetBody(body)
+	return nil
+}
+
+func (r *Recorder) record(file string, req *fasthttp.Request, res *fasthttp.Response, deadline time.Time) error {
+	if err := r.next.RoundTrip(req, res, deadline); err != nil {
+		return err
+	}
+
+	g := golden{
+		Request: goldenRequest{
+			Method: string(req.Header.Method()),
+			URL:    req.URI().String(),
+		},
+		Response: goldenResponse{
+			Status: res.StatusCode(),
+			Header: http.Header{},
+		},
+	}
+	g.Request.Body, g.Request.BodyEncoding = encodeBody(req.Body())
+	// 读取流式的回复后会变为普通的body，不影响调用方继续读取
+	g.Response.Body, g.Response.BodyEncoding = encodeBody(res.Body())
+	res.Header.VisitAll(func(k, v []byte) {
+		key := string(k)
+		// 长度由回放时的body决定，Date每次都不同，都不需要保存
+		if key == fasthttp.HeaderContentLength || key == fasthttp.HeaderDate {
+			return
+		}
+		g.Response.Header.Add(key, string(v))
+	})
+
+	b, err := json.MarshalIndent(&g, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(b, '\n'), 0o644)
+}
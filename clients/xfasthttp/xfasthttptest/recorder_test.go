@@ -0,0 +1,110 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttptest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/NetEase-Media/easy-ngo/clients/xfasthttp"
+)
+
+func TestRecorder(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("X-Path", r.URL.Path)
+		w.Write([]byte("hello " + r.URL.Query().Get("name")))
+	}))
+	dir := t.TempDir()
+
+	rec := NewRecorder(dir, nil).SetMode(ModeRecord)
+	c := NewClient(rec)
+	var s string
+	status, err := c.Get(ts.URL+"/hello").AddQuery("name", "a").BindString(&s).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "hello a", s)
+	ts.Close()
+
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1)
+
+	// 服务关闭后仍然可以从golden文件回放
+	c = NewClient(rec.SetMode(ModeReplay))
+	s = ""
+	h := xfasthttp.H{}
+	status, err = c.Get(ts.URL+"/hello").AddQuery("name", "a").BindString(&s).BindHeader(h).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "hello a", s)
+	assert.Equal(t, "/hello", h.Get("X-Path"))
+
+	// 参数不同的请求没有录制过
+	_, err = c.Get(ts.URL+"/hello").AddQuery("name", "b").Do(context.Background())
+	assert.NotNil(t, err)
+}
+
+func TestRecorder_Multipart(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, h, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		b, _ := io.ReadAll(f)
+		w.Write([]byte(r.FormValue("name") + ":" + h.Filename + ":" + string(b)))
+	}))
+	dir := t.TempDir()
+	upload := func(c *xfasthttp.Xfasthttp, content string) (string, error) {
+		var s string
+		_, err := c.Post(ts.URL+"/upload").
+			AddWWWForm("name", "a").
+			AddFormFile("file", "a.txt", strings.NewReader(content)).
+			BindString(&s).Do(context.Background())
+		return s, err
+	}
+
+	rec := NewRecorder(dir, nil).SetMode(ModeRecord)
+	s, err := upload(NewClient(rec), "hello")
+	assert.Nil(t, err)
+	assert.Equal(t, "a:a.txt:hello", s)
+	ts.Close()
+
+	// 每次请求的boundary不同，回放时依然可以找到golden文件
+	c := NewClient(rec.SetMode(ModeReplay))
+	s, err = upload(c, "hello")
+	assert.Nil(t, err)
+	assert.Equal(t, "a:a.txt:hello", s)
+
+	_, err = upload(c, "other")
+	assert.NotNil(t, err)
+}
+
+func TestRecorder_Golden(t *testing.T) {
+	c := NewClient(NewRecorder("testdata", nil).SetMode(ModeAuto))
+	var u user
+	status, err := c.Post("http://api.test/users").SetJson(user{Name: "a"}).BindJson(&u).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, user{ID: 1, Name: "a"}, u)
+}
@@ -0,0 +1,376 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttptest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ErrNoRoute 请求没有匹配到任何路由
+var ErrNoRoute = errors.New("xfasthttptest: no route matched")
+
+// Call 记录收到的一次请求
+type Call struct {
+	Method string
+	URL    string
+	Host   string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+}
+
+// JSON 将请求体按JSON解析到v
+func (c *Call) JSON(v interface{}) error {
+	return json.Unmarshal(c.Body, v)
+}
+
+func newCall(req *fasthttp.Request) *Call {
+	uri := req.URI()
+	c := &Call{
+		Method: string(req.Header.Method()),
+		URL:    uri.String(),
+		Host:   string(uri.Host()),
+		Path:   string(uri.Path()),
+		Query:  url.Values{},
+		Header: http.Header{},
+		Body:   append([]byte(nil), req.Body()...),
+	}
+	uri.QueryArgs().VisitAll(func(k, v []byte) {
+		c.Query.Add(string(k), string(v))
+	})
+	req.Header.VisitAll(func(k, v []byte) {
+		c.Header.Add(string(k), string(v))
+	})
+	return c
+}
+
+// Route 模拟服务的一条路由，通过链式调用设置匹配条件和回复
+type Route struct {
+	method  string
+	pattern string
+	query   url.Values
+	header  http.Header
+
+	status      int
+	replyHeader http.Header
+	body        []byte
+	handler     func(req *fasthttp.Request, res *fasthttp.Response) error
+	err         error
+	delay       time.Duration
+	times       int
+	hits        int
+}
+
+// WithQuery 要求请求带有指定的查询参数
+func (r *Route) WithQuery(key, value string) *Route {
+	r.query.Add(key, value)
+	return r
+}
+
+// WithHeader 要求请求带有指定的头
+func (r *Route) WithHeader(key, value string) *Route {
+	r.header.Add(key, value)
+	return r
+}
+
+// Reply 设置回复的状态码和内容
+func (r *Route) Reply(status int, body string) *Route {
+	r.status = status
+	r.body = []byte(body)
+	return r
+}
+
+// ReplyJSON 设置回复的状态码，并将v序列化为JSON作为回复内容
+func (r *Route) ReplyJSON(status int, v interface{}) *Route {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	r.status = status
+	r.body = b
+	r.replyHeader.Set("Content-Type", "application/json")
+	return r
+}
+
+// ReplyHeader 设置回复的头
+func (r *Route) ReplyHeader(key, value string) *Route {
+	r.replyHeader.Add(key, value)
+	return r
+}
+
+// ReplyFunc 由f生成回复，设置后忽略Reply等静态回复
+func (r *Route) ReplyFunc(f func(req *fasthttp.Request, res *fasthttp.Response) error) *Route {
+	r.handler = f
+	return r
+}
+
+// ReplyError 模拟发送失败，返回err
+func (r *Route) ReplyError(err error) *Route {
+	r.err = err
+	return r
+}
+
+// Delay 延迟d后回复，超过请求的deadline时返回fasthttp.ErrTimeout
+func (r *Route) Delay(d time.Duration) *Route {
+	r.delay = d
+	return r
+}
+
+// Times 路由只匹配n次，之后的请求由其他路由处理。默认不限次数
+func (r *Route) Times(n int) *Route {
+	r.times = n
+	return r
+}
+
+func (r *Route) String() string {
+	method := r.method
+	if method == "" {
+		method = "*"
+	}
+	return method + " " + r.pattern
+}
+
+// matchPath pattern可以是完整的URL或者路径，支持path.Match的通配符
+func (r *Route) matchPath(c *Call) bool {
+	target := c.Path
+	if strings.Contains(r.pattern, "://") {
+		target = strings.SplitN(c.URL, "?", 2)[0]
+	}
+	if ok, _ := path.Match(r.pattern, target); ok {
+		return true
+	}
+	return r.pattern == target
+}
+
+func (r *Route) match(c *Call) bool {
+	if r.times > 0 && r.hits >= r.times {
+		return false
+	}
+	if r.method != "" && r.method != c.Method {
+		return false
+	}
+	if !r.matchPath(c) {
+		return false
+	}
+	for k, vs := range r.query {
+		for _, v := range vs {
+			if !contains(c.Query[k], v) {
+				return false
+			}
+		}
+	}
+	for k, vs := range r.header {
+		for _, v := range vs {
+			if !contains(c.Header.Values(k), v) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (r *Route) reply(req *fasthttp.Request, res *fasthttp.Response, deadline time.Time) error {
+	if r.delay > 0 {
+		if !deadline.IsZero() && time.Until(deadline) < r.delay {
+			time.Sleep(time.Until(deadline))
+			return fasthttp.ErrTimeout
+		}
+		time.Sleep(r.delay)
+	}
+	if r.err != nil {
+		return r.err
+	}
+	res.Reset()
+	if r.handler != nil {
+		return r.handler(req, res)
+	}
+	res.SetStatusCode(r.status)
+	for k, vs := range r.replyHeader {
+		for _, v := range vs {
+			res.Header.Add(k, v)
+		}
+	}
+	res.SetBody(r.body)
+	return nil
+}
+
+func contains(vs []string, v string) bool {
+	for _, s := range vs {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Server 基于路由的模拟服务，实现了xfasthttp.Transport。按注册顺序匹配路由，并记录收到的所有请求
+type Server struct {
+	mu     sync.Mutex
+	routes []*Route
+	calls  []*Call
+}
+
+func NewServer() *Server {
+	return &Server{}
+}
+
+// Handle 注册路由。method为空时匹配所有方法，pattern为路径或完整的URL，支持path.Match的通配符
+func (s *Server) Handle(method, pattern string) *Route {
+	r := &Route{
+		method:      strings.ToUpper(method),
+		pattern:     pattern,
+		query:       url.Values{},
+		header:      http.Header{},
+		status:      http.StatusOK,
+		replyHeader: http.Header{},
+	}
+	s.mu.Lock()
+	s.routes = append(s.routes, r)
+	s.mu.Unlock()
+	return r
+}
+
+func (s *Server) Get(pattern string) *Route {
+	return s.Handle(http.MethodGet, pattern)
+}
+
+func (s *Server) Post(pattern string) *Route {
+	return s.Handle(http.MethodPost, pattern)
+}
+
+func (s *Server) Put(pattern string) *Route {
+	return s.Handle(http.MethodPut, pattern)
+}
+
+func (s *Server) Delete(pattern string) *Route {
+	return s.Handle(http.MethodDelete, pattern)
+}
+
+func (s *Server) Patch(pattern string) *Route {
+	return s.Handle(http.MethodPatch, pattern)
+}
+
+func (s *Server) RoundTrip(req *fasthttp.Request, res *fasthttp.Response, deadline time.Time) error {
+	c := newCall(req)
+	s.mu.Lock()
+	s.calls = append(s.calls, c)
+	var route *Route
+	for _, r := range s.routes {
+		if r.match(c) {
+			route = r
+			r.hits++
+			break
+		}
+	}
+	s.mu.Unlock()
+	if route == nil {
+		return fmt.Errorf("%w: %s %s", ErrNoRoute, c.Method, c.URL)
+	}
+	return route.reply(req, res, deadline)
+}
+
+// Calls 返回收到的所有请求
+func (s *Server) Calls() []*Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Call(nil), s.calls...)
+}
+
+// CallsTo 返回方法和路径匹配的请求，参数含义同Handle
+func (s *Server) CallsTo(method, pattern string) []*Call {
+	r := &Route{method: strings.ToUpper(method), pattern: pattern}
+	var calls []*Call
+	for _, c := range s.Calls() {
+		if r.match(c) {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// LastCall 返回最后一次请求，没有请求时返回nil
+func (s *Server) LastCall() *Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.calls) == 0 {
+		return nil
+	}
+	return s.calls[len(s.calls)-1]
+}
+
+// Reset 清空路由和请求记录
+func (s *Server) Reset() {
+	s.mu.Lock()
+	s.routes = nil
+	s.calls = nil
+	s.mu.Unlock()
+}
+
+// AssertCalled 断言收到过匹配的请求
+func (s *Server) AssertCalled(t testing.TB, method, pattern string) bool {
+	t.Helper()
+	if len(s.CallsTo(method, pattern)) == 0 {
+		t.Errorf("xfasthttptest: expected call to %s %s, got none", method, pattern)
+		return false
+	}
+	return true
+}
+
+// AssertNotCalled 断言没有收到过匹配的请求
+func (s *Server) AssertNotCalled(t testing.TB, method, pattern string) bool {
+	t.Helper()
+	if n := len(s.CallsTo(method, pattern)); n != 0 {
+		t.Errorf("xfasthttptest: expected no call to %s %s, got %d", method, pattern, n)
+		return false
+	}
+	return true
+}
+
+// AssertCallCount 断言匹配的请求次数为n
+func (s *Server) AssertCallCount(t testing.TB, method, pattern string, n int) bool {
+	t.Helper()
+	if got := len(s.CallsTo(method, pattern)); got != n {
+		t.Errorf("xfasthttptest: expected %d calls to %s %s, got %d", n, method, pattern, got)
+		return false
+	}
+	return true
+}
+
+// AssertAllRoutesCalled 断言所有路由都至少被匹配过一次，设置了Times的路由需要匹配满次数
+func (s *Server) AssertAllRoutesCalled(t testing.TB) bool {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ok := true
+	for _, r := range s.routes {
+		if r.hits == 0 || r.hits < r.times {
+			t.Errorf("xfasthttptest: route %s matched %d times", r, r.hits)
+			ok = false
+		}
+	}
+	return ok
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xfasthttptest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+
+	"github.com/NetEase-Media/easy-ngo/clients/xfasthttp"
+)
+
+type user struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestServer_Reply(t *testing.T) {
+	srv := NewServer()
+	srv.Get("/users/*").WithQuery("verbose", "1").ReplyJSON(http.StatusOK, user{ID: 1, Name: "a"})
+	srv.Post("/users").Reply(http.StatusCreated, "")
+	c := NewClient(srv)
+
+	var u user
+	status, err := c.Get("http://api.test/users/1").AddQuery("verbose", "1").BindJson(&u).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, user{ID: 1, Name: "a"}, u)
+
+	status, err = c.Post("http://api.test/users").SetJson(user{Name: "b"}).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, status)
+
+	_, err = c.Get("http://api.test/users/1").Do(context.Background())
+	assert.True(t, errors.Is(err, ErrNoRoute))
+
+	srv.AssertCallCount(t, http.MethodGet, "/users/1", 2)
+	srv.AssertCalled(t, http.MethodPost, "/users")
+	srv.AssertNotCalled(t, http.MethodDelete, "/users/*")
+	srv.AssertAllRoutesCalled(t)
+	assert.Equal(t, "/users/1", srv.LastCall().Path)
+
+	var body user
+	assert.Nil(t, srv.CallsTo(http.MethodPost, "/users")[0].JSON(&body))
+	assert.Equal(t, "b", body.Name)
+	assert.Equal(t, "application/json", srv.CallsTo(http.MethodPost, "/users")[0].Header.Get("Content-Type"))
+}
+
+func TestServer_Times(t *testing.T) {
+	srv := NewServer()
+	srv.Get("/flaky").Times(1).Reply(http.StatusServiceUnavailable, "")
+	srv.Get("/flaky").Reply(http.StatusOK, "ok")
+	c := NewClient(srv)
+
+	status, _ := c.Get("http://api.test/flaky").Do(context.Background())
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	status, _ = c.Get("http://api.test/flaky").Do(context.Background())
+	assert.Equal(t, http.StatusOK, status)
+	srv.AssertAllRoutesCalled(t)
+}
+
+func TestServer_ReplyFuncAndError(t *testing.T) {
+	srv := NewServer()
+	srv.Get("/echo").WithHeader("X-Name", "a").ReplyFunc(func(req *fasthttp.Request, res *fasthttp.Response) error {
+		res.SetBodyString(string(req.Header.Peek("X-Name")))
+		return nil
+	})
+	srv.Get("/down").ReplyError(fasthttp.ErrConnectionClosed)
+	srv.Get("/slow").Delay(time.Second)
+	c := NewClient(srv)
+
+	var s string
+	_, err := c.Get("http://api.test/echo").AddHeaderKV("X-Name", "a").BindString(&s).Do(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "a", s)
+
+	_, err = c.Get("http://api.test/down").Do(context.Background())
+	assert.True(t, errors.Is(err, fasthttp.ErrConnectionClosed))
+
+	_, err = c.Get("http://api.test/slow").Timeout(10 * time.Millisecond).Do(context.Background())
+	assert.True(t, errors.Is(err, fasthttp.ErrTimeout))
+}
+
+func TestInstall(t *testing.T) {
+	c, _ := xfasthttp.New(xfasthttp.DefaultConfig())
+	old := c.Transport()
+	t.Run("install", func(t *testing.T) {
+		srv := NewServer()
+		srv.Get("http://api.test/ping").Reply(http.StatusOK, "pong")
+		Install(t, c, srv)
+
+		status, err := c.Get("http://api.test/ping").Do(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, status)
+	})
+	assert.Equal(t, old, c.Transport())
+}
@@ -0,0 +1,45 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xfasthttptest 提供xfasthttp的测试工具：基于路由的模拟服务、请求断言，以及将真实回复录制为golden文件后离线回放
+package xfasthttptest
+
+import (
+	"testing"
+
+	"github.com/NetEase-Media/easy-ngo/clients/xfasthttp"
+)
+
+// Install 将client的Transport替换为transport，测试结束时恢复。之后由client创建的请求都会经过transport
+//
+//	srv := xfasthttptest.NewServer()
+//	xfasthttptest.Install(t, xfasthttp.DefaultHttpClient(), srv)
+func Install(t testing.TB, client *xfasthttp.Xfasthttp, transport xfasthttp.Transport) {
+	t.Helper()
+	old := client.Transport()
+	client.SetTransport(transport)
+	t.Cleanup(func() {
+		client.SetTransport(old)
+	})
+}
+
+// NewClient 创建使用默认配置和指定Transport的客户端
+func NewClient(transport xfasthttp.Transport) *xfasthttp.Xfasthttp {
+	c, err := xfasthttp.New(xfasthttp.DefaultConfig())
+	if err != nil {
+		panic(err)
+	}
+	c.SetTransport(transport)
+	return c
+}
@@ -46,6 +46,7 @@ func NewClient(opt *Config) *RedisContainer {
 		Redis:     baseClient,
 		Opt:       *opt,
 		redisType: RedisTypeClient,
+		metrics:   addHook(opt, baseClient, opt.Addr[0], baseClient.PoolStats),
 	}
 	return c
 }
@@ -0,0 +1,388 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xredis
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	redisv9 "github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+// invalidateChannel 是服务端发送失效消息的频道
+const invalidateChannel = "__redis__:invalidate"
+
+// 便于测试时替换不支持CLIENT ID和CLIENT TRACKING的服务端
+var (
+	clientID = func(ctx context.Context, cn *redisv9.Conn) (int64, error) {
+		return cn.ClientID(ctx).Result()
+	}
+	clientTracking = func(ctx context.Context, cn *redisv9.Conn, args ...interface{}) error {
+		cmd := redisv9.NewStatusCmd(ctx, args...)
+		_ = cn.Process(ctx, cmd)
+		return cmd.Err()
+	}
+)
+
+// ClientCacheConfig 客户端缓存配置。缓存基于服务端的CLIENT TRACKING，
+// 失效消息通过独立连接订阅__redis__:invalidate频道，RESP2和RESP3都可以使用
+type ClientCacheConfig struct {
+	// 是否开启客户端缓存
+	Enabled bool
+
+	// 最多缓存的key数量，超出时淘汰最久未使用的key
+	MaxEntries int
+
+	// 缓存的最长有效期，作为丢失失效消息时的兜底，为0时不过期
+	TTL time.Duration
+
+	// 广播模式的key前缀，为空时只跟踪读取过的key
+	Prefixes []string
+
+	// 订阅连接出错后的重试间隔
+	RetryInterval time.Duration
+}
+
+func DefaultClientCacheConfig() ClientCacheConfig {
+	return ClientCacheConfig{
+		MaxEntries:    10000,
+		TTL:           time.Minute,
+		RetryInterval: time.Second,
+	}
+}
+
+// ClientCacheStats 客户端缓存的统计信息
+type ClientCacheStats struct {
+	Hits          uint64
+	Misses        uint64
+	Invalidations uint64
+	Entries       int
+}
+
+type cacheEntry struct {
+	key      string
+	value    string
+	nil      bool
+	expireAt time.Time
+	loading  bool
+	// 占位的序号，用于区分同一个key的不同读取
+	gen uint64
+}
+
+// ClientCache 进程内的热点key缓存，服务端的key变化时通过失效消息删除本地缓存
+type ClientCache struct {
+	config    ClientCacheConfig
+	newClient func(func(context.Context, *redisv9.Conn) error) *redisv9.Client
+
+	sub    *redisv9.Client
+	pubsub *redisv9.PubSub
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List
+	redirect int64
+	tracking *redisv9.Client
+	gen      uint64
+
+	group singleflight.Group
+
+	hits          uint64
+	misses        uint64
+	invalidations uint64
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func newClientCache(config *ClientCacheConfig, newClient func(func(context.Context, *redisv9.Conn) error) *redisv9.Client) (*ClientCache, error) {
+	c := &ClientCache{
+		config:    *config,
+		newClient: newClient,
+		entries:   make(map[string]*list.Element),
+		lru:       list.New(),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if c.config.RetryInterval <= 0 {
+		c.config.RetryInterval = DefaultClientCacheConfig().RetryInterval
+	}
+
+	ctx := context.Background()
+	c.sub = newClient(c.onSubscribe)
+	c.pubsub = c.sub.Subscribe(ctx, invalidateChannel)
+	// 等待订阅成功，保证重定向的连接ID已经获取
+	if _, err := c.pubsub.Receive(ctx); err != nil {
+		_ = c.pubsub.Close()
+		_ = c.sub.Close()
+		return nil, err
+	}
+	c.mu.Lock()
+	c.tracking = newClient(c.onTrack)
+	c.mu.Unlock()
+
+	go c.watch()
+	return c, nil
+}
+
+// onSubscribe 记录订阅连接的ID。订阅连接重连后ID会变化，已开启跟踪的连接需要全部重建
+func (c *ClientCache) onSubscribe(ctx context.Context, cn *redisv9.Conn) error {
+	id, err := clientID(ctx, cn)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	old := c.redirect
+	c.redirect = id
+	var stale *redisv9.Client
+	if old != 0 && old != id && c.tracking != nil {
+		stale = c.tracking
+		c.tracking = c.newClient(c.onTrack)
+		c.resetLocked()
+	}
+	c.mu.Unlock()
+	if stale != nil {
+		go stale.Close()
+	}
+	return nil
+}
+
+// onTrack 为读取数据的连接开启跟踪，失效消息重定向到订阅连接
+func (c *ClientCache) onTrack(ctx context.Context, cn *redisv9.Conn) error {
+	c.mu.Lock()
+	redirect := c.redirect
+	c.mu.Unlock()
+
+	args := []interface{}{"client", "tracking", "on", "redirect", redirect}
+	if len(c.config.Prefixes) > 0 {
+		args = append(args, "bcast")
+		for _, prefix := range c.config.Prefixes {
+			args = append(args, "prefix", prefix)
+		}
+	}
+	return clientTracking(ctx, cn, args...)
+}
+
+func (c *ClientCache) watch() {
+	defer close(c.done)
+	for {
+		msg, err := c.pubsub.Receive(context.Background())
+		select {
+		case <-c.stop:
+			return
+		default:
+		}
+		if err != nil {
+			// 失效消息可能丢失，清空缓存
+			c.Flush()
+			// payload为空表示服务端执行了flushdb等命令
+			if strings.HasPrefix(err.Error(), "redis: unsupported pubsub message payload") {
+				continue
+			}
+			select {
+			case <-c.stop:
+				return
+			case <-time.After(c.config.RetryInterval):
+			}
+			continue
+		}
+		if m, ok := msg.(*redisv9.Message); ok && m.Channel == invalidateChannel {
+			if len(m.PayloadSlice) > 0 {
+				c.invalidate(m.PayloadSlice...)
+			} else {
+				c.invalidate(m.Payload)
+			}
+		}
+	}
+}
+
+// Get 优先从本地缓存读取key，未命中时从服务端读取并缓存，不存在的key同样会缓存
+func (c *ClientCache) Get(ctx context.Context, key string) *redisv9.StringCmd {
+	cmd := redisv9.NewStringCmd(ctx, "get", key)
+	if e, ok := c.lookup(key); ok {
+		atomic.AddUint64(&c.hits, 1)
+		setResult(cmd, e)
+		return cmd
+	}
+	atomic.AddUint64(&c.misses, 1)
+	el, flight := c.placeholder(key)
+	v, err, _ := c.group.Do(flight, func() (interface{}, error) {
+		return c.load(ctx, key, el)
+	})
+	if err != nil {
+		cmd.SetErr(err)
+		return cmd
+	}
+	setResult(cmd, v.(*cacheEntry))
+	return cmd
+}
+
+func setResult(cmd *redisv9.StringCmd, e *cacheEntry) {
+	if e.nil {
+		cmd.SetErr(redisv9.Nil)
+		return
+	}
+	cmd.SetVal(e.value)
+}
+
+func (c *ClientCache) lookup(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if e.loading {
+		return nil, false
+	}
+	if !e.expireAt.IsZero() && time.Now().After(e.expireAt) {
+		c.removeLocked(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	// 返回副本，避免与后续的更新冲突
+	v := *e
+	return &v, true
+}
+
+// placeholder 返回key正在读取的占位，没有时放入新的占位。每个占位使用不同的singleflight key，
+// 占位被失效消息删除后到达的Get会重新读取，不会得到失效前读取的结果
+func (c *ClientCache) placeholder(key string) (*list.Element, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		if e := el.Value.(*cacheEntry); e.loading {
+			return el, flightKey(key, e.gen)
+		}
+		c.removeLocked(el)
+	}
+	c.gen++
+	e := &cacheEntry{key: key, loading: true, gen: c.gen}
+	el := c.lru.PushFront(e)
+	c.entries[key] = el
+	c.evictLocked()
+	return el, flightKey(key, e.gen)
+}
+
+func flightKey(key string, gen uint64) string {
+	return key + "\x00" + strconv.FormatUint(gen, 10)
+}
+
+// load 从服务端读取key。读取期间收到失效消息会删除占位，结果不会写入缓存
+func (c *ClientCache) load(ctx context.Context, key string, el *list.Element) (*cacheEntry, error) {
+	e := el.Value.(*cacheEntry)
+	c.mu.Lock()
+	client := c.tracking
+	c.mu.Unlock()
+
+	val, err := client.Get(ctx, key).Result()
+	if err != nil && !errors.Is(err, redisv9.Nil) {
+		c.mu.Lock()
+		if cur, ok := c.entries[key]; ok && cur == el {
+			c.removeLocked(el)
+		}
+		c.mu.Unlock()
+		return nil, err
+	}
+
+	result := &cacheEntry{key: key, value: val, nil: err != nil}
+	c.mu.Lock()
+	if cur, ok := c.entries[key]; ok && cur == el {
+		e.value = result.value
+		e.nil = result.nil
+		e.loading = false
+		if c.config.TTL > 0 {
+			e.expireAt = time.Now().Add(c.config.TTL)
+		}
+	}
+	c.mu.Unlock()
+	return result, nil
+}
+
+func (c *ClientCache) invalidate(keys ...string) {
+	c.mu.Lock()
+	for _, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			c.removeLocked(el)
+			atomic.AddUint64(&c.invalidations, 1)
+		}
+	}
+	c.mu.Unlock()
+}
+
+func (c *ClientCache) evictLocked() {
+	if c.config.MaxEntries <= 0 {
+		return
+	}
+	for c.lru.Len() > c.config.MaxEntries {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+func (c *ClientCache) removeLocked(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
+
+func (c *ClientCache) resetLocked() {
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+// Flush 清空本地缓存
+func (c *ClientCache) Flush() {
+	c.mu.Lock()
+	c.resetLocked()
+	c.mu.Unlock()
+}
+
+// Len 返回本地缓存的key数量
+func (c *ClientCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Stats 返回缓存的命中、未命中和失效次数
+func (c *ClientCache) Stats() ClientCacheStats {
+	return ClientCacheStats{
+		Hits:          atomic.LoadUint64(&c.hits),
+		Misses:        atomic.LoadUint64(&c.misses),
+		Invalidations: atomic.LoadUint64(&c.invalidations),
+		Entries:       c.Len(),
+	}
+}
+
+func (c *ClientCache) close() {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		_ = c.pubsub.Close()
+		<-c.done
+		_ = c.sub.Close()
+		c.mu.Lock()
+		tracking := c.tracking
+		c.resetLocked()
+		c.mu.Unlock()
+		_ = tracking.Close()
+	})
+}
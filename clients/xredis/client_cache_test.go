@@ -0,0 +1,200 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xredis
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	miniredisv2 "github.com/alicebob/miniredis/v2"
+	redisv9 "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockTracking 替换CLIENT ID和CLIENT TRACKING，miniredis不支持这两个命令，失效消息通过PUBLISH模拟
+func mockTracking(t *testing.T) *int64 {
+	var id int64
+	var tracked int64
+	oldID, oldTracking := clientID, clientTracking
+	clientID = func(ctx context.Context, cn *redisv9.Conn) (int64, error) {
+		return atomic.AddInt64(&id, 1), nil
+	}
+	clientTracking = func(ctx context.Context, cn *redisv9.Conn, args ...interface{}) error {
+		atomic.AddInt64(&tracked, 1)
+		return nil
+	}
+	t.Cleanup(func() {
+		clientID, clientTracking = oldID, oldTracking
+	})
+	return &tracked
+}
+
+func newTestCacheClient(t *testing.T, s *miniredisv2.Miniredis, protocol int) *RedisV9Container {
+	opt := DefaultConfig()
+	opt.Name = "test cache client"
+	opt.ConnType = RedisTypeClient
+	opt.Addr = []string{s.Addr()}
+	opt.Protocol = protocol
+	opt.ClientCache.Enabled = true
+	opt.ClientCache.MaxEntries = 2
+	c, err := NewV9(opt)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	return c
+}
+
+func TestClientCache_Invalidate(t *testing.T) {
+	for _, protocol := range []int{2, 3} {
+		tracked := mockTracking(t)
+		s := miniredisv2.RunT(t)
+		ctx := context.Background()
+		c := newTestCacheClient(t, s, protocol)
+		cache := c.Cache()
+
+		assert.NoError(t, s.Set("k1", "v1"))
+		assert.Equal(t, "v1", cache.Get(ctx, "k1").Val())
+		assert.Equal(t, int64(1), atomic.LoadInt64(tracked))
+
+		// 未收到失效消息前读取本地缓存
+		assert.NoError(t, s.Set("k1", "v2"))
+		assert.Equal(t, "v1", cache.Get(ctx, "k1").Val())
+
+		s.Publish(invalidateChannel, "k1")
+		assert.Eventually(t, func() bool {
+			return cache.Get(ctx, "k1").Val() == "v2"
+		}, time.Second, 10*time.Millisecond)
+
+		stats := cache.Stats()
+		assert.Equal(t, uint64(1), stats.Invalidations)
+		assert.True(t, stats.Hits >= 1)
+		assert.True(t, stats.Misses >= 2)
+	}
+}
+
+func TestClientCache_Nil(t *testing.T) {
+	mockTracking(t)
+	s := miniredisv2.RunT(t)
+	ctx := context.Background()
+	cache := newTestCacheClient(t, s, 3).Cache()
+
+	assert.Equal(t, redisv9.Nil, cache.Get(ctx, "missing").Err())
+	assert.NoError(t, s.Set("missing", "v"))
+	assert.Equal(t, redisv9.Nil, cache.Get(ctx, "missing").Err())
+
+	s.Publish(invalidateChannel, "missing")
+	assert.Eventually(t, func() bool {
+		return cache.Get(ctx, "missing").Val() == "v"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestClientCache_Evict(t *testing.T) {
+	mockTracking(t)
+	s := miniredisv2.RunT(t)
+	ctx := context.Background()
+	cache := newTestCacheClient(t, s, 3).Cache()
+
+	for _, k := range []string{"k1", "k2", "k3"} {
+		assert.NoError(t, s.Set(k, k))
+		assert.Equal(t, k, cache.Get(ctx, k).Val())
+	}
+	assert.Equal(t, 2, cache.Len())
+
+	cache.Flush()
+	assert.Equal(t, 0, cache.Len())
+}
+
+func TestClientCache_Resubscribe(t *testing.T) {
+	mockTracking(t)
+	s := miniredisv2.RunT(t)
+	ctx := context.Background()
+	cache := newTestCacheClient(t, s, 3).Cache()
+
+	assert.NoError(t, s.Set("k1", "v1"))
+	assert.Equal(t, "v1", cache.Get(ctx, "k1").Val())
+	tracking := cache.tracking
+
+	// 订阅连接重连后ID变化，需要清空缓存并重建读取连接
+	assert.NoError(t, cache.onSubscribe(ctx, nil))
+	assert.Equal(t, 0, cache.Len())
+	assert.NotSame(t, tracking, cache.tracking)
+	assert.Equal(t, "v1", cache.Get(ctx, "k1").Val())
+}
+
+// blockGetHook 阻塞第一个get命令，模拟读取期间收到失效消息
+type blockGetHook struct {
+	started chan struct{}
+	release chan struct{}
+	blocked int32
+}
+
+func (h *blockGetHook) DialHook(next redisv9.DialHook) redisv9.DialHook {
+	return next
+}
+
+func (h *blockGetHook) ProcessHook(next redisv9.ProcessHook) redisv9.ProcessHook {
+	return func(ctx context.Context, cmd redisv9.Cmder) error {
+		if cmd.Name() != "get" {
+			return next(ctx, cmd)
+		}
+		err := next(ctx, cmd)
+		if atomic.CompareAndSwapInt32(&h.blocked, 0, 1) {
+			close(h.started)
+			<-h.release
+		}
+		return err
+	}
+}
+
+func (h *blockGetHook) ProcessPipelineHook(next redisv9.ProcessPipelineHook) redisv9.ProcessPipelineHook {
+	return next
+}
+
+func TestClientCache_InvalidateDuringLoad(t *testing.T) {
+	mockTracking(t)
+	s := miniredisv2.RunT(t)
+	ctx := context.Background()
+	cache := newTestCacheClient(t, s, 3).Cache()
+	hook := &blockGetHook{started: make(chan struct{}), release: make(chan struct{})}
+	cache.tracking.AddHook(hook)
+
+	assert.NoError(t, s.Set("k", "v1"))
+	first := make(chan string, 1)
+	go func() {
+		first <- cache.Get(ctx, "k").Val()
+	}()
+	<-hook.started
+
+	// 读取期间key被修改并收到失效消息，之后的Get不会加入之前的读取
+	assert.NoError(t, s.Set("k", "v2"))
+	cache.invalidate("k")
+	second := make(chan string, 1)
+	go func() {
+		second <- cache.Get(ctx, "k").Val()
+	}()
+	select {
+	case v := <-second:
+		assert.Equal(t, "v2", v)
+	case <-time.After(time.Second):
+		t.Fatal("get joined the load started before invalidation")
+	}
+
+	close(hook.release)
+	assert.Equal(t, "v1", <-first)
+	assert.Equal(t, "v2", cache.Get(ctx, "k").Val())
+}
@@ -15,6 +15,8 @@
 package xredis
 
 import (
+	"strings"
+
 	"github.com/go-redis/redis/v8"
 )
 
@@ -45,6 +47,8 @@ func NewClusterClient(opt *Config) *RedisContainer {
 		Redis:     baseClient,
 		Opt:       *opt,
 		redisType: RedisTypeCluster,
+		// 集群的连接池指标是所有节点的汇总
+		metrics: addHook(opt, baseClient, strings.Join(opt.Addr, ","), baseClient.PoolStats),
 	}
 	return c
 }
@@ -38,6 +38,18 @@ type Config struct {
 	// 该字段用来兼容旧项目，非特殊情况请勿设置成true，否则在MasterNames顺序变化时会造成分配rehash
 	AutoGenShardName bool
 
+	// 分片权重，key为master名称，默认为1，只当sharded_sentinel 类型使用。
+	// 运行时修改后需要调用RedisContainer.Reshard生效
+	ShardWeights map[string]int
+
+	// 启动时解析master地址的重试次数和间隔，只当sharded_sentinel 类型使用。
+	// 重试后仍然失败的分片先返回ErrMasterUnavailable，后台继续解析
+	SentinelRetries       int
+	SentinelRetryInterval time.Duration
+
+	// 定期从sentinel校对master地址的间隔，防止订阅断开时丢失切换事件，为0时不校对。只当sharded_sentinel 类型使用
+	SentinelCheckInterval time.Duration
+
 	// 用于认证的用户名
 	Username string
 
@@ -59,19 +71,44 @@ type Config struct {
 	WriteTimeout time.Duration
 
 	// 最大连接数
-	PoolSize           int
-	MinIdleConns       int
-	MaxConnAge         time.Duration
-	PoolTimeout        time.Duration
-	IdleTimeout        time.Duration
+	PoolSize     int
+	MinIdleConns int
+	PoolTimeout  time.Duration
+
+	// 连接的最长存活时间和最长空闲时间，v9客户端对应ConnMaxLifetime和ConnMaxIdleTime
+	MaxConnAge  time.Duration
+	IdleTimeout time.Duration
+
+	// 空闲连接的检查间隔，v9客户端已经去掉后台检查，该配置不生效
 	IdleCheckFrequency time.Duration
 
+	// RESP协议版本，可选2或3，只对v9客户端生效，默认为3。服务端不支持HELLO命令时自动使用2
+	Protocol int
+
+	// 客户端缓存配置，只对v9客户端生效
+	ClientCache ClientCacheConfig
+
 	// TODO: 未来增加
 	TLSConfig *tls.Config
+
+	// 是否开启监控，需要先设置xmetrics的Provider
+	EnableMetrics bool
+
+	// 监控配置
+	Metrics MetricsConfig
+
+	// 是否开启链路追踪
+	EnableTracer bool
 }
 
 func DefaultConfig() *Config {
-	return &Config{}
+	return &Config{
+		Metrics:               DefaultMetricsConfig(),
+		ClientCache:           DefaultClientCacheConfig(),
+		SentinelRetries:       3,
+		SentinelRetryInterval: time.Second,
+		SentinelCheckInterval: 30 * time.Second,
+	}
 }
 
 func checkConfig(opt *Config) error {
@@ -81,5 +118,8 @@ func checkConfig(opt *Config) error {
 	if len(opt.Addr) == 0 {
 		return errors.New("empty address")
 	}
+	if opt.Protocol != 0 && opt.Protocol != 2 && opt.Protocol != 3 {
+		return errors.New("protocol must be 2 or 3")
+	}
 	return nil
 }
@@ -0,0 +1,206 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xredis
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/xtracer"
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.14.0"
+)
+
+const (
+	tracerName = "xredis"
+
+	// pipeline的db.statement最多记录的命令数
+	maxPipelineStatementCmds = 10
+)
+
+// hook 实现redis.Hook，记录命令的监控指标和client span
+type hook struct {
+	metrics *clientMetrics
+	tracer  xtracer.Tracer
+	attrs   []attribute.KeyValue
+}
+
+// addHook 按照配置为go-redis客户端增加监控和链路追踪，返回的metrics需要在关闭客户端时停止
+func addHook(opt *Config, client interface{ AddHook(redis.Hook) }, addr string, stats func() *redis.PoolStats) *clientMetrics {
+	h := newHook(opt, addr, stats)
+	if h == nil {
+		return nil
+	}
+	client.AddHook(h)
+	return h.metrics
+}
+
+// newHook 按照配置创建hook，监控和链路追踪都未开启时返回nil
+func newHook(opt *Config, addr string, stats func() *redis.PoolStats) *hook {
+	if !opt.EnableMetrics && !opt.EnableTracer {
+		return nil
+	}
+	h := &hook{}
+	if opt.EnableMetrics {
+		h.metrics = newClientMetrics(opt.Name, addr, &opt.Metrics, stats)
+	}
+	if opt.EnableTracer {
+		h.tracer = xtracer.GetTracer(tracerName)
+		h.attrs = spanAttributes(opt, addr)
+	}
+	if h.metrics == nil && h.tracer == nil {
+		return nil
+	}
+	return h
+}
+
+func spanAttributes(opt *Config, addr string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.DBSystemRedis,
+		semconv.DBRedisDBIndexKey.Int(opt.DB),
+	}
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		attrs = append(attrs, semconv.NetPeerNameKey.String(host))
+		if p, err := strconv.Atoi(port); err == nil {
+			attrs = append(attrs, semconv.NetPeerPortKey.Int(p))
+		}
+	} else if addr != "" {
+		attrs = append(attrs, semconv.NetPeerNameKey.String(addr))
+	}
+	return attrs
+}
+
+func (h *hook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return h.before(ctx, cmd), nil
+}
+
+func (h *hook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.after(ctx, cmd)
+	return nil
+}
+
+func (h *hook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	cs := make([]command, len(cmds))
+	for i, cmd := range cmds {
+		cs[i] = cmd
+	}
+	return h.beforePipeline(ctx, cs), nil
+}
+
+func (h *hook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	cs := make([]command, len(cmds))
+	for i, cmd := range cmds {
+		cs[i] = cmd
+	}
+	h.afterPipeline(ctx, cs)
+	return nil
+}
+
+func (h *hook) before(ctx context.Context, cmd command) context.Context {
+	if h.tracer != nil {
+		ctx, _ = h.tracer.Start(ctx, cmd.FullName(),
+			xtracer.WithSpanKind(xtracer.SpanKindClient),
+			xtracer.WithAttributes(h.attrs...),
+			xtracer.WithAttributes(
+				semconv.DBOperationKey.String(cmd.Name()),
+				semconv.DBStatementKey.String(statement(cmd)),
+			),
+		)
+	}
+	if h.metrics != nil {
+		ctx = withStartTime(ctx)
+	}
+	return ctx
+}
+
+func (h *hook) after(ctx context.Context, cmd command) {
+	if h.metrics != nil {
+		h.metrics.record(cmd, time.Since(startTime(ctx)))
+	}
+	if h.tracer != nil {
+		endSpan(xtracer.SpanFromContext(ctx), cmd.Err())
+	}
+}
+
+func (h *hook) beforePipeline(ctx context.Context, cmds []command) context.Context {
+	if h.tracer != nil {
+		names := make([]string, 0, maxPipelineStatementCmds)
+		for i, cmd := range cmds {
+			if i == maxPipelineStatementCmds {
+				names = append(names, "...")
+				break
+			}
+			names = append(names, statement(cmd))
+		}
+		ctx, _ = h.tracer.Start(ctx, "pipeline",
+			xtracer.WithSpanKind(xtracer.SpanKindClient),
+			xtracer.WithAttributes(h.attrs...),
+			xtracer.WithAttributes(
+				semconv.DBOperationKey.String("pipeline"),
+				semconv.DBStatementKey.String(strings.Join(names, "\n")),
+				attribute.Int("db.redis.num_cmd", len(cmds)),
+			),
+		)
+	}
+	if h.metrics != nil {
+		ctx = withStartTime(ctx)
+	}
+	return ctx
+}
+
+func (h *hook) afterPipeline(ctx context.Context, cmds []command) {
+	if h.metrics != nil {
+		d := time.Since(startTime(ctx))
+		for _, cmd := range cmds {
+			h.metrics.record(cmd, d)
+		}
+	}
+	if h.tracer != nil {
+		var err error
+		for _, cmd := range cmds {
+			if e := cmd.Err(); e != nil && !isNil(e) {
+				err = e
+				break
+			}
+		}
+		endSpan(xtracer.SpanFromContext(ctx), err)
+	}
+}
+
+// statement 只记录命令名和key，不记录可能包含敏感信息的值
+func statement(cmd command) string {
+	args := cmd.Args()
+	if len(args) < 2 {
+		return cmd.Name()
+	}
+	if key, ok := args[1].(string); ok {
+		return cmd.Name() + " " + key
+	}
+	return cmd.Name()
+}
+
+// endSpan 记录命令结果并结束span，redis.Nil不是错误
+func endSpan(span xtracer.Span, err error) {
+	defer span.End()
+	if err != nil && !isNil(err) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
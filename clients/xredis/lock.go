@@ -0,0 +1,475 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xredis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math"
+	mrand "math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/xlog"
+)
+
+var (
+	// ErrNotObtained 锁已被其他持有者占用
+	ErrNotObtained = errors.New("redis lock: not obtained")
+	// ErrLockNotHeld 锁已过期或者被其他持有者占用
+	ErrLockNotHeld = errors.New("redis lock: not held")
+)
+
+var (
+	// KEYS[1]锁，KEYS[2]fencing token计数器；ARGV[1]持有者标识，ARGV[2]过期时间（毫秒）
+	lockScript = NewScript("lock", `
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0`)
+	unlockScript = NewScript("unlock", `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+	// Redlock模式下计数器追平到token，KEYS[1]fencing token计数器；ARGV[1]token
+	fenceScript = NewScript("fence", `
+if tonumber(redis.call("GET", KEYS[1]) or "0") < tonumber(ARGV[1]) then
+	redis.call("SET", KEYS[1], ARGV[1])
+end
+return 1`)
+	renewScript = NewScript("renew", `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+// LockConfig 分布式锁配置
+type LockConfig struct {
+	// key前缀，锁的key为{Prefix}{name}，fencing token计数器为{Prefix}{name}:fence
+	Prefix string
+
+	// 锁的过期时间
+	TTL time.Duration
+
+	// 自动续期的间隔，为0时使用TTL/3，小于0时不自动续期
+	RenewInterval time.Duration
+
+	// Lock等待时第一次重试的间隔，之后每次翻倍，最大不超过MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// 等待时间的随机抖动比例（0-1）
+	Jitter float64
+
+	// Redlock模式下的时钟漂移系数，锁的有效时间需要扣除TTL*DriftFactor
+	DriftFactor float64
+}
+
+func DefaultLockConfig() LockConfig {
+	return LockConfig{
+		Prefix:         "xredis:lock:",
+		TTL:            30 * time.Second,
+		InitialBackoff: 50 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Jitter:         0.2,
+		DriftFactor:    0.01,
+	}
+}
+
+// backoff 返回第n次重试前的等待时间，n从0开始
+func (c *LockConfig) backoff(n int) time.Duration {
+	d := float64(c.InitialBackoff) * math.Pow(2, float64(n))
+	if c.MaxBackoff > 0 && d > float64(c.MaxBackoff) {
+		d = float64(c.MaxBackoff)
+	}
+	if c.Jitter > 0 {
+		d += d * c.Jitter * (mrand.Float64()*2 - 1)
+	}
+	return time.Duration(d)
+}
+
+// Locker 基于redis的分布式锁
+//
+// NewLocker创建的锁只依赖一个redis（client、cluster、sentinel或者按key分片的ShardedClient），
+// NewRedlock创建的锁需要在多数独立的master上加锁成功（Redlock算法）。
+type Locker struct {
+	config  LockConfig
+	redlock bool
+	nodes   func() []Redis
+}
+
+// NewLocker 创建单实例的分布式锁
+func NewLocker(client Redis, config *LockConfig) *Locker {
+	return newLocker(config, false, func() []Redis {
+		return []Redis{client}
+	})
+}
+
+// NewRedlock 创建Redlock模式的分布式锁，clients必须是相互独立的master。
+// ShardedClient（如sharded_sentinel）会展开为各个分片的master，每次加锁时获取最新的master，主从切换后依然有效
+func NewRedlock(config *LockConfig, clients ...Redis) (*Locker, error) {
+	if len(clients) == 0 {
+		return nil, errors.New("redis lock: no redlock nodes")
+	}
+	return newLocker(config, true, func() []Redis {
+		nodes := make([]Redis, 0, len(clients))
+		for _, c := range clients {
+			nodes = append(nodes, redlockNodes(c)...)
+		}
+		return nodes
+	}), nil
+}
+
+func newLocker(config *LockConfig, redlock bool, nodes func() []Redis) *Locker {
+	l := &Locker{
+		config:  DefaultLockConfig(),
+		redlock: redlock,
+		nodes:   nodes,
+	}
+	if config != nil {
+		l.config = *config
+	}
+	if l.config.TTL <= 0 {
+		l.config.TTL = DefaultLockConfig().TTL
+	}
+	if l.config.RenewInterval == 0 {
+		l.config.RenewInterval = l.config.TTL / 3
+	}
+	return l
+}
+
+// redlockNodes 将ShardedClient展开为各个分片的master
+func redlockNodes(c Redis) []Redis {
+	if rc, ok := c.(*RedisContainer); ok {
+		c = rc.Redis
+	}
+	if sc, ok := c.(*ShardedClient); ok {
+		return sc.masters()
+	}
+	return []Redis{c}
+}
+
+// masters 返回当前哈希环上的所有分片，按照分片id排序
+func (c *ShardedClient) masters() []Redis {
+	c.RLock()
+	sis := make([]*ShardInfo, 0, len(c.ring.resources))
+	for _, si := range c.ring.resources {
+		sis = append(sis, si)
+	}
+	c.RUnlock()
+	sort.Slice(sis, func(i, j int) bool {
+		return sis[i].id < sis[j].id
+	})
+	clients := make([]Redis, 0, len(sis))
+	seen := make(map[Redis]bool, len(sis))
+	for _, si := range sis {
+		if !seen[si.client] {
+			seen[si.client] = true
+			clients = append(clients, si.client)
+		}
+	}
+	return clients
+}
+
+// TryLock 尝试加锁一次，锁被占用时返回ErrNotObtained
+func (l *Locker) TryLock(ctx context.Context, name string) (*Lock, error) {
+	value, err := randomValue()
+	if err != nil {
+		return nil, err
+	}
+	lk := &Lock{
+		locker: l,
+		name:   name,
+		key:    l.config.Prefix + "{" + name + "}",
+		value:  value,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		lost:   make(chan struct{}),
+	}
+	lk.fence = lk.key + ":fence"
+
+	start := time.Now()
+	nodes := l.nodes()
+	quorum := l.quorum(len(nodes))
+	var mu sync.Mutex
+	var failed int
+	var firstErr error
+	tokens := make(map[Redis]int64, len(nodes))
+	eachNode(nodes, func(client Redis) {
+		token, err := lockScript.Run(ctx, client, []string{lk.key, lk.fence}, lk.value, l.config.TTL.Milliseconds()).Int64()
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+			return
+		}
+		if token > 0 {
+			tokens[client] = token
+			if token > lk.token {
+				lk.token = token
+			}
+		}
+	})
+	acquired := len(tokens)
+	if acquired >= quorum && l.redlock {
+		acquired = l.syncFence(ctx, lk, tokens)
+	}
+
+	until := start.Add(l.config.TTL - l.drift())
+	if acquired >= quorum && time.Now().Before(until) {
+		lk.until = until
+		if l.config.RenewInterval > 0 {
+			go lk.renew()
+		} else {
+			close(lk.done)
+		}
+		return lk, nil
+	}
+
+	if len(tokens) > 0 {
+		l.release(context.Background(), nodes, lk)
+	}
+	// 可用的节点不足时返回错误，而不是锁被占用
+	if len(nodes)-failed < quorum {
+		return nil, firstErr
+	}
+	return nil, ErrNotObtained
+}
+
+// Lock 加锁，锁被占用时退避重试，直到加锁成功或者ctx结束
+func (l *Locker) Lock(ctx context.Context, name string) (*Lock, error) {
+	for n := 0; ; n++ {
+		lk, err := l.TryLock(ctx, name)
+		if !errors.Is(err, ErrNotObtained) {
+			return lk, err
+		}
+		timer := time.NewTimer(l.config.backoff(n))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// syncFence 将加锁成功的节点上的计数器追平到token，返回计数器不小于token的节点数。
+// 任意两次加锁的多数节点至少有一个重合，重合节点上的计数器保证下一次的token严格大于本次
+func (l *Locker) syncFence(ctx context.Context, lk *Lock, tokens map[Redis]int64) int {
+	var mu sync.Mutex
+	synced := 0
+	nodes := make([]Redis, 0, len(tokens))
+	for client, token := range tokens {
+		if token == lk.token {
+			synced++
+			continue
+		}
+		nodes = append(nodes, client)
+	}
+	if len(nodes) == 0 {
+		return synced
+	}
+	eachNode(nodes, func(client Redis) {
+		if err := fenceScript.Run(ctx, client, []string{lk.fence}, lk.token).Err(); err != nil {
+			return
+		}
+		mu.Lock()
+		synced++
+		mu.Unlock()
+	})
+	return synced
+}
+
+func (l *Locker) quorum(n int) int {
+	if !l.redlock {
+		return 1
+	}
+	return n/2 + 1
+}
+
+// drift 锁的有效时间需要扣除的时钟漂移
+func (l *Locker) drift() time.Duration {
+	if !l.redlock {
+		return 0
+	}
+	return time.Duration(float64(l.config.TTL)*l.config.DriftFactor) + 2*time.Millisecond
+}
+
+// release 在所有节点上释放锁，返回释放成功的节点数
+func (l *Locker) release(ctx context.Context, nodes []Redis, lk *Lock) (int, error) {
+	var mu sync.Mutex
+	var released int
+	var firstErr error
+	eachNode(nodes, func(client Redis) {
+		n, err := unlockScript.Run(ctx, client, []string{lk.key}, lk.value).Int64()
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return
+		}
+		released += int(n)
+	})
+	return released, firstErr
+}
+
+func eachNode(nodes []Redis, fn func(client Redis)) {
+	if len(nodes) == 1 {
+		fn(nodes[0])
+		return
+	}
+	var wg sync.WaitGroup
+	for _, client := range nodes {
+		wg.Add(1)
+		go func(client Redis) {
+			defer wg.Done()
+			fn(client)
+		}(client)
+	}
+	wg.Wait()
+}
+
+func randomValue() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Lock 已经获得的锁
+type Lock struct {
+	locker *Locker
+	name   string
+	key    string
+	fence  string
+	value  string
+	token  int64
+
+	mu    sync.Mutex
+	until time.Time
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+	lostOnce sync.Once
+	lost     chan struct{}
+}
+
+// Name 返回锁名
+func (lk *Lock) Name() string {
+	return lk.name
+}
+
+// Token 返回fencing token，同一个锁每次加锁成功后严格递增。Redlock模式下取各节点计数器的最大值，并在返回前将多数节点的计数器追平。
+// 写入下游存储时带上token，拒绝比已见过的token更小的写入，可以避免持有者暂停导致锁过期后的并发写
+func (lk *Lock) Token() int64 {
+	return lk.token
+}
+
+// Until 返回锁的有效期
+func (lk *Lock) Until() time.Time {
+	lk.mu.Lock()
+	defer lk.mu.Unlock()
+	return lk.until
+}
+
+// Lost 续期失败、锁已经不再被持有时关闭
+func (lk *Lock) Lost() <-chan struct{} {
+	return lk.lost
+}
+
+// Unlock 停止续期并释放锁，锁已过期或者被其他持有者占用时返回ErrLockNotHeld
+func (lk *Lock) Unlock(ctx context.Context) error {
+	released := false
+	lk.stopOnce.Do(func() {
+		close(lk.stop)
+		released = true
+	})
+	if !released {
+		return ErrLockNotHeld
+	}
+	<-lk.done
+
+	l := lk.locker
+	nodes := l.nodes()
+	n, err := l.release(ctx, nodes, lk)
+	if n >= l.quorum(len(nodes)) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return ErrLockNotHeld
+}
+
+// renew 定期续期，直到Unlock或者锁丢失
+func (lk *Lock) renew() {
+	defer close(lk.done)
+	l := lk.locker
+	ticker := time.NewTicker(l.config.RenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lk.stop:
+			return
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), l.config.RenewInterval)
+		nodes := l.nodes()
+		var mu sync.Mutex
+		var renewed, failed int
+		eachNode(nodes, func(client Redis) {
+			n, err := renewScript.Run(ctx, client, []string{lk.key}, lk.value, l.config.TTL.Milliseconds()).Int64()
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed++
+				return
+			}
+			renewed += int(n)
+		})
+		cancel()
+
+		quorum := l.quorum(len(nodes))
+		if renewed >= quorum {
+			lk.mu.Lock()
+			lk.until = start.Add(l.config.TTL - l.drift())
+			lk.mu.Unlock()
+			continue
+		}
+		// 网络错误时在有效期内继续重试，确定锁已丢失或者已过期时停止续期
+		if renewed+failed >= quorum && time.Now().Before(lk.Until()) {
+			continue
+		}
+		xlog.Warnf("redis lock: lock %s lost", lk.name)
+		lk.lostOnce.Do(func() {
+			close(lk.lost)
+		})
+		return
+	}
+}
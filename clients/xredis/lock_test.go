@@ -0,0 +1,199 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xredis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	miniredisv2 "github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLockClient(t *testing.T) (Redis, *miniredisv2.Miniredis) {
+	s := miniredisv2.RunT(t)
+	return NewClient(&Config{Name: "lock", Addr: []string{s.Addr()}}), s
+}
+
+func TestLocker(t *testing.T) {
+	ctx := context.Background()
+	client, s := newTestLockClient(t)
+	defer client.Close()
+	l := NewLocker(client, &LockConfig{Prefix: "lock:", TTL: time.Second, RenewInterval: -1})
+
+	lk, err := l.TryLock(ctx, "job")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), lk.Token())
+	assert.True(t, s.Exists("lock:{job}"))
+	assert.Equal(t, time.Second, s.TTL("lock:{job}"))
+
+	_, err = l.TryLock(ctx, "job")
+	assert.Equal(t, ErrNotObtained, err)
+
+	assert.NoError(t, lk.Unlock(ctx))
+	assert.Equal(t, ErrLockNotHeld, lk.Unlock(ctx))
+	assert.False(t, s.Exists("lock:{job}"))
+
+	lk, err = l.TryLock(ctx, "job")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), lk.Token())
+
+	// 锁过期后被其他持有者占用
+	s.FastForward(time.Second)
+	other, err := l.TryLock(ctx, "job")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), other.Token())
+	assert.Equal(t, ErrLockNotHeld, lk.Unlock(ctx))
+	assert.NoError(t, other.Unlock(ctx))
+}
+
+func TestLocker_Wait(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newTestLockClient(t)
+	defer client.Close()
+	l := NewLocker(client, &LockConfig{TTL: time.Second, RenewInterval: -1, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond})
+
+	lk, err := l.Lock(ctx, "job")
+	assert.NoError(t, err)
+
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	_, err = l.Lock(timeout, "job")
+	cancel()
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		lk.Unlock(ctx)
+	}()
+	timeout, cancel = context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	lk, err = l.Lock(timeout, "job")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), lk.Token())
+	assert.NoError(t, lk.Unlock(ctx))
+}
+
+func TestLocker_Renew(t *testing.T) {
+	ctx := context.Background()
+	client, s := newTestLockClient(t)
+	defer client.Close()
+	l := NewLocker(client, &LockConfig{TTL: time.Second, RenewInterval: 20 * time.Millisecond})
+
+	lk, err := l.TryLock(ctx, "job")
+	assert.NoError(t, err)
+	s.FastForward(900 * time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return s.TTL("{job}") > 900*time.Millisecond
+	}, time.Second, 10*time.Millisecond)
+
+	// 锁被删除后续期失败
+	s.Del("{job}")
+	select {
+	case <-lk.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("lock not lost")
+	}
+	assert.Equal(t, ErrLockNotHeld, lk.Unlock(ctx))
+}
+
+func TestRedlock(t *testing.T) {
+	ctx := context.Background()
+	clients := make([]Redis, 3)
+	servers := make([]*miniredisv2.Miniredis, 3)
+	for i := range clients {
+		clients[i], servers[i] = newTestLockClient(t)
+		defer clients[i].Close()
+	}
+	l, err := NewRedlock(&LockConfig{TTL: time.Second, RenewInterval: -1}, clients...)
+	assert.NoError(t, err)
+
+	lk, err := l.TryLock(ctx, "job")
+	assert.NoError(t, err)
+	for _, s := range servers {
+		assert.True(t, s.Exists("{job}"))
+	}
+	assert.NoError(t, lk.Unlock(ctx))
+
+	// 多数节点被占用时加锁失败，并释放已获得的节点
+	servers[0].Set("{job}", "other")
+	servers[1].Set("{job}", "other")
+	_, err = l.TryLock(ctx, "job")
+	assert.Equal(t, ErrNotObtained, err)
+	assert.False(t, servers[2].Exists("{job}"))
+
+	// 少数节点不可用时依然可以加锁，token取各节点的最大值
+	servers[0].Del("{job}")
+	servers[1].Del("{job}")
+	servers[1].Incr("{job}:fence", 10)
+	servers[2].Close()
+	lk, err = l.TryLock(ctx, "job")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), lk.Token())
+	assert.NoError(t, lk.Unlock(ctx))
+
+	servers[1].Close()
+	_, err = l.TryLock(ctx, "job")
+	assert.Error(t, err)
+	assert.NotEqual(t, ErrNotObtained, err)
+
+	_, err = NewRedlock(nil)
+	assert.Error(t, err)
+}
+
+func TestRedlock_Sharded(t *testing.T) {
+	ctx := context.Background()
+	do(func(client Redis) {
+		l, err := NewRedlock(&LockConfig{TTL: time.Second, RenewInterval: -1}, client)
+		assert.NoError(t, err)
+		assert.Len(t, l.nodes(), 3)
+
+		lk, err := l.TryLock(ctx, "job")
+		assert.NoError(t, err)
+		for _, node := range l.nodes() {
+			assert.Equal(t, int64(1), node.Exists(ctx, "{job}").Val())
+		}
+		assert.NoError(t, lk.Unlock(ctx))
+	})
+}
+
+func TestRedlock_FenceAcrossQuorums(t *testing.T) {
+	ctx := context.Background()
+	clients := make([]Redis, 3)
+	servers := make([]*miniredisv2.Miniredis, 3)
+	for i := range clients {
+		clients[i], servers[i] = newTestLockClient(t)
+		defer clients[i].Close()
+	}
+	l, err := NewRedlock(&LockConfig{TTL: time.Second, RenewInterval: -1}, clients...)
+	assert.NoError(t, err)
+	servers[0].Set("{job}:fence", "5")
+
+	// 第一次在A、B上加锁
+	servers[2].Set("{job}", "other")
+	first, err := l.TryLock(ctx, "job")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), first.Token())
+	assert.NoError(t, first.Unlock(ctx))
+	servers[2].Del("{job}")
+
+	// 第二次在B、C上加锁，token依然大于第一次
+	servers[0].Set("{job}", "other")
+	second, err := l.TryLock(ctx, "job")
+	assert.NoError(t, err)
+	assert.Greater(t, second.Token(), first.Token())
+	assert.NoError(t, second.Unlock(ctx))
+}
@@ -17,6 +17,9 @@ package xredis
 import (
 	"os"
 	"testing"
+
+	"github.com/NetEase-Media/easy-ngo/xlog"
+	"github.com/NetEase-Media/easy-ngo/xlog/contrib/xstdout"
 )
 
 func TestMain(m *testing.M) {
@@ -27,6 +30,7 @@ func TestMain(m *testing.M) {
 }
 
 func setupTest() {
+	xlog.WithVendor(xstdout.New())
 }
 
 func tearDownTest() {
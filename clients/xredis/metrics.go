@@ -0,0 +1,323 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xredis
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/xmetrics"
+	"github.com/go-redis/redis/v8"
+	redisv9 "github.com/redis/go-redis/v9"
+)
+
+var (
+	metricCommandTotal    = "redis_client_command_total"
+	metricCommandDuration = "redis_client_command_duration"
+	metricReadTotal       = "redis_client_read_total"
+	metricPoolStats       = "redis_client_pool_stats"
+	metricSentinelEvent   = "redis_client_sentinel_event_total"
+	metricQueueBacklog    = "redis_queue_backlog"
+	metricQueueMessages   = "redis_queue_messages_total"
+	metricQueueDuration   = "redis_queue_process_duration"
+
+	LABELCLIENT  = "client"
+	LABELCOMMAND = "command"
+	LABELSTATUS  = "status"
+	LABELRESULT  = "result"
+	LABELADDR    = "addr"
+	LABELSTAT    = "stat"
+	LABELMASTER  = "master"
+	LABELEVENT   = "event"
+	LABELQUEUE   = "queue"
+	LABELSTATE   = "state"
+
+	clientMetricsOnce sync.Once
+	commandTotal      xmetrics.Counter
+	commandDuration   xmetrics.Histogram
+	readTotal         xmetrics.Counter
+	poolStats         xmetrics.Gauge
+
+	sentinelMetricsOnce sync.Once
+	sentinelEvents      xmetrics.Counter
+
+	queueMetricsOnce sync.Once
+	queueBacklog     xmetrics.Gauge
+	queueMessages    xmetrics.Counter
+	queueDuration    xmetrics.Histogram
+)
+
+const (
+	statusOK    = "ok"
+	statusNil   = "nil"
+	statusError = "error"
+
+	resultHit  = "hit"
+	resultMiss = "miss"
+
+	sentinelEventSwitchMaster = "switch_master"
+	sentinelEventResolveError = "resolve_error"
+
+	queueResultAck   = "ack"
+	queueResultRetry = "retry"
+	queueResultDead  = "dead"
+)
+
+// readCommands 统计命中率的读命令，返回redis.Nil或空值时记为未命中
+var readCommands = map[string]bool{
+	"get":     true,
+	"getex":   true,
+	"getdel":  true,
+	"hget":    true,
+	"hgetall": true,
+	"mget":    true,
+	"hmget":   true,
+	"lindex":  true,
+	"zscore":  true,
+}
+
+type MetricsConfig struct {
+	// 命令耗时（毫秒）的直方图分桶
+	Bucket xmetrics.Bucket
+
+	// 连接池指标的采集间隔，为0时不采集
+	PoolInterval time.Duration
+}
+
+func DefaultMetricsConfig() MetricsConfig {
+	return MetricsConfig{
+		Bucket: xmetrics.Bucket{
+			Start:  0.5,
+			Factor: 2,
+			Count:  14,
+		},
+		PoolInterval: 10 * time.Second,
+	}
+}
+
+// clientMetrics 记录一个go-redis客户端的命令和连接池指标
+type clientMetrics struct {
+	name  string
+	addr  string
+	stats func() *redis.PoolStats
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func newClientMetrics(name, addr string, config *MetricsConfig, stats func() *redis.PoolStats) *clientMetrics {
+	provider := xmetrics.GetProvider()
+	if provider == nil {
+		return nil
+	}
+	clientMetricsOnce.Do(func() {
+		bucket := config.Bucket
+		if bucket.Count < 1 {
+			bucket = DefaultMetricsConfig().Bucket
+		}
+		commandTotal = provider.NewCounter(metricCommandTotal, LABELCLIENT, LABELCOMMAND, LABELSTATUS)
+		commandDuration = provider.NewHistogram(metricCommandDuration, exponentialBuckets(bucket.Start, bucket.Factor, bucket.Count),
+			LABELCLIENT, LABELCOMMAND)
+		readTotal = provider.NewCounter(metricReadTotal, LABELCLIENT, LABELCOMMAND, LABELRESULT)
+		poolStats = provider.NewGauge(metricPoolStats, LABELCLIENT, LABELADDR, LABELSTAT)
+	})
+	m := &clientMetrics{
+		name:  name,
+		addr:  addr,
+		stats: stats,
+		stop:  make(chan struct{}),
+	}
+	if config.PoolInterval > 0 {
+		go m.watch(config.PoolInterval)
+	}
+	return m
+}
+
+func exponentialBuckets(start, factor float64, count int) []float64 {
+	if count < 1 || start <= 0 || factor <= 1 {
+		panic("invalid redis client metrics bucket")
+	}
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// record 记录一条命令的结果，d为命令或所在pipeline的耗时
+func (m *clientMetrics) record(cmd command, d time.Duration) {
+	name := strings.ToLower(cmd.Name())
+	err := cmd.Err()
+	status := statusOK
+	if isNil(err) {
+		status = statusNil
+	} else if err != nil {
+		status = statusError
+	}
+	commandTotal.With(LABELCLIENT, m.name, LABELCOMMAND, name, LABELSTATUS, status).Inc()
+	commandDuration.With(LABELCLIENT, m.name, LABELCOMMAND, name).Observe(float64(d) / float64(time.Millisecond))
+
+	if !readCommands[name] || status == statusError {
+		return
+	}
+	hits, misses := readResult(cmd)
+	if hits > 0 {
+		readTotal.With(LABELCLIENT, m.name, LABELCOMMAND, name, LABELRESULT, resultHit).Add(float64(hits))
+	}
+	if misses > 0 {
+		readTotal.With(LABELCLIENT, m.name, LABELCOMMAND, name, LABELRESULT, resultMiss).Add(float64(misses))
+	}
+}
+
+// readResult 统计读命令的命中数，mget和hmget按照每个key分别统计
+func readResult(cmd command) (hits, misses int) {
+	if isNil(cmd.Err()) {
+		return 0, 1
+	}
+	var vals []interface{}
+	switch c := cmd.(type) {
+	case *redis.SliceCmd:
+		vals = c.Val()
+	case *redisv9.SliceCmd:
+		vals = c.Val()
+	case *redis.StringStringMapCmd:
+		if len(c.Val()) == 0 {
+			return 0, 1
+		}
+		return 1, 0
+	case *redisv9.MapStringStringCmd:
+		if len(c.Val()) == 0 {
+			return 0, 1
+		}
+		return 1, 0
+	default:
+		return 1, 0
+	}
+	for _, v := range vals {
+		if v == nil {
+			misses++
+		} else {
+			hits++
+		}
+	}
+	return
+}
+
+// command 是v8和v9命令的公共部分
+type command interface {
+	Name() string
+	FullName() string
+	Args() []interface{}
+	Err() error
+}
+
+// isNil 判断是否为v8或v9的redis.Nil
+func isNil(err error) bool {
+	return errors.Is(err, redis.Nil) || errors.Is(err, redisv9.Nil)
+}
+
+// collect 采集连接池指标
+func (m *clientMetrics) collect() {
+	s := m.stats()
+	if s == nil {
+		return
+	}
+	poolStats.With(LABELCLIENT, m.name, LABELADDR, m.addr, LABELSTAT, "hits").Set(float64(s.Hits))
+	poolStats.With(LABELCLIENT, m.name, LABELADDR, m.addr, LABELSTAT, "misses").Set(float64(s.Misses))
+	poolStats.With(LABELCLIENT, m.name, LABELADDR, m.addr, LABELSTAT, "timeouts").Set(float64(s.Timeouts))
+	poolStats.With(LABELCLIENT, m.name, LABELADDR, m.addr, LABELSTAT, "total_conns").Set(float64(s.TotalConns))
+	poolStats.With(LABELCLIENT, m.name, LABELADDR, m.addr, LABELSTAT, "idle_conns").Set(float64(s.IdleConns))
+	poolStats.With(LABELCLIENT, m.name, LABELADDR, m.addr, LABELSTAT, "stale_conns").Set(float64(s.StaleConns))
+}
+
+func (m *clientMetrics) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.collect()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *clientMetrics) close() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+}
+
+// recordSentinelEvent 记录sharded_sentinel的master切换和解析失败次数
+func recordSentinelEvent(client, master, event string) {
+	provider := xmetrics.GetProvider()
+	if provider == nil {
+		return
+	}
+	sentinelMetricsOnce.Do(func() {
+		sentinelEvents = provider.NewCounter(metricSentinelEvent, LABELCLIENT, LABELMASTER, LABELEVENT)
+	})
+	sentinelEvents.With(LABELCLIENT, client, LABELMASTER, master, LABELEVENT, event).Inc()
+}
+
+func initQueueMetrics() bool {
+	provider := xmetrics.GetProvider()
+	if provider == nil {
+		return false
+	}
+	queueMetricsOnce.Do(func() {
+		bucket := DefaultMetricsConfig().Bucket
+		queueBacklog = provider.NewGauge(metricQueueBacklog, LABELQUEUE, LABELSTATE)
+		queueMessages = provider.NewCounter(metricQueueMessages, LABELQUEUE, LABELRESULT)
+		queueDuration = provider.NewHistogram(metricQueueDuration, exponentialBuckets(bucket.Start, bucket.Factor, bucket.Count),
+			LABELQUEUE, LABELRESULT)
+	})
+	return true
+}
+
+// recordQueueMessage 记录消息的处理结果和处理耗时
+func recordQueueMessage(queue, result string, d time.Duration) {
+	if !initQueueMetrics() {
+		return
+	}
+	queueMessages.With(LABELQUEUE, queue, LABELRESULT, result).Inc()
+	queueDuration.With(LABELQUEUE, queue, LABELRESULT, result).Observe(float64(d) / float64(time.Millisecond))
+}
+
+// recordQueueBacklog 记录队列中各个状态的消息数
+func recordQueueBacklog(queue string, backlog map[string]int64) {
+	if !initQueueMetrics() {
+		return
+	}
+	for state, n := range backlog {
+		queueBacklog.With(LABELQUEUE, queue, LABELSTATE, state).Set(float64(n))
+	}
+}
+
+type startTimeKey struct{}
+
+func withStartTime(ctx context.Context) context.Context {
+	return context.WithValue(ctx, startTimeKey{}, time.Now())
+}
+
+func startTime(ctx context.Context) time.Time {
+	t, _ := ctx.Value(startTimeKey{}).(time.Time)
+	return t
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xredis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NetEase-Media/easy-ngo/xmetrics"
+	"github.com/NetEase-Media/easy-ngo/xmetrics/contrib/xprometheus"
+	"github.com/NetEase-Media/easy-ngo/xtracer"
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.14.0"
+)
+
+func findMetric(name string, labels map[string]string) *dto.Metric {
+	mfs, _ := prometheus.DefaultGatherer.Gather()
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+	next:
+		for _, m := range mf.GetMetric() {
+			for _, lp := range m.GetLabel() {
+				if v, ok := labels[lp.GetName()]; ok && v != lp.GetValue() {
+					continue next
+				}
+			}
+			return m
+		}
+	}
+	return nil
+}
+
+func TestClientMetrics(t *testing.T) {
+	xmetrics.WithVendor(xprometheus.NewProvider(xprometheus.DefaultConfig()))
+	defer xmetrics.WithVendor(nil)
+	s, err := miniredis.Run()
+	assert.Nil(t, err)
+	defer s.Close()
+
+	opt := DefaultConfig()
+	opt.Name = "metrics"
+	opt.Addr = []string{s.Addr()}
+	opt.EnableMetrics = true
+	opt.Metrics.PoolInterval = 0
+	c := NewClient(opt)
+	defer c.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, c.Set(ctx, "k1", "v", 0).Err())
+	assert.Equal(t, redis.Nil, c.Get(ctx, "missing").Err())
+	assert.Nil(t, c.Get(ctx, "k1").Err())
+	assert.Nil(t, c.MGet(ctx, "k1", "missing", "missing2").Err())
+	_, err = c.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.Get(ctx, "k1")
+		p.Incr(ctx, "k1")
+		return nil
+	})
+	assert.Error(t, err)
+
+	m := findMetric(metricCommandTotal, map[string]string{LABELCLIENT: "metrics", LABELCOMMAND: "get", LABELSTATUS: statusOK})
+	assert.Equal(t, 2.0, m.GetCounter().GetValue())
+	m = findMetric(metricCommandTotal, map[string]string{LABELCLIENT: "metrics", LABELCOMMAND: "get", LABELSTATUS: statusNil})
+	assert.Equal(t, 1.0, m.GetCounter().GetValue())
+	m = findMetric(metricCommandTotal, map[string]string{LABELCLIENT: "metrics", LABELCOMMAND: "incr", LABELSTATUS: statusError})
+	assert.Equal(t, 1.0, m.GetCounter().GetValue())
+	m = findMetric(metricCommandDuration, map[string]string{LABELCLIENT: "metrics", LABELCOMMAND: "set"})
+	assert.Equal(t, uint64(1), m.GetHistogram().GetSampleCount())
+
+	m = findMetric(metricReadTotal, map[string]string{LABELCLIENT: "metrics", LABELCOMMAND: "get", LABELRESULT: resultHit})
+	assert.Equal(t, 2.0, m.GetCounter().GetValue())
+	m = findMetric(metricReadTotal, map[string]string{LABELCLIENT: "metrics", LABELCOMMAND: "get", LABELRESULT: resultMiss})
+	assert.Equal(t, 1.0, m.GetCounter().GetValue())
+	m = findMetric(metricReadTotal, map[string]string{LABELCLIENT: "metrics", LABELCOMMAND: "mget", LABELRESULT: resultMiss})
+	assert.Equal(t, 2.0, m.GetCounter().GetValue())
+
+	c.metrics.collect()
+	m = findMetric(metricPoolStats, map[string]string{LABELCLIENT: "metrics", LABELADDR: s.Addr(), LABELSTAT: "total_conns"})
+	assert.Equal(t, 1.0, m.GetGauge().GetValue())
+}
+
+func TestClientTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	xtracer.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	s, err := miniredis.Run()
+	assert.Nil(t, err)
+	defer s.Close()
+
+	opt := DefaultConfig()
+	opt.Name = "tracer"
+	opt.Addr = []string{s.Addr()}
+	opt.DB = 0
+	opt.EnableTracer = true
+	c := NewClient(opt)
+	defer c.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, c.Set(ctx, "k1", "secret", 0).Err())
+	assert.Equal(t, redis.Nil, c.Get(ctx, "missing").Err())
+	assert.Error(t, c.Incr(ctx, "k1").Err())
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 3)
+	assert.Equal(t, "set", spans[0].Name())
+	assert.Contains(t, spans[0].Attributes(), semconv.DBSystemRedis)
+	assert.Contains(t, spans[0].Attributes(), semconv.DBStatementKey.String("set k1"))
+	assert.Equal(t, codes.Unset, spans[1].Status().Code)
+	assert.Equal(t, codes.Error, spans[2].Status().Code)
+}
+
+func TestShardedSentinelClientOptions(t *testing.T) {
+	opt := &Config{Name: "sharded", EnableMetrics: true, EnableTracer: true, Metrics: DefaultMetricsConfig()}
+	shard := clientOptions(opt, "127.0.0.1:6379")
+	assert.Equal(t, "sharded", shard.Name)
+	assert.True(t, shard.EnableMetrics)
+	assert.True(t, shard.EnableTracer)
+	assert.Equal(t, opt.Metrics, shard.Metrics)
+}
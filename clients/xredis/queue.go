@@ -0,0 +1,435 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xredis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/utils/gopool"
+	"github.com/NetEase-Media/easy-ngo/xlog"
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	// KEYS[1]payload KEYS[2]pending；ARGV[1]id ARGV[2]消息体 ARGV[3]投递时间
+	enqueueScript = NewScript("queue_enqueue", `
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+return redis.call("ZADD", KEYS[2], ARGV[3], ARGV[1])`)
+
+	// KEYS[1]pending KEYS[2]processing KEYS[3]attempts KEYS[4]payload KEYS[5]dead；
+	// ARGV[1]当前时间 ARGV[2]可见性超时的截止时间 ARGV[3]拉取数量 ARGV[4]最大投递次数
+	// 时间以字符串传给redis，避免lua的数字转字符串时使用科学计数法
+	popScript = NewScript("queue_pop", `
+local expired = redis.call("ZRANGEBYSCORE", KEYS[2], "-inf", ARGV[1], "LIMIT", 0, 100)
+for _, id in ipairs(expired) do
+	redis.call("ZREM", KEYS[2], id)
+	redis.call("ZADD", KEYS[1], ARGV[1], id)
+end
+local max = tonumber(ARGV[4])
+local result = {}
+local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, tonumber(ARGV[3]))
+for _, id in ipairs(ids) do
+	redis.call("ZREM", KEYS[1], id)
+	local body = redis.call("HGET", KEYS[4], id)
+	if body then
+		local attempts = redis.call("HINCRBY", KEYS[3], id, 1)
+		if max > 0 and attempts > max then
+			redis.call("LPUSH", KEYS[5], id)
+		else
+			redis.call("ZADD", KEYS[2], ARGV[2], id)
+			table.insert(result, id)
+			table.insert(result, body)
+			table.insert(result, attempts)
+		end
+	end
+end
+return result`)
+
+	// KEYS[1]processing KEYS[2]pending KEYS[3]payload KEYS[4]attempts；ARGV[1]id
+	ackScript = NewScript("queue_ack", `
+local n = redis.call("ZREM", KEYS[1], ARGV[1]) + redis.call("ZREM", KEYS[2], ARGV[1])
+redis.call("HDEL", KEYS[3], ARGV[1])
+redis.call("HDEL", KEYS[4], ARGV[1])
+return n`)
+
+	// KEYS[1]processing KEYS[2]pending KEYS[3]dead；ARGV[1]id ARGV[2]重新投递的时间 ARGV[3]是否进入死信队列
+	nackScript = NewScript("queue_nack", `
+if redis.call("ZREM", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+if ARGV[3] == "1" then
+	redis.call("LPUSH", KEYS[3], ARGV[1])
+else
+	redis.call("ZADD", KEYS[2], ARGV[2], ARGV[1])
+end
+return 1`)
+
+	// KEYS[1]dead KEYS[2]pending KEYS[3]attempts；ARGV[1]当前时间
+	requeueScript = NewScript("queue_requeue", `
+local ids = redis.call("LRANGE", KEYS[1], 0, -1)
+for _, id in ipairs(ids) do
+	redis.call("ZADD", KEYS[2], ARGV[1], id)
+	redis.call("HDEL", KEYS[3], id)
+end
+redis.call("DEL", KEYS[1])
+return #ids`)
+)
+
+// QueueConfig 队列配置
+type QueueConfig struct {
+	// 并发处理消息的数量
+	Concurrency int
+
+	// 每次最多拉取的消息数
+	BatchSize int
+
+	// 没有消息时的轮询间隔
+	PollInterval time.Duration
+
+	// 消息取出后超过该时间没有确认会被重新投递，同时也是处理消息的超时时间
+	VisibilityTimeout time.Duration
+
+	// 最大投递次数，超过后进入死信队列，为0时不限制
+	MaxAttempts int
+
+	// 处理失败后重新投递的延迟，第n次失败后等待RetryDelay*n
+	RetryDelay time.Duration
+
+	// Stream队列的最大长度（近似值），为0时不限制
+	StreamMaxLen int64
+
+	// 消费时采集积压指标的间隔
+	MetricsInterval time.Duration
+
+	EnableMetrics bool
+}
+
+func DefaultQueueConfig() QueueConfig {
+	return QueueConfig{
+		Concurrency:       10,
+		BatchSize:         10,
+		PollInterval:      100 * time.Millisecond,
+		VisibilityTimeout: 30 * time.Second,
+		MaxAttempts:       5,
+		RetryDelay:        time.Second,
+		MetricsInterval:   10 * time.Second,
+	}
+}
+
+func newQueueConfig(config *QueueConfig) QueueConfig {
+	c := DefaultQueueConfig()
+	if config == nil {
+		return c
+	}
+	d := c
+	c = *config
+	if c.Concurrency <= 0 {
+		c.Concurrency = d.Concurrency
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = d.BatchSize
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = d.PollInterval
+	}
+	if c.VisibilityTimeout <= 0 {
+		c.VisibilityTimeout = d.VisibilityTimeout
+	}
+	return c
+}
+
+// Message 队列中的消息
+type Message struct {
+	ID   string
+	Body []byte
+
+	// 当前是第几次投递，从1开始
+	Attempts int
+}
+
+// Handler 处理消息，返回nil时确认消息，返回错误或者panic时重新投递
+type Handler func(ctx context.Context, msg *Message) error
+
+// QueueStats 队列中各个状态的消息数
+type QueueStats struct {
+	Ready      int64
+	Delayed    int64
+	Processing int64
+	Dead       int64
+}
+
+// Queue 基于有序集合的延迟队列，支持至少一次的可靠消费。
+//
+// 消息按照投递时间保存在有序集合中，消费时通过lua脚本原子地取出到期的消息并放入处理中集合，
+// 超过VisibilityTimeout没有确认的消息会被重新投递，超过MaxAttempts的消息进入死信队列。
+// 所有key使用{name}作为hash tag，可以用于cluster和ShardedClient。时间使用客户端时钟，各实例之间需要同步时钟。
+type Queue struct {
+	client Redis
+	name   string
+	config QueueConfig
+
+	pending    string
+	processing string
+	attempts   string
+	payload    string
+	dead       string
+}
+
+// NewQueue 创建队列
+func NewQueue(client Redis, name string, config *QueueConfig) *Queue {
+	prefix := "{" + name + "}:"
+	return &Queue{
+		client:     client,
+		name:       name,
+		config:     newQueueConfig(config),
+		pending:    prefix + "pending",
+		processing: prefix + "processing",
+		attempts:   prefix + "attempts",
+		payload:    prefix + "payload",
+		dead:       prefix + "dead",
+	}
+}
+
+// Enqueue 投递立即可以消费的消息，返回消息id
+func (q *Queue) Enqueue(ctx context.Context, body []byte) (string, error) {
+	return q.EnqueueAt(ctx, body, time.Now())
+}
+
+// EnqueueDelay 投递延迟消息，delay之后才可以被消费
+func (q *Queue) EnqueueDelay(ctx context.Context, body []byte, delay time.Duration) (string, error) {
+	return q.EnqueueAt(ctx, body, time.Now().Add(delay))
+}
+
+// EnqueueAt 投递在指定时间之后才可以被消费的消息
+func (q *Queue) EnqueueAt(ctx context.Context, body []byte, at time.Time) (string, error) {
+	id, err := randomValue()
+	if err != nil {
+		return "", err
+	}
+	err = enqueueScript.Run(ctx, q.client, []string{q.payload, q.pending}, id, body, millis(at)).Err()
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Consume 消费消息，阻塞直到ctx结束，返回前等待处理中的消息完成
+func (q *Queue) Consume(ctx context.Context, handler Handler) error {
+	return consume(ctx, "xredis-queue-"+q.name, &q.config, q.pop, func(msg *Message) {
+		q.handle(handler, msg)
+	}, q.collect)
+}
+
+// Stats 返回队列中各个状态的消息数
+func (q *Queue) Stats(ctx context.Context) (QueueStats, error) {
+	var stats QueueStats
+	var err error
+	now := millis(time.Now())
+	if stats.Ready, err = q.client.ZCount(ctx, q.pending, "-inf", now).Result(); err != nil {
+		return stats, err
+	}
+	if stats.Delayed, err = q.client.ZCount(ctx, q.pending, "("+now, "+inf").Result(); err != nil {
+		return stats, err
+	}
+	if stats.Processing, err = q.client.ZCard(ctx, q.processing).Result(); err != nil {
+		return stats, err
+	}
+	if stats.Dead, err = q.client.LLen(ctx, q.dead).Result(); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// DeadLetters 返回死信队列中最近的count条消息
+func (q *Queue) DeadLetters(ctx context.Context, count int64) ([]*Message, error) {
+	ids, err := q.client.LRange(ctx, q.dead, 0, count-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]*Message, 0, len(ids))
+	for _, id := range ids {
+		body, err := q.client.HGet(ctx, q.payload, id).Bytes()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		attempts, _ := q.client.HGet(ctx, q.attempts, id).Int()
+		msgs = append(msgs, &Message{ID: id, Body: body, Attempts: attempts})
+	}
+	return msgs, nil
+}
+
+// RequeueDead 将死信队列中的消息重新投递，并重置投递次数，返回重新投递的消息数
+func (q *Queue) RequeueDead(ctx context.Context) (int64, error) {
+	return requeueScript.Run(ctx, q.client, []string{q.dead, q.pending, q.attempts}, millis(time.Now())).Int64()
+}
+
+func (q *Queue) pop(ctx context.Context, n int) ([]*Message, error) {
+	now := time.Now()
+	keys := []string{q.pending, q.processing, q.attempts, q.payload, q.dead}
+	vals, err := popScript.Run(ctx, q.client, keys,
+		millis(now), millis(now.Add(q.config.VisibilityTimeout)), n, q.config.MaxAttempts).Slice()
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]*Message, 0, len(vals)/3)
+	for i := 0; i+2 < len(vals); i += 3 {
+		id, _ := vals[i].(string)
+		body, _ := vals[i+1].(string)
+		attempts, _ := vals[i+2].(int64)
+		msgs = append(msgs, &Message{ID: id, Body: []byte(body), Attempts: int(attempts)})
+	}
+	return msgs, nil
+}
+
+func (q *Queue) handle(handler Handler, msg *Message) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), q.config.VisibilityTimeout)
+	defer cancel()
+
+	result := queueResultAck
+	if err := safeHandle(ctx, handler, msg); err != nil {
+		dead := q.config.MaxAttempts > 0 && msg.Attempts >= q.config.MaxAttempts
+		result = queueResultRetry
+		if dead {
+			result = queueResultDead
+		}
+		xlog.Warnf("xredis queue: %s handle message %s failed (attempt %d): %v", q.name, msg.ID, msg.Attempts, err)
+		retryAt := time.Now().Add(q.config.RetryDelay * time.Duration(msg.Attempts))
+		err = nackScript.Run(context.Background(), q.client, []string{q.processing, q.pending, q.dead},
+			msg.ID, millis(retryAt), boolArg(dead)).Err()
+		if err != nil {
+			xlog.Warnf("xredis queue: %s nack message %s failed: %v", q.name, msg.ID, err)
+		}
+	} else {
+		err = ackScript.Run(context.Background(), q.client, []string{q.processing, q.pending, q.payload, q.attempts}, msg.ID).Err()
+		if err != nil {
+			xlog.Warnf("xredis queue: %s ack message %s failed: %v", q.name, msg.ID, err)
+		}
+	}
+	if q.config.EnableMetrics {
+		recordQueueMessage(q.name, result, time.Since(start))
+	}
+}
+
+func (q *Queue) collect(ctx context.Context) {
+	stats, err := q.Stats(ctx)
+	if err != nil {
+		xlog.Warnf("xredis queue: %s stats failed: %v", q.name, err)
+		return
+	}
+	recordQueueBacklog(q.name, map[string]int64{
+		"ready":      stats.Ready,
+		"delayed":    stats.Delayed,
+		"processing": stats.Processing,
+		"dead":       stats.Dead,
+	})
+}
+
+// consume 拉取消息并在gopool中并发处理，同时处理中的消息不超过Concurrency
+func consume(ctx context.Context, name string, config *QueueConfig,
+	fetch func(ctx context.Context, n int) ([]*Message, error), handle func(msg *Message), collect func(ctx context.Context)) error {
+	pool := gopool.NewPool(name, int32(config.Concurrency), gopool.NewConfig())
+	slots := make(chan struct{}, config.Concurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	if config.EnableMetrics && config.MetricsInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(config.MetricsInterval)
+			defer ticker.Stop()
+			for {
+				collect(ctx)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+
+	for {
+		// 至少有一个空闲的并发才拉取消息
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		}
+		n := 1
+	acquire:
+		for n < config.BatchSize {
+			select {
+			case slots <- struct{}{}:
+				n++
+			default:
+				break acquire
+			}
+		}
+
+		msgs, err := fetch(ctx, n)
+		if err != nil && ctx.Err() == nil {
+			xlog.Warnf("xredis queue: %s fetch messages failed: %v", name, err)
+		}
+		for i := len(msgs); i < n; i++ {
+			<-slots
+		}
+		for _, msg := range msgs {
+			msg := msg
+			wg.Add(1)
+			pool.Go(func() {
+				defer wg.Done()
+				defer func() { <-slots }()
+				handle(msg)
+			})
+		}
+		if len(msgs) == 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(config.PollInterval):
+			}
+		}
+	}
+}
+
+// safeHandle 调用handler，panic时作为错误返回
+func safeHandle(ctx context.Context, handler Handler, msg *Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, msg)
+}
+
+func millis(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+}
+
+func boolArg(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,262 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xredis
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/xlog"
+	"github.com/go-redis/redis/v8"
+)
+
+const streamBodyField = "body"
+
+// StreamStats Stream队列的消息数
+type StreamStats struct {
+	// Stream的长度，包含已经确认但还未被裁剪的消息
+	Length int64
+	// 已经投递但还未确认的消息
+	Pending int64
+	Dead    int64
+}
+
+// StreamQueue 基于Redis Streams消费组的队列
+//
+// 同一个消费组内的消费者共同消费一个Stream，处理成功后XACK。处理失败或者消费者退出的消息，
+// 在VisibilityTimeout之后被其他消费者XCLAIM重新投递，超过MaxAttempts的消息写入{stream}:dead。
+type StreamQueue struct {
+	client   Redis
+	stream   string
+	dead     string
+	group    string
+	consumer string
+	config   QueueConfig
+
+	mu          sync.Mutex
+	lastReclaim time.Time
+}
+
+// NewStreamQueue 创建Stream队列，consumer为空时使用hostname-pid
+func NewStreamQueue(client Redis, stream, group, consumer string, config *QueueConfig) *StreamQueue {
+	if consumer == "" {
+		host, _ := os.Hostname()
+		consumer = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	return &StreamQueue{
+		client:   client,
+		stream:   stream,
+		dead:     stream + ":dead",
+		group:    group,
+		consumer: consumer,
+		config:   newQueueConfig(config),
+	}
+}
+
+// Enqueue 投递消息，返回消息id
+func (q *StreamQueue) Enqueue(ctx context.Context, body []byte) (string, error) {
+	args := &redis.XAddArgs{
+		Stream: q.stream,
+		Values: []interface{}{streamBodyField, body},
+	}
+	if q.config.StreamMaxLen > 0 {
+		args.MaxLen = q.config.StreamMaxLen
+		args.Approx = true
+	}
+	return q.client.XAdd(ctx, args).Result()
+}
+
+// Consume 消费消息，阻塞直到ctx结束，返回前等待处理中的消息完成
+func (q *StreamQueue) Consume(ctx context.Context, handler Handler) error {
+	if err := q.createGroup(ctx); err != nil {
+		return err
+	}
+	return consume(ctx, "xredis-stream-"+q.stream, &q.config, q.fetch, func(msg *Message) {
+		q.handle(handler, msg)
+	}, q.collect)
+}
+
+// Stats 返回Stream队列的消息数
+func (q *StreamQueue) Stats(ctx context.Context) (StreamStats, error) {
+	var stats StreamStats
+	var err error
+	if stats.Length, err = q.client.XLen(ctx, q.stream).Result(); err != nil {
+		return stats, err
+	}
+	pending, err := q.client.XPending(ctx, q.stream, q.group).Result()
+	if err != nil {
+		return stats, err
+	}
+	stats.Pending = pending.Count
+	if stats.Dead, err = q.client.XLen(ctx, q.dead).Result(); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+func (q *StreamQueue) createGroup(ctx context.Context) error {
+	err := q.client.XGroupCreateMkStream(ctx, q.stream, q.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+func (q *StreamQueue) fetch(ctx context.Context, n int) ([]*Message, error) {
+	msgs, err := q.reclaim(ctx, n)
+	if err != nil || len(msgs) >= n {
+		return msgs, err
+	}
+	streams, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    q.group,
+		Consumer: q.consumer,
+		Streams:  []string{q.stream, ">"},
+		Count:    int64(n - len(msgs)),
+		Block:    -1,
+	}).Result()
+	if err == redis.Nil {
+		return msgs, nil
+	}
+	if err != nil {
+		return msgs, err
+	}
+	for _, s := range streams {
+		for _, m := range s.Messages {
+			msgs = append(msgs, streamMessage(m, 1))
+		}
+	}
+	return msgs, nil
+}
+
+// reclaim 认领超过VisibilityTimeout没有确认的消息，投递次数超过MaxAttempts的消息写入死信队列
+func (q *StreamQueue) reclaim(ctx context.Context, n int) ([]*Message, error) {
+	q.mu.Lock()
+	if time.Since(q.lastReclaim) < q.config.VisibilityTimeout/2 {
+		q.mu.Unlock()
+		return nil, nil
+	}
+	q.lastReclaim = time.Now()
+	q.mu.Unlock()
+
+	pending, err := q.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: q.stream,
+		Group:  q.group,
+		Start:  "-",
+		End:    "+",
+		Count:  100,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	attempts := make(map[string]int, len(pending))
+	ids := make([]string, 0, n)
+	for _, p := range pending {
+		if p.Idle < q.config.VisibilityTimeout {
+			continue
+		}
+		if q.config.MaxAttempts > 0 && int(p.RetryCount) >= q.config.MaxAttempts {
+			if err := q.moveToDead(ctx, p.ID, int(p.RetryCount)); err != nil {
+				xlog.Warnf("xredis queue: %s move message %s to dead failed: %v", q.stream, p.ID, err)
+			}
+			continue
+		}
+		if len(ids) < n {
+			attempts[p.ID] = int(p.RetryCount) + 1
+			ids = append(ids, p.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	claimed, err := q.client.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   q.stream,
+		Group:    q.group,
+		Consumer: q.consumer,
+		MinIdle:  q.config.VisibilityTimeout,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]*Message, 0, len(claimed))
+	for _, m := range claimed {
+		msgs = append(msgs, streamMessage(m, attempts[m.ID]))
+	}
+	return msgs, nil
+}
+
+func (q *StreamQueue) handle(handler Handler, msg *Message) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), q.config.VisibilityTimeout)
+	defer cancel()
+
+	result := queueResultAck
+	if err := safeHandle(ctx, handler, msg); err != nil {
+		xlog.Warnf("xredis queue: %s handle message %s failed (attempt %d): %v", q.stream, msg.ID, msg.Attempts, err)
+		// 未确认的消息在VisibilityTimeout之后重新投递
+		result = queueResultRetry
+		if q.config.MaxAttempts > 0 && msg.Attempts >= q.config.MaxAttempts {
+			result = queueResultDead
+			if err := q.moveToDead(context.Background(), msg.ID, msg.Attempts); err != nil {
+				xlog.Warnf("xredis queue: %s move message %s to dead failed: %v", q.stream, msg.ID, err)
+			}
+		}
+	} else if err := q.client.XAck(context.Background(), q.stream, q.group, msg.ID).Err(); err != nil {
+		xlog.Warnf("xredis queue: %s ack message %s failed: %v", q.stream, msg.ID, err)
+	}
+	if q.config.EnableMetrics {
+		recordQueueMessage(q.stream, result, time.Since(start))
+	}
+}
+
+// moveToDead 将消息写入死信Stream并确认
+func (q *StreamQueue) moveToDead(ctx context.Context, id string, attempts int) error {
+	msgs, err := q.client.XRangeN(ctx, q.stream, id, id, 1).Result()
+	if err != nil {
+		return err
+	}
+	if len(msgs) > 0 {
+		err = q.client.XAdd(ctx, &redis.XAddArgs{
+			Stream: q.dead,
+			Values: []interface{}{streamBodyField, msgs[0].Values[streamBodyField], "id", id, "attempts", attempts},
+		}).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return q.client.XAck(ctx, q.stream, q.group, id).Err()
+}
+
+func (q *StreamQueue) collect(ctx context.Context) {
+	stats, err := q.Stats(ctx)
+	if err != nil {
+		xlog.Warnf("xredis queue: %s stats failed: %v", q.stream, err)
+		return
+	}
+	recordQueueBacklog(q.stream, map[string]int64{
+		"length":  stats.Length,
+		"pending": stats.Pending,
+		"dead":    stats.Dead,
+	})
+}
+
+func streamMessage(m redis.XMessage, attempts int) *Message {
+	body, _ := m.Values[streamBodyField].(string)
+	return &Message{ID: m.ID, Body: []byte(body), Attempts: attempts}
+}
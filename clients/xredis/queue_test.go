@@ -0,0 +1,284 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/xmetrics"
+	"github.com/NetEase-Media/easy-ngo/xmetrics/contrib/xprometheus"
+	miniredisv2 "github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func testQueueConfig() *QueueConfig {
+	return &QueueConfig{
+		Concurrency:       3,
+		BatchSize:         5,
+		PollInterval:      10 * time.Millisecond,
+		VisibilityTimeout: 200 * time.Millisecond,
+		MaxAttempts:       2,
+		RetryDelay:        10 * time.Millisecond,
+	}
+}
+
+func newTestQueueClient(t *testing.T) Redis {
+	s := miniredisv2.RunT(t)
+	client := NewClient(&Config{Name: "queue", Addr: []string{s.Addr()}})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// consumeUntil 在后台消费，直到done返回true
+func consumeUntil(t *testing.T, consume func(ctx context.Context, handler Handler) error, handler Handler, done func() bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- consume(ctx, handler)
+	}()
+	assert.Eventually(t, done, 3*time.Second, 10*time.Millisecond)
+	cancel()
+	assert.NoError(t, <-errc)
+}
+
+func TestQueue_Delay(t *testing.T) {
+	ctx := context.Background()
+	setupTest()
+	q := NewQueue(newTestQueueClient(t), "delay", testQueueConfig())
+
+	start := time.Now()
+	_, err := q.EnqueueDelay(ctx, []byte("later"), 200*time.Millisecond)
+	assert.NoError(t, err)
+	_, err = q.Enqueue(ctx, []byte("now"))
+	assert.NoError(t, err)
+	stats, err := q.Stats(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, QueueStats{Ready: 1, Delayed: 1}, stats)
+
+	var mu sync.Mutex
+	got := make([]string, 0)
+	var laterAt time.Duration
+	consumeUntil(t, q.Consume, func(ctx context.Context, msg *Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, string(msg.Body))
+		if string(msg.Body) == "later" {
+			laterAt = time.Since(start)
+		}
+		return nil
+	}, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got) == 2
+	})
+	assert.Equal(t, []string{"now", "later"}, got)
+	assert.True(t, laterAt >= 200*time.Millisecond)
+
+	stats, err = q.Stats(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, QueueStats{}, stats)
+	assert.Equal(t, int64(0), q.client.HLen(ctx, q.payload).Val())
+}
+
+func TestQueue_RetryAndDead(t *testing.T) {
+	ctx := context.Background()
+	setupTest()
+	q := NewQueue(newTestQueueClient(t), "retry", testQueueConfig())
+
+	_, err := q.Enqueue(ctx, []byte("flaky"))
+	assert.NoError(t, err)
+	id, err := q.Enqueue(ctx, []byte("bad"))
+	assert.NoError(t, err)
+
+	var acked int32
+	consumeUntil(t, q.Consume, func(ctx context.Context, msg *Message) error {
+		if string(msg.Body) == "bad" {
+			panic("bad message")
+		}
+		if msg.Attempts < 2 {
+			return errors.New("try again")
+		}
+		atomic.AddInt32(&acked, 1)
+		return nil
+	}, func() bool {
+		stats, _ := q.Stats(ctx)
+		return atomic.LoadInt32(&acked) == 1 && stats.Dead == 1
+	})
+
+	dead, err := q.DeadLetters(ctx, 10)
+	assert.NoError(t, err)
+	assert.Len(t, dead, 1)
+	assert.Equal(t, &Message{ID: id, Body: []byte("bad"), Attempts: 2}, dead[0])
+
+	n, err := q.RequeueDead(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	stats, _ := q.Stats(ctx)
+	assert.Equal(t, QueueStats{Ready: 1}, stats)
+}
+
+func TestQueue_VisibilityTimeout(t *testing.T) {
+	ctx := context.Background()
+	q := NewQueue(newTestQueueClient(t), "visibility", testQueueConfig())
+	id, err := q.Enqueue(ctx, []byte("m"))
+	assert.NoError(t, err)
+
+	msgs, err := q.pop(ctx, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Message{{ID: id, Body: []byte("m"), Attempts: 1}}, msgs)
+	msgs, _ = q.pop(ctx, 10)
+	assert.Empty(t, msgs)
+
+	// 没有确认的消息在超时后重新投递，超过最大投递次数后进入死信队列
+	time.Sleep(250 * time.Millisecond)
+	msgs, _ = q.pop(ctx, 10)
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, 2, msgs[0].Attempts)
+	time.Sleep(250 * time.Millisecond)
+	msgs, _ = q.pop(ctx, 10)
+	assert.Empty(t, msgs)
+	stats, _ := q.Stats(ctx)
+	assert.Equal(t, QueueStats{Dead: 1}, stats)
+}
+
+func TestQueue_Concurrency(t *testing.T) {
+	ctx := context.Background()
+	config := testQueueConfig()
+	config.EnableMetrics = true
+	config.MetricsInterval = 10 * time.Millisecond
+	xmetrics.WithVendor(xprometheus.NewProvider(xprometheus.DefaultConfig()))
+	defer xmetrics.WithVendor(nil)
+	name := fmt.Sprintf("concurrency-%d", time.Now().UnixNano())
+	q := NewQueue(newTestQueueClient(t), name, config)
+	for i := 0; i < 10; i++ {
+		_, err := q.Enqueue(ctx, []byte("m"))
+		assert.NoError(t, err)
+	}
+
+	var running, max, done int32
+	consumeUntil(t, q.Consume, func(ctx context.Context, msg *Message) error {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		atomic.AddInt32(&done, 1)
+		return nil
+	}, func() bool {
+		return atomic.LoadInt32(&done) == 10
+	})
+	assert.Equal(t, int32(3), max)
+
+	m := findMetric(metricQueueMessages, map[string]string{LABELQUEUE: name, LABELRESULT: queueResultAck})
+	assert.Equal(t, 10.0, m.GetCounter().GetValue())
+	m = findMetric(metricQueueDuration, map[string]string{LABELQUEUE: name, LABELRESULT: queueResultAck})
+	assert.Equal(t, uint64(10), m.GetHistogram().GetSampleCount())
+	assert.NotNil(t, findMetric(metricQueueBacklog, map[string]string{LABELQUEUE: name, LABELSTATE: "ready"}))
+}
+
+func TestQueue_Sharded(t *testing.T) {
+	ctx := context.Background()
+	do(func(client Redis) {
+		q := NewQueue(client, "sharded", testQueueConfig())
+		_, err := q.EnqueueDelay(ctx, []byte("m"), 10*time.Millisecond)
+		assert.NoError(t, err)
+		var n int32
+		consumeUntil(t, q.Consume, func(ctx context.Context, msg *Message) error {
+			atomic.AddInt32(&n, 1)
+			return nil
+		}, func() bool {
+			return atomic.LoadInt32(&n) == 1
+		})
+	})
+}
+
+func TestStreamQueue(t *testing.T) {
+	ctx := context.Background()
+	setupTest()
+	client := newTestQueueClient(t)
+	q := NewStreamQueue(client, "events", "workers", "c1", testQueueConfig())
+
+	for _, body := range []string{"ok", "flaky", "bad"} {
+		_, err := q.Enqueue(ctx, []byte(body))
+		assert.NoError(t, err)
+	}
+
+	var mu sync.Mutex
+	acked := make(map[string]int)
+	consumeUntil(t, q.Consume, func(ctx context.Context, msg *Message) error {
+		body := string(msg.Body)
+		if body == "bad" || (body == "flaky" && msg.Attempts < 2) {
+			return errors.New("failed")
+		}
+		mu.Lock()
+		acked[body] = msg.Attempts
+		mu.Unlock()
+		return nil
+	}, func() bool {
+		mu.Lock()
+		n := len(acked)
+		mu.Unlock()
+		stats, _ := q.Stats(ctx)
+		return n == 2 && stats.Dead == 1 && stats.Pending == 0
+	})
+	assert.Equal(t, map[string]int{"ok": 1, "flaky": 2}, acked)
+
+	dead, err := client.XRange(ctx, "events:dead", "-", "+").Result()
+	assert.NoError(t, err)
+	assert.Len(t, dead, 1)
+	assert.Equal(t, "bad", dead[0].Values["body"])
+
+	// 重复创建消费组
+	assert.NoError(t, q.createGroup(ctx))
+}
+
+func TestStreamQueue_Sharded(t *testing.T) {
+	ctx := context.Background()
+	s0, _ := newTestShard(t, "shard-0")
+	s1, _ := newTestShard(t, "shard-1")
+	client := NewShardedClient([]*ShardInfo{s0, s1})
+	defer client.Close()
+
+	q := NewStreamQueue(client, "events", "workers", "", testQueueConfig())
+	_, err := q.Enqueue(ctx, []byte("m"))
+	assert.NoError(t, err)
+	var n int32
+	consumeUntil(t, q.Consume, func(ctx context.Context, msg *Message) error {
+		atomic.AddInt32(&n, 1)
+		return nil
+	}, func() bool {
+		return atomic.LoadInt32(&n) == 1
+	})
+	stats, err := q.Stats(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, StreamStats{Length: 1}, stats)
+
+	c := client.(*ShardedClient)
+	other := "other"
+	for i := 0; c.getShard(other) == c.getShard("events"); i++ {
+		other = fmt.Sprintf("other-%d", i)
+	}
+	assert.Equal(t, ErrCrossShard, client.XReadStreams(ctx, "events", other, "0", "0").Err())
+}
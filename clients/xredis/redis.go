@@ -15,6 +15,7 @@
 package xredis
 
 import (
+	"context"
 	"errors"
 	"io"
 
@@ -74,4 +75,41 @@ type RedisContainer struct {
 	Redis
 	Opt       Config
 	redisType string
+	metrics   *clientMetrics
+	sentinel  *ShardedSentinelClient
+}
+
+// Close 关闭客户端，并停止采集连接池指标
+func (c *RedisContainer) Close() error {
+	if c.sentinel != nil {
+		c.sentinel.close()
+	}
+	if c.metrics != nil {
+		c.metrics.close()
+	}
+	return c.Redis.Close()
+}
+
+// Reshard 按照新配置中的MasterNames和ShardWeights重新分片，只支持sharded_sentinel类型。
+// 之后需要调用ShardedClient的MigrateKeys迁移数据，完成后调用FinishMigration
+func (c *RedisContainer) Reshard(opt *Config) error {
+	if c.sentinel == nil {
+		return errors.New("reshard is only supported for sharded_sentinel")
+	}
+	if len(opt.MasterNames) == 0 {
+		return errors.New("empty master name")
+	}
+	if err := c.sentinel.reshard(context.Background(), opt); err != nil {
+		return err
+	}
+	c.Opt.MasterNames = opt.MasterNames
+	c.Opt.ShardWeights = opt.ShardWeights
+	return nil
+}
+
+// OnFailover 注册master切换事件的回调，只对sharded_sentinel类型生效
+func (c *RedisContainer) OnFailover(fn func(FailoverEvent)) {
+	if c.sentinel != nil {
+		c.sentinel.onFailover(fn)
+	}
 }
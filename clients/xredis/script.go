@@ -0,0 +1,135 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrScriptNotFound 脚本没有在注册表中注册
+var ErrScriptNotFound = errors.New("script not found")
+
+// Script 带名字的lua脚本，Run时优先使用EVALSHA，服务端返回NOSCRIPT时自动退回EVAL
+//
+// 对ShardedClient和ClusterClient，脚本按照第一个key路由，所有key需要落在同一个分片上，可以使用{...}指定分片
+type Script struct {
+	*redis.Script
+	name string
+}
+
+// NewScript 创建脚本
+func NewScript(name, src string) *Script {
+	return &Script{
+		Script: redis.NewScript(src),
+		name:   name,
+	}
+}
+
+// Name 返回脚本名
+func (s *Script) Name() string {
+	return s.name
+}
+
+// ScriptRegistry 脚本注册表，启动时通过Preload在所有节点和分片上加载脚本
+type ScriptRegistry struct {
+	mu      sync.RWMutex
+	scripts map[string]*Script
+}
+
+// NewScriptRegistry 创建脚本注册表
+func NewScriptRegistry() *ScriptRegistry {
+	return &ScriptRegistry{
+		scripts: make(map[string]*Script),
+	}
+}
+
+// Register 注册脚本，同名脚本会被覆盖
+func (r *ScriptRegistry) Register(name, src string) *Script {
+	s := NewScript(name, src)
+	r.mu.Lock()
+	r.scripts[name] = s
+	r.mu.Unlock()
+	return s
+}
+
+// LoadFS 从文件系统（如embed.FS）中注册所有匹配pattern的脚本，脚本名为去掉扩展名的文件名
+func (r *ScriptRegistry) LoadFS(fsys fs.FS, pattern string) error {
+	files, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		src, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		base := path.Base(file)
+		r.Register(strings.TrimSuffix(base, path.Ext(base)), string(src))
+	}
+	return nil
+}
+
+// Get 获取脚本
+func (r *ScriptRegistry) Get(name string) (*Script, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.scripts[name]
+	return s, ok
+}
+
+// Scripts 返回所有脚本，按名字排序
+func (r *ScriptRegistry) Scripts() []*Script {
+	r.mu.RLock()
+	scripts := make([]*Script, 0, len(r.scripts))
+	for _, s := range r.scripts {
+		scripts = append(scripts, s)
+	}
+	r.mu.RUnlock()
+	sort.Slice(scripts, func(i, j int) bool {
+		return scripts[i].name < scripts[j].name
+	})
+	return scripts
+}
+
+// Preload 加载所有脚本。ShardedClient和ClusterClient会在每个分片上加载。
+// 主从切换或者迁移到新分片后脚本可能不存在，Run会自动退回EVAL，不需要重新Preload
+func (r *ScriptRegistry) Preload(ctx context.Context, c redis.Scripter) error {
+	for _, s := range r.Scripts() {
+		if err := s.Load(ctx, c).Err(); err != nil {
+			return fmt.Errorf("load script %s: %w", s.name, err)
+		}
+	}
+	return nil
+}
+
+// Run 执行已注册的脚本
+func (r *ScriptRegistry) Run(ctx context.Context, c redis.Scripter, name string, keys []string, args ...interface{}) *redis.Cmd {
+	s, ok := r.Get(name)
+	if !ok {
+		cmd := redis.NewCmd(ctx)
+		cmd.SetErr(fmt.Errorf("%w: %s", ErrScriptNotFound, name))
+		return cmd
+	}
+	return s.Run(ctx, c, keys, args...)
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xredis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScriptRegistry(t *testing.T) {
+	ctx := context.Background()
+	fsys := fstest.MapFS{
+		"lua/incrby.lua": {Data: []byte(`return redis.call("INCRBY", KEYS[1], ARGV[1])`)},
+		"lua/get.lua":    {Data: []byte(`return redis.call("GET", KEYS[1])`)},
+		"lua/README.md":  {Data: []byte("ignored")},
+	}
+	r := NewScriptRegistry()
+	assert.NoError(t, r.LoadFS(fsys, "lua/*.lua"))
+	scripts := r.Scripts()
+	assert.Len(t, scripts, 2)
+	assert.Equal(t, "get", scripts[0].Name())
+	assert.Equal(t, "incrby", scripts[1].Name())
+
+	do(func(client Redis) {
+		assert.NoError(t, r.Preload(ctx, client))
+		incrby, _ := r.Get("incrby")
+		exists, err := incrby.Exists(ctx, client).Result()
+		assert.NoError(t, err)
+		assert.Equal(t, []bool{true}, exists)
+
+		for _, key := range []string{"a", "b", "c", "d"} {
+			assert.Equal(t, int64(2), r.Run(ctx, client, "incrby", []string{key}, 2).Val())
+			assert.Equal(t, "2", r.Run(ctx, client, "get", []string{key}).Val())
+		}
+
+		// 脚本被清空后自动退回EVAL
+		assert.NoError(t, client.ScriptFlush(ctx).Err())
+		assert.Equal(t, []bool{false}, incrby.Exists(ctx, client).Val())
+		assert.Equal(t, int64(5), r.Run(ctx, client, "incrby", []string{"a"}, 3).Val())
+
+		err = r.Run(ctx, client, "missing", []string{"a"}).Err()
+		assert.True(t, errors.Is(err, ErrScriptNotFound))
+		err = r.Run(ctx, client, "get", []string{"a", "b", "c", "d"}).Err()
+		assert.Equal(t, ErrCrossShard, err)
+		assert.Equal(t, int64(1), r.Run(ctx, client, "incrby", []string{"{a}1", "{a}2"}, 1).Val())
+	})
+}
@@ -47,6 +47,7 @@ func NewSentinelClient(opt *Config) *RedisContainer {
 		Redis:     baseClient,
 		Opt:       *opt,
 		redisType: RedisTypeSentinel,
+		metrics:   addHook(opt, baseClient, opt.MasterNames[0], baseClient.PoolStats),
 	}
 	return c
 }
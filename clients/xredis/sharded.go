@@ -16,12 +16,11 @@ package xredis
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"regexp"
 	"sort"
 	"sync"
 	"time"
-	_ "unsafe"
 
 	"github.com/hashicorp/go-multierror"
 
@@ -32,11 +31,9 @@ const (
 	shardedFactor = 160
 )
 
-var keyTagPattern = regexp.MustCompile(`{(.+?)}`)
-
 var pipelineCmds = [...]string{
 	// key
-	"del", "exists", "expire", "expireat", "persist", "pexpire", "pexpireat", "pttl", "randomkey", "rename", "renamenx", "restore", "sort", "ttl", "type",
+	"del", "exists", "expire", "expireat", "persist", "pexpire", "pexpireat", "pttl", "randomkey", "rename", "renamenx", "restore", "sort", "touch", "ttl", "type", "unlink",
 	// string
 	"append", "bitcount", "bitop", "decr", "decrby", "get", "getbit", "getrange", "getset", "incr", "incrby", "incrbyfloat", "mget", "mset", "msetnx", "psetex", "set", "setbit", "setex", "setnx", "setrange", "strlen",
 	// hash
@@ -47,13 +44,45 @@ var pipelineCmds = [...]string{
 	"sadd", "scard", "sdiff", "sdiffstore", "sinter", "sinterstore", "sismember", "smembers", "smove", "spop", "srandmember", "srem", "sunion", "sunionstore", "sscan",
 	// sort set
 	"zadd", "zcard", "zcount", "zincrby", "zrange", "zrangebyscore", "zrank", "zrem", "zremrangebyrank", "zremrangebyscore", "zrevrange", "zrevrangebyscore", "zrevrank", "zscore", "zunionstore", "zinterstore", "zscan",
+	// hyperloglog
+	"pfadd", "pfcount", "pfmerge",
 }
 
 func NewShardedClient(sis []*ShardInfo) Redis {
+	algo := &MurmurHash{}
+	return &ShardedClient{
+		ring: newShardRing(sis, algo),
+		algo: algo,
+		ctx:  context.Background(),
+	}
+}
+
+type ShardedClient struct {
+	Redis
+	ring *shardRing
+	algo Hashing
+
+	// 迁移期间保留旧的hash环，读不到数据时回退到旧分片
+	oldRing *shardRing
+	// 迁移结束后的回调，sentinel用来停止跟踪被移除的master
+	onFinishMigration func()
+
+	sync.RWMutex
+
+	ctx context.Context
+}
+
+// shardRing 一致性hash环
+type shardRing struct {
+	nodes        map[int64]*ShardInfo
+	sortedHashes []int64
+	resources    map[string]*ShardInfo
+}
+
+func newShardRing(sis []*ShardInfo, algo Hashing) *shardRing {
 	nodes := make(map[int64]*ShardInfo, len(sis)*shardedFactor)
 	sortedHashes := make([]int64, 0, len(sis)*shardedFactor)
 	resources := make(map[string]*ShardInfo, len(sis))
-	algo := &MurmurHash{}
 
 	for i, si := range sis {
 		if si.name == "" {
@@ -74,27 +103,38 @@ func NewShardedClient(sis []*ShardInfo) Redis {
 	sort.Slice(sortedHashes, func(i int, j int) bool {
 		return sortedHashes[i] < sortedHashes[j]
 	})
-	return &ShardedClient{
+	return &shardRing{
 		nodes:        nodes,
 		sortedHashes: sortedHashes,
-		algo:         algo,
 		resources:    resources,
-		tagPattern:   keyTagPattern,
-		ctx:          context.Background(),
 	}
 }
 
-type ShardedClient struct {
-	Redis
-	nodes        map[int64]*ShardInfo
-	sortedHashes []int64
-	algo         Hashing
-	resources    map[string]*ShardInfo
-	tagPattern   *regexp.Regexp
+func (r *shardRing) get(k int64) *ShardInfo {
+	idx := sort.Search(len(r.sortedHashes), func(i int) bool {
+		return r.sortedHashes[i] >= k
+	})
 
-	sync.RWMutex // 目前只有读 没有写
+	if idx >= len(r.sortedHashes) {
+		idx = 0
+	}
 
-	ctx context.Context
+	return r.nodes[r.sortedHashes[idx]]
+}
+
+// replace 替换id对应的分片，返回被替换的分片
+func (r *shardRing) replace(id string, si *ShardInfo) *ShardInfo {
+	old, ok := r.resources[id]
+	if !ok {
+		return nil
+	}
+	r.resources[id] = si
+	for k, v := range r.nodes {
+		if v == old {
+			r.nodes[k] = si
+		}
+	}
+	return old
 }
 
 // --- commands --------------------------------------
@@ -116,30 +156,51 @@ func (c *ShardedClient) Pipelined(ctx context.Context, fn func(redis.Pipeliner)
 	return c.Pipeline().Pipelined(ctx, fn)
 }
 
+// processPipeline 按照key将命令分配到各个分片，并发执行每个分片的pipeline。
+// 不支持的命令单独记录错误，不影响其他命令执行。迁移期间返回redis.Nil的命令会在旧分片上重新执行
 func (c *ShardedClient) processPipeline(ctx context.Context, cmds []redis.Cmder) error {
-	err := checkCmds(cmds)
-	if err != nil {
-		return err
-	}
 	cmdsMap := newCmdsMap()
+	olds := make([]Redis, len(cmds))
 	for i := range cmds {
-		key, ok := cmds[i].Args()[1].(string)
-		if !ok {
-			return fmt.Errorf("exists unsupport command: [%s]", cmds[i])
+		if err := checkCmds(cmds[i : i+1]); err != nil {
+			cmds[i].SetErr(err)
+			continue
+		}
+		keys, err := cmdKeys(cmds[i])
+		if err != nil {
+			cmds[i].SetErr(err)
+			continue
 		}
-		client := c.getShard(key)
+		if _, err := c.sameShard(keys...); err != nil {
+			cmds[i].SetErr(err)
+			continue
+		}
+		client, old := c.getShards(keys[0])
 		cmdsMap.Add(client, cmds[i])
+		olds[i] = old
+	}
+	execShards(ctx, cmdsMap)
+
+	fallback := newCmdsMap()
+	for i, old := range olds {
+		if old != nil && fallbackCmds[cmds[i].Name()] && errors.Is(cmds[i].Err(), redis.Nil) {
+			fallback.Add(old, cmds[i])
+		}
 	}
+	execShards(ctx, fallback)
+	return cmdsFirstErr(cmds)
+}
+
+func execShards(ctx context.Context, cmdsMap *cmdsMap) {
 	var wg sync.WaitGroup
 	for client, cmds := range cmdsMap.m {
 		wg.Add(1)
 		go func(client Redis, cmds []redis.Cmder) {
 			defer wg.Done()
-			processPipeline(ctx, client, cmds)
+			_ = execPipeline(ctx, client, cmds)
 		}(client, cmds)
 	}
 	wg.Wait()
-	return cmdsFirstErr(cmds)
 }
 
 func checkCmds(cmds []redis.Cmder) error {
@@ -188,31 +249,10 @@ func (c *ShardedClient) Ping(ctx context.Context) *redis.StatusCmd {
 func (c *ShardedClient) Quit(ctx context.Context) *redis.StatusCmd {
 	panic("unsupport method..")
 }
-func (c *ShardedClient) Del(ctx context.Context, keys ...string) *redis.IntCmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
-	}
-	client := c.getShard(keys[0])
-	return client.Del(ctx, keys[0])
-}
-func (c *ShardedClient) Unlink(ctx context.Context, keys ...string) *redis.IntCmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
-	}
-	client := c.getShard(keys[0])
-	return client.Unlink(ctx, keys[0])
-}
 func (c *ShardedClient) Dump(ctx context.Context, key string) *redis.StringCmd {
 	client := c.getShard(key)
 	return client.Dump(ctx, key)
 }
-func (c *ShardedClient) Exists(ctx context.Context, keys ...string) *redis.IntCmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
-	}
-	client := c.getShard(keys[0])
-	return client.Exists(ctx, keys[0])
-}
 func (c *ShardedClient) Expire(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd {
 	client := c.getShard(key)
 	return client.Expire(ctx, key, expiration)
@@ -221,9 +261,6 @@ func (c *ShardedClient) ExpireAt(ctx context.Context, key string, tm time.Time)
 	client := c.getShard(key)
 	return client.ExpireAt(ctx, key, tm)
 }
-func (c *ShardedClient) Keys(ctx context.Context, pattern string) *redis.StringSliceCmd {
-	panic("unsupport method..")
-}
 func (c *ShardedClient) Migrate(ctx context.Context, host, port, key string, db int, timeout time.Duration) *redis.StatusCmd {
 	client := c.getShard(key)
 	return client.Migrate(ctx, host, port, key, db, timeout)
@@ -257,18 +294,32 @@ func (c *ShardedClient) PExpireAt(ctx context.Context, key string, tm time.Time)
 	return client.PExpireAt(ctx, key, tm)
 }
 func (c *ShardedClient) PTTL(ctx context.Context, key string) *redis.DurationCmd {
-	client := c.getShard(key)
-	return client.PTTL(ctx, key)
+	client, old := c.getShards(key)
+	cmd := client.PTTL(ctx, key)
+	if old != nil && cmd.Err() == nil && cmd.Val() == -2 {
+		return old.PTTL(ctx, key)
+	}
+	return cmd
 }
 func (c *ShardedClient) RandomKey(ctx context.Context) *redis.StringCmd {
 	panic("unsupport method..")
 }
 func (c *ShardedClient) Rename(ctx context.Context, key, newkey string) *redis.StatusCmd {
-	client := c.getShard(key)
+	client, err := c.sameShard(key, newkey)
+	if err != nil {
+		cmd := redis.NewStatusCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
+	}
 	return client.Rename(ctx, key, newkey)
 }
 func (c *ShardedClient) RenameNX(ctx context.Context, key, newkey string) *redis.BoolCmd {
-	client := c.getShard(key)
+	client, err := c.sameShard(key, newkey)
+	if err != nil {
+		cmd := redis.NewBoolCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
+	}
 	return client.RenameNX(ctx, key, newkey)
 }
 func (c *ShardedClient) Restore(ctx context.Context, key string, ttl time.Duration, value string) *redis.StatusCmd {
@@ -291,20 +342,21 @@ func (c *ShardedClient) SortInterfaces(ctx context.Context, key string, sort *re
 	client := c.getShard(key)
 	return client.SortInterfaces(ctx, key, sort)
 }
-func (c *ShardedClient) Touch(ctx context.Context, keys ...string) *redis.IntCmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
-	}
-	client := c.getShard(keys[0])
-	return client.Touch(ctx, keys[0])
-}
 func (c *ShardedClient) TTL(ctx context.Context, key string) *redis.DurationCmd {
-	client := c.getShard(key)
-	return client.TTL(ctx, key)
+	client, old := c.getShards(key)
+	cmd := client.TTL(ctx, key)
+	if old != nil && cmd.Err() == nil && cmd.Val() == -2 {
+		return old.TTL(ctx, key)
+	}
+	return cmd
 }
 func (c *ShardedClient) Type(ctx context.Context, key string) *redis.StatusCmd {
-	client := c.getShard(key)
-	return client.Type(ctx, key)
+	client, old := c.getShards(key)
+	cmd := client.Type(ctx, key)
+	if old != nil && cmd.Err() == nil && cmd.Val() == "none" {
+		return old.Type(ctx, key)
+	}
+	return cmd
 }
 func (c *ShardedClient) Append(ctx context.Context, key, value string) *redis.IntCmd {
 	client := c.getShard(key)
@@ -319,8 +371,12 @@ func (c *ShardedClient) DecrBy(ctx context.Context, key string, decrement int64)
 	return client.DecrBy(ctx, key, decrement)
 }
 func (c *ShardedClient) Get(ctx context.Context, key string) *redis.StringCmd {
-	client := c.getShard(key)
-	return client.Get(ctx, key)
+	client, old := c.getShards(key)
+	cmd := client.Get(ctx, key)
+	if old != nil && errors.Is(cmd.Err(), redis.Nil) {
+		return old.Get(ctx, key)
+	}
+	return cmd
 }
 func (c *ShardedClient) GetRange(ctx context.Context, key string, start, end int64) *redis.StringCmd {
 	client := c.getShard(key)
@@ -342,19 +398,6 @@ func (c *ShardedClient) IncrByFloat(ctx context.Context, key string, value float
 	client := c.getShard(key)
 	return client.IncrByFloat(ctx, key, value)
 }
-func (c *ShardedClient) MGet(ctx context.Context, keys ...string) *redis.SliceCmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
-	}
-	client := c.getShard(keys[0])
-	return client.MGet(ctx, keys[0])
-}
-func (c *ShardedClient) MSet(ctx context.Context, values ...interface{}) *redis.StatusCmd {
-	panic("unsupport method..")
-}
-func (c *ShardedClient) MSetNX(ctx context.Context, values ...interface{}) *redis.BoolCmd {
-	panic("unsupport method..")
-}
 func (c *ShardedClient) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
 	client := c.getShard(key)
 	return client.Set(ctx, key, value, expiration)
@@ -392,28 +435,39 @@ func (c *ShardedClient) BitCount(ctx context.Context, key string, bitCount *redi
 	return client.BitCount(ctx, key, bitCount)
 }
 func (c *ShardedClient) BitOpAnd(ctx context.Context, destKey string, keys ...string) *redis.IntCmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
+	client, err := c.sameShard(append([]string{destKey}, keys...)...)
+	if err != nil {
+		cmd := redis.NewIntCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
 	}
-	client := c.getShard(keys[0])
-	return client.BitOpAnd(ctx, destKey, keys[0])
+	return client.BitOpAnd(ctx, destKey, keys...)
 }
 func (c *ShardedClient) BitOpOr(ctx context.Context, destKey string, keys ...string) *redis.IntCmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
+	client, err := c.sameShard(append([]string{destKey}, keys...)...)
+	if err != nil {
+		cmd := redis.NewIntCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
 	}
-	client := c.getShard(keys[0])
-	return client.BitOpOr(ctx, destKey, keys[0])
+	return client.BitOpOr(ctx, destKey, keys...)
 }
 func (c *ShardedClient) BitOpXor(ctx context.Context, destKey string, keys ...string) *redis.IntCmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
+	client, err := c.sameShard(append([]string{destKey}, keys...)...)
+	if err != nil {
+		cmd := redis.NewIntCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
 	}
-	client := c.getShard(keys[0])
-	return client.BitOpXor(ctx, destKey, keys[0])
+	return client.BitOpXor(ctx, destKey, keys...)
 }
 func (c *ShardedClient) BitOpNot(ctx context.Context, destKey string, key string) *redis.IntCmd {
-	client := c.getShard(key)
+	client, err := c.sameShard(destKey, key)
+	if err != nil {
+		cmd := redis.NewIntCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
+	}
 	return client.BitOpNot(ctx, destKey, key)
 }
 func (c *ShardedClient) BitPos(ctx context.Context, key string, bit int64, pos ...int64) *redis.IntCmd {
@@ -424,9 +478,6 @@ func (c *ShardedClient) BitField(ctx context.Context, key string, args ...interf
 	client := c.getShard(key)
 	return client.BitField(ctx, key, args...)
 }
-func (c *ShardedClient) Scan(ctx context.Context, cursor uint64, match string, count int64) *redis.ScanCmd {
-	panic("unsupport method..")
-}
 func (c *ShardedClient) SScan(ctx context.Context, key string, cursor uint64, match string, count int64) *redis.ScanCmd {
 	client := c.getShard(key)
 	return client.SScan(ctx, key, cursor, match, count)
@@ -444,16 +495,28 @@ func (c *ShardedClient) HDel(ctx context.Context, key string, fields ...string)
 	return client.HDel(ctx, key, fields...)
 }
 func (c *ShardedClient) HExists(ctx context.Context, key, field string) *redis.BoolCmd {
-	client := c.getShard(key)
-	return client.HExists(ctx, key, field)
+	client, old := c.getShards(key)
+	cmd := client.HExists(ctx, key, field)
+	if old != nil && cmd.Err() == nil && !cmd.Val() {
+		return old.HExists(ctx, key, field)
+	}
+	return cmd
 }
 func (c *ShardedClient) HGet(ctx context.Context, key, field string) *redis.StringCmd {
-	client := c.getShard(key)
-	return client.HGet(ctx, key, field)
+	client, old := c.getShards(key)
+	cmd := client.HGet(ctx, key, field)
+	if old != nil && errors.Is(cmd.Err(), redis.Nil) {
+		return old.HGet(ctx, key, field)
+	}
+	return cmd
 }
 func (c *ShardedClient) HGetAll(ctx context.Context, key string) *redis.StringStringMapCmd {
-	client := c.getShard(key)
-	return client.HGetAll(ctx, key)
+	client, old := c.getShards(key)
+	cmd := client.HGetAll(ctx, key)
+	if old != nil && cmd.Err() == nil && len(cmd.Val()) == 0 {
+		return old.HGetAll(ctx, key)
+	}
+	return cmd
 }
 func (c *ShardedClient) HIncrBy(ctx context.Context, key, field string, incr int64) *redis.IntCmd {
 	client := c.getShard(key)
@@ -464,16 +527,28 @@ func (c *ShardedClient) HIncrByFloat(ctx context.Context, key, field string, inc
 	return client.HIncrByFloat(ctx, key, field, incr)
 }
 func (c *ShardedClient) HKeys(ctx context.Context, key string) *redis.StringSliceCmd {
-	client := c.getShard(key)
-	return client.HKeys(ctx, key)
+	client, old := c.getShards(key)
+	cmd := client.HKeys(ctx, key)
+	if old != nil && cmd.Err() == nil && len(cmd.Val()) == 0 {
+		return old.HKeys(ctx, key)
+	}
+	return cmd
 }
 func (c *ShardedClient) HLen(ctx context.Context, key string) *redis.IntCmd {
-	client := c.getShard(key)
-	return client.HLen(ctx, key)
+	client, old := c.getShards(key)
+	cmd := client.HLen(ctx, key)
+	if old != nil && cmd.Err() == nil && cmd.Val() == 0 {
+		return old.HLen(ctx, key)
+	}
+	return cmd
 }
 func (c *ShardedClient) HMGet(ctx context.Context, key string, fields ...string) *redis.SliceCmd {
-	client := c.getShard(key)
-	return client.HMGet(ctx, key, fields...)
+	client, old := c.getShards(key)
+	cmd := client.HMGet(ctx, key, fields...)
+	if old != nil && cmd.Err() == nil && allNil(cmd.Val()) {
+		return old.HMGet(ctx, key, fields...)
+	}
+	return cmd
 }
 func (c *ShardedClient) HSet(ctx context.Context, key string, values ...interface{}) *redis.IntCmd {
 	client := c.getShard(key)
@@ -488,29 +563,47 @@ func (c *ShardedClient) HSetNX(ctx context.Context, key, field string, value int
 	return client.HSetNX(ctx, key, field, value)
 }
 func (c *ShardedClient) HVals(ctx context.Context, key string) *redis.StringSliceCmd {
-	client := c.getShard(key)
-	return client.HVals(ctx, key)
+	client, old := c.getShards(key)
+	cmd := client.HVals(ctx, key)
+	if old != nil && cmd.Err() == nil && len(cmd.Val()) == 0 {
+		return old.HVals(ctx, key)
+	}
+	return cmd
 }
 func (c *ShardedClient) BLPop(ctx context.Context, timeout time.Duration, keys ...string) *redis.StringSliceCmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
+	client, err := c.sameShard(keys...)
+	if err != nil {
+		cmd := redis.NewStringSliceCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
 	}
-	client := c.getShard(keys[0])
-	return client.BLPop(ctx, timeout, keys[0])
+	return client.BLPop(ctx, timeout, keys...)
 }
 func (c *ShardedClient) BRPop(ctx context.Context, timeout time.Duration, keys ...string) *redis.StringSliceCmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
+	client, err := c.sameShard(keys...)
+	if err != nil {
+		cmd := redis.NewStringSliceCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
 	}
-	client := c.getShard(keys[0])
-	return client.BRPop(ctx, timeout, keys[0])
+	return client.BRPop(ctx, timeout, keys...)
 }
 func (c *ShardedClient) BRPopLPush(ctx context.Context, source, destination string, timeout time.Duration) *redis.StringCmd {
-	panic("unsupport method..")
+	client, err := c.sameShard(source, destination)
+	if err != nil {
+		cmd := redis.NewStringCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
+	}
+	return client.BRPopLPush(ctx, source, destination, timeout)
 }
 func (c *ShardedClient) LIndex(ctx context.Context, key string, index int64) *redis.StringCmd {
-	client := c.getShard(key)
-	return client.LIndex(ctx, key, index)
+	client, old := c.getShards(key)
+	cmd := client.LIndex(ctx, key, index)
+	if old != nil && errors.Is(cmd.Err(), redis.Nil) {
+		return old.LIndex(ctx, key, index)
+	}
+	return cmd
 }
 func (c *ShardedClient) LInsert(ctx context.Context, key, op string, pivot, value interface{}) *redis.IntCmd {
 	client := c.getShard(key)
@@ -525,8 +618,12 @@ func (c *ShardedClient) LInsertAfter(ctx context.Context, key string, pivot, val
 	return client.LInsertAfter(ctx, key, pivot, value)
 }
 func (c *ShardedClient) LLen(ctx context.Context, key string) *redis.IntCmd {
-	client := c.getShard(key)
-	return client.LLen(ctx, key)
+	client, old := c.getShards(key)
+	cmd := client.LLen(ctx, key)
+	if old != nil && cmd.Err() == nil && cmd.Val() == 0 {
+		return old.LLen(ctx, key)
+	}
+	return cmd
 }
 func (c *ShardedClient) LPop(ctx context.Context, key string) *redis.StringCmd {
 	client := c.getShard(key)
@@ -541,8 +638,12 @@ func (c *ShardedClient) LPushX(ctx context.Context, key string, values ...interf
 	return client.LPushX(ctx, key, values...)
 }
 func (c *ShardedClient) LRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd {
-	client := c.getShard(key)
-	return client.LRange(ctx, key, start, stop)
+	client, old := c.getShards(key)
+	cmd := client.LRange(ctx, key, start, stop)
+	if old != nil && cmd.Err() == nil && len(cmd.Val()) == 0 {
+		return old.LRange(ctx, key, start, stop)
+	}
+	return cmd
 }
 func (c *ShardedClient) LRem(ctx context.Context, key string, count int64, value interface{}) *redis.IntCmd {
 	client := c.getShard(key)
@@ -561,7 +662,13 @@ func (c *ShardedClient) RPop(ctx context.Context, key string) *redis.StringCmd {
 	return client.RPop(ctx, key)
 }
 func (c *ShardedClient) RPopLPush(ctx context.Context, source, destination string) *redis.StringCmd {
-	panic("unsupport method..")
+	client, err := c.sameShard(source, destination)
+	if err != nil {
+		cmd := redis.NewStringCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
+	}
+	return client.RPopLPush(ctx, source, destination)
 }
 func (c *ShardedClient) RPush(ctx context.Context, key string, values ...interface{}) *redis.IntCmd {
 	client := c.getShard(key)
@@ -576,51 +683,77 @@ func (c *ShardedClient) SAdd(ctx context.Context, key string, members ...interfa
 	return client.SAdd(ctx, key, members...)
 }
 func (c *ShardedClient) SCard(ctx context.Context, key string) *redis.IntCmd {
-	client := c.getShard(key)
-	return client.SCard(ctx, key)
+	client, old := c.getShards(key)
+	cmd := client.SCard(ctx, key)
+	if old != nil && cmd.Err() == nil && cmd.Val() == 0 {
+		return old.SCard(ctx, key)
+	}
+	return cmd
 }
 func (c *ShardedClient) SDiff(ctx context.Context, keys ...string) *redis.StringSliceCmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
+	client, err := c.sameShard(keys...)
+	if err != nil {
+		cmd := redis.NewStringSliceCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
 	}
-	client := c.getShard(keys[0])
-	return client.SDiff(ctx, keys[0])
+	return client.SDiff(ctx, keys...)
 }
 func (c *ShardedClient) SDiffStore(ctx context.Context, destination string, keys ...string) *redis.IntCmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
+	client, err := c.sameShard(append([]string{destination}, keys...)...)
+	if err != nil {
+		cmd := redis.NewIntCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
 	}
-	client := c.getShard(keys[0])
-	return client.SDiffStore(ctx, destination, keys[0])
+	return client.SDiffStore(ctx, destination, keys...)
 }
 func (c *ShardedClient) SInter(ctx context.Context, keys ...string) *redis.StringSliceCmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
+	client, err := c.sameShard(keys...)
+	if err != nil {
+		cmd := redis.NewStringSliceCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
 	}
-	client := c.getShard(keys[0])
-	return client.SInter(ctx, keys[0])
+	return client.SInter(ctx, keys...)
 }
 func (c *ShardedClient) SInterStore(ctx context.Context, destination string, keys ...string) *redis.IntCmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
+	client, err := c.sameShard(append([]string{destination}, keys...)...)
+	if err != nil {
+		cmd := redis.NewIntCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
 	}
-	client := c.getShard(keys[0])
-	return client.SInterStore(ctx, destination, keys[0])
+	return client.SInterStore(ctx, destination, keys...)
 }
 func (c *ShardedClient) SIsMember(ctx context.Context, key string, member interface{}) *redis.BoolCmd {
-	client := c.getShard(key)
-	return client.SIsMember(ctx, key, member)
+	client, old := c.getShards(key)
+	cmd := client.SIsMember(ctx, key, member)
+	if old != nil && cmd.Err() == nil && !cmd.Val() {
+		return old.SIsMember(ctx, key, member)
+	}
+	return cmd
 }
 func (c *ShardedClient) SMembers(ctx context.Context, key string) *redis.StringSliceCmd {
-	client := c.getShard(key)
-	return client.SMembers(ctx, key)
+	client, old := c.getShards(key)
+	cmd := client.SMembers(ctx, key)
+	if old != nil && cmd.Err() == nil && len(cmd.Val()) == 0 {
+		return old.SMembers(ctx, key)
+	}
+	return cmd
 }
 func (c *ShardedClient) SMembersMap(ctx context.Context, key string) *redis.StringStructMapCmd {
 	client := c.getShard(key)
 	return client.SMembersMap(ctx, key)
 }
 func (c *ShardedClient) SMove(ctx context.Context, source, destination string, member interface{}) *redis.BoolCmd {
-	panic("unsupport method..")
+	client, err := c.sameShard(source, destination)
+	if err != nil {
+		cmd := redis.NewBoolCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
+	}
+	return client.SMove(ctx, source, destination, member)
 }
 func (c *ShardedClient) SPop(ctx context.Context, key string) *redis.StringCmd {
 	client := c.getShard(key)
@@ -643,78 +776,125 @@ func (c *ShardedClient) SRem(ctx context.Context, key string, members ...interfa
 	return client.SRem(ctx, key, members...)
 }
 func (c *ShardedClient) SUnion(ctx context.Context, keys ...string) *redis.StringSliceCmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
+	client, err := c.sameShard(keys...)
+	if err != nil {
+		cmd := redis.NewStringSliceCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
 	}
-	client := c.getShard(keys[0])
-	return client.SUnion(ctx, keys[0])
+	return client.SUnion(ctx, keys...)
 }
 func (c *ShardedClient) SUnionStore(ctx context.Context, destination string, keys ...string) *redis.IntCmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
+	client, err := c.sameShard(append([]string{destination}, keys...)...)
+	if err != nil {
+		cmd := redis.NewIntCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
 	}
-	client := c.getShard(keys[0])
-	return client.SUnionStore(ctx, destination, keys[0])
+	return client.SUnionStore(ctx, destination, keys...)
 }
 func (c *ShardedClient) XAdd(ctx context.Context, a *redis.XAddArgs) *redis.StringCmd {
-	panic("unsupport method..")
+	client := c.getShard(a.Stream)
+	return client.XAdd(ctx, a)
 }
 func (c *ShardedClient) XDel(ctx context.Context, stream string, ids ...string) *redis.IntCmd {
-	panic("unsupport method..")
+	client := c.getShard(stream)
+	return client.XDel(ctx, stream, ids...)
 }
 func (c *ShardedClient) XLen(ctx context.Context, stream string) *redis.IntCmd {
-	panic("unsupport method..")
+	client := c.getShard(stream)
+	return client.XLen(ctx, stream)
 }
 func (c *ShardedClient) XRange(ctx context.Context, stream, start, stop string) *redis.XMessageSliceCmd {
-	panic("unsupport method..")
+	client := c.getShard(stream)
+	return client.XRange(ctx, stream, start, stop)
 }
 func (c *ShardedClient) XRangeN(ctx context.Context, stream, start, stop string, count int64) *redis.XMessageSliceCmd {
-	panic("unsupport method..")
+	client := c.getShard(stream)
+	return client.XRangeN(ctx, stream, start, stop, count)
 }
 func (c *ShardedClient) XRevRange(ctx context.Context, stream string, start, stop string) *redis.XMessageSliceCmd {
-	panic("unsupport method..")
+	client := c.getShard(stream)
+	return client.XRevRange(ctx, stream, start, stop)
 }
 func (c *ShardedClient) XRevRangeN(ctx context.Context, stream string, start, stop string, count int64) *redis.XMessageSliceCmd {
-	panic("unsupport method..")
+	client := c.getShard(stream)
+	return client.XRevRangeN(ctx, stream, start, stop, count)
 }
 func (c *ShardedClient) XRead(ctx context.Context, a *redis.XReadArgs) *redis.XStreamSliceCmd {
-	panic("unsupport method..")
+	client, err := c.sameShard(a.Streams[:len(a.Streams)/2]...)
+	if err != nil {
+		cmd := redis.NewXStreamSliceCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
+	}
+	return client.XRead(ctx, a)
 }
 func (c *ShardedClient) XReadStreams(ctx context.Context, streams ...string) *redis.XStreamSliceCmd {
-	panic("unsupport method..")
+	client, err := c.sameShard(streams[:len(streams)/2]...)
+	if err != nil {
+		cmd := redis.NewXStreamSliceCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
+	}
+	return client.XReadStreams(ctx, streams...)
 }
 func (c *ShardedClient) XGroupCreate(ctx context.Context, stream, group, start string) *redis.StatusCmd {
-	panic("unsupport method..")
+	client := c.getShard(stream)
+	return client.XGroupCreate(ctx, stream, group, start)
 }
 func (c *ShardedClient) XGroupCreateMkStream(ctx context.Context, stream, group, start string) *redis.StatusCmd {
-	panic("unsupport method..")
+	client := c.getShard(stream)
+	return client.XGroupCreateMkStream(ctx, stream, group, start)
 }
 func (c *ShardedClient) XGroupSetID(ctx context.Context, stream, group, start string) *redis.StatusCmd {
-	panic("unsupport method..")
+	client := c.getShard(stream)
+	return client.XGroupSetID(ctx, stream, group, start)
 }
 func (c *ShardedClient) XGroupDestroy(ctx context.Context, stream, group string) *redis.IntCmd {
-	panic("unsupport method..")
+	client := c.getShard(stream)
+	return client.XGroupDestroy(ctx, stream, group)
 }
 func (c *ShardedClient) XGroupDelConsumer(ctx context.Context, stream, group, consumer string) *redis.IntCmd {
-	panic("unsupport method..")
+	client := c.getShard(stream)
+	return client.XGroupDelConsumer(ctx, stream, group, consumer)
 }
 func (c *ShardedClient) XReadGroup(ctx context.Context, a *redis.XReadGroupArgs) *redis.XStreamSliceCmd {
-	panic("unsupport method..")
+	client, err := c.sameShard(a.Streams[:len(a.Streams)/2]...)
+	if err != nil {
+		cmd := redis.NewXStreamSliceCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
+	}
+	return client.XReadGroup(ctx, a)
 }
 func (c *ShardedClient) XAck(ctx context.Context, stream, group string, ids ...string) *redis.IntCmd {
-	panic("unsupport method..")
+	client := c.getShard(stream)
+	return client.XAck(ctx, stream, group, ids...)
 }
 func (c *ShardedClient) XPending(ctx context.Context, stream, group string) *redis.XPendingCmd {
-	panic("unsupport method..")
+	client := c.getShard(stream)
+	return client.XPending(ctx, stream, group)
 }
 func (c *ShardedClient) XPendingExt(ctx context.Context, a *redis.XPendingExtArgs) *redis.XPendingExtCmd {
-	panic("unsupport method..")
+	client := c.getShard(a.Stream)
+	return client.XPendingExt(ctx, a)
 }
 func (c *ShardedClient) XClaim(ctx context.Context, a *redis.XClaimArgs) *redis.XMessageSliceCmd {
-	panic("unsupport method..")
+	client := c.getShard(a.Stream)
+	return client.XClaim(ctx, a)
 }
 func (c *ShardedClient) XClaimJustID(ctx context.Context, a *redis.XClaimArgs) *redis.StringSliceCmd {
-	panic("unsupport method..")
+	client := c.getShard(a.Stream)
+	return client.XClaimJustID(ctx, a)
+}
+func (c *ShardedClient) XAutoClaim(ctx context.Context, a *redis.XAutoClaimArgs) *redis.XAutoClaimCmd {
+	client := c.getShard(a.Stream)
+	return client.XAutoClaim(ctx, a)
+}
+func (c *ShardedClient) XAutoClaimJustID(ctx context.Context, a *redis.XAutoClaimArgs) *redis.XAutoClaimJustIDCmd {
+	client := c.getShard(a.Stream)
+	return client.XAutoClaimJustID(ctx, a)
 }
 func (c *ShardedClient) XTrim(ctx context.Context, key string, maxLen int64) *redis.IntCmd {
 	client := c.getShard(key)
@@ -733,18 +913,22 @@ func (c *ShardedClient) XInfoStream(ctx context.Context, key string) *redis.XInf
 	return client.XInfoStream(ctx, key)
 }
 func (c *ShardedClient) BZPopMax(ctx context.Context, timeout time.Duration, keys ...string) *redis.ZWithKeyCmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
+	client, err := c.sameShard(keys...)
+	if err != nil {
+		cmd := redis.NewZWithKeyCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
 	}
-	client := c.getShard(keys[0])
-	return client.BZPopMax(ctx, timeout, keys[0])
+	return client.BZPopMax(ctx, timeout, keys...)
 }
 func (c *ShardedClient) BZPopMin(ctx context.Context, timeout time.Duration, keys ...string) *redis.ZWithKeyCmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
+	client, err := c.sameShard(keys...)
+	if err != nil {
+		cmd := redis.NewZWithKeyCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
 	}
-	client := c.getShard(keys[0])
-	return client.BZPopMin(ctx, timeout, keys[0])
+	return client.BZPopMin(ctx, timeout, keys...)
 }
 func (c *ShardedClient) ZAdd(ctx context.Context, key string, members ...*redis.Z) *redis.IntCmd {
 	client := c.getShard(key)
@@ -783,8 +967,12 @@ func (c *ShardedClient) ZIncrXX(ctx context.Context, key string, member *redis.Z
 	return client.ZIncrXX(ctx, key, member)
 }
 func (c *ShardedClient) ZCard(ctx context.Context, key string) *redis.IntCmd {
-	client := c.getShard(key)
-	return client.ZCard(ctx, key)
+	client, old := c.getShards(key)
+	cmd := client.ZCard(ctx, key)
+	if old != nil && cmd.Err() == nil && cmd.Val() == 0 {
+		return old.ZCard(ctx, key)
+	}
+	return cmd
 }
 func (c *ShardedClient) ZCount(ctx context.Context, key, min, max string) *redis.IntCmd {
 	client := c.getShard(key)
@@ -799,7 +987,13 @@ func (c *ShardedClient) ZIncrBy(ctx context.Context, key string, increment float
 	return client.ZIncrBy(ctx, key, increment, member)
 }
 func (c *ShardedClient) ZInterStore(ctx context.Context, destination string, store *redis.ZStore) *redis.IntCmd {
-	panic("unsupport method..")
+	client, err := c.sameShard(append([]string{destination}, store.Keys...)...)
+	if err != nil {
+		cmd := redis.NewIntCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
+	}
+	return client.ZInterStore(ctx, destination, store)
 }
 func (c *ShardedClient) ZPopMax(ctx context.Context, key string, count ...int64) *redis.ZSliceCmd {
 	client := c.getShard(key)
@@ -810,12 +1004,20 @@ func (c *ShardedClient) ZPopMin(ctx context.Context, key string, count ...int64)
 	return client.ZPopMin(ctx, key, count...)
 }
 func (c *ShardedClient) ZRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd {
-	client := c.getShard(key)
-	return client.ZRange(ctx, key, start, stop)
+	client, old := c.getShards(key)
+	cmd := client.ZRange(ctx, key, start, stop)
+	if old != nil && cmd.Err() == nil && len(cmd.Val()) == 0 {
+		return old.ZRange(ctx, key, start, stop)
+	}
+	return cmd
 }
 func (c *ShardedClient) ZRangeWithScores(ctx context.Context, key string, start, stop int64) *redis.ZSliceCmd {
-	client := c.getShard(key)
-	return client.ZRangeWithScores(ctx, key, start, stop)
+	client, old := c.getShards(key)
+	cmd := client.ZRangeWithScores(ctx, key, start, stop)
+	if old != nil && cmd.Err() == nil && len(cmd.Val()) == 0 {
+		return old.ZRangeWithScores(ctx, key, start, stop)
+	}
+	return cmd
 }
 func (c *ShardedClient) ZRangeByScore(ctx context.Context, key string, opt *redis.ZRangeBy) *redis.StringSliceCmd {
 	client := c.getShard(key)
@@ -850,8 +1052,12 @@ func (c *ShardedClient) ZRemRangeByLex(ctx context.Context, key, min, max string
 	return client.ZRemRangeByLex(ctx, key, min, max)
 }
 func (c *ShardedClient) ZRevRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd {
-	client := c.getShard(key)
-	return client.ZRevRange(ctx, key, start, stop)
+	client, old := c.getShards(key)
+	cmd := client.ZRevRange(ctx, key, start, stop)
+	if old != nil && cmd.Err() == nil && len(cmd.Val()) == 0 {
+		return old.ZRevRange(ctx, key, start, stop)
+	}
+	return cmd
 }
 func (c *ShardedClient) ZRevRangeWithScores(ctx context.Context, key string, start, stop int64) *redis.ZSliceCmd {
 	client := c.getShard(key)
@@ -874,29 +1080,43 @@ func (c *ShardedClient) ZRevRank(ctx context.Context, key, member string) *redis
 	return client.ZRevRank(ctx, key, member)
 }
 func (c *ShardedClient) ZScore(ctx context.Context, key, member string) *redis.FloatCmd {
-	client := c.getShard(key)
-	return client.ZScore(ctx, key, member)
+	client, old := c.getShards(key)
+	cmd := client.ZScore(ctx, key, member)
+	if old != nil && errors.Is(cmd.Err(), redis.Nil) {
+		return old.ZScore(ctx, key, member)
+	}
+	return cmd
 }
 func (c *ShardedClient) ZUnionStore(ctx context.Context, dest string, store *redis.ZStore) *redis.IntCmd {
-	panic("unsupport method..")
+	client, err := c.sameShard(append([]string{dest}, store.Keys...)...)
+	if err != nil {
+		cmd := redis.NewIntCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
+	}
+	return client.ZUnionStore(ctx, dest, store)
 }
 func (c *ShardedClient) PFAdd(ctx context.Context, key string, els ...interface{}) *redis.IntCmd {
 	client := c.getShard(key)
 	return client.PFAdd(ctx, key, els...)
 }
 func (c *ShardedClient) PFCount(ctx context.Context, keys ...string) *redis.IntCmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
+	client, err := c.sameShard(keys...)
+	if err != nil {
+		cmd := redis.NewIntCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
 	}
-	client := c.getShard(keys[0])
-	return client.PFCount(ctx, keys[0])
+	return client.PFCount(ctx, keys...)
 }
 func (c *ShardedClient) PFMerge(ctx context.Context, dest string, keys ...string) *redis.StatusCmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
+	client, err := c.sameShard(append([]string{dest}, keys...)...)
+	if err != nil {
+		cmd := redis.NewStatusCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
 	}
-	client := c.getShard(keys[0])
-	return client.PFMerge(ctx, dest, keys[0])
+	return client.PFMerge(ctx, dest, keys...)
 }
 func (c *ShardedClient) BgRewriteAOF(ctx context.Context) *redis.StatusCmd {
 	panic("unsupport method..")
@@ -931,9 +1151,6 @@ func (c *ShardedClient) ConfigSet(ctx context.Context, parameter, value string)
 func (c *ShardedClient) ConfigRewrite(ctx context.Context) *redis.StatusCmd {
 	panic("unsupport method..")
 }
-func (c *ShardedClient) DBSize(ctx context.Context) *redis.IntCmd {
-	panic("unsupport method..")
-}
 func (c *ShardedClient) FlushAll(ctx context.Context) *redis.StatusCmd {
 	panic("unsupport method..")
 }
@@ -985,30 +1202,96 @@ func (c *ShardedClient) MemoryUsage(ctx context.Context, key string, samples ...
 	return client.MemoryUsage(ctx, key, samples...)
 }
 func (c *ShardedClient) Eval(ctx context.Context, script string, keys []string, args ...interface{}) *redis.Cmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
+	client, err := c.sameShard(keys...)
+	if err != nil {
+		cmd := redis.NewCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
 	}
-	client := c.getShard(keys[0])
-	return client.Eval(ctx, script, keys[:1], args)
+	return client.Eval(ctx, script, keys, args...)
 }
 func (c *ShardedClient) EvalSha(ctx context.Context, sha1 string, keys []string, args ...interface{}) *redis.Cmd {
-	if len(keys) != 1 {
-		panic("the length of keys must be equal to 1")
+	client, err := c.sameShard(keys...)
+	if err != nil {
+		cmd := redis.NewCmd(ctx)
+		cmd.SetErr(err)
+		return cmd
 	}
-	client := c.getShard(keys[0])
-	return client.EvalSha(ctx, sha1, keys[:1], args)
+	return client.EvalSha(ctx, sha1, keys, args...)
 }
+
+// ScriptExists 在所有分片上检查脚本，只有全部分片都已加载才返回true
 func (c *ShardedClient) ScriptExists(ctx context.Context, hashes ...string) *redis.BoolSliceCmd {
-	panic("unsupport method..")
+	args := make([]interface{}, 2+len(hashes))
+	args[0] = "script"
+	args[1] = "exists"
+	for i, hash := range hashes {
+		args[2+i] = hash
+	}
+	cmd := redis.NewBoolSliceCmd(ctx, args...)
+	val := make([]bool, len(hashes))
+	for i := range val {
+		val[i] = true
+	}
+	var mu sync.Mutex
+	err := c.eachShard(func(client Redis) error {
+		exists, err := client.ScriptExists(ctx, hashes...).Result()
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for i := range val {
+			val[i] = val[i] && i < len(exists) && exists[i]
+		}
+		return nil
+	})
+	if err != nil {
+		cmd.SetErr(err)
+		return cmd
+	}
+	cmd.SetVal(val)
+	return cmd
 }
+
+// ScriptFlush 清空所有分片上的脚本缓存
 func (c *ShardedClient) ScriptFlush(ctx context.Context) *redis.StatusCmd {
-	panic("unsupport method..")
+	cmd := redis.NewStatusCmd(ctx, "script", "flush")
+	err := c.eachShard(func(client Redis) error {
+		return client.ScriptFlush(ctx).Err()
+	})
+	if err != nil {
+		cmd.SetErr(err)
+		return cmd
+	}
+	cmd.SetVal("OK")
+	return cmd
 }
 func (c *ShardedClient) ScriptKill(ctx context.Context) *redis.StatusCmd {
 	panic("unsupport method..")
 }
+
+// ScriptLoad 在所有分片上加载脚本，返回脚本的sha1
 func (c *ShardedClient) ScriptLoad(ctx context.Context, script string) *redis.StringCmd {
-	panic("unsupport method..")
+	cmd := redis.NewStringCmd(ctx, "script", "load", script)
+	var mu sync.Mutex
+	var sha string
+	err := c.eachShard(func(client Redis) error {
+		v, err := client.ScriptLoad(ctx, script).Result()
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		sha = v
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		cmd.SetErr(err)
+		return cmd
+	}
+	cmd.SetVal(sha)
+	return cmd
 }
 func (c *ShardedClient) Publish(ctx context.Context, channel string, message interface{}) *redis.IntCmd {
 	panic("unsupport method..")
@@ -1114,10 +1397,24 @@ func (c *ShardedClient) GeoHash(ctx context.Context, key string, members ...stri
 }
 
 // ---------------------------------------------------
+// getAllShards 返回所有分片，迁移期间包含只在旧hash环中的分片
 func (c *ShardedClient) getAllShards() []*ShardInfo {
-	sis := make([]*ShardInfo, 0, len(c.resources))
-	for _, v := range c.resources {
+	c.RLock()
+	defer c.RUnlock()
+
+	sis := make([]*ShardInfo, 0, len(c.ring.resources))
+	clients := make(map[Redis]bool, len(c.ring.resources))
+	for _, v := range c.ring.resources {
 		sis = append(sis, v)
+		clients[v.client] = true
+	}
+	if c.oldRing != nil {
+		for _, v := range c.oldRing.resources {
+			if !clients[v.client] {
+				sis = append(sis, v)
+				clients[v.client] = true
+			}
+		}
 	}
 	return sis
 }
@@ -1131,39 +1428,49 @@ func (c *ShardedClient) getShardInfo(key string) *ShardInfo {
 	c.RLock()
 	defer c.RUnlock()
 
-	k := c.algo.hash(c.getKeyTag(key))
-	idx := sort.Search(len(c.sortedHashes), func(i int) bool {
-		return c.sortedHashes[i] >= k
-	})
-
-	if idx >= len(c.sortedHashes) {
-		idx = 0
-	}
-
-	return c.nodes[c.sortedHashes[idx]]
+	return c.ring.get(c.algo.hash(c.getKeyTag(key)))
 }
 
+// ChangeShardInfo 替换分片的客户端，并关闭旧的客户端
 func (c *ShardedClient) ChangeShardInfo(id string, si *ShardInfo) {
 	c.Lock()
 	defer c.Unlock()
-	old := c.resources[id]
-	c.resources[id] = si
-	for k, v := range c.nodes {
-		if v == old {
-			c.nodes[k] = si
+	old := c.ring.replace(id, si)
+	placed := old != nil
+	if c.oldRing != nil {
+		if o := c.oldRing.replace(id, si); o != nil {
+			placed = true
+			if old == nil || o.client != old.client {
+				o.client.Close()
+			}
 		}
 	}
-	old.client.Close()
+	if old != nil {
+		old.client.Close()
+	}
+	// 分片已经不在hash环中，新的客户端不会被使用
+	if !placed {
+		si.client.Close()
+	}
 }
 
-func (c *ShardedClient) getKeyTag(key string) string {
-	if c.tagPattern != nil {
-		m := c.tagPattern.FindStringSubmatch(key)
-		if len(m) > 1 {
-			return m[1]
-		}
+// hasShard 返回id对应的分片是否在hash环中，迁移期间同时检查旧hash环
+func (c *ShardedClient) hasShard(id string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	if _, ok := c.ring.resources[id]; ok {
+		return true
+	}
+	if c.oldRing != nil {
+		_, ok := c.oldRing.resources[id]
+		return ok
 	}
-	return key
+	return false
+}
+
+// getKeyTag 返回用于计算分片的key，包含{...}时只使用其中的内容，保证相同hash tag的key在同一个分片
+func (c *ShardedClient) getKeyTag(key string) string {
+	return hashTag(key)
 }
 
 type ShardInfo struct {
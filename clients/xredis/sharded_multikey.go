@@ -0,0 +1,496 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrCrossShard 多个key不在同一个分片，命令的语义无法跨分片保证。可以使用{...}将key分配到同一个分片
+var ErrCrossShard = errors.New("keys in request don't hash to the same shard")
+
+// scanShardShift Scan的游标高16位为分片的序号，低48位为分片内的游标
+const scanShardShift = 48
+
+// sameShard 返回所有key所在的分片，key不在同一个分片时返回ErrCrossShard
+func (c *ShardedClient) sameShard(keys ...string) (Redis, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no keys in request")
+	}
+	client := c.getShard(keys[0])
+	for _, key := range keys[1:] {
+		if c.getShard(key) != client {
+			return nil, ErrCrossShard
+		}
+	}
+	return client, nil
+}
+
+// groupKeys 按照分片对key分组，返回每个分片的key在原列表中的下标
+func (c *ShardedClient) groupKeys(keys []string) map[Redis][]int {
+	groups := make(map[Redis][]int)
+	for i, key := range keys {
+		client := c.getShard(key)
+		groups[client] = append(groups[client], i)
+	}
+	return groups
+}
+
+// eachGroup 并发地在每个分片上执行fn
+func eachGroup(groups map[Redis][]int, fn func(client Redis, idx []int)) {
+	var wg sync.WaitGroup
+	for client, idx := range groups {
+		wg.Add(1)
+		go func(client Redis, idx []int) {
+			defer wg.Done()
+			fn(client, idx)
+		}(client, idx)
+	}
+	wg.Wait()
+}
+
+// eachShard 在所有分片（迁移期间包含旧分片）上并发执行fn，返回第一个错误
+func (c *ShardedClient) eachShard(fn func(client Redis) error) error {
+	var mu sync.Mutex
+	var err error
+	groups := make(map[Redis][]int)
+	for _, si := range c.getAllShards() {
+		groups[si.client] = nil
+	}
+	eachGroup(groups, func(client Redis, _ []int) {
+		if e := fn(client); e != nil {
+			mu.Lock()
+			if err == nil {
+				err = e
+			}
+			mu.Unlock()
+		}
+	})
+	return err
+}
+
+func pick(keys []string, idx []int) []string {
+	ks := make([]string, len(idx))
+	for i, j := range idx {
+		ks[i] = keys[j]
+	}
+	return ks
+}
+
+func keysArgs(name string, keys []string) []interface{} {
+	args := make([]interface{}, 0, len(keys)+1)
+	args = append(args, name)
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	return args
+}
+
+// sumKeys 按分片拆分命令并累加每个分片返回的数量。迁移期间的删除命令同时在旧分片上执行
+func (c *ShardedClient) sumKeys(ctx context.Context, name string, keys []string, fn func(Redis, []string) *redis.IntCmd) *redis.IntCmd {
+	cmd := redis.NewIntCmd(ctx, keysArgs(name, keys)...)
+	groups := c.groupKeys(keys)
+	if name == "del" || name == "unlink" {
+		for i, key := range keys {
+			if _, old := c.getShards(key); old != nil {
+				groups[old] = append(groups[old], i)
+			}
+		}
+	}
+	var mu sync.Mutex
+	var sum int64
+	var err error
+	eachGroup(groups, func(client Redis, idx []int) {
+		n, e := fn(client, pick(keys, idx)).Result()
+		mu.Lock()
+		defer mu.Unlock()
+		if e != nil {
+			if err == nil {
+				err = e
+			}
+			return
+		}
+		sum += n
+	})
+	if err != nil {
+		cmd.SetErr(err)
+		return cmd
+	}
+	cmd.SetVal(sum)
+	return cmd
+}
+
+// Del 按分片删除key，迁移期间同时删除旧分片中的key，避免读回退时读到已删除的数据
+func (c *ShardedClient) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	return c.sumKeys(ctx, "del", keys, func(client Redis, keys []string) *redis.IntCmd {
+		return client.Del(ctx, keys...)
+	})
+}
+
+// Unlink 按分片删除key，迁移期间同时删除旧分片中的key，避免读回退时读到已删除的数据
+func (c *ShardedClient) Unlink(ctx context.Context, keys ...string) *redis.IntCmd {
+	return c.sumKeys(ctx, "unlink", keys, func(client Redis, keys []string) *redis.IntCmd {
+		return client.Unlink(ctx, keys...)
+	})
+}
+
+// Exists 按分片统计存在的key数量，迁移期间新分片中不存在的key从旧分片统计
+func (c *ShardedClient) Exists(ctx context.Context, keys ...string) *redis.IntCmd {
+	if !c.Migrating() {
+		return c.sumKeys(ctx, "exists", keys, func(client Redis, keys []string) *redis.IntCmd {
+			return client.Exists(ctx, keys...)
+		})
+	}
+	cmd := redis.NewIntCmd(ctx, keysArgs("exists", keys)...)
+	var n int64
+	for _, key := range keys {
+		client, old := c.getShards(key)
+		res := client.Exists(ctx, key)
+		if old != nil && res.Err() == nil && res.Val() == 0 {
+			res = old.Exists(ctx, key)
+		}
+		if err := res.Err(); err != nil {
+			cmd.SetErr(err)
+			return cmd
+		}
+		n += res.Val()
+	}
+	cmd.SetVal(n)
+	return cmd
+}
+
+func (c *ShardedClient) Touch(ctx context.Context, keys ...string) *redis.IntCmd {
+	return c.sumKeys(ctx, "touch", keys, func(client Redis, keys []string) *redis.IntCmd {
+		return client.Touch(ctx, keys...)
+	})
+}
+
+// MGet 按分片读取并按照key的顺序合并结果，迁移期间新分片中不存在的key从旧分片读取
+func (c *ShardedClient) MGet(ctx context.Context, keys ...string) *redis.SliceCmd {
+	cmd := redis.NewSliceCmd(ctx, keysArgs("mget", keys)...)
+	vals := make([]interface{}, len(keys))
+	var mu sync.Mutex
+	var err error
+	mget := func(client Redis, idx []int) {
+		res, e := client.MGet(ctx, pick(keys, idx)...).Result()
+		mu.Lock()
+		defer mu.Unlock()
+		if e != nil {
+			if err == nil {
+				err = e
+			}
+			return
+		}
+		for i, j := range idx {
+			if res[i] != nil {
+				vals[j] = res[i]
+			}
+		}
+	}
+	eachGroup(c.groupKeys(keys), mget)
+	if err == nil && c.Migrating() {
+		fallback := make(map[Redis][]int)
+		for i, key := range keys {
+			if _, old := c.getShards(key); old != nil && vals[i] == nil {
+				fallback[old] = append(fallback[old], i)
+			}
+		}
+		eachGroup(fallback, mget)
+	}
+	if err != nil {
+		cmd.SetErr(err)
+		return cmd
+	}
+	cmd.SetVal(vals)
+	return cmd
+}
+
+// MSet 按分片写入，不同分片之间不保证原子性
+func (c *ShardedClient) MSet(ctx context.Context, values ...interface{}) *redis.StatusCmd {
+	cmd := redis.NewStatusCmd(ctx, append([]interface{}{"mset"}, values...)...)
+	keys, vals, err := pairs(values)
+	if err != nil {
+		cmd.SetErr(err)
+		return cmd
+	}
+	var mu sync.Mutex
+	eachGroup(c.groupKeys(keys), func(client Redis, idx []int) {
+		args := make([]interface{}, 0, len(idx)*2)
+		for _, j := range idx {
+			args = append(args, keys[j], vals[j])
+		}
+		e := client.MSet(ctx, args...).Err()
+		mu.Lock()
+		defer mu.Unlock()
+		if e != nil && err == nil {
+			err = e
+		}
+	})
+	if err != nil {
+		cmd.SetErr(err)
+		return cmd
+	}
+	cmd.SetVal("OK")
+	return cmd
+}
+
+// MSetNX 需要所有key在同一个分片，否则返回ErrCrossShard
+func (c *ShardedClient) MSetNX(ctx context.Context, values ...interface{}) *redis.BoolCmd {
+	keys, _, err := pairs(values)
+	if err == nil {
+		var client Redis
+		if client, err = c.sameShard(keys...); err == nil {
+			return client.MSetNX(ctx, values...)
+		}
+	}
+	cmd := redis.NewBoolCmd(ctx, append([]interface{}{"msetnx"}, values...)...)
+	cmd.SetErr(err)
+	return cmd
+}
+
+// pairs 解析MSet的参数，支持go-redis的三种格式：key、value交替的列表，[]string或[]interface{}，map
+func pairs(values []interface{}) ([]string, []interface{}, error) {
+	if len(values) == 1 {
+		switch v := values[0].(type) {
+		case []string:
+			values = make([]interface{}, len(v))
+			for i := range v {
+				values[i] = v[i]
+			}
+		case []interface{}:
+			values = v
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			vals := make([]interface{}, 0, len(v))
+			for k, val := range v {
+				keys = append(keys, k)
+				vals = append(vals, val)
+			}
+			return keys, vals, nil
+		case map[string]string:
+			keys := make([]string, 0, len(v))
+			vals := make([]interface{}, 0, len(v))
+			for k, val := range v {
+				keys = append(keys, k)
+				vals = append(vals, val)
+			}
+			return keys, vals, nil
+		}
+	}
+	if len(values) == 0 || len(values)%2 != 0 {
+		return nil, nil, fmt.Errorf("invalid number of arguments: %d", len(values))
+	}
+	keys := make([]string, 0, len(values)/2)
+	vals := make([]interface{}, 0, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		key, ok := values[i].(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid key type: %T", values[i])
+		}
+		keys = append(keys, key)
+		vals = append(vals, values[i+1])
+	}
+	return keys, vals, nil
+}
+
+// scanShards 返回按照id排序的所有分片，保证多次Scan之间分片的序号一致
+func (c *ShardedClient) scanShards() []*ShardInfo {
+	sis := c.getAllShards()
+	sort.Slice(sis, func(i, j int) bool {
+		return sis[i].id < sis[j].id
+	})
+	return sis
+}
+
+// Scan 依次扫描所有分片，游标的高16位为分片序号。返回的ScanCmd可以使用Iterator遍历所有分片。
+// 扫描期间分片发生变化时可能重复或遗漏key
+func (c *ShardedClient) Scan(ctx context.Context, cursor uint64, match string, count int64) *redis.ScanCmd {
+	args := []interface{}{"scan", cursor}
+	if match != "" {
+		args = append(args, "match", match)
+	}
+	if count > 0 {
+		args = append(args, "count", count)
+	}
+	cmd := redis.NewScanCmd(ctx, c.processScan, args...)
+	_ = c.processScan(ctx, cmd)
+	return cmd
+}
+
+func (c *ShardedClient) processScan(ctx context.Context, cmd redis.Cmder) error {
+	scan := cmd.(*redis.ScanCmd)
+	args := scan.Args()
+	cursor, err := toUint64(args[1])
+	if err != nil {
+		scan.SetErr(err)
+		return err
+	}
+	var match string
+	var count int64
+	for i := 2; i+1 < len(args); i += 2 {
+		switch args[i] {
+		case "match":
+			match, _ = args[i+1].(string)
+		case "count":
+			count, _ = args[i+1].(int64)
+		}
+	}
+
+	sis := c.scanShards()
+	idx := int(cursor >> scanShardShift)
+	if idx >= len(sis) {
+		err := fmt.Errorf("invalid cursor: %d", cursor)
+		scan.SetErr(err)
+		return err
+	}
+	keys, next, err := sis[idx].client.Scan(ctx, cursor&(1<<scanShardShift-1), match, count).Result()
+	if err != nil {
+		scan.SetErr(err)
+		return err
+	}
+	if next == 0 && idx+1 < len(sis) {
+		next = uint64(idx+1) << scanShardShift
+	} else if next != 0 {
+		next |= uint64(idx) << scanShardShift
+	}
+	scan.SetVal(keys, next)
+	return nil
+}
+
+func toUint64(v interface{}) (uint64, error) {
+	switch v := v.(type) {
+	case uint64:
+		return v, nil
+	case int64:
+		return uint64(v), nil
+	case int:
+		return uint64(v), nil
+	case string:
+		return strconv.ParseUint(v, 10, 64)
+	}
+	return 0, fmt.Errorf("invalid cursor type: %T", v)
+}
+
+// Keys 在所有分片上执行并合并结果，key较多时请使用Scan
+func (c *ShardedClient) Keys(ctx context.Context, pattern string) *redis.StringSliceCmd {
+	cmd := redis.NewStringSliceCmd(ctx, "keys", pattern)
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	keys := make([]string, 0)
+	err := c.eachShard(func(client Redis) error {
+		res, err := client.Keys(ctx, pattern).Result()
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, key := range res {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		cmd.SetErr(err)
+		return cmd
+	}
+	cmd.SetVal(keys)
+	return cmd
+}
+
+// DBSize 返回所有分片的key数量之和
+func (c *ShardedClient) DBSize(ctx context.Context) *redis.IntCmd {
+	cmd := redis.NewIntCmd(ctx, "dbsize")
+	var mu sync.Mutex
+	var sum int64
+	err := c.eachShard(func(client Redis) error {
+		n, err := client.DBSize(ctx).Result()
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		sum += n
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		cmd.SetErr(err)
+		return cmd
+	}
+	cmd.SetVal(sum)
+	return cmd
+}
+
+// cmdKeys 返回pipeline中命令的所有key，用于检查key是否在同一个分片
+func cmdKeys(cmd redis.Cmder) ([]string, error) {
+	args := cmd.Args()
+	var keyArgs []interface{}
+	switch cmd.Name() {
+	case "del", "unlink", "exists", "touch", "mget", "pfcount", "pfmerge",
+		"sdiff", "sinter", "sunion", "sdiffstore", "sinterstore", "sunionstore":
+		keyArgs = args[1:]
+	case "mset", "msetnx":
+		for i := 1; i < len(args); i += 2 {
+			keyArgs = append(keyArgs, args[i])
+		}
+	case "rename", "renamenx", "rpoplpush", "smove", "brpoplpush":
+		keyArgs = args[1:3]
+	case "bitop":
+		keyArgs = args[2:]
+	case "blpop", "brpop":
+		keyArgs = args[1 : len(args)-1]
+	case "zunionstore", "zinterstore":
+		keyArgs = append(keyArgs, args[1])
+		if n, ok := args[2].(int); ok && 3+n <= len(args) {
+			keyArgs = append(keyArgs, args[3:3+n]...)
+		}
+	default:
+		keyArgs = args[1:2]
+	}
+
+	keys := make([]string, 0, len(keyArgs))
+	for _, arg := range keyArgs {
+		key, ok := arg.(string)
+		if !ok {
+			return nil, fmt.Errorf("exists unsupport command: [%s]", cmd)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("exists unsupport command: [%s]", cmd)
+	}
+	return keys, nil
+}
+
+// keyTagPattern 提取key中第一个{...}的内容作为hash tag，修改规则会导致已有的key被分配到其他分片
+var keyTagPattern = regexp.MustCompile(`{(.+?)}`)
+
+// hashTag 返回key的hash tag，没有hash tag时使用整个key
+func hashTag(key string) string {
+	if m := keyTagPattern.FindStringSubmatch(key); len(m) > 1 {
+		return m[1]
+	}
+	return key
+}
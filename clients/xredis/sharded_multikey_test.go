@@ -0,0 +1,193 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xredis
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedClient_MultiKey(t *testing.T) {
+	ctx := context.Background()
+	do(func(client Redis) {
+		keys := make([]string, 20)
+		values := make([]interface{}, 0, 40)
+		for i := range keys {
+			keys[i] = fmt.Sprintf("mk-%d", i)
+			values = append(values, keys[i], i)
+		}
+		assert.NoError(t, client.MSet(ctx, values...).Err())
+		assert.NoError(t, client.MSet(ctx, map[string]interface{}{"mk-map": "v"}).Err())
+
+		vals, err := client.MGet(ctx, append(keys, "missing")...).Result()
+		assert.NoError(t, err)
+		for i := range keys {
+			assert.Equal(t, fmt.Sprint(i), vals[i])
+		}
+		assert.Nil(t, vals[len(keys)])
+
+		assert.Equal(t, int64(21), client.Exists(ctx, append(keys, "mk-map", "missing")...).Val())
+		assert.Equal(t, int64(21), client.DBSize(ctx).Val())
+		assert.Equal(t, int64(10), client.Del(ctx, keys[:10]...).Val())
+		assert.Equal(t, int64(10), client.Del(ctx, keys...).Val())
+		assert.Equal(t, int64(0), client.Exists(ctx, keys...).Val())
+	})
+}
+
+func TestShardedClient_Scan(t *testing.T) {
+	ctx := context.Background()
+	do(func(client Redis) {
+		want := make([]string, 50)
+		for i := range want {
+			want[i] = fmt.Sprintf("scan-%02d", i)
+			assert.NoError(t, client.Set(ctx, want[i], i, 0).Err())
+		}
+		assert.NoError(t, client.Set(ctx, "other", 1, 0).Err())
+
+		got := make([]string, 0)
+		iter := client.Scan(ctx, 0, "scan-*", 10).Iterator()
+		for iter.Next(ctx) {
+			got = append(got, iter.Val())
+		}
+		assert.NoError(t, iter.Err())
+		sort.Strings(got)
+		assert.Equal(t, want, got)
+
+		keys, err := client.Keys(ctx, "scan-*").Result()
+		assert.NoError(t, err)
+		sort.Strings(keys)
+		assert.Equal(t, want, keys)
+	})
+}
+
+func TestShardedClient_CrossShard(t *testing.T) {
+	ctx := context.Background()
+	do(func(client Redis) {
+		c := client.(*ShardedClient)
+		var a, b string
+		for i := 0; ; i++ {
+			b = fmt.Sprintf("set-%d", i)
+			if a == "" {
+				a = b
+			} else if c.getShard(a) != c.getShard(b) {
+				break
+			}
+		}
+		assert.Equal(t, ErrCrossShard, client.SUnionStore(ctx, "dest", a, b).Err())
+		assert.Equal(t, ErrCrossShard, client.Rename(ctx, a, b).Err())
+		assert.Equal(t, ErrCrossShard, client.MSetNX(ctx, a, 1, b, 2).Err())
+
+		// 使用hash tag分配到同一个分片
+		assert.NoError(t, client.SAdd(ctx, "{set}:a", "1", "2").Err())
+		assert.NoError(t, client.SAdd(ctx, "{set}:b", "2", "3").Err())
+		assert.Equal(t, int64(3), client.SUnionStore(ctx, "{set}:dest", "{set}:a", "{set}:b").Val())
+		assert.True(t, client.MSetNX(ctx, "{m}:a", 1, "{m}:b", 2).Val())
+
+		pipe := client.Pipeline()
+		pipe.Rename(ctx, a, b)
+		pipe.SInter(ctx, "{set}:a", "{set}:b")
+		cmds, err := pipe.Exec(ctx)
+		assert.Equal(t, ErrCrossShard, err)
+		assert.Equal(t, ErrCrossShard, cmds[0].Err())
+		assert.Equal(t, []string{"2"}, cmds[1].(*redis.StringSliceCmd).Val())
+
+		// 多key命令在pipeline中也需要检查是否在同一个分片
+		pipe = client.Pipeline()
+		pipe.Touch(ctx, a, b)
+		pipe.Unlink(ctx, a, b)
+		pipe.PFCount(ctx, a, b)
+		pipe.PFMerge(ctx, a, b)
+		cmds, err = pipe.Exec(ctx)
+		assert.Equal(t, ErrCrossShard, err)
+		for _, cmd := range cmds {
+			assert.Equal(t, ErrCrossShard, cmd.Err(), cmd.Name())
+		}
+	})
+}
+
+func TestShardedClient_PipelineMultiKey(t *testing.T) {
+	ctx := context.Background()
+	s0, _ := newTestShard(t, "shard-0")
+	s1, _ := newTestShard(t, "shard-1")
+	client := NewShardedClient([]*ShardInfo{s0, s1})
+	defer client.Close()
+
+	pipe := client.Pipeline()
+	pipe.PFAdd(ctx, "{hll}:a", "1", "2")
+	pipe.PFAdd(ctx, "{hll}:b", "3")
+	count := pipe.PFCount(ctx, "{hll}:a", "{hll}:b")
+	pipe.Touch(ctx, "{hll}:a", "{hll}:b")
+	pipe.Unlink(ctx, "{hll}:a", "{hll}:b")
+	cmds, err := pipe.Exec(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count.Val())
+	assert.Equal(t, int64(2), cmds[3].(*redis.IntCmd).Val())
+	assert.Equal(t, int64(2), cmds[4].(*redis.IntCmd).Val())
+}
+
+func TestCmdKeys(t *testing.T) {
+	ctx := context.Background()
+	cases := []redis.Cmder{
+		redis.NewIntCmd(ctx, "touch", "a", "b"),
+		redis.NewIntCmd(ctx, "unlink", "a", "b"),
+		redis.NewIntCmd(ctx, "pfcount", "a", "b"),
+		redis.NewStatusCmd(ctx, "pfmerge", "a", "b"),
+	}
+	for _, cmd := range cases {
+		keys, err := cmdKeys(cmd)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a", "b"}, keys, cmd.Name())
+	}
+}
+
+func TestHashTag(t *testing.T) {
+	cases := map[string]string{
+		"foo":          "foo",
+		"{user1}:name": "user1",
+		"a{b}{c}":      "b",
+		"a{}{b}":       "}{b",
+		"a{}b}":        "}b",
+		"a{b":          "a{b",
+		"}{a}":         "a",
+	}
+	for key, want := range cases {
+		assert.Equal(t, want, hashTag(key), key)
+	}
+}
+
+// 和之前版本的分片结果保持一致，已有的key不会被分配到其他分片
+func TestShardedClient_KeyTagCompatible(t *testing.T) {
+	s0, _ := newTestShard(t, "shard-0")
+	s1, _ := newTestShard(t, "shard-1")
+	s2, _ := newTestShard(t, "shard-2")
+	c := NewShardedClient([]*ShardInfo{s0, s1, s2}).(*ShardedClient)
+	defer c.Close()
+
+	pattern := regexp.MustCompile(`{(.+?)}`)
+	keys := []string{"foo", "{user1}:name", "a{}b}", "a{}{b}", "{}", "{{a}}", "a{b", "}{a}", "x{y}z{w}"}
+	for _, key := range keys {
+		tag := key
+		if m := pattern.FindStringSubmatch(key); len(m) > 1 {
+			tag = m[1]
+		}
+		assert.Equal(t, c.ring.get(c.algo.hash(tag)).client, c.getShard(key), key)
+	}
+}
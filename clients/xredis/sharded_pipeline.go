@@ -17,43 +17,193 @@ package xredis
 import (
 	"context"
 	"errors"
-	"unsafe"
+	"sync"
 
 	"github.com/go-redis/redis/v8"
 )
 
+// errQueued 录制命令时中止发送，不会返回给调用方
+var errQueued = errors.New("xredis: command queued")
+
+// recorder 录制命令的客户端，所有命令都只加入pipeline，不会建立连接
+var recorder = redis.NewClient(&redis.Options{
+	Addr:        "sharded-pipeline",
+	IdleTimeout: -1,
+})
+
+// queueHook 拦截录制客户端的命令并加入pipeline
+type queueHook struct {
+	p *ShardedPipeline
+}
+
+func (h queueHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	_ = h.p.Process(ctx, cmd)
+	return ctx, errQueued
+}
+
+func (h queueHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if errors.Is(cmd.Err(), errQueued) {
+		cmd.SetErr(nil)
+	}
+	return nil
+}
+
+func (h queueHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h queueHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// ShardedPipeline 只使用go-redis公开接口实现的pipeline。命令先在本地排队，Exec时由exec统一发送
+type ShardedPipeline struct {
+	redis.Cmdable
+
+	ctx  context.Context
+	exec func(context.Context, []redis.Cmder) error
+
+	mu     sync.Mutex
+	cmds   []redis.Cmder
+	closed bool
+}
+
+var _ redis.Pipeliner = (*ShardedPipeline)(nil)
+
+// NewShardedPipeline 创建pipeline，Exec时调用fn发送排队的命令，fn需要将结果和错误写入每个命令
 func NewShardedPipeline(ctx context.Context, fn func(context.Context, []redis.Cmder) error) redis.Pipeliner {
-	pipe := &redis.Pipeline{}
-	c := (*context.Context)(unsafe.Pointer(uintptr(unsafe.Pointer(pipe)) +
-		unsafe.Sizeof(func(ctx context.Context, cmd redis.Cmder) error { return nil }) +
-		unsafe.Sizeof(ctx)))
-	*c = ctx
-	e := (*func(context.Context, []redis.Cmder) error)(unsafe.Pointer(uintptr(unsafe.Pointer(pipe)) +
-		unsafe.Sizeof(func(ctx context.Context, cmd redis.Cmder) error { return nil }) +
-		unsafe.Sizeof(ctx) +
-		unsafe.Sizeof(func(context.Context, []redis.Cmder) error { return nil })))
-	*e = fn
-	pipeInit(pipe)
-	return pipe
-}
-
-//go:linkname pipeInit github.com/go-redis/redis/v8.(*Pipeline).init
-func pipeInit(*redis.Pipeline) int
-
-//go:linkname clientProcessPipeline github.com/go-redis/redis/v8.(*Client).processPipeline
-func clientProcessPipeline(*redis.Client, context.Context, []redis.Cmder) error
-
-//go:linkname clusterClientProcessPipeline github.com/go-redis/redis/v8.(*ClusterClient).processPipeline
-func clusterClientProcessPipeline(*redis.ClusterClient, context.Context, []redis.Cmder) error
-
-func processPipeline(ctx context.Context, client interface{}, cmds []redis.Cmder) error {
-	rc := client.(*RedisContainer)
-	switch rc.Redis.(type) {
-	case *redis.Client:
-		return clientProcessPipeline(rc.Redis.(*redis.Client), ctx, cmds)
-	case *redis.ClusterClient:
-		return clusterClientProcessPipeline(rc.Redis.(*redis.ClusterClient), ctx, cmds)
-	default:
-		return errors.New("client must be type of redis.Client or redis.ClusterClient")
+	p := &ShardedPipeline{
+		ctx:  ctx,
+		exec: fn,
+	}
+	client := recorder.WithContext(ctx)
+	client.AddHook(queueHook{p: p})
+	p.Cmdable = client
+	return p
+}
+
+func (p *ShardedPipeline) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.cmds)
+}
+
+// Do 将命令加入pipeline
+func (p *ShardedPipeline) Do(ctx context.Context, args ...interface{}) *redis.Cmd {
+	cmd := redis.NewCmd(ctx, args...)
+	_ = p.Process(ctx, cmd)
+	return cmd
+}
+
+// Process 将命令加入pipeline
+func (p *ShardedPipeline) Process(ctx context.Context, cmd redis.Cmder) error {
+	p.mu.Lock()
+	p.cmds = append(p.cmds, cmd)
+	p.mu.Unlock()
+	return nil
+}
+
+// Close 关闭pipeline并丢弃未执行的命令
+func (p *ShardedPipeline) Close() error {
+	p.mu.Lock()
+	_ = p.discard()
+	p.closed = true
+	p.mu.Unlock()
+	return nil
+}
+
+// Discard 丢弃未执行的命令
+func (p *ShardedPipeline) Discard() error {
+	p.mu.Lock()
+	err := p.discard()
+	p.mu.Unlock()
+	return err
+}
+
+func (p *ShardedPipeline) discard() error {
+	if p.closed {
+		return redis.ErrClosed
+	}
+	p.cmds = p.cmds[:0]
+	return nil
+}
+
+// Exec 发送所有排队的命令，返回的命令与加入的顺序一致，每个命令的错误单独记录，返回值为第一个错误
+func (p *ShardedPipeline) Exec(ctx context.Context) ([]redis.Cmder, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, redis.ErrClosed
+	}
+	if len(p.cmds) == 0 {
+		return nil, nil
+	}
+
+	cmds := p.cmds
+	p.cmds = nil
+	return cmds, p.exec(ctx, cmds)
+}
+
+func (p *ShardedPipeline) Pipelined(ctx context.Context, fn func(redis.Pipeliner) error) ([]redis.Cmder, error) {
+	if err := fn(p); err != nil {
+		return nil, err
+	}
+	cmds, err := p.Exec(ctx)
+	_ = p.Close()
+	return cmds, err
+}
+
+func (p *ShardedPipeline) Pipeline() redis.Pipeliner {
+	return p
+}
+
+func (p *ShardedPipeline) TxPipelined(ctx context.Context, fn func(redis.Pipeliner) error) ([]redis.Cmder, error) {
+	panic("unsupport method..")
+}
+
+func (p *ShardedPipeline) TxPipeline() redis.Pipeliner {
+	panic("unsupport method..")
+}
+
+// 以下为连接相关的命令，分片的pipeline不支持，Exec时返回错误
+
+func (p *ShardedPipeline) Auth(ctx context.Context, password string) *redis.StatusCmd {
+	cmd := redis.NewStatusCmd(ctx, "auth", password)
+	_ = p.Process(ctx, cmd)
+	return cmd
+}
+
+func (p *ShardedPipeline) AuthACL(ctx context.Context, username, password string) *redis.StatusCmd {
+	cmd := redis.NewStatusCmd(ctx, "auth", username, password)
+	_ = p.Process(ctx, cmd)
+	return cmd
+}
+
+func (p *ShardedPipeline) Select(ctx context.Context, index int) *redis.StatusCmd {
+	cmd := redis.NewStatusCmd(ctx, "select", index)
+	_ = p.Process(ctx, cmd)
+	return cmd
+}
+
+func (p *ShardedPipeline) SwapDB(ctx context.Context, index1, index2 int) *redis.StatusCmd {
+	cmd := redis.NewStatusCmd(ctx, "swapdb", index1, index2)
+	_ = p.Process(ctx, cmd)
+	return cmd
+}
+
+func (p *ShardedPipeline) ClientSetName(ctx context.Context, name string) *redis.BoolCmd {
+	cmd := redis.NewBoolCmd(ctx, "client", "setname", name)
+	_ = p.Process(ctx, cmd)
+	return cmd
+}
+
+// execPipeline 在一个分片上以普通pipeline执行命令，结果直接写入cmds
+func execPipeline(ctx context.Context, client Redis, cmds []redis.Cmder) error {
+	pipe := client.Pipeline()
+	for _, cmd := range cmds {
+		_ = pipe.Process(ctx, cmd)
 	}
+	_, err := pipe.Exec(ctx)
+	return err
 }
@@ -0,0 +1,275 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/hashicorp/go-multierror"
+)
+
+var (
+	ErrMigrating    = errors.New("sharded client is migrating")
+	ErrNotMigrating = errors.New("sharded client is not migrating")
+	// ErrMigrateConflict 迁移期间新分片已经写入了同名key，旧分片中的key被保留，需要业务自行合并
+	ErrMigrateConflict = errors.New("key already exists in new shard")
+)
+
+// fallbackCmds pipeline中未命中时回退到旧分片的读命令
+var fallbackCmds = map[string]bool{
+	"get":    true,
+	"hget":   true,
+	"lindex": true,
+	"zscore": true,
+}
+
+// NewShardInfo 创建分片信息。name为空时按照分片的顺序生成hash环，weight小于1时为1
+func NewShardInfo(id, name string, client Redis, weight int) *ShardInfo {
+	if weight < 1 {
+		weight = 1
+	}
+	return &ShardInfo{
+		id:     id,
+		name:   name,
+		client: client,
+		weight: weight,
+	}
+}
+
+// MigrateOptions 迁移key的配置
+type MigrateOptions struct {
+	// 扫描key的匹配规则，默认为*
+	Match string
+
+	// 每次扫描的数量，默认为100
+	Count int64
+
+	// 新分片已经存在同名key时是否覆盖。默认不覆盖，保留旧分片中的key并记为冲突
+	Replace bool
+}
+
+// MigrateResult 迁移key的结果
+type MigrateResult struct {
+	Scanned int
+	Moved   int
+	Skipped int
+	// 新分片已经存在同名key，旧分片中的key没有删除
+	Conflicts int
+	Failed    int
+}
+
+// Reshard 使用新的分片重建hash环，之后的命令都发送到新hash环中的分片。
+// 旧hash环保留到FinishMigration为止，期间读命令在新分片未命中时回退到旧分片，Del和Unlink会同时删除旧分片中的key。
+// 写命令只写入新分片，key迁移完成前写入的key在MigrateKeys时记为冲突，需要尽快调用MigrateKeys
+func (c *ShardedClient) Reshard(sis []*ShardInfo) error {
+	if len(sis) == 0 {
+		return errors.New("empty shards")
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.oldRing != nil {
+		return ErrMigrating
+	}
+	c.oldRing = c.ring
+	c.ring = newShardRing(sis, c.algo)
+	return nil
+}
+
+// Migrating 返回是否正在迁移
+func (c *ShardedClient) Migrating() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.oldRing != nil
+}
+
+// MigrateKeys 扫描旧hash环中每个分片的key，将新hash环中属于其他分片的key移动过去。
+// 使用DUMP和RESTORE保留过期时间，移动成功后删除旧分片中的key。单个key失败不会中断迁移。
+// 迁移期间hset、rpush、incr等写命令只写入新分片，新分片中的key只包含部分数据，
+// 这时不会覆盖也不会删除旧分片中的key，返回的错误中包含ErrMigrateConflict
+func (c *ShardedClient) MigrateKeys(ctx context.Context, opt *MigrateOptions) (*MigrateResult, error) {
+	c.RLock()
+	if c.oldRing == nil {
+		c.RUnlock()
+		return nil, ErrNotMigrating
+	}
+	clients := ringClients(c.oldRing)
+	c.RUnlock()
+
+	match, count, replace := "*", int64(100), false
+	if opt != nil {
+		if opt.Match != "" {
+			match = opt.Match
+		}
+		if opt.Count > 0 {
+			count = opt.Count
+		}
+		replace = opt.Replace
+	}
+
+	res := &MigrateResult{}
+	var mulerr error
+	for _, client := range clients {
+		var cursor uint64
+		for {
+			keys, next, err := client.Scan(ctx, cursor, match, count).Result()
+			if err != nil {
+				return res, multierror.Append(mulerr, err)
+			}
+			for _, key := range keys {
+				res.Scanned++
+				dst := c.getShard(key)
+				if dst == client {
+					continue
+				}
+				moved, err := moveKey(ctx, client, dst, key, replace)
+				switch {
+				case errors.Is(err, ErrMigrateConflict):
+					res.Conflicts++
+					mulerr = multierror.Append(mulerr, fmt.Errorf("%w: %s", err, key))
+				case err != nil:
+					res.Failed++
+					mulerr = multierror.Append(mulerr, err)
+				case moved:
+					res.Moved++
+				default:
+					res.Skipped++
+				}
+			}
+			cursor = next
+			if cursor == 0 {
+				break
+			}
+		}
+	}
+	return res, mulerr
+}
+
+// moveKey 将key从src移动到dst，key已经不存在时返回false，dst已经存在时返回ErrMigrateConflict
+func moveKey(ctx context.Context, src, dst Redis, key string, replace bool) (bool, error) {
+	if !replace {
+		n, err := dst.Exists(ctx, key).Result()
+		if err != nil {
+			return false, err
+		}
+		if n > 0 {
+			return false, ErrMigrateConflict
+		}
+	}
+	dump, err := src.Dump(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	ttl, err := src.PTTL(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	// -2表示key已经过期，-1表示没有过期时间
+	if ttl == -2 {
+		return false, nil
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	if replace {
+		err = dst.RestoreReplace(ctx, key, ttl, dump).Err()
+	} else {
+		err = dst.Restore(ctx, key, ttl, dump).Err()
+	}
+	if err != nil {
+		if !replace && strings.HasPrefix(err.Error(), "BUSYKEY") {
+			return false, ErrMigrateConflict
+		}
+		return false, err
+	}
+	return true, src.Del(ctx, key).Err()
+}
+
+// FinishMigration 结束迁移，删除旧hash环并关闭不再使用的分片客户端
+func (c *ShardedClient) FinishMigration() error {
+	c.Lock()
+	old := c.oldRing
+	if old == nil {
+		c.Unlock()
+		return ErrNotMigrating
+	}
+	c.oldRing = nil
+	current := make(map[Redis]bool, len(c.ring.resources))
+	for _, client := range ringClients(c.ring) {
+		current[client] = true
+	}
+	onFinish := c.onFinishMigration
+	c.Unlock()
+
+	if onFinish != nil {
+		onFinish()
+	}
+
+	var mulerr error
+	for _, client := range ringClients(old) {
+		if current[client] {
+			continue
+		}
+		if err := client.Close(); err != nil {
+			mulerr = multierror.Append(mulerr, err)
+		}
+	}
+	return mulerr
+}
+
+// getShards 返回key所在的分片，迁移期间如果旧hash环中的分片不同，同时返回旧分片
+func (c *ShardedClient) getShards(key string) (Redis, Redis) {
+	c.RLock()
+	defer c.RUnlock()
+
+	k := c.algo.hash(c.getKeyTag(key))
+	client := c.ring.get(k).client
+	if c.oldRing == nil {
+		return client, nil
+	}
+	if old := c.oldRing.get(k).client; old != client {
+		return client, old
+	}
+	return client, nil
+}
+
+// ringClients 返回hash环中的所有客户端，多个分片共用的客户端只返回一次
+func ringClients(r *shardRing) []Redis {
+	clients := make([]Redis, 0, len(r.resources))
+	seen := make(map[Redis]bool, len(r.resources))
+	for _, si := range r.resources {
+		if !seen[si.client] {
+			seen[si.client] = true
+			clients = append(clients, si.client)
+		}
+	}
+	return clients
+}
+
+func allNil(vals []interface{}) bool {
+	for _, v := range vals {
+		if v != nil {
+			return false
+		}
+	}
+	return true
+}
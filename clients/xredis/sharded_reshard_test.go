@@ -0,0 +1,160 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xredis
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	miniredisv2 "github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestShard(t *testing.T, id string) (*ShardInfo, *miniredisv2.Miniredis) {
+	s := miniredisv2.RunT(t)
+	client := NewClient(&Config{Name: id, Addr: []string{s.Addr()}})
+	return NewShardInfo(id, id, client, 1), s
+}
+
+func TestShardedClient_Reshard(t *testing.T) {
+	ctx := context.Background()
+	s0, _ := newTestShard(t, "shard-0")
+	s1, _ := newTestShard(t, "shard-1")
+	s2, m2 := newTestShard(t, "shard-2")
+	c := NewShardedClient([]*ShardInfo{s0, s1}).(*ShardedClient)
+	defer c.Close()
+
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		assert.NoError(t, c.Set(ctx, keys[i], keys[i], 0).Err())
+	}
+
+	_, err := c.MigrateKeys(ctx, nil)
+	assert.Equal(t, ErrNotMigrating, err)
+	assert.NoError(t, c.Reshard([]*ShardInfo{s0, s1, s2}))
+	assert.True(t, c.Migrating())
+	assert.Equal(t, ErrMigrating, c.Reshard([]*ShardInfo{s0}))
+
+	// 迁移前从旧分片读取
+	moved := make([]string, 0)
+	for _, key := range keys {
+		if c.getShard(key) == s2.client {
+			moved = append(moved, key)
+		}
+		assert.Equal(t, key, c.Get(ctx, key).Val())
+	}
+	assert.NotEmpty(t, moved)
+	assert.Empty(t, m2.Keys())
+
+	pipe := c.Pipeline()
+	pipe.Get(ctx, moved[0])
+	pipe.Exists(ctx, moved[0])
+	cmds, err := pipe.Exec(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, moved[0], cmds[0].(*redis.StringCmd).Val())
+
+	// 删除时同时删除旧分片
+	assert.Equal(t, int64(1), c.Del(ctx, moved[1]).Val())
+	assert.Equal(t, redis.Nil, c.Get(ctx, moved[1]).Err())
+
+	// 新分片已经写入的key记为冲突，不覆盖新分片
+	assert.NoError(t, c.Set(ctx, moved[2], "new", 0).Err())
+
+	res, err := c.MigrateKeys(ctx, &MigrateOptions{Count: 10})
+	assert.ErrorIs(t, err, ErrMigrateConflict)
+	assert.Equal(t, 99, res.Scanned)
+	assert.Equal(t, len(moved)-2, res.Moved)
+	assert.Equal(t, 0, res.Skipped)
+	assert.Equal(t, 1, res.Conflicts)
+	assert.Equal(t, len(moved)-1, len(m2.Keys()))
+
+	assert.NoError(t, c.FinishMigration())
+	assert.False(t, c.Migrating())
+	for _, key := range keys {
+		switch key {
+		case moved[1]:
+			assert.Equal(t, redis.Nil, c.Get(ctx, key).Err())
+		case moved[2]:
+			assert.Equal(t, "new", c.Get(ctx, key).Val())
+		default:
+			assert.Equal(t, key, c.Get(ctx, key).Val())
+		}
+	}
+}
+
+func TestShardedClient_MigrateConflict(t *testing.T) {
+	ctx := context.Background()
+	s0, m0 := newTestShard(t, "shard-0")
+	s1, m1 := newTestShard(t, "shard-1")
+	c := NewShardedClient([]*ShardInfo{s0}).(*ShardedClient)
+	defer c.Close()
+
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("hash-%d", i)
+		assert.NoError(t, c.HSet(ctx, keys[i], "a", "1", "b", "2").Err())
+	}
+	assert.NoError(t, c.Reshard([]*ShardInfo{s0, s1}))
+	var key string
+	for _, k := range keys {
+		if c.getShard(k) == s1.client {
+			key = k
+			break
+		}
+	}
+	assert.NotEmpty(t, key)
+
+	// 迁移期间hset只写入新分片
+	assert.NoError(t, c.HSet(ctx, key, "c", "3").Err())
+	assert.Equal(t, "3", m1.HGet(key, "c"))
+
+	res, err := c.MigrateKeys(ctx, nil)
+	assert.ErrorIs(t, err, ErrMigrateConflict)
+	assert.Contains(t, err.Error(), key)
+	assert.Equal(t, 1, res.Conflicts)
+
+	// 旧分片中的数据没有被删除，新分片也没有被覆盖
+	assert.Equal(t, "1", m0.HGet(key, "a"))
+	assert.Equal(t, "2", m0.HGet(key, "b"))
+	assert.Equal(t, "3", m1.HGet(key, "c"))
+	assert.Equal(t, "", m1.HGet(key, "a"))
+}
+
+func TestShardedClient_FinishMigration(t *testing.T) {
+	s0, _ := newTestShard(t, "shard-0")
+	s1, _ := newTestShard(t, "shard-1")
+	c := NewShardedClient([]*ShardInfo{s0, s1}).(*ShardedClient)
+	defer c.Close()
+
+	assert.Equal(t, ErrNotMigrating, c.FinishMigration())
+	assert.NoError(t, c.Reshard([]*ShardInfo{NewShardInfo("shard-0", "shard-0", s0.client, 2)}))
+	assert.Equal(t, 2, len(c.getAllShards()))
+	assert.NoError(t, c.FinishMigration())
+	assert.Equal(t, 1, len(c.getAllShards()))
+
+	// 不在新hash环中的客户端已经关闭
+	assert.Error(t, s1.client.Ping(context.Background()).Err())
+	assert.NoError(t, s0.client.Ping(context.Background()).Err())
+
+	// 已经移除的分片不能再被替换，新的客户端直接关闭
+	assert.False(t, c.hasShard("shard-1"))
+	s2, _ := newTestShard(t, "shard-1")
+	c.ChangeShardInfo("shard-1", s2)
+	assert.Error(t, s2.client.Ping(context.Background()).Err())
+	assert.Equal(t, 1, len(c.getAllShards()))
+}
@@ -16,14 +16,30 @@ package xredis
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/NetEase-Media/easy-ngo/xlog"
 	"github.com/go-redis/redis/v8"
 )
 
+const switchMasterChannel = "+switch-master"
+
+// ErrMasterUnavailable 启动时没有解析到master地址，分片的命令都会返回该错误，直到解析成功
+var ErrMasterUnavailable = errors.New("sentinel: master is unavailable")
+
+// FailoverEvent master切换事件，OldAddr为空表示启动时没有解析到地址，之后解析成功
+type FailoverEvent struct {
+	MasterName string
+	OldAddr    string
+	NewAddr    string
+	Time       time.Time
+}
+
 func NewShardedSentinelClient(opt *Config) *RedisContainer {
 	sentinels := make(map[string]*redis.SentinelClient, len(opt.Addr))
 	masterAddrs := make(map[string]string, len(opt.Addr))
@@ -32,6 +48,8 @@ func NewShardedSentinelClient(opt *Config) *RedisContainer {
 		sentinels:   sentinels,
 		masterNames: opt.MasterNames,
 		masterAddrs: masterAddrs,
+		weights:     opt.ShardWeights,
+		stop:        make(chan struct{}),
 	}
 
 	ctx := context.Background()
@@ -41,45 +59,31 @@ func NewShardedSentinelClient(opt *Config) *RedisContainer {
 
 	sis := make([]*ShardInfo, 0, len(opt.MasterNames))
 	for _, name := range opt.MasterNames {
-		var addr string
-		for i := range opt.Addr {
-			sentinel := sentinels[opt.Addr[i]]
-			masterAddr, err := sentinel.GetMasterAddrByName(ctx, name).Result()
-			if err != nil {
-				// xfmt.Errorf("sentinel: GetMasterAddrByName master=%s failed: %s",
-				// 	name, err)
-				continue
-			}
-			addr = net.JoinHostPort(masterAddr[0], masterAddr[1])
+		var client Redis
+		addr, err := ssc.resolveMaster(ctx, name)
+		if err != nil {
+			// 暂时无法解析的master先使用不可用的客户端，后台继续解析
+			xlog.Warnf("sentinel: resolve master %s failed: %v", name, err)
+			ssc.record(name, sentinelEventResolveError)
+			client = newUnavailableClient(opt, name)
+		} else {
 			masterAddrs[name] = addr
-			break
-		}
-
-		if len(addr) == 0 {
-			panic(fmt.Sprintf("sentinel: GetMasterAddrByName master=%s all failed", name))
+			client = NewClient(clientOptions(opt, addr))
 		}
-		tmp := clientOptions(opt, addr)
-		shardName := name
-		// 兼容旧分片名称规则，避免线上rehash
-		if opt.AutoGenShardName {
-			shardName = ""
-		}
-		sis = append(sis, &ShardInfo{
-			id:     name,
-			name:   shardName,
-			client: NewClient(tmp),
-			weight: 1,
-		})
+		sis = append(sis, NewShardInfo(name, ssc.shardName(name), client, shardWeight(opt, name)))
 	}
 
 	baseClient := NewShardedClient(sis)
+	baseClient.(*ShardedClient).onFinishMigration = ssc.pruneMasters
 	c := &RedisContainer{
 		Redis:     baseClient,
 		Opt:       *opt,
 		redisType: RedisTypeShardedSentinel,
+		sentinel:  ssc,
 	}
 	ssc.c = c
-	go ssc.listen(ctx)
+	ssc.listen(ctx)
+	go ssc.watch(ctx)
 	return c
 }
 
@@ -88,56 +92,281 @@ type ShardedSentinelClient struct {
 	sentinels   map[string]*redis.SentinelClient
 	masterNames []string
 	masterAddrs map[string]string
+	weights     map[string]int
+	handlers    []func(FailoverEvent)
+	pubsubs     []*redis.PubSub
 	sync.Mutex
 	c *RedisContainer
+
+	stopOnce sync.Once
+	stop     chan struct{}
 }
 
+// listen 订阅所有sentinel的切换事件，订阅连接断开后go-redis会自动重连
 func (ssc *ShardedSentinelClient) listen(ctx context.Context) {
 	for k, v := range ssc.sentinels {
 		if v == nil {
 			ssc.sentinels[k] = redis.NewSentinelClient(sentinelOptions(ssc.opt, k))
 		}
-		pubsub := ssc.sentinels[k].Subscribe(ctx, "+switch-master")
+		pubsub := ssc.sentinels[k].Subscribe(ctx, switchMasterChannel)
+		ssc.pubsubs = append(ssc.pubsubs, pubsub)
 		go func(pubsub *redis.PubSub) {
 			ch := pubsub.Channel()
 			for msg := range ch {
-				if msg.Channel == "+switch-master" {
-					parts := strings.Split(msg.Payload, " ")
-					masterName := parts[0]
-					if _, exists := Find(ssc.masterNames, masterName); !exists {
-						// xlog.Warnf("sentinel: ignore addr for master=%q", parts[0])
-						continue
-					}
-					addr := net.JoinHostPort(parts[3], parts[4])
-
-					ssc.Lock()
-					if ssc.masterAddrs[masterName] == addr {
-						// xlog.Warnf("sentinel: addr for master=%q is not change", parts[0])
-						ssc.Unlock()
-						continue
-					}
-
-					client := ssc.c.Redis.(*ShardedClient)
-					shardName := masterName
-					// 兼容旧分片名称规则，避免线上rehash
-					if ssc.opt.AutoGenShardName {
-						shardName = ""
-					}
-					client.ChangeShardInfo(masterName, &ShardInfo{
-						id:     masterName,
-						name:   shardName,
-						client: NewClient(clientOptions(ssc.opt, addr)),
-						weight: 1,
-					})
-					// xlog.Infof("sentinel: switch master \"%v\"", msg.Payload)
-					ssc.masterAddrs[masterName] = addr
-					ssc.Unlock()
+				if msg.Channel != switchMasterChannel {
+					continue
 				}
+				// 格式为<master name> <old ip> <old port> <new ip> <new port>
+				parts := strings.Split(msg.Payload, " ")
+				if len(parts) < 5 {
+					xlog.Warnf("sentinel: invalid switch master message %q", msg.Payload)
+					continue
+				}
+				ssc.switchMaster(parts[0], net.JoinHostPort(parts[3], parts[4]))
 			}
 		}(pubsub)
 	}
 }
 
+// watch 定期从sentinel校对master地址，避免订阅断开期间丢失切换事件。
+// 未开启校对时只重试启动时没有解析到的master
+func (ssc *ShardedSentinelClient) watch(ctx context.Context) {
+	for {
+		interval := ssc.opt.SentinelCheckInterval
+		if interval <= 0 {
+			if !ssc.hasUnresolved() {
+				return
+			}
+			interval = ssc.retryInterval()
+		}
+		select {
+		case <-ssc.stop:
+			return
+		case <-time.After(interval):
+		}
+
+		ssc.Lock()
+		names := append([]string{}, ssc.masterNames...)
+		ssc.Unlock()
+		for _, name := range names {
+			addr, err := ssc.masterAddr(ctx, name)
+			if err != nil {
+				ssc.record(name, sentinelEventResolveError)
+				continue
+			}
+			ssc.switchMaster(name, addr)
+		}
+	}
+}
+
+func (ssc *ShardedSentinelClient) hasUnresolved() bool {
+	ssc.Lock()
+	defer ssc.Unlock()
+	for _, name := range ssc.masterNames {
+		if _, ok := ssc.masterAddrs[name]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// switchMaster master地址变化时重建分片的客户端，并通知切换事件
+func (ssc *ShardedSentinelClient) switchMaster(masterName, addr string) {
+	ssc.Lock()
+	if _, exists := Find(ssc.masterNames, masterName); !exists {
+		ssc.Unlock()
+		return
+	}
+	old := ssc.masterAddrs[masterName]
+	if old == addr {
+		ssc.Unlock()
+		return
+	}
+
+	client := ssc.c.Redis.(*ShardedClient)
+	// 已经迁移结束被移除的master，新建的客户端无法放入hash环
+	if !client.hasShard(masterName) {
+		ssc.Unlock()
+		return
+	}
+	client.ChangeShardInfo(masterName, NewShardInfo(masterName, ssc.shardName(masterName),
+		NewClient(clientOptions(ssc.opt, addr)), ssc.weights[masterName]))
+	ssc.masterAddrs[masterName] = addr
+	handlers := ssc.handlers
+	ssc.Unlock()
+
+	xlog.Infof("sentinel: switch master %s from %q to %q", masterName, old, addr)
+	ssc.record(masterName, sentinelEventSwitchMaster)
+	event := FailoverEvent{
+		MasterName: masterName,
+		OldAddr:    old,
+		NewAddr:    addr,
+		Time:       time.Now(),
+	}
+	for _, fn := range handlers {
+		fn(event)
+	}
+}
+
+// onFailover 注册切换事件的回调，回调在切换完成后同步执行
+func (ssc *ShardedSentinelClient) onFailover(fn func(FailoverEvent)) {
+	ssc.Lock()
+	defer ssc.Unlock()
+	handlers := make([]func(FailoverEvent), 0, len(ssc.handlers)+1)
+	ssc.handlers = append(append(handlers, ssc.handlers...), fn)
+}
+
+// resolveMaster 启动时解析master地址，失败后按照配置重试
+func (ssc *ShardedSentinelClient) resolveMaster(ctx context.Context, name string) (string, error) {
+	addr, err := ssc.masterAddr(ctx, name)
+	for i := 0; err != nil && i < ssc.opt.SentinelRetries; i++ {
+		time.Sleep(ssc.retryInterval())
+		addr, err = ssc.masterAddr(ctx, name)
+	}
+	return addr, err
+}
+
+func (ssc *ShardedSentinelClient) retryInterval() time.Duration {
+	if ssc.opt.SentinelRetryInterval > 0 {
+		return ssc.opt.SentinelRetryInterval
+	}
+	return time.Second
+}
+
+func (ssc *ShardedSentinelClient) record(masterName, event string) {
+	if ssc.opt.EnableMetrics {
+		recordSentinelEvent(ssc.opt.Name, masterName, event)
+	}
+}
+
+func (ssc *ShardedSentinelClient) close() {
+	ssc.stopOnce.Do(func() {
+		close(ssc.stop)
+		for _, pubsub := range ssc.pubsubs {
+			_ = pubsub.Close()
+		}
+		for _, sentinel := range ssc.sentinels {
+			_ = sentinel.Close()
+		}
+	})
+}
+
+// newUnavailableClient 创建master地址未知时使用的客户端，所有命令都返回ErrMasterUnavailable
+func newUnavailableClient(opt *Config, masterName string) *RedisContainer {
+	return &RedisContainer{
+		Redis: redis.NewClient(&redis.Options{
+			Addr: masterName,
+			Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return nil, ErrMasterUnavailable
+			},
+			MaxRetries: -1,
+		}),
+		Opt:       *clientOptions(opt, masterName),
+		redisType: RedisTypeClient,
+	}
+}
+
+// masterAddr 依次从sentinel获取master的地址
+func (ssc *ShardedSentinelClient) masterAddr(ctx context.Context, name string) (string, error) {
+	for i := range ssc.opt.Addr {
+		sentinel := ssc.sentinels[ssc.opt.Addr[i]]
+		masterAddr, err := sentinel.GetMasterAddrByName(ctx, name).Result()
+		if err != nil {
+			xlog.Debugf("sentinel: GetMasterAddrByName master=%s from %s failed: %v", name, ssc.opt.Addr[i], err)
+			continue
+		}
+		return net.JoinHostPort(masterAddr[0], masterAddr[1]), nil
+	}
+	return "", fmt.Errorf("sentinel: GetMasterAddrByName master=%s all failed", name)
+}
+
+// shardName 兼容旧分片名称规则，避免线上rehash
+func (ssc *ShardedSentinelClient) shardName(masterName string) string {
+	if ssc.opt.AutoGenShardName {
+		return ""
+	}
+	return masterName
+}
+
+func shardWeight(opt *Config, masterName string) int {
+	if w, ok := opt.ShardWeights[masterName]; ok && w > 0 {
+		return w
+	}
+	return 1
+}
+
+// reshard 按照新的MasterNames和ShardWeights重建hash环，已有的master继续使用原来的客户端
+func (ssc *ShardedSentinelClient) reshard(ctx context.Context, opt *Config) error {
+	ssc.Lock()
+	defer ssc.Unlock()
+
+	client := ssc.c.Redis.(*ShardedClient)
+	client.RLock()
+	current := make(map[string]*ShardInfo, len(client.ring.resources))
+	for id, si := range client.ring.resources {
+		current[id] = si
+	}
+	client.RUnlock()
+
+	sis := make([]*ShardInfo, 0, len(opt.MasterNames))
+	created := make([]Redis, 0)
+	addrs := make(map[string]string)
+	for _, name := range opt.MasterNames {
+		if si, ok := current[name]; ok {
+			sis = append(sis, NewShardInfo(name, ssc.shardName(name), si.client, shardWeight(opt, name)))
+			continue
+		}
+		addr, err := ssc.masterAddr(ctx, name)
+		if err != nil {
+			for _, c := range created {
+				_ = c.Close()
+			}
+			return err
+		}
+		c := NewClient(clientOptions(ssc.opt, addr))
+		created = append(created, c)
+		addrs[name] = addr
+		sis = append(sis, NewShardInfo(name, ssc.shardName(name), c, shardWeight(opt, name)))
+	}
+	if err := client.Reshard(sis); err != nil {
+		for _, c := range created {
+			_ = c.Close()
+		}
+		return err
+	}
+
+	// 迁移期间旧分片仍然可能被读取，继续跟踪旧master的切换
+	for name, addr := range addrs {
+		ssc.masterAddrs[name] = addr
+	}
+	masterNames := append([]string{}, ssc.masterNames...)
+	for _, name := range opt.MasterNames {
+		if _, exists := Find(masterNames, name); !exists {
+			masterNames = append(masterNames, name)
+		}
+	}
+	ssc.masterNames = masterNames
+	ssc.weights = opt.ShardWeights
+	return nil
+}
+
+// pruneMasters 迁移结束后停止跟踪已经不在hash环中的master
+func (ssc *ShardedSentinelClient) pruneMasters() {
+	ssc.Lock()
+	defer ssc.Unlock()
+
+	client := ssc.c.Redis.(*ShardedClient)
+	masterNames := make([]string, 0, len(ssc.masterNames))
+	for _, name := range ssc.masterNames {
+		if client.hasShard(name) {
+			masterNames = append(masterNames, name)
+			continue
+		}
+		delete(ssc.masterAddrs, name)
+	}
+	ssc.masterNames = masterNames
+}
+
 func sentinelOptions(opt *Config, addr string) *redis.Options {
 	return &redis.Options{
 		Addr:               addr,
@@ -160,6 +389,7 @@ func sentinelOptions(opt *Config, addr string) *redis.Options {
 
 func clientOptions(opt *Config, addr string) *Config {
 	return &Config{
+		Name:               opt.Name,
 		Addr:               []string{addr},
 		DB:                 0,
 		Password:           opt.Password,
@@ -176,6 +406,9 @@ func clientOptions(opt *Config, addr string) *Config {
 		MinIdleConns:       opt.MinIdleConns,
 		MaxConnAge:         opt.MaxConnAge,
 		TLSConfig:          opt.TLSConfig,
+		EnableMetrics:      opt.EnableMetrics,
+		Metrics:            opt.Metrics,
+		EnableTracer:       opt.EnableTracer,
 	}
 }
 
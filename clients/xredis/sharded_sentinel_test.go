@@ -17,14 +17,129 @@ package xredis
 import (
 	"context"
 	"fmt"
+	"net"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	miniredisv2 "github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
 	"github.com/stretchr/testify/assert"
 )
 
+// fakeSentinel 使用miniredis模拟sentinel，支持get-master-addr-by-name和+switch-master事件
+type fakeSentinel struct {
+	*miniredisv2.Miniredis
+	mu      sync.Mutex
+	masters map[string]string
+}
+
+func newFakeSentinel(t *testing.T) *fakeSentinel {
+	s := &fakeSentinel{
+		Miniredis: miniredisv2.RunT(t),
+		masters:   make(map[string]string),
+	}
+	_ = s.Server().Register("SENTINEL", func(c *server.Peer, cmd string, args []string) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		addr, ok := s.masters[args[1]]
+		if !ok {
+			c.WriteNull()
+			return
+		}
+		host, port, _ := net.SplitHostPort(addr)
+		c.WriteLen(2)
+		c.WriteBulk(host)
+		c.WriteBulk(port)
+	})
+	return s
+}
+
+func (s *fakeSentinel) setMaster(name, addr string) {
+	s.mu.Lock()
+	s.masters[name] = addr
+	s.mu.Unlock()
+}
+
+// failover 切换master并发布+switch-master事件
+func (s *fakeSentinel) failover(name, addr string) {
+	s.mu.Lock()
+	old := s.masters[name]
+	s.masters[name] = addr
+	s.mu.Unlock()
+	oldHost, oldPort, _ := net.SplitHostPort(old)
+	host, port, _ := net.SplitHostPort(addr)
+	s.Publish(switchMasterChannel, strings.Join([]string{name, oldHost, oldPort, host, port}, " "))
+}
+
+func TestShardedSentinelClient_Failover(t *testing.T) {
+	ctx := context.Background()
+	sentinel := newFakeSentinel(t)
+	m1 := miniredisv2.RunT(t)
+	m2 := miniredisv2.RunT(t)
+	sentinel.setMaster("master-1", m1.Addr())
+
+	opt := DefaultConfig()
+	opt.Name = "test sharded sentinel"
+	opt.ConnType = RedisTypeShardedSentinel
+	opt.Addr = []string{sentinel.Addr()}
+	opt.MasterNames = []string{"master-1"}
+	opt.SentinelCheckInterval = 0
+	client := NewShardedSentinelClient(opt)
+	defer client.Close()
+
+	events := make(chan FailoverEvent, 1)
+	client.OnFailover(func(e FailoverEvent) {
+		events <- e
+	})
+	assert.NoError(t, client.Set(ctx, "k", "v1", 0).Err())
+	assert.NoError(t, m2.Set("k", "v2"))
+
+	// 等待订阅成功
+	assert.Eventually(t, func() bool {
+		return len(sentinel.PubSubChannels("")) > 0
+	}, time.Second, 10*time.Millisecond)
+	sentinel.failover("master-1", m2.Addr())
+
+	select {
+	case e := <-events:
+		assert.Equal(t, "master-1", e.MasterName)
+		assert.Equal(t, m1.Addr(), e.OldAddr)
+		assert.Equal(t, m2.Addr(), e.NewAddr)
+	case <-time.After(time.Second):
+		t.Fatal("failover event not received")
+	}
+	assert.Equal(t, "v2", client.Get(ctx, "k").Val())
+}
+
+func TestShardedSentinelClient_UnavailableMaster(t *testing.T) {
+	ctx := context.Background()
+	sentinel := newFakeSentinel(t)
+	m1 := miniredisv2.RunT(t)
+
+	opt := DefaultConfig()
+	opt.Name = "test sharded sentinel"
+	opt.ConnType = RedisTypeShardedSentinel
+	opt.Addr = []string{sentinel.Addr()}
+	opt.MasterNames = []string{"master-1"}
+	opt.SentinelRetries = 1
+	opt.SentinelRetryInterval = 10 * time.Millisecond
+	opt.SentinelCheckInterval = 0
+	client := NewShardedSentinelClient(opt)
+	defer client.Close()
+
+	assert.ErrorIs(t, client.Set(ctx, "k", "v", 0).Err(), ErrMasterUnavailable)
+
+	// 后台解析到地址后恢复
+	sentinel.setMaster("master-1", m1.Addr())
+	assert.Eventually(t, func() bool {
+		return client.Set(ctx, "k", "v", 0).Err() == nil
+	}, time.Second, 10*time.Millisecond)
+	m1.CheckGet(t, "k", "v")
+}
+
 func TestShardedSentinelClient(t *testing.T) {
 	// 有外部依赖，先忽略
 	t.Skip()
@@ -80,3 +195,49 @@ func BenchmarkShardedSentinelClient_Set(b *testing.B) {
 	}
 	b.StopTimer()
 }
+
+func TestShardedSentinelClient_FinishMigrationPrunesMasters(t *testing.T) {
+	ctx := context.Background()
+	sentinel := newFakeSentinel(t)
+	m1 := miniredisv2.RunT(t)
+	m2 := miniredisv2.RunT(t)
+	m3 := miniredisv2.RunT(t)
+	sentinel.setMaster("master-1", m1.Addr())
+	sentinel.setMaster("master-2", m2.Addr())
+
+	opt := DefaultConfig()
+	opt.Name = "test sharded sentinel"
+	opt.ConnType = RedisTypeShardedSentinel
+	opt.Addr = []string{sentinel.Addr()}
+	opt.MasterNames = []string{"master-1"}
+	opt.SentinelCheckInterval = 0
+	client := NewShardedSentinelClient(opt)
+	defer client.Close()
+
+	events := make(chan FailoverEvent, 1)
+	client.OnFailover(func(e FailoverEvent) {
+		events <- e
+	})
+
+	newOpt := *opt
+	newOpt.MasterNames = []string{"master-2"}
+	assert.NoError(t, client.Reshard(&newOpt))
+	ssc := client.sentinel
+	assert.Equal(t, []string{"master-1", "master-2"}, ssc.masterNames)
+
+	sc := client.Redis.(*ShardedClient)
+	assert.NoError(t, sc.FinishMigration())
+	assert.Equal(t, []string{"master-2"}, ssc.masterNames)
+	_, ok := ssc.masterAddrs["master-1"]
+	assert.False(t, ok)
+
+	// 被移除的master发生切换时不再创建客户端
+	ssc.switchMaster("master-1", m3.Addr())
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected failover event %v", e)
+	default:
+	}
+	assert.NoError(t, client.Set(ctx, "k", "v", 0).Err())
+	m2.CheckGet(t, "k", "v")
+}
@@ -92,6 +92,44 @@ func TestPipelineUnsupportedCmd(t *testing.T) {
 	})
 }
 
+func TestPipelineCmdErrors(t *testing.T) {
+	ctx := context.Background()
+	do(func(client Redis) {
+		client.Set(ctx, "k1", "v1", time.Second*60)
+		client.Set(ctx, "k3", "v3", time.Second*60)
+
+		pipe := client.Pipeline()
+		pipe.Get(ctx, "k1")
+		pipe.Dump(ctx, "k2")
+		pipe.Get(ctx, "k3")
+		pipe.Get(ctx, "missing")
+		cmds, err := pipe.Exec(ctx)
+		assert.Equal(t, "unsupport command: [dump]", err.Error())
+		assert.Equal(t, 4, len(cmds))
+		assert.Equal(t, "v1", cmds[0].(*redis.StringCmd).Val())
+		assert.Error(t, cmds[1].Err())
+		assert.Equal(t, "v3", cmds[2].(*redis.StringCmd).Val())
+		assert.Equal(t, redis.Nil, cmds[3].Err())
+	})
+}
+
+func TestPipelined(t *testing.T) {
+	ctx := context.Background()
+	do(func(client Redis) {
+		var incr *redis.IntCmd
+		cmds, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, "p1", "v1", time.Second*60)
+			incr = pipe.Incr(ctx, "p2")
+			assert.Equal(t, 2, pipe.Len())
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(cmds))
+		assert.Equal(t, int64(1), incr.Val())
+		assert.Equal(t, "v1", client.Get(ctx, "p1").Val())
+	})
+}
+
 func BenchmarkShardedClient_Set(b *testing.B) {
 	ctx := context.Background()
 	do(func(redis Redis) {
@@ -0,0 +1,201 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xredis
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	redisv9 "github.com/redis/go-redis/v9"
+)
+
+// RedisV9 是基于go-redis v9的客户端接口，兼容单实例、cluster和sentinel类型
+type RedisV9 interface {
+	redisv9.UniversalClient
+}
+
+// RedisV9Container 用来存储v9客户端及其额外信息
+type RedisV9Container struct {
+	RedisV9
+	Opt       Config
+	redisType string
+	metrics   *clientMetrics
+	cache     *ClientCache
+}
+
+// NewV9 使用同样的配置创建go-redis v9客户端，支持RESP3和客户端缓存。
+// 暂不支持sharded_sentinel类型，客户端缓存只支持client和sentinel类型
+func NewV9(opt *Config) (*RedisV9Container, error) {
+	if err := checkConfig(opt); err != nil {
+		return nil, err
+	}
+
+	var c *RedisV9Container
+	switch opt.ConnType {
+	case RedisTypeClient:
+		c = newClientV9(opt)
+	case RedisTypeCluster:
+		if opt.ClientCache.Enabled {
+			return nil, errors.New("client cache is unsupported for cluster")
+		}
+		c = newClusterClientV9(opt)
+	case RedisTypeSentinel:
+		if len(opt.MasterNames) == 0 {
+			return nil, errors.New("empty master name")
+		}
+		c = newSentinelClientV9(opt)
+	case RedisTypeShardedSentinel:
+		return nil, errors.New("sharded_sentinel is unsupported for go-redis v9")
+	default:
+		return nil, errors.New("redis connection type need ")
+	}
+
+	if opt.ClientCache.Enabled {
+		cache, err := newClientCache(&opt.ClientCache, c.newTrackingClient)
+		if err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+		c.cache = cache
+	}
+	return c, nil
+}
+
+func newClientOptionsV9(opt *Config) *redisv9.Options {
+	return &redisv9.Options{
+		Addr:            opt.Addr[0],
+		Protocol:        opt.Protocol,
+		Username:        opt.Username,
+		Password:        opt.Password,
+		DB:              opt.DB,
+		MaxRetries:      opt.MaxRetries,
+		MinRetryBackoff: opt.MinRetryBackoff,
+		MaxRetryBackoff: opt.MaxRetryBackoff,
+		DialTimeout:     opt.DialTimeout,
+		ReadTimeout:     opt.ReadTimeout,
+		WriteTimeout:    opt.WriteTimeout,
+		PoolSize:        opt.PoolSize,
+		MinIdleConns:    opt.MinIdleConns,
+		PoolTimeout:     opt.PoolTimeout,
+		ConnMaxLifetime: opt.MaxConnAge,
+		ConnMaxIdleTime: opt.IdleTimeout,
+		TLSConfig:       opt.TLSConfig,
+	}
+}
+
+func newClusterOptionsV9(opt *Config) *redisv9.ClusterOptions {
+	return &redisv9.ClusterOptions{
+		Addrs:           opt.Addr,
+		Protocol:        opt.Protocol,
+		Username:        opt.Username,
+		Password:        opt.Password,
+		MaxRetries:      opt.MaxRetries,
+		MinRetryBackoff: opt.MinRetryBackoff,
+		MaxRetryBackoff: opt.MaxRetryBackoff,
+		DialTimeout:     opt.DialTimeout,
+		ReadTimeout:     opt.ReadTimeout,
+		WriteTimeout:    opt.WriteTimeout,
+		PoolSize:        opt.PoolSize,
+		MinIdleConns:    opt.MinIdleConns,
+		PoolTimeout:     opt.PoolTimeout,
+		ConnMaxLifetime: opt.MaxConnAge,
+		ConnMaxIdleTime: opt.IdleTimeout,
+		TLSConfig:       opt.TLSConfig,
+	}
+}
+
+func newSentinelOptionsV9(opt *Config) *redisv9.FailoverOptions {
+	return &redisv9.FailoverOptions{
+		MasterName:      opt.MasterNames[0],
+		SentinelAddrs:   opt.Addr,
+		Protocol:        opt.Protocol,
+		Username:        opt.Username,
+		Password:        opt.Password,
+		DB:              opt.DB,
+		MaxRetries:      opt.MaxRetries,
+		MinRetryBackoff: opt.MinRetryBackoff,
+		MaxRetryBackoff: opt.MaxRetryBackoff,
+		DialTimeout:     opt.DialTimeout,
+		ReadTimeout:     opt.ReadTimeout,
+		WriteTimeout:    opt.WriteTimeout,
+		PoolSize:        opt.PoolSize,
+		MinIdleConns:    opt.MinIdleConns,
+		PoolTimeout:     opt.PoolTimeout,
+		ConnMaxLifetime: opt.MaxConnAge,
+		ConnMaxIdleTime: opt.IdleTimeout,
+		TLSConfig:       opt.TLSConfig,
+	}
+}
+
+func newClientV9(opt *Config) *RedisV9Container {
+	baseClient := redisv9.NewClient(newClientOptionsV9(opt))
+	return &RedisV9Container{
+		RedisV9:   baseClient,
+		Opt:       *opt,
+		redisType: RedisTypeClient,
+		metrics:   addHookV9(opt, baseClient, opt.Addr[0]),
+	}
+}
+
+func newClusterClientV9(opt *Config) *RedisV9Container {
+	baseClient := redisv9.NewClusterClient(newClusterOptionsV9(opt))
+	return &RedisV9Container{
+		RedisV9:   baseClient,
+		Opt:       *opt,
+		redisType: RedisTypeCluster,
+		metrics:   addHookV9(opt, baseClient, strings.Join(opt.Addr, ",")),
+	}
+}
+
+func newSentinelClientV9(opt *Config) *RedisV9Container {
+	baseClient := redisv9.NewFailoverClient(newSentinelOptionsV9(opt))
+	return &RedisV9Container{
+		RedisV9:   baseClient,
+		Opt:       *opt,
+		redisType: RedisTypeSentinel,
+		metrics:   addHookV9(opt, baseClient, opt.MasterNames[0]),
+	}
+}
+
+// newTrackingClient 创建与当前客户端配置相同、但使用独立连接池的客户端，供客户端缓存订阅失效消息和读取数据
+func (c *RedisV9Container) newTrackingClient(onConnect func(context.Context, *redisv9.Conn) error) *redisv9.Client {
+	switch c.redisType {
+	case RedisTypeSentinel:
+		o := newSentinelOptionsV9(&c.Opt)
+		o.OnConnect = onConnect
+		return redisv9.NewFailoverClient(o)
+	default:
+		o := newClientOptionsV9(&c.Opt)
+		o.OnConnect = onConnect
+		return redisv9.NewClient(o)
+	}
+}
+
+// Cache 返回客户端缓存，未开启时返回nil
+func (c *RedisV9Container) Cache() *ClientCache {
+	return c.cache
+}
+
+// Close 关闭客户端和客户端缓存，并停止采集连接池指标
+func (c *RedisV9Container) Close() error {
+	if c.cache != nil {
+		c.cache.close()
+	}
+	if c.metrics != nil {
+		c.metrics.close()
+	}
+	return c.RedisV9.Close()
+}
@@ -0,0 +1,73 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xredis
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+	redisv9 "github.com/redis/go-redis/v9"
+)
+
+// hookV9 实现go-redis v9的redis.Hook，与v8共用监控指标和span
+type hookV9 struct {
+	*hook
+}
+
+// addHookV9 按照配置为v9客户端增加监控和链路追踪，返回的metrics需要在关闭客户端时停止
+func addHookV9(opt *Config, client redisv9.UniversalClient, addr string) *clientMetrics {
+	h := newHook(opt, addr, func() *redis.PoolStats {
+		s := client.PoolStats()
+		return &redis.PoolStats{
+			Hits:       s.Hits,
+			Misses:     s.Misses,
+			Timeouts:   s.Timeouts,
+			TotalConns: s.TotalConns,
+			IdleConns:  s.IdleConns,
+			StaleConns: s.StaleConns,
+		}
+	})
+	if h == nil {
+		return nil
+	}
+	client.AddHook(hookV9{hook: h})
+	return h.metrics
+}
+
+func (h hookV9) DialHook(next redisv9.DialHook) redisv9.DialHook {
+	return next
+}
+
+func (h hookV9) ProcessHook(next redisv9.ProcessHook) redisv9.ProcessHook {
+	return func(ctx context.Context, cmd redisv9.Cmder) error {
+		ctx = h.before(ctx, cmd)
+		err := next(ctx, cmd)
+		h.after(ctx, cmd)
+		return err
+	}
+}
+
+func (h hookV9) ProcessPipelineHook(next redisv9.ProcessPipelineHook) redisv9.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redisv9.Cmder) error {
+		cs := make([]command, len(cmds))
+		for i, cmd := range cmds {
+			cs[i] = cmd
+		}
+		ctx = h.beforePipeline(ctx, cs)
+		err := next(ctx, cmds)
+		h.afterPipeline(ctx, cs)
+		return err
+	}
+}
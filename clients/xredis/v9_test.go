@@ -0,0 +1,69 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xredis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	miniredisv2 "github.com/alicebob/miniredis/v2"
+	redisv9 "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewV9(t *testing.T) {
+	s := miniredisv2.RunT(t)
+	ctx := context.Background()
+
+	for _, protocol := range []int{2, 3} {
+		opt := DefaultConfig()
+		opt.Name = "test v9 client"
+		opt.ConnType = RedisTypeClient
+		opt.Addr = []string{s.Addr()}
+		opt.Protocol = protocol
+		opt.MaxConnAge = time.Minute
+		opt.IdleTimeout = time.Minute
+		c, err := NewV9(opt)
+		assert.NoError(t, err)
+
+		assert.NoError(t, c.Set(ctx, "k", "v", time.Minute).Err())
+		assert.Equal(t, "v", c.Get(ctx, "k").Val())
+		assert.Equal(t, redisv9.Nil, c.Get(ctx, "missing").Err())
+		assert.Nil(t, c.Cache())
+		assert.NoError(t, c.Close())
+	}
+}
+
+func TestNewV9_Unsupported(t *testing.T) {
+	opt := DefaultConfig()
+	opt.Name = "test v9 client"
+	opt.Addr = []string{"127.0.0.1:6379"}
+
+	opt.ConnType = RedisTypeShardedSentinel
+	opt.MasterNames = []string{"master"}
+	_, err := NewV9(opt)
+	assert.Error(t, err)
+
+	opt.ConnType = RedisTypeCluster
+	opt.ClientCache.Enabled = true
+	_, err = NewV9(opt)
+	assert.Error(t, err)
+
+	opt.ConnType = RedisTypeClient
+	opt.Protocol = 4
+	_, err = NewV9(opt)
+	assert.Error(t, err)
+}
@@ -15,6 +15,7 @@
 package xzk
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -303,6 +304,8 @@ func (z *ZookeeperProxy) Delete(path string) error {
 	if err != nil {
 		return err
 	}
+	// 主动删除的临时节点在session重建后不再恢复
+	z.tmpNode.Delete(path)
 	err_ := z.Conn.Delete(path, stat.Version)
 	return err_
 }
@@ -339,17 +342,34 @@ type WatchChildrenResponse struct {
 
 // 监听子节点                               				TODO： 目前只支持对子节点进行 增加 或 删除的监控， 不支持对 子节点值改变 的监控
 func (z *ZookeeperProxy) WatchChildren(path string, listener func(respChan <-chan *WatchChildrenResponse)) {
+	z.WatchChildrenContext(context.Background(), path, listener)
+}
+
+// WatchChildrenContext 监听子节点，ctx结束后停止监听并关闭respChan
+func (z *ZookeeperProxy) WatchChildrenContext(ctx context.Context, path string, listener func(respChan <-chan *WatchChildrenResponse)) {
 	z.done.Add(1)
 	respChan := make(chan *WatchChildrenResponse, 100)
 	go listener(respChan)
 
+	send := func(resp *WatchChildrenResponse) bool {
+		select {
+		case respChan <- resp:
+			return true
+		case <-ctx.Done():
+			return false
+		case <-z.stop:
+			return false
+		}
+	}
+
 	oldChildren, _, eventCh, err := z.Conn.ChildrenW(path)
 	if err != nil {
-		errResp := &WatchChildrenResponse{
+		send(&WatchChildrenResponse{
 			ChildrenChangeInfo: []*ChildrenChange{},
 			Err:                err,
-		}
-		respChan <- errResp
+		})
+		close(respChan)
+		z.done.Done()
 		return
 	}
 	go func(path string, oldChildren []string) {
@@ -363,6 +383,8 @@ func (z *ZookeeperProxy) WatchChildren(path string, listener func(respChan <-cha
 			select {
 			case <-z.stop:
 				return
+			case <-ctx.Done():
+				return
 			case <-eventCh:
 				newChildren, _, newEventCh, er := z.Conn.ChildrenW(path)
 				eventCh = newEventCh
@@ -371,13 +393,14 @@ func (z *ZookeeperProxy) WatchChildren(path string, listener func(respChan <-cha
 						ChildrenChangeInfo: nil,
 						Err:                er,
 					}
-					respChan <- response
-					if er == zk.ErrNoNode {
+					if !send(response) || er == zk.ErrNoNode {
 						return
 					}
 				} else {
 					response = childrenWatcherResponse(path, oldChildren, newChildren)
-					respChan <- response
+					if !send(response) {
+						return
+					}
 					oldChildren = newChildren
 				}
 			}
@@ -482,6 +505,10 @@ func (z *ZookeeperProxy) WatchNode(path string, listener func(respChan <-chan *W
 			case e := <-eventCh:
 				newDataBuf, _, newEventCh, er := z.Conn.GetW(path)
 				for isEphemeralNode && er != nil {
+					// 临时节点被主动删除后不再等待恢复
+					if _, isEphemeralNode = z.tmpNode.Load(path); !isEphemeralNode {
+						break
+					}
 					newDataBuf, _, newEventCh, er = z.Conn.GetW(path)
 				}
 				eventCh = newEventCh
@@ -0,0 +1,33 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+var resolver *Resolver
+
+// Pick 使用全局的Resolver选择实例
+func Pick(service, key string) (*Instance, error) {
+	if resolver == nil {
+		return nil, ErrNoResolver
+	}
+	return resolver.Pick(service, key)
+}
+
+func WithResolver(r *Resolver) {
+	resolver = r
+}
+
+func GetResolver() *Resolver {
+	return resolver
+}
@@ -0,0 +1,226 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	RoundRobin     = "round_robin"
+	WeightedRandom = "weighted_random"
+	LeastRequest   = "least_request"
+	ConsistentHash = "consistent_hash"
+
+	// 一致性哈希中每个实例的虚拟节点数
+	defaultReplicas = 160
+)
+
+var (
+	balancersMu sync.RWMutex
+	balancers   = map[string]BalancerBuilder{
+		RoundRobin:     newRoundRobin,
+		WeightedRandom: newWeightedRandom,
+		LeastRequest:   newLeastRequest,
+		ConsistentHash: newConsistentHash,
+	}
+)
+
+// RegisterBalancer 注册自定义的负载均衡策略，相同名称会覆盖
+func RegisterBalancer(name string, builder BalancerBuilder) {
+	balancersMu.Lock()
+	defer balancersMu.Unlock()
+	balancers[name] = builder
+}
+
+// NewBalancer 根据名称创建负载均衡器
+func NewBalancer(name string) (Balancer, error) {
+	balancersMu.RLock()
+	defer balancersMu.RUnlock()
+	builder, ok := balancers[name]
+	if !ok {
+		return nil, fmt.Errorf("balancer %s not found", name)
+	}
+	return builder(), nil
+}
+
+// available 返回未被摘除的实例，如果全部被摘除则返回全部实例，避免无实例可用
+func available(instances []*Instance) []*Instance {
+	res := make([]*Instance, 0, len(instances))
+	for _, ins := range instances {
+		if !ins.Ejected() {
+			res = append(res, ins)
+		}
+	}
+	if len(res) == 0 {
+		return instances
+	}
+	return res
+}
+
+type instanceList struct {
+	v atomic.Value
+}
+
+func (l *instanceList) Update(instances []*Instance) {
+	l.v.Store(instances)
+}
+
+func (l *instanceList) load() []*Instance {
+	instances, _ := l.v.Load().([]*Instance)
+	return instances
+}
+
+type roundRobin struct {
+	instanceList
+	next uint64
+}
+
+func newRoundRobin() Balancer {
+	return &roundRobin{}
+}
+
+func (b *roundRobin) Pick(key string) (*Instance, error) {
+	instances := available(b.load())
+	if len(instances) == 0 {
+		return nil, ErrNoInstance
+	}
+	n := atomic.AddUint64(&b.next, 1)
+	return instances[n%uint64(len(instances))], nil
+}
+
+type weightedRandom struct {
+	instanceList
+}
+
+func newWeightedRandom() Balancer {
+	return &weightedRandom{}
+}
+
+func weight(ins *Instance) int {
+	// 未配置权重时按1处理
+	if ins.Weight <= 0 {
+		return 1
+	}
+	return ins.Weight
+}
+
+func (b *weightedRandom) Pick(key string) (*Instance, error) {
+	instances := available(b.load())
+	if len(instances) == 0 {
+		return nil, ErrNoInstance
+	}
+	total := 0
+	for _, ins := range instances {
+		total += weight(ins)
+	}
+	r := rand.Intn(total)
+	for _, ins := range instances {
+		r -= weight(ins)
+		if r < 0 {
+			return ins, nil
+		}
+	}
+	return instances[len(instances)-1], nil
+}
+
+type leastRequest struct {
+	instanceList
+}
+
+func newLeastRequest() Balancer {
+	return &leastRequest{}
+}
+
+// Pick 使用power of two choices，随机选择两个实例中请求数较少的一个
+func (b *leastRequest) Pick(key string) (*Instance, error) {
+	instances := available(b.load())
+	switch len(instances) {
+	case 0:
+		return nil, ErrNoInstance
+	case 1:
+		return instances[0], nil
+	}
+	i := rand.Intn(len(instances))
+	j := rand.Intn(len(instances) - 1)
+	if j >= i {
+		j++
+	}
+	if instances[j].Inflight() < instances[i].Inflight() {
+		return instances[j], nil
+	}
+	return instances[i], nil
+}
+
+type consistentHash struct {
+	mu        sync.RWMutex
+	instances []*Instance
+	hashes    []uint32
+	ring      map[uint32]*Instance
+}
+
+func newConsistentHash() Balancer {
+	return &consistentHash{}
+}
+
+func (b *consistentHash) Update(instances []*Instance) {
+	ring := make(map[uint32]*Instance, len(instances)*defaultReplicas)
+	hashes := make([]uint32, 0, len(instances)*defaultReplicas)
+	for _, ins := range instances {
+		for i := 0; i < defaultReplicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(ins.Endpoint() + "#" + strconv.Itoa(i)))
+			if _, ok := ring[h]; ok {
+				continue
+			}
+			ring[h] = ins
+			hashes = append(hashes, h)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.instances = instances
+	b.hashes = hashes
+	b.ring = ring
+}
+
+// Pick 沿哈希环顺时针查找第一个可用的实例，key为空时随机选择
+func (b *consistentHash) Pick(key string) (*Instance, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.hashes) == 0 {
+		return nil, ErrNoInstance
+	}
+	if key == "" {
+		instances := available(b.instances)
+		return instances[rand.Intn(len(instances))], nil
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	idx := sort.Search(len(b.hashes), func(i int) bool { return b.hashes[i] >= h })
+	for i := 0; i < len(b.hashes); i++ {
+		ins := b.ring[b.hashes[(idx+i)%len(b.hashes)]]
+		if !ins.Ejected() {
+			return ins, nil
+		}
+	}
+	return b.ring[b.hashes[idx%len(b.hashes)]], nil
+}
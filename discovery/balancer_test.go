@@ -0,0 +1,134 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/NetEase-Media/easy-ngo/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestInstances(weights ...int) []*Instance {
+	instances := make([]*Instance, 0, len(weights))
+	for i, w := range weights {
+		instances = append(instances, &Instance{ServiceInstance: &registry.ServiceInstance{
+			Name:    "test",
+			Address: "10.0.0." + strconv.Itoa(i+1),
+			Port:    8080,
+			Weight:  w,
+		}})
+	}
+	return instances
+}
+
+func TestRoundRobin(t *testing.T) {
+	b, err := NewBalancer(RoundRobin)
+	assert.Nil(t, err)
+	_, err = b.Pick("")
+	assert.Equal(t, ErrNoInstance, err)
+
+	instances := newTestInstances(1, 1, 1)
+	b.Update(instances)
+	counts := make(map[*Instance]int)
+	for i := 0; i < 30; i++ {
+		ins, err := b.Pick("")
+		assert.Nil(t, err)
+		counts[ins]++
+	}
+	for _, ins := range instances {
+		assert.Equal(t, 10, counts[ins])
+	}
+
+	instances[0].ejectedUntil = 1 << 62
+	for i := 0; i < 10; i++ {
+		ins, _ := b.Pick("")
+		assert.NotEqual(t, instances[0], ins)
+	}
+}
+
+func TestWeightedRandom(t *testing.T) {
+	b, _ := NewBalancer(WeightedRandom)
+	instances := newTestInstances(1, 0, 98)
+	b.Update(instances)
+	counts := make(map[*Instance]int)
+	for i := 0; i < 1000; i++ {
+		ins, _ := b.Pick("")
+		counts[ins]++
+	}
+	assert.Greater(t, counts[instances[2]], 900)
+}
+
+func TestLeastRequest(t *testing.T) {
+	b, _ := NewBalancer(LeastRequest)
+	instances := newTestInstances(1, 1)
+	b.Update(instances)
+	instances[0].acquire()
+	for i := 0; i < 10; i++ {
+		ins, _ := b.Pick("")
+		assert.Equal(t, instances[1], ins)
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	b, _ := NewBalancer(ConsistentHash)
+	instances := newTestInstances(1, 1, 1, 1)
+	b.Update(instances)
+	first, err := b.Pick("user-42")
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		ins, _ := b.Pick("user-42")
+		assert.Equal(t, first, ins)
+	}
+
+	// 摘除后选择环上的下一个实例，恢复后回到原来的实例
+	first.ejectedUntil = 1 << 62
+	next, _ := b.Pick("user-42")
+	assert.NotEqual(t, first, next)
+	first.ejectedUntil = 0
+	ins, _ := b.Pick("user-42")
+	assert.Equal(t, first, ins)
+
+	// 删除其他实例不影响已有key的分配
+	var rest []*Instance
+	for _, i := range instances {
+		if i == first || i == next {
+			rest = append(rest, i)
+		}
+	}
+	b.Update(rest)
+	ins, _ = b.Pick("user-42")
+	assert.Equal(t, first, ins)
+}
+
+func TestRegisterBalancer(t *testing.T) {
+	_, err := NewBalancer("not_exists")
+	assert.NotNil(t, err)
+	RegisterBalancer("first", func() Balancer { return &testFirstBalancer{} })
+	b, err := NewBalancer("first")
+	assert.Nil(t, err)
+	b.Update(newTestInstances(1, 1))
+	ins, _ := b.Pick("")
+	assert.Equal(t, "10.0.0.1", ins.Address)
+}
+
+type testFirstBalancer struct {
+	instanceList
+}
+
+func (b *testFirstBalancer) Pick(key string) (*Instance, error) {
+	return b.load()[0], nil
+}
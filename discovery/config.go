@@ -0,0 +1,61 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/registry"
+)
+
+type Config struct {
+	// 是否开启服务发现
+	Enabled bool
+	// 服务发现类型，支持zookeeper、static、file
+	Type string
+	// 负载均衡策略，支持round_robin、weighted_random、least_request、consistent_hash
+	Balancer string
+	// 异常实例摘除配置
+	Outlier OutlierConfig
+	// static类型使用的服务实例列表，key为服务名
+	Static map[string][]*registry.ServiceInstance
+	// file类型使用的文件路径，文件内容为json格式的map[服务名][]实例
+	File string
+	// file类型检查文件变化的间隔
+	FileReloadInterval time.Duration
+}
+
+// OutlierConfig 是异常实例摘除的配置，实例连续失败达到阈值后在一段时间内不会被选中
+type OutlierConfig struct {
+	// 连续失败次数阈值，0表示不摘除
+	ConsecutiveErrors int
+	// 摘除时间
+	EjectionTime time.Duration
+	// 最多摘除的实例比例（0-100）
+	MaxEjectionPercent int
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Type:     "zookeeper",
+		Balancer: RoundRobin,
+		Outlier: OutlierConfig{
+			ConsecutiveErrors:  5,
+			EjectionTime:       30 * time.Second,
+			MaxEjectionPercent: 50,
+		},
+		FileReloadInterval: 5 * time.Second,
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xgrpc
+
+import (
+	"context"
+
+	"github.com/NetEase-Media/easy-ngo/discovery"
+	"github.com/NetEase-Media/easy-ngo/registry"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme 是服务发现使用的target协议，例如grpc.Dial("ngo:///user-service")
+const Scheme = "ngo"
+
+type attrKey string
+
+const (
+	// WeightKey 是地址Attributes中保存实例权重的key
+	WeightKey attrKey = "weight"
+	// InstanceKey 是地址Attributes中保存registry.ServiceInstance的key
+	InstanceKey attrKey = "instance"
+)
+
+// Register 使用discovery注册全局的gRPC resolver
+func Register(d discovery.Discovery) {
+	resolver.Register(NewBuilder(d))
+}
+
+// NewBuilder 返回gRPC的resolver.Builder，可以通过grpc.WithResolvers单独使用
+func NewBuilder(d discovery.Discovery) resolver.Builder {
+	return &builder{discovery: d}
+}
+
+type builder struct {
+	discovery discovery.Discovery
+}
+
+func (b *builder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &grpcResolver{cancel: cancel}
+	err := b.discovery.Watch(ctx, target.Endpoint(), func(instances []*registry.ServiceInstance) {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := cc.UpdateState(resolver.State{Addresses: toAddresses(instances)}); err != nil {
+			cc.ReportError(err)
+		}
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (b *builder) Scheme() string {
+	return Scheme
+}
+
+func toAddresses(instances []*registry.ServiceInstance) []resolver.Address {
+	addrs := make([]resolver.Address, 0, len(instances))
+	for _, ins := range instances {
+		addrs = append(addrs, resolver.Address{
+			Addr:       ins.Endpoint(),
+			ServerName: ins.Name,
+			Attributes: attributes.New(WeightKey, ins.Weight).WithValue(InstanceKey, ins),
+		})
+	}
+	return addrs
+}
+
+type grpcResolver struct {
+	cancel context.CancelFunc
+}
+
+// ResolveNow 实例列表由discovery主动推送，这里不需要处理
+func (r *grpcResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *grpcResolver) Close() {
+	r.cancel()
+}
@@ -0,0 +1,87 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/registry"
+)
+
+var (
+	ErrNoInstance = errors.New("no available instance")
+	ErrNoResolver = errors.New("discovery resolver not found")
+)
+
+// Discovery 是服务发现的抽象，第三方实现必须实现该接口
+type Discovery interface {
+	// Watch 监听服务的实例列表，返回前必须使用当前的实例列表调用一次listener，
+	// 之后实例列表每次变化都会调用listener，ctx取消后停止监听
+	Watch(ctx context.Context, service string, listener func([]*registry.ServiceInstance)) error
+}
+
+// Instance 是负载均衡使用的服务实例，记录了正在处理的请求数和异常状态
+type Instance struct {
+	*registry.ServiceInstance
+
+	svc      *service
+	inflight int64
+	// 摘除截止时间，UnixNano
+	ejectedUntil int64
+
+	mu                sync.Mutex
+	consecutiveErrors int
+}
+
+// Inflight 返回实例上正在处理的请求数
+func (ins *Instance) Inflight() int64 {
+	return atomic.LoadInt64(&ins.inflight)
+}
+
+// Ejected 返回实例是否因为连续错误被临时摘除
+func (ins *Instance) Ejected() bool {
+	return time.Now().UnixNano() < atomic.LoadInt64(&ins.ejectedUntil)
+}
+
+// Done 请求结束后必须调用，err不为空时记为一次失败，用于least_request统计和异常实例摘除
+func (ins *Instance) Done(err error) {
+	atomic.AddInt64(&ins.inflight, -1)
+	if ins.svc != nil {
+		ins.svc.report(ins, err)
+	}
+}
+
+// Release 请求被取消时代替Done调用，只减少正在处理的请求数，不影响异常实例摘除的统计
+func (ins *Instance) Release() {
+	atomic.AddInt64(&ins.inflight, -1)
+}
+
+func (ins *Instance) acquire() {
+	atomic.AddInt64(&ins.inflight, 1)
+}
+
+// Balancer 从实例列表中选择一个实例，实现需要跳过Ejected的实例，可以并发调用
+type Balancer interface {
+	// Update 在实例列表变化时调用
+	Update(instances []*Instance)
+	// Pick 选择一个实例，key用于一致性哈希等需要亲和性的策略
+	Pick(key string) (*Instance, error)
+}
+
+type BalancerBuilder func() Balancer
@@ -0,0 +1,109 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/registry"
+	"github.com/NetEase-Media/easy-ngo/xlog"
+)
+
+// File 从json文件中读取实例列表，文件格式为map[服务名][]实例，文件变化后自动通知
+type File struct {
+	path     string
+	interval time.Duration
+
+	mu       sync.Mutex
+	modTime  time.Time
+	services map[string][]*registry.ServiceInstance
+}
+
+func NewFile(path string, interval time.Duration) (*File, error) {
+	f := &File{
+		path:     path,
+		interval: interval,
+	}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// load 文件有变化时重新读取
+func (f *File) load() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if info.ModTime().Equal(f.modTime) {
+		return nil
+	}
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	services := make(map[string][]*registry.ServiceInstance)
+	if err := json.Unmarshal(b, &services); err != nil {
+		return err
+	}
+	f.services = services
+	f.modTime = info.ModTime()
+	return nil
+}
+
+func (f *File) get(service string) []*registry.ServiceInstance {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.services[service]
+}
+
+func (f *File) Watch(ctx context.Context, service string, listener func([]*registry.ServiceInstance)) error {
+	last := f.get(service)
+	listener(last)
+	if f.interval <= 0 {
+		return nil
+	}
+	go func() {
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := f.load(); err != nil {
+					xlog.Errorf("reload discovery file %s failed: %v", f.path, err)
+					continue
+				}
+				// 多个服务共用一个文件，只在当前服务变化时通知
+				current := f.get(service)
+				if reflect.DeepEqual(last, current) {
+					continue
+				}
+				last = current
+				listener(current)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,191 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/registry"
+	"github.com/NetEase-Media/easy-ngo/xlog"
+)
+
+// Resolver 维护每个服务的实时实例列表，并按照负载均衡策略选择实例
+type Resolver struct {
+	discovery Discovery
+	config    *Config
+
+	mu       sync.Mutex
+	services map[string]*service
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+func NewResolver(d Discovery, config *Config) (*Resolver, error) {
+	// 提前检查负载均衡策略是否存在
+	if _, err := NewBalancer(config.Balancer); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Resolver{
+		discovery: d,
+		config:    config,
+		services:  make(map[string]*service),
+		ctx:       ctx,
+		cancel:    cancel,
+	}, nil
+}
+
+// Pick 为服务选择一个实例，第一次调用时开始监听该服务。返回的实例使用完毕后必须调用Done
+func (r *Resolver) Pick(name, key string) (*Instance, error) {
+	svc, err := r.service(name)
+	if err != nil {
+		return nil, err
+	}
+	ins, err := svc.balancer.Pick(key)
+	if err != nil {
+		return nil, err
+	}
+	ins.acquire()
+	return ins, nil
+}
+
+// Instances 返回服务当前的实例列表
+func (r *Resolver) Instances(name string) ([]*Instance, error) {
+	svc, err := r.service(name)
+	if err != nil {
+		return nil, err
+	}
+	svc.mu.RLock()
+	defer svc.mu.RUnlock()
+	return append([]*Instance(nil), svc.instances...), nil
+}
+
+// service 返回服务，第一次调用时开始监听。Watch可能需要访问注册中心，在锁外执行，
+// 同一个服务的并发调用等待同一次Watch完成，不会阻塞其他服务
+func (r *Resolver) service(name string) (*service, error) {
+	r.mu.Lock()
+	if svc, ok := r.services[name]; ok {
+		r.mu.Unlock()
+		<-svc.ready
+		if svc.err != nil {
+			return nil, svc.err
+		}
+		return svc, nil
+	}
+	balancer, err := NewBalancer(r.config.Balancer)
+	if err != nil {
+		r.mu.Unlock()
+		return nil, err
+	}
+	svc := &service{
+		name:     name,
+		balancer: balancer,
+		outlier:  &r.config.Outlier,
+		ready:    make(chan struct{}),
+	}
+	r.services[name] = svc
+	r.mu.Unlock()
+
+	if err := r.discovery.Watch(r.ctx, name, svc.update); err != nil {
+		// 监听失败时删除服务，下次调用重新监听
+		r.mu.Lock()
+		delete(r.services, name)
+		r.mu.Unlock()
+		svc.err = err
+		close(svc.ready)
+		return nil, err
+	}
+	close(svc.ready)
+	return svc, nil
+}
+
+// Close 停止监听所有服务
+func (r *Resolver) Close() {
+	r.cancel()
+}
+
+type service struct {
+	name     string
+	balancer Balancer
+	outlier  *OutlierConfig
+
+	// Watch完成后关闭，err为Watch返回的错误
+	ready chan struct{}
+	err   error
+
+	mu        sync.RWMutex
+	instances []*Instance
+}
+
+// update 替换实例列表，已存在的实例保留统计数据
+func (s *service) update(list []*registry.ServiceInstance) {
+	s.mu.Lock()
+	old := make(map[string]*Instance, len(s.instances))
+	for _, ins := range s.instances {
+		old[ins.Endpoint()] = ins
+	}
+	instances := make([]*Instance, 0, len(list))
+	for _, si := range list {
+		// 实例数据没有变化时保留原来的对象，继续使用请求数和摘除状态
+		ins, ok := old[si.Endpoint()]
+		if !ok || !reflect.DeepEqual(ins.ServiceInstance, si) {
+			ins = &Instance{ServiceInstance: si, svc: s}
+		}
+		instances = append(instances, ins)
+	}
+	s.instances = instances
+	s.mu.Unlock()
+	s.balancer.Update(instances)
+	xlog.Infof("service %s instances updated, count: %d", s.name, len(instances))
+}
+
+// report 记录请求结果，连续失败达到阈值时摘除实例
+func (s *service) report(ins *Instance, err error) {
+	if s.outlier.ConsecutiveErrors <= 0 {
+		return
+	}
+	ins.mu.Lock()
+	defer ins.mu.Unlock()
+	if err == nil {
+		ins.consecutiveErrors = 0
+		return
+	}
+	ins.consecutiveErrors++
+	if ins.consecutiveErrors < s.outlier.ConsecutiveErrors || ins.Ejected() {
+		return
+	}
+	if !s.canEject() {
+		return
+	}
+	ins.consecutiveErrors = 0
+	atomic.StoreInt64(&ins.ejectedUntil, time.Now().Add(s.outlier.EjectionTime).UnixNano())
+	xlog.Warnf("instance %s of service %s ejected for %s", ins.Endpoint(), s.name, s.outlier.EjectionTime)
+}
+
+func (s *service) canEject() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ejected := 0
+	for _, ins := range s.instances {
+		if ins.Ejected() {
+			ejected++
+		}
+	}
+	return (ejected+1)*100 <= len(s.instances)*s.outlier.MaxEjectionPercent
+}
@@ -0,0 +1,172 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/registry"
+	"github.com/NetEase-Media/easy-ngo/xlog"
+	"github.com/NetEase-Media/easy-ngo/xlog/contrib/xstdout"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	xlog.WithVendor(xstdout.New())
+}
+
+func testServices() map[string][]*registry.ServiceInstance {
+	return map[string][]*registry.ServiceInstance{
+		"user-service": {
+			{Name: "user-service", Address: "10.0.0.1", Port: 8080},
+			{Name: "user-service", Address: "10.0.0.2", Port: 8080},
+		},
+	}
+}
+
+func TestResolverPick(t *testing.T) {
+	r, err := NewResolver(NewStatic(testServices()), DefaultConfig())
+	assert.Nil(t, err)
+	defer r.Close()
+
+	ins, err := r.Pick("user-service", "")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), ins.Inflight())
+	ins.Done(nil)
+	assert.Equal(t, int64(0), ins.Inflight())
+
+	_, err = r.Pick("order-service", "")
+	assert.Equal(t, ErrNoInstance, err)
+
+	_, err = NewResolver(NewStatic(nil), &Config{Balancer: "not_exists"})
+	assert.NotNil(t, err)
+}
+
+// blockingDiscovery 监听指定服务时阻塞，模拟无法访问的注册中心
+type blockingDiscovery struct {
+	Discovery
+	service string
+	block   chan struct{}
+}
+
+func (d *blockingDiscovery) Watch(ctx context.Context, service string, listener func([]*registry.ServiceInstance)) error {
+	if service == d.service {
+		<-d.block
+		return errors.New("watch failed")
+	}
+	return d.Discovery.Watch(ctx, service, listener)
+}
+
+func TestResolverSlowWatch(t *testing.T) {
+	d := &blockingDiscovery{Discovery: NewStatic(testServices()), service: "slow-service", block: make(chan struct{})}
+	r, err := NewResolver(d, DefaultConfig())
+	assert.Nil(t, err)
+	defer r.Close()
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := r.Pick("slow-service", "")
+			errs <- err
+		}()
+	}
+
+	// 其他服务不受阻塞的Watch影响
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ins, err := r.Pick("user-service", "")
+		assert.Nil(t, err)
+		ins.Done(nil)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("pick blocked by another service")
+	}
+
+	// 等待同一次Watch的调用都收到错误，之后可以重新监听
+	close(d.block)
+	for i := 0; i < 2; i++ {
+		assert.EqualError(t, <-errs, "watch failed")
+	}
+	_, err = r.Pick("slow-service", "")
+	assert.EqualError(t, err, "watch failed")
+}
+
+func TestResolverOutlierEjection(t *testing.T) {
+	c := DefaultConfig()
+	c.Outlier = OutlierConfig{ConsecutiveErrors: 2, EjectionTime: time.Minute, MaxEjectionPercent: 50}
+	r, _ := NewResolver(NewStatic(testServices()), c)
+	defer r.Close()
+
+	instances, err := r.Instances("user-service")
+	assert.Nil(t, err)
+	bad, good := instances[0], instances[1]
+	failed := errors.New("failed")
+	bad.acquire()
+	bad.Done(failed)
+	assert.False(t, bad.Ejected())
+	// 被取消的请求不会重置连续错误数
+	bad.acquire()
+	bad.Release()
+	assert.Equal(t, int64(0), bad.Inflight())
+	bad.acquire()
+	bad.Done(failed)
+	assert.True(t, bad.Ejected())
+	for i := 0; i < 10; i++ {
+		ins, _ := r.Pick("user-service", "")
+		assert.Equal(t, good, ins)
+		ins.Done(nil)
+	}
+
+	// 超过最大摘除比例时不再摘除
+	for i := 0; i < 2; i++ {
+		good.acquire()
+		good.Done(failed)
+	}
+	assert.False(t, good.Ejected())
+}
+
+func TestFileDiscovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.json")
+	write := func(services map[string][]*registry.ServiceInstance, modTime time.Time) {
+		b, _ := json.Marshal(services)
+		assert.Nil(t, os.WriteFile(path, b, 0600))
+		assert.Nil(t, os.Chtimes(path, modTime, modTime))
+	}
+	services := testServices()
+	write(services, time.Now())
+
+	f, err := NewFile(path, 10*time.Millisecond)
+	assert.Nil(t, err)
+	r, _ := NewResolver(f, DefaultConfig())
+	defer r.Close()
+	instances, _ := r.Instances("user-service")
+	assert.Len(t, instances, 2)
+
+	services["user-service"] = services["user-service"][:1]
+	write(services, time.Now().Add(time.Minute))
+	assert.Eventually(t, func() bool {
+		instances, _ := r.Instances("user-service")
+		return len(instances) == 1
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,35 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+
+	"github.com/NetEase-Media/easy-ngo/registry"
+)
+
+// Static 使用固定的实例列表，一般用于测试或者没有注册中心的环境
+type Static struct {
+	services map[string][]*registry.ServiceInstance
+}
+
+func NewStatic(services map[string][]*registry.ServiceInstance) *Static {
+	return &Static{services: services}
+}
+
+func (s *Static) Watch(ctx context.Context, service string, listener func([]*registry.ServiceInstance)) error {
+	listener(s.services[service])
+	return nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/NetEase-Media/easy-ngo/server"
+)
+
+var registry Registry
+
+func Register(ctx context.Context, ins *ServiceInstance) error {
+	if registry == nil {
+		return errors.New("registry not found")
+	}
+	return registry.Register(ctx, ins)
+}
+
+func Deregister(ctx context.Context, ins *ServiceInstance) error {
+	if registry == nil {
+		return errors.New("registry not found")
+	}
+	return registry.Deregister(ctx, ins)
+}
+
+func WithVendor(r Registry) {
+	registry = r
+}
+
+func GetRegistry() Registry {
+	return registry
+}
+
+// Instances 按照配置为每个server地址生成一个服务实例
+func Instances(config *Config, endpoints []server.Endpoint) ([]*ServiceInstance, error) {
+	instances := make([]*ServiceInstance, 0, len(endpoints))
+	for _, e := range endpoints {
+		address := config.Address
+		if address == "" {
+			address = e.Host
+		}
+		if ip := net.ParseIP(address); address == "" || (ip != nil && ip.IsUnspecified()) {
+			local, err := LocalIP()
+			if err != nil {
+				return nil, err
+			}
+			address = local
+		}
+		instances = append(instances, &ServiceInstance{
+			Name:     config.Name,
+			Scheme:   e.Scheme,
+			Address:  address,
+			Port:     e.Port,
+			Weight:   config.Weight,
+			Metadata: config.Metadata,
+		})
+	}
+	return instances, nil
+}
+
+// LocalIP 返回本机第一个非回环的IPv4地址
+func LocalIP() (string, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() {
+			continue
+		}
+		if ip := ipNet.IP.To4(); ip != nil {
+			return ip.String(), nil
+		}
+	}
+	return "", errors.New("no available local ip address")
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/NetEase-Media/easy-ngo/server"
+	"github.com/stretchr/testify/assert"
+)
+
+type testRegistry struct {
+	instances map[string]*ServiceInstance
+}
+
+func (r *testRegistry) Register(ctx context.Context, ins *ServiceInstance) error {
+	r.instances[ins.Endpoint()] = ins
+	return nil
+}
+
+func (r *testRegistry) Deregister(ctx context.Context, ins *ServiceInstance) error {
+	delete(r.instances, ins.Endpoint())
+	return nil
+}
+
+func TestInstances(t *testing.T) {
+	c := DefaultConfig()
+	c.Name = "user-service"
+	c.Metadata = map[string]string{"version": "v1"}
+
+	ins, err := Instances(c, []server.Endpoint{
+		{Scheme: "http", Host: "10.1.1.1", Port: 8080},
+		{Scheme: "https", Host: "0.0.0.0", Port: 8443},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, ins, 2)
+	assert.Equal(t, "10.1.1.1:8080", ins[0].Endpoint())
+	assert.Equal(t, 100, ins[0].Weight)
+	assert.Equal(t, "v1", ins[0].Metadata["version"])
+	assert.Equal(t, "https", ins[1].Scheme)
+	assert.False(t, net.ParseIP(ins[1].Address).IsUnspecified())
+
+	c.Address = "user.example.com"
+	ins, err = Instances(c, []server.Endpoint{{Scheme: "http", Host: "0.0.0.0", Port: 8080}})
+	assert.Nil(t, err)
+	assert.Equal(t, "user.example.com:8080", ins[0].Endpoint())
+}
+
+func TestRegisterWithVendor(t *testing.T) {
+	ins := &ServiceInstance{Name: "user-service", Address: "10.1.1.1", Port: 8080}
+	WithVendor(nil)
+	assert.NotNil(t, Register(context.Background(), ins))
+
+	r := &testRegistry{instances: make(map[string]*ServiceInstance)}
+	WithVendor(r)
+	defer WithVendor(nil)
+	assert.Nil(t, Register(context.Background(), ins))
+	assert.Equal(t, ins, r.instances["10.1.1.1:8080"])
+	assert.Nil(t, Deregister(context.Background(), ins))
+	assert.Empty(t, r.instances)
+}
@@ -0,0 +1,37 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+type Config struct {
+	// 是否开启服务注册
+	Enabled bool
+	// 注册中心类型，目前支持zookeeper
+	Type string
+	// 服务名称，必须指定
+	Name string
+	// 注册的地址，为空时使用server监听的地址，监听所有网卡时自动获取本机IP
+	Address string
+	// 负载均衡权重
+	Weight int
+	// 自定义元数据
+	Metadata map[string]string
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Type:   "zookeeper",
+		Weight: 100,
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xzookeeper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+
+	"github.com/NetEase-Media/easy-ngo/clients/xzk"
+	"github.com/NetEase-Media/easy-ngo/registry"
+	"github.com/NetEase-Media/easy-ngo/xlog"
+	"github.com/go-zookeeper/zk"
+)
+
+type Config struct {
+	// 使用的zk客户端名称
+	ZkName string
+	// 服务注册的根路径，实例路径为{Root}/{服务名}/{host:port}
+	Root string
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		ZkName: "default",
+		Root:   "/easy-ngo/services",
+	}
+}
+
+// Registry 使用zookeeper的临时节点注册服务实例，session断开重连后由xzk自动恢复节点。
+// 同时实现了discovery.Discovery，通过监听子节点发现服务实例
+type Registry struct {
+	client *xzk.ZookeeperProxy
+	config *Config
+}
+
+func New(client *xzk.ZookeeperProxy, config *Config) *Registry {
+	return &Registry{
+		client: client,
+		config: config,
+	}
+}
+
+// ServicePath 返回服务下所有实例节点的父路径
+func ServicePath(root, name string) string {
+	return path.Join(root, name)
+}
+
+func (r *Registry) instancePath(ins *registry.ServiceInstance) string {
+	return path.Join(ServicePath(r.config.Root, ins.Name), ins.Endpoint())
+}
+
+func (r *Registry) Register(ctx context.Context, ins *registry.ServiceInstance) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ins.Name == "" {
+		return errors.New("service name can not be empty")
+	}
+	if err := r.ensurePath(ServicePath(r.config.Root, ins.Name)); err != nil {
+		return err
+	}
+	data, err := json.Marshal(ins)
+	if err != nil {
+		return err
+	}
+	p := r.instancePath(ins)
+	_, err = r.client.CreateNode(p, xzk.EPHEMERAL, string(data))
+	if errors.Is(err, zk.ErrNodeExists) {
+		// 可能是上一个session残留的节点，删除后重新创建
+		if err = r.client.Delete(p); err != nil && !errors.Is(err, zk.ErrNoNode) {
+			return err
+		}
+		_, err = r.client.CreateNode(p, xzk.EPHEMERAL, string(data))
+	}
+	return err
+}
+
+func (r *Registry) Deregister(ctx context.Context, ins *registry.ServiceInstance) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := r.client.Delete(r.instancePath(ins))
+	if errors.Is(err, zk.ErrNoNode) {
+		return nil
+	}
+	return err
+}
+
+// ensurePath 逐级创建持久化的父节点
+func (r *Registry) ensurePath(p string) error {
+	current := ""
+	for _, node := range strings.Split(strings.Trim(p, "/"), "/") {
+		current = current + "/" + node
+		exist, err := r.client.Exist(current)
+		if err != nil {
+			return err
+		}
+		if exist {
+			continue
+		}
+		if _, err := r.client.CreateNode(current, xzk.PERSISTENT, ""); err != nil && !errors.Is(err, zk.ErrNodeExists) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Watch 监听服务下的实例节点，子节点增加或删除时重新读取实例列表。
+// 服务节点不存在时不会创建，等待节点被注册方创建后再开始监听
+func (r *Registry) Watch(ctx context.Context, service string, listener func([]*registry.ServiceInstance)) error {
+	p := ServicePath(r.config.Root, service)
+	instances, err := r.instances(p)
+	if err != nil && !errors.Is(err, zk.ErrNoNode) {
+		return err
+	}
+	listener(instances)
+	go r.watch(ctx, service, p, listener)
+	return nil
+}
+
+// watch 服务节点存在时监听子节点，节点不存在或被删除时等待节点创建，ctx取消或zk客户端关闭后退出
+func (r *Registry) watch(ctx context.Context, service, p string, listener func([]*registry.ServiceInstance)) {
+	for {
+		exist, _, eventCh, err := r.client.Conn.ExistsW(p)
+		if err != nil {
+			xlog.Errorf("watch service %s failed: %v", service, err)
+			return
+		}
+		if !exist {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-eventCh:
+				if ev.Err != nil {
+					xlog.Errorf("watch service %s failed: %v", service, ev.Err)
+					return
+				}
+			}
+			r.notify(service, p, listener)
+			continue
+		}
+
+		// ctx取消后WatchChildrenContext停止监听并关闭respChan，节点被删除时同样关闭
+		var lastErr error
+		done := make(chan struct{})
+		r.client.WatchChildrenContext(ctx, p, func(respChan <-chan *xzk.WatchChildrenResponse) {
+			defer close(done)
+			for resp := range respChan {
+				if ctx.Err() != nil {
+					return
+				}
+				lastErr = resp.Err
+				if errors.Is(resp.Err, zk.ErrNoNode) {
+					listener([]*registry.ServiceInstance{})
+					continue
+				}
+				if resp.Err != nil {
+					xlog.Errorf("watch service %s failed: %v", service, resp.Err)
+					continue
+				}
+				r.notify(service, p, listener)
+			}
+		})
+		<-done
+		if ctx.Err() != nil || (lastErr != nil && !errors.Is(lastErr, zk.ErrNoNode)) {
+			return
+		}
+	}
+}
+
+// notify 读取实例列表并通知listener，服务节点不存在时通知空列表
+func (r *Registry) notify(service, p string, listener func([]*registry.ServiceInstance)) {
+	instances, err := r.instances(p)
+	if err != nil && !errors.Is(err, zk.ErrNoNode) {
+		xlog.Errorf("get instances of service %s failed: %v", service, err)
+		return
+	}
+	listener(instances)
+}
+
+func (r *Registry) instances(p string) ([]*registry.ServiceInstance, error) {
+	children, err := r.client.GetChildren(p)
+	if err != nil {
+		return []*registry.ServiceInstance{}, err
+	}
+	instances := make([]*registry.ServiceInstance, 0, len(children))
+	for _, child := range children {
+		data, err := r.client.GetData(child)
+		if errors.Is(err, zk.ErrNoNode) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ins := &registry.ServiceInstance{}
+		if err := json.Unmarshal([]byte(data), ins); err != nil {
+			xlog.Warnf("invalid service instance %s: %v", child, err)
+			continue
+		}
+		instances = append(instances, ins)
+	}
+	return instances, nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"net"
+	"strconv"
+)
+
+// ServiceInstance 是注册到注册中心的一个服务实例
+type ServiceInstance struct {
+	// 服务名称
+	Name string `json:"name"`
+	// 协议，例如http、https
+	Scheme string `json:"scheme"`
+	// 实例的ip或者域名
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	// 负载均衡权重
+	Weight int `json:"weight"`
+	// 自定义元数据，例如版本、机房
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Endpoint 返回host:port格式的地址，同一服务下唯一
+func (ins *ServiceInstance) Endpoint() string {
+	return net.JoinHostPort(ins.Address, strconv.Itoa(ins.Port))
+}
+
+// Registry 是注册中心的抽象，第三方实现必须实现该接口
+type Registry interface {
+	// Register 注册实例，重复注册会覆盖旧的数据
+	Register(ctx context.Context, ins *ServiceInstance) error
+	// Deregister 注销实例，实例不存在时不返回错误
+	Deregister(ctx context.Context, ins *ServiceInstance) error
+}
@@ -0,0 +1,64 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// HttpConfig 是基于net/http的server共用的配置，各个server contrib的配置中内嵌该结构体
+type HttpConfig struct {
+	// 读取整个请求（包括body）的超时时间，0表示不限制
+	ReadTimeout time.Duration
+
+	// 写回复的超时时间，0表示不限制
+	WriteTimeout time.Duration
+
+	// keep-alive连接的最大空闲时间，0表示使用ReadTimeout
+	IdleTimeout time.Duration
+
+	// 请求header的最大字节数，0表示使用http.DefaultMaxHeaderBytes
+	MaxHeaderBytes int
+
+	// TLS配置
+	TLS TLSConfig
+}
+
+// NewHttpServer 使用通用配置构造http.Server，certs不为空时开启TLS
+func NewHttpServer(addr string, handler http.Handler, config *HttpConfig, certs *CertReloader) *http.Server {
+	s := &http.Server{
+		Addr:           addr,
+		Handler:        handler,
+		ReadTimeout:    config.ReadTimeout,
+		WriteTimeout:   config.WriteTimeout,
+		IdleTimeout:    config.IdleTimeout,
+		MaxHeaderBytes: config.MaxHeaderBytes,
+	}
+	if certs != nil {
+		s.TLSConfig = certs.TLSConfig()
+	}
+	return s
+}
+
+// ServeHttp 在listener上启动服务，如果http.Server配置了TLS则使用ServeTLS
+func ServeHttp(s *http.Server, listener net.Listener) error {
+	if s.TLSConfig != nil {
+		// 证书由TLSConfig.GetCertificate提供，这里不需要传入文件
+		return s.ServeTLS(listener, "", "")
+	}
+	return s.Serve(listener)
+}
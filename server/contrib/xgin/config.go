@@ -14,7 +14,10 @@
 
 package xgin
 
-import "github.com/NetEase-Media/easy-ngo/xmetrics"
+import (
+	"github.com/NetEase-Media/easy-ngo/server"
+	"github.com/NetEase-Media/easy-ngo/xmetrics"
+)
 
 type MODE string
 
@@ -31,6 +34,15 @@ type Config struct {
 	EnabledTracer  bool
 	Mode           MODE
 	Metrics        Metrics
+
+	// 是否在同一端口上支持h2c（明文HTTP/2），开启TLS时不生效
+	EnabledH2C bool
+
+	// WebSocket连接配置
+	WebSocket WebSocketConfig
+
+	// 超时、header大小和TLS等通用配置
+	server.HttpConfig `mapstructure:",squash"`
 }
 
 type Metrics struct {
@@ -48,5 +60,6 @@ func DefaultConfig() *Config {
 		EnabledMetrics: false,
 		EnabledTracer:  false,
 		Mode:           DEBUG,
+		WebSocket:      defaultWebSocketConfig(),
 	}
 }
@@ -0,0 +1,33 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xgin
+
+import (
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/server"
+	"github.com/gin-gonic/gin"
+)
+
+// SSE 在gin handler中建立Server-Sent Events流，heartbeat大于0时定时发送心跳。
+// 客户端断开后stream的Context会被取消，Send返回错误
+func SSE(c *gin.Context, heartbeat time.Duration, fn func(stream *server.SSEStream) error) error {
+	return server.ServeSSE(c.Writer, c.Request, heartbeat, fn)
+}
+
+// JSONLines 在gin handler中以JSON Lines格式分块返回数据
+func JSONLines(c *gin.Context, fn func(stream *server.JSONLinesStream) error) error {
+	return server.ServeJSONLines(c.Writer, c.Request, fn)
+}
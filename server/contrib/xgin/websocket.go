@@ -0,0 +1,402 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xgin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/server"
+	"github.com/NetEase-Media/easy-ngo/xlog"
+	"github.com/NetEase-Media/easy-ngo/xmetrics"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket消息类型，与RFC 6455中定义的一致
+const (
+	TextMessage   = websocket.TextMessage
+	BinaryMessage = websocket.BinaryMessage
+)
+
+var (
+	ErrWebSocketClosed    = errors.New("websocket connection closed")
+	ErrWebSocketQueueFull = errors.New("websocket send queue is full")
+)
+
+var (
+	metricWebSocketConnections = "websocket_connections"
+)
+
+type WebSocketConfig struct {
+	// 发送ping的间隔
+	PingInterval time.Duration
+
+	// 等待pong的超时时间，超时未收到任何消息则关闭连接，必须大于PingInterval
+	PongTimeout time.Duration
+
+	// 单条消息的写超时时间
+	WriteTimeout time.Duration
+
+	// 单条消息的最大字节数，0表示不限制
+	MaxMessageSize int64
+
+	// 每个连接的发送队列长度，队列满时Send返回ErrWebSocketQueueFull
+	SendQueueSize int
+
+	// 允许跨域连接的Origin列表，"*"表示允许所有，为空时只允许同源
+	AllowedOrigins []string
+}
+
+func defaultWebSocketConfig() WebSocketConfig {
+	return WebSocketConfig{
+		PingInterval:  30 * time.Second,
+		PongTimeout:   60 * time.Second,
+		WriteTimeout:  10 * time.Second,
+		SendQueueSize: 256,
+	}
+}
+
+// withDefaults 返回填充了默认值的配置副本，避免非法的间隔导致time.NewTicker panic
+func (c *WebSocketConfig) withDefaults() *WebSocketConfig {
+	d := defaultWebSocketConfig()
+	config := *c
+	if config.PingInterval <= 0 {
+		config.PingInterval = d.PingInterval
+	}
+	if config.PongTimeout <= config.PingInterval {
+		config.PongTimeout = 2 * config.PingInterval
+	}
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = d.WriteTimeout
+	}
+	if config.SendQueueSize <= 0 {
+		config.SendQueueSize = d.SendQueueSize
+	}
+	return &config
+}
+
+// WebSocketHandler 处理一个已经建立的WebSocket连接，返回后连接会被关闭
+type WebSocketHandler func(c *gin.Context, conn *WebSocketConn)
+
+type wsMessage struct {
+	messageType int
+	data        []byte
+}
+
+// WebSocketConn 是一个WebSocket连接。读取消息直接使用ReadMessage，
+// 写消息必须通过Send系列方法，由后台协程统一写出并负责ping保活
+type WebSocketConn struct {
+	*websocket.Conn
+	hub    *WebSocketHub
+	path   string
+	send   chan wsMessage
+	done   chan struct{}
+	once   sync.Once
+	mu     sync.Mutex
+	groups map[string]struct{}
+}
+
+// Send 将消息放入发送队列，不会阻塞
+func (conn *WebSocketConn) Send(messageType int, data []byte) error {
+	select {
+	case <-conn.done:
+		return ErrWebSocketClosed
+	default:
+	}
+	select {
+	case conn.send <- wsMessage{messageType: messageType, data: data}:
+		return nil
+	case <-conn.done:
+		return ErrWebSocketClosed
+	default:
+		return ErrWebSocketQueueFull
+	}
+}
+
+// SendText 发送文本消息
+func (conn *WebSocketConn) SendText(s string) error {
+	return conn.Send(TextMessage, []byte(s))
+}
+
+// SendJSON 将对象编码为json后以文本消息发送
+func (conn *WebSocketConn) SendJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return conn.Send(TextMessage, b)
+}
+
+// Join 将连接加入分组，用于分组广播
+func (conn *WebSocketConn) Join(group string) {
+	conn.mu.Lock()
+	conn.groups[group] = struct{}{}
+	conn.mu.Unlock()
+	conn.hub.join(conn, group)
+}
+
+// Leave 将连接移出分组
+func (conn *WebSocketConn) Leave(group string) {
+	conn.mu.Lock()
+	delete(conn.groups, group)
+	conn.mu.Unlock()
+	conn.hub.leave(conn, group)
+}
+
+// Done 在连接关闭时被关闭
+func (conn *WebSocketConn) Done() <-chan struct{} {
+	return conn.done
+}
+
+// Close 发送close帧并关闭连接，可以重复调用
+func (conn *WebSocketConn) Close() error {
+	return conn.closeWith(websocket.CloseNormalClosure, "")
+}
+
+func (conn *WebSocketConn) closeWith(code int, text string) error {
+	var err error
+	conn.once.Do(func() {
+		close(conn.done)
+		deadline := time.Now().Add(conn.hub.config.WriteTimeout)
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), deadline)
+		err = conn.Conn.Close()
+		conn.hub.remove(conn)
+	})
+	return err
+}
+
+// writeLoop 是唯一写连接的协程，负责发送队列中的消息和定时ping
+func (conn *WebSocketConn) writeLoop() {
+	ticker := time.NewTicker(conn.hub.config.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-conn.done:
+			return
+		case msg := <-conn.send:
+			_ = conn.SetWriteDeadline(time.Now().Add(conn.hub.config.WriteTimeout))
+			if err := conn.WriteMessage(msg.messageType, msg.data); err != nil {
+				xlog.Debugf("write websocket message failed: %v", err)
+				_ = conn.closeWith(websocket.CloseInternalServerErr, "")
+				return
+			}
+		case <-ticker.C:
+			deadline := time.Now().Add(conn.hub.config.WriteTimeout)
+			if err := conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				_ = conn.closeWith(websocket.CloseGoingAway, "")
+				return
+			}
+		}
+	}
+}
+
+// WebSocketHub 管理所有的WebSocket连接，支持分组广播，在server关闭时关闭所有连接
+type WebSocketHub struct {
+	config   *WebSocketConfig
+	upgrader websocket.Upgrader
+	gauge    xmetrics.Gauge
+
+	mu     sync.RWMutex
+	closed bool
+	conns  map[*WebSocketConn]struct{}
+	groups map[string]map[*WebSocketConn]struct{}
+}
+
+func newWebSocketHub(config *WebSocketConfig) *WebSocketHub {
+	h := &WebSocketHub{
+		config: config.withDefaults(),
+		conns:  make(map[*WebSocketConn]struct{}),
+		groups: make(map[string]map[*WebSocketConn]struct{}),
+	}
+	h.upgrader = websocket.Upgrader{
+		CheckOrigin: h.checkOrigin,
+	}
+	return h
+}
+
+func (h *WebSocketHub) initMetrics(provider xmetrics.Provider) {
+	h.gauge = provider.NewGauge(metricWebSocketConnections, server.LABELURL)
+}
+
+func (h *WebSocketHub) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, o := range h.config.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	// 默认只允许同源
+	return strings.EqualFold(strings.TrimPrefix(strings.TrimPrefix(origin, "https://"), "http://"), r.Host)
+}
+
+func (h *WebSocketHub) handle(path string, handler WebSocketHandler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ws, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			// Upgrade已经写回了错误的状态码
+			xlog.Debugf("upgrade websocket failed: %v", err)
+			return
+		}
+		conn := &WebSocketConn{
+			Conn:   ws,
+			hub:    h,
+			path:   path,
+			send:   make(chan wsMessage, h.config.SendQueueSize),
+			done:   make(chan struct{}),
+			groups: make(map[string]struct{}),
+		}
+		if !h.add(conn) {
+			_ = conn.closeWith(websocket.CloseGoingAway, "server is shutting down")
+			return
+		}
+		defer conn.Close()
+
+		if h.config.MaxMessageSize > 0 {
+			ws.SetReadLimit(h.config.MaxMessageSize)
+		}
+		_ = ws.SetReadDeadline(time.Now().Add(h.config.PongTimeout))
+		ws.SetPongHandler(func(string) error {
+			return ws.SetReadDeadline(time.Now().Add(h.config.PongTimeout))
+		})
+		go conn.writeLoop()
+		handler(c, conn)
+	}
+}
+
+func (h *WebSocketHub) add(conn *WebSocketConn) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return false
+	}
+	h.conns[conn] = struct{}{}
+	if h.gauge != nil {
+		h.gauge.With(server.LABELURL, conn.path).Inc()
+	}
+	return true
+}
+
+func (h *WebSocketHub) remove(conn *WebSocketConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.conns[conn]; !ok {
+		return
+	}
+	delete(h.conns, conn)
+	conn.mu.Lock()
+	for group := range conn.groups {
+		h.deleteFromGroup(conn, group)
+	}
+	conn.mu.Unlock()
+	if h.gauge != nil {
+		h.gauge.With(server.LABELURL, conn.path).Add(-1)
+	}
+}
+
+func (h *WebSocketHub) join(conn *WebSocketConn, group string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.conns[conn]; !ok {
+		return
+	}
+	if h.groups[group] == nil {
+		h.groups[group] = make(map[*WebSocketConn]struct{})
+	}
+	h.groups[group][conn] = struct{}{}
+}
+
+func (h *WebSocketHub) leave(conn *WebSocketConn, group string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.deleteFromGroup(conn, group)
+}
+
+func (h *WebSocketHub) deleteFromGroup(conn *WebSocketConn, group string) {
+	members := h.groups[group]
+	delete(members, conn)
+	if len(members) == 0 {
+		delete(h.groups, group)
+	}
+}
+
+// Broadcast 向分组内所有连接发送消息，返回发送成功的连接数。发送队列已满的连接会被跳过
+func (h *WebSocketHub) Broadcast(group string, messageType int, data []byte) int {
+	h.mu.RLock()
+	members := make([]*WebSocketConn, 0, len(h.groups[group]))
+	for conn := range h.groups[group] {
+		members = append(members, conn)
+	}
+	h.mu.RUnlock()
+	return h.sendAll(members, messageType, data)
+}
+
+// BroadcastAll 向所有连接发送消息，返回发送成功的连接数
+func (h *WebSocketHub) BroadcastAll(messageType int, data []byte) int {
+	h.mu.RLock()
+	all := make([]*WebSocketConn, 0, len(h.conns))
+	for conn := range h.conns {
+		all = append(all, conn)
+	}
+	h.mu.RUnlock()
+	return h.sendAll(all, messageType, data)
+}
+
+func (h *WebSocketHub) sendAll(conns []*WebSocketConn, messageType int, data []byte) int {
+	n := 0
+	for _, conn := range conns {
+		if err := conn.Send(messageType, data); err != nil {
+			xlog.Debugf("broadcast websocket message failed: %v", err)
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// Count 返回当前的连接数
+func (h *WebSocketHub) Count() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.conns)
+}
+
+// GroupCount 返回分组内的连接数
+func (h *WebSocketHub) GroupCount(group string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.groups[group])
+}
+
+// Close 拒绝新的连接，并向所有已建立的连接发送close帧后关闭
+func (h *WebSocketHub) Close() {
+	h.mu.Lock()
+	h.closed = true
+	all := make([]*WebSocketConn, 0, len(h.conns))
+	for conn := range h.conns {
+		all = append(all, conn)
+	}
+	h.mu.Unlock()
+	for _, conn := range all {
+		_ = conn.closeWith(websocket.CloseGoingAway, "server is shutting down")
+	}
+}
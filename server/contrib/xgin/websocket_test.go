@@ -0,0 +1,122 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xgin
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func dialTestWebSocket(t *testing.T, url string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), nil)
+	assert.Nil(t, err)
+	return conn
+}
+
+func TestWebSocketBroadcast(t *testing.T) {
+	s := newTestServer()
+	s.WebSocket("/ws", func(c *gin.Context, conn *WebSocketConn) {
+		conn.Join(c.Query("room"))
+		_ = conn.SendText("welcome")
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	ts := httptest.NewServer(s.Engine)
+	defer ts.Close()
+
+	c1 := dialTestWebSocket(t, ts.URL+"/ws?room=a")
+	defer c1.Close()
+	c2 := dialTestWebSocket(t, ts.URL+"/ws?room=b")
+	defer c2.Close()
+	for _, c := range []*websocket.Conn{c1, c2} {
+		_, msg, err := c.ReadMessage()
+		assert.Nil(t, err)
+		assert.Equal(t, "welcome", string(msg))
+	}
+	assert.Equal(t, 2, s.WebSocketHub().Count())
+	assert.Equal(t, 1, s.WebSocketHub().GroupCount("a"))
+
+	assert.Equal(t, 1, s.WebSocketHub().Broadcast("a", TextMessage, []byte("to a")))
+	_, msg, err := c1.ReadMessage()
+	assert.Nil(t, err)
+	assert.Equal(t, "to a", string(msg))
+
+	assert.Equal(t, 2, s.WebSocketHub().BroadcastAll(TextMessage, []byte("to all")))
+	_, msg, err = c2.ReadMessage()
+	assert.Nil(t, err)
+	assert.Equal(t, "to all", string(msg))
+
+	c2.Close()
+	assert.Eventually(t, func() bool {
+		return s.WebSocketHub().Count() == 1 && s.WebSocketHub().GroupCount("b") == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestWebSocketCloseOnShutdown(t *testing.T) {
+	s := newTestServer()
+	s.WebSocket("/ws", func(c *gin.Context, conn *WebSocketConn) {
+		<-conn.Done()
+	})
+	ts := httptest.NewServer(s.Engine)
+	defer ts.Close()
+
+	c := dialTestWebSocket(t, ts.URL+"/ws")
+	defer c.Close()
+	assert.Eventually(t, func() bool {
+		return s.WebSocketHub().Count() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	s.WebSocketHub().Close()
+	_, _, err := c.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway))
+	assert.Equal(t, 0, s.WebSocketHub().Count())
+
+	// 关闭后不再接受新连接
+	c2 := dialTestWebSocket(t, ts.URL+"/ws")
+	defer c2.Close()
+	_, _, err = c2.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway))
+}
+
+func TestWebSocketZeroConfig(t *testing.T) {
+	c := DefaultConfig()
+	c.Mode = TEST
+	c.WebSocket = WebSocketConfig{}
+	s := New(c)
+	assert.Equal(t, 30*time.Second, s.WebSocketHub().config.PingInterval)
+	assert.Equal(t, 60*time.Second, s.WebSocketHub().config.PongTimeout)
+
+	s.WebSocket("/ws", func(c *gin.Context, conn *WebSocketConn) {
+		assert.Nil(t, conn.Send(TextMessage, []byte("hello")))
+		<-conn.Done()
+	})
+	ts := httptest.NewServer(s.Engine)
+	defer ts.Close()
+
+	conn := dialTestWebSocket(t, ts.URL+"/ws")
+	defer conn.Close()
+	_, msg, err := conn.ReadMessage()
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(msg))
+}
@@ -24,6 +24,8 @@ import (
 	"github.com/NetEase-Media/easy-ngo/xlog"
 	"github.com/NetEase-Media/easy-ngo/xmetrics"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 type Server struct {
@@ -31,6 +33,8 @@ type Server struct {
 	*http.Server
 	config   *Config
 	listener net.Listener
+	certs    *server.CertReloader
+	hub      *WebSocketHub
 
 	metrics *server.HttpMetrics
 }
@@ -40,21 +44,24 @@ func New(config *Config) *Server {
 		config: config,
 		Engine: gin.New(),
 	}
+	s.hub = newWebSocketHub(&config.WebSocket)
 	if config.EnabledMetrics {
 		s.metrics = server.NewHttpMetrics(xmetrics.GetProvider(), config.Metrics.Bucket)
 	}
 	return s
 }
 
-func (server *Server) Serve() error {
-	server.Server = &http.Server{
-		Addr:    server.Address(),
-		Handler: server,
+func (s *Server) Serve() error {
+	var handler http.Handler = s
+	if s.config.EnabledH2C && s.certs == nil {
+		handler = h2c.NewHandler(s, &http2.Server{IdleTimeout: s.config.IdleTimeout})
 	}
-	err := server.Server.Serve(server.listener)
-	if err != nil && err == http.ErrServerClosed {
-		xlog.Panicf("close gin[%s]", err)
-		return nil
+	s.Server = server.NewHttpServer(s.Address(), handler, &s.config.HttpConfig, s.certs)
+	err := server.ServeHttp(s.Server, s.listener)
+	// 正常关闭时返回ErrServerClosed，其他错误（如证书或监听失败）需要返回给调用方
+	if err != nil && err != http.ErrServerClosed {
+		xlog.Errorf("gin serve error[%s]", err)
+		return err
 	}
 	return nil
 }
@@ -62,17 +69,35 @@ func (server *Server) Serve() error {
 func (s *Server) Init() error {
 	if s.config.EnabledMetrics {
 		s.metrics.Init()
+		s.hub.initMetrics(xmetrics.GetProvider())
 		s.Use(s.metricsMiddleware())
 	}
 	if s.config.EnabledTracer {
 		s.Use(s.traceMiddleware())
 	}
+	if s.config.TLS.Enabled {
+		certs, err := server.NewCertReloader(&s.config.TLS)
+		if err != nil {
+			xlog.Errorf("gin Init tls error![%s]", err)
+			return err
+		}
+		s.certs = certs
+	}
 	listener, err := net.Listen("tcp", s.Address())
 	if err != nil {
 		xlog.Panicf("gin Init error![%s]", err)
 		return err
 	}
 	s.listener = listener
+	scheme := "http"
+	if s.certs != nil {
+		scheme = "https"
+	}
+	server.AddEndpoint(server.Endpoint{
+		Scheme: scheme,
+		Host:   s.config.Host,
+		Port:   listener.Addr().(*net.TCPAddr).Port,
+	})
 	gin.SetMode(string(s.config.Mode))
 	return nil
 }
@@ -85,19 +110,39 @@ func (s *Server) metricsMiddleware() gin.HandlerFunc {
 		// }
 		start := time.Now()
 		c.Next()
-		s.metrics.Record(server.HttpLabels{
+		labels := server.HttpLabels{
 			Url:    c.Request.URL.Path,
 			Method: c.Request.Method,
 			Code:   c.Writer.Status(),
 			Domain: c.Request.Host,
-		}, start, time.Now())
+		}
+		if server.IsStreaming(c.Writer.Header().Get("Content-Type")) {
+			s.metrics.RecordStream(labels)
+			return
+		}
+		s.metrics.Record(labels, start, time.Now())
 	}
 }
 
 func (s *Server) Shutdown() error {
+	// 被劫持的WebSocket连接不受http.Server管理，需要单独关闭
+	s.hub.Close()
+	if s.certs != nil {
+		s.certs.Stop()
+	}
 	return s.Server.Close()
 }
 
+// WebSocket 注册一个WebSocket路由
+func (s *Server) WebSocket(relativePath string, handler WebSocketHandler) {
+	s.Engine.GET(relativePath, s.hub.handle(relativePath, handler))
+}
+
+// WebSocketHub 返回管理所有WebSocket连接的hub，可用于广播
+func (s *Server) WebSocketHub() *WebSocketHub {
+	return s.hub
+}
+
 func (server *Server) Healthz() bool {
 	return true
 }
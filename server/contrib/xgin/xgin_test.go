@@ -0,0 +1,100 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xgin
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/xlog"
+	"github.com/NetEase-Media/easy-ngo/xlog/contrib/xstdout"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+)
+
+func init() {
+	xlog.WithVendor(xstdout.New())
+}
+
+func newTestServer() *Server {
+	c := DefaultConfig()
+	c.Host = "127.0.0.1"
+	c.Port = 0
+	c.Mode = TEST
+	return New(c)
+}
+
+func TestServeH2C(t *testing.T) {
+	s := newTestServer()
+	s.config.EnabledH2C = true
+	assert.Nil(t, s.Init())
+	s.GET("/proto", func(c *gin.Context) {
+		c.String(http.StatusOK, c.Request.Proto)
+	})
+	go s.Serve()
+	defer s.Shutdown()
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	var resp *http.Response
+	var err error
+	assert.Eventually(t, func() bool {
+		resp, err = client.Get("http://" + s.listener.Addr().String() + "/proto")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	defer resp.Body.Close()
+	assert.Equal(t, 2, resp.ProtoMajor)
+}
+
+func TestServeReturnsError(t *testing.T) {
+	s := newTestServer()
+	assert.Nil(t, s.Init())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Serve()
+	}()
+	assert.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + s.listener.Addr().String() + "/")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	// 正常关闭时返回nil
+	assert.Nil(t, s.Shutdown())
+	select {
+	case err := <-errs:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("serve not returned")
+	}
+
+	// 监听失败时返回错误
+	s = newTestServer()
+	assert.Nil(t, s.Init())
+	assert.Nil(t, s.listener.Close())
+	assert.NotNil(t, s.Serve())
+}
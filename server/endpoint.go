@@ -0,0 +1,45 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import "sync"
+
+var (
+	endpointsMu sync.RWMutex
+	endpoints   []Endpoint
+)
+
+// Endpoint 是一个正在监听的server地址，服务注册时会按照Endpoint逐个注册
+type Endpoint struct {
+	// 协议，例如http、https、grpc
+	Scheme string
+	// 监听的host，0.0.0.0或者空表示所有网卡
+	Host string
+	Port int
+}
+
+// AddEndpoint 在server开始监听后调用，登记server的地址
+func AddEndpoint(e Endpoint) {
+	endpointsMu.Lock()
+	defer endpointsMu.Unlock()
+	endpoints = append(endpoints, e)
+}
+
+// Endpoints 返回当前进程中所有server的地址
+func Endpoints() []Endpoint {
+	endpointsMu.RLock()
+	defer endpointsMu.RUnlock()
+	return append([]Endpoint(nil), endpoints...)
+}
@@ -22,13 +22,15 @@ import (
 )
 
 var (
-	requestTotal    xmetrics.Counter
-	requestDuration xmetrics.Histogram
+	requestTotal       xmetrics.Counter
+	requestDuration    xmetrics.Histogram
+	streamRequestTotal xmetrics.Counter
 )
 
 var (
-	metricRequestTotal    = "request_total"
-	metricRequestDuration = "request_duration"
+	metricRequestTotal       = "request_total"
+	metricRequestDuration    = "request_duration"
+	metricStreamRequestTotal = "stream_request_total"
 
 	LABELDOMAIN = "domain"
 	LABELURL    = "url"
@@ -53,6 +55,12 @@ func (httpMetrics *HttpMetrics) Record(labels HttpLabels, start time.Time, end t
 	requestDuration.With(LABELDOMAIN, labels.Domain, LABELURL, labels.Url, LABELMETHOD, labels.Method, LABELCODE).Observe(float64((end.Nanosecond() - start.Nanosecond()) / 1e6))
 }
 
+// RecordStream 记录流式请求（SSE、JSON Lines等）。流式请求的耗时取决于连接保持的时间，
+// 因此只计数，不计入request_duration，避免影响普通请求的延迟分布
+func (httpMetrics *HttpMetrics) RecordStream(labels HttpLabels) {
+	streamRequestTotal.With(LABELDOMAIN, labels.Domain, LABELURL, labels.Url, LABELMETHOD, labels.Method, LABELCODE, strconv.Itoa(labels.Code)).Add(1)
+}
+
 func NewHttpMetrics(metrics xmetrics.Provider, bucket xmetrics.Bucket) *HttpMetrics {
 	return &HttpMetrics{
 		metrics: metrics,
@@ -62,6 +70,7 @@ func NewHttpMetrics(metrics xmetrics.Provider, bucket xmetrics.Bucket) *HttpMetr
 
 func (httpMetrics *HttpMetrics) Init() {
 	requestTotal = httpMetrics.metrics.NewCounter(metricRequestTotal, LABELDOMAIN, LABELURL, LABELMETHOD, LABELCODE)
+	streamRequestTotal = httpMetrics.metrics.NewCounter(metricStreamRequestTotal, LABELDOMAIN, LABELURL, LABELMETHOD, LABELCODE)
 	requestDuration = httpMetrics.metrics.NewHistogram(metricRequestDuration, httpMetrics.exponentialBuckets(httpMetrics.bucket.Start, httpMetrics.bucket.Factor, httpMetrics.bucket.Count), LABELDOMAIN, LABELURL, LABELMETHOD, LABELCODE)
 }
 
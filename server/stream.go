@@ -0,0 +1,271 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ContentTypeEventStream = "text/event-stream"
+	ContentTypeJSONLines   = "application/x-ndjson"
+)
+
+var (
+	ErrStreamingUnsupported = errors.New("response writer does not support streaming")
+)
+
+// IsStreaming 根据回复的Content-Type判断是否是流式请求
+func IsStreaming(contentType string) bool {
+	return strings.HasPrefix(contentType, ContentTypeEventStream) || strings.HasPrefix(contentType, ContentTypeJSONLines)
+}
+
+type stream struct {
+	mu      sync.Mutex
+	w       *bufio.Writer
+	flusher http.Flusher
+	ctx     context.Context
+}
+
+func newStream(w http.ResponseWriter, r *http.Request, contentType string) (*stream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, ErrStreamingUnsupported
+	}
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Cache-Control", "no-cache")
+	// 关闭nginx等代理的缓冲，保证数据实时到达客户端
+	h.Set("X-Accel-Buffering", "no")
+	h.Del("Content-Length")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &stream{
+		w:       bufio.NewWriter(w),
+		flusher: flusher,
+		ctx:     r.Context(),
+	}, nil
+}
+
+// write 写入一段数据并立即flush，客户端断开后返回context的错误
+func (s *stream) write(fn func(w *bufio.Writer) error) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := fn(s.w); err != nil {
+		return err
+	}
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// Context 返回请求的context，客户端断开时会被取消
+func (s *stream) Context() context.Context {
+	return s.ctx
+}
+
+// SSEEvent 是一个Server-Sent Event
+type SSEEvent struct {
+	// 事件id，客户端重连时会通过Last-Event-ID header带回
+	ID string
+
+	// 事件类型，为空时客户端按message处理
+	Event string
+
+	// 事件数据，string和[]byte原样发送，其他类型编码为json
+	Data interface{}
+
+	// 建议客户端的重连间隔，为0时不发送
+	Retry time.Duration
+}
+
+// SSEStream 用于向客户端发送Server-Sent Events，可以并发调用
+type SSEStream struct {
+	*stream
+	lastEventID string
+}
+
+// NewSSEStream 写入SSE的header并返回SSEStream
+func NewSSEStream(w http.ResponseWriter, r *http.Request) (*SSEStream, error) {
+	s, err := newStream(w, r, ContentTypeEventStream)
+	if err != nil {
+		return nil, err
+	}
+	return &SSEStream{
+		stream:      s,
+		lastEventID: r.Header.Get("Last-Event-ID"),
+	}, nil
+}
+
+// LastEventID 返回客户端重连时带回的最后一个事件id
+func (s *SSEStream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Send 发送一个事件
+func (s *SSEStream) Send(e SSEEvent) error {
+	data, err := encodeSSEData(e.Data)
+	if err != nil {
+		return err
+	}
+	return s.write(func(w *bufio.Writer) error {
+		if e.ID != "" {
+			writeSSEField(w, "id", e.ID)
+		}
+		if e.Event != "" {
+			writeSSEField(w, "event", e.Event)
+		}
+		if e.Retry > 0 {
+			writeSSEField(w, "retry", strconv.FormatInt(e.Retry.Milliseconds(), 10))
+		}
+		// 多行数据需要拆分成多个data字段
+		for _, line := range strings.Split(data, "\n") {
+			writeSSEField(w, "data", strings.TrimSuffix(line, "\r"))
+		}
+		return w.WriteByte('\n')
+	})
+}
+
+// SendData 发送只包含数据的事件
+func (s *SSEStream) SendData(data interface{}) error {
+	return s.Send(SSEEvent{Data: data})
+}
+
+// Retry 建议客户端断开后的重连间隔
+func (s *SSEStream) Retry(d time.Duration) error {
+	return s.write(func(w *bufio.Writer) error {
+		writeSSEField(w, "retry", strconv.FormatInt(d.Milliseconds(), 10))
+		return w.WriteByte('\n')
+	})
+}
+
+// Comment 发送注释，客户端会忽略，一般用于心跳
+func (s *SSEStream) Comment(text string) error {
+	return s.write(func(w *bufio.Writer) error {
+		_, _ = w.WriteString(": ")
+		_, _ = w.WriteString(text)
+		_, err := w.WriteString("\n\n")
+		return err
+	})
+}
+
+func writeSSEField(w *bufio.Writer, name, value string) {
+	_, _ = w.WriteString(name)
+	_, _ = w.WriteString(": ")
+	_, _ = w.WriteString(value)
+	_ = w.WriteByte('\n')
+}
+
+func encodeSSEData(data interface{}) (string, error) {
+	switch d := data.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return d, nil
+	case []byte:
+		return string(d), nil
+	default:
+		b, err := json.Marshal(d)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
+
+// ServeSSE 建立SSE流并调用fn，heartbeat大于0时会定时发送心跳注释防止连接被代理断开。
+// fn返回或者客户端断开后结束
+func ServeSSE(w http.ResponseWriter, r *http.Request, heartbeat time.Duration, fn func(s *SSEStream) error) error {
+	s, err := NewSSEStream(w, r)
+	if err != nil {
+		return err
+	}
+	if heartbeat > 0 {
+		var wg sync.WaitGroup
+		stop := make(chan struct{})
+		defer func() {
+			// 等待心跳协程退出，保证handler返回后不再写回复
+			close(stop)
+			wg.Wait()
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(heartbeat)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-s.ctx.Done():
+					return
+				case <-ticker.C:
+					if err := s.Comment("heartbeat"); err != nil {
+						return
+					}
+				}
+			}
+		}()
+	}
+	return fn(s)
+}
+
+// JSONLinesStream 以JSON Lines格式分块发送数据，每个对象占一行，可以并发调用
+type JSONLinesStream struct {
+	*stream
+}
+
+// NewJSONLinesStream 写入header并返回JSONLinesStream
+func NewJSONLinesStream(w http.ResponseWriter, r *http.Request) (*JSONLinesStream, error) {
+	s, err := newStream(w, r, ContentTypeJSONLines)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLinesStream{stream: s}, nil
+}
+
+// Send 将对象编码为一行json发送
+func (s *JSONLinesStream) Send(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.write(func(w *bufio.Writer) error {
+		_, _ = w.Write(b)
+		return w.WriteByte('\n')
+	})
+}
+
+// ServeJSONLines 建立JSON Lines流并调用fn
+func ServeJSONLines(w http.ResponseWriter, r *http.Request, fn func(s *JSONLinesStream) error) error {
+	s, err := NewJSONLinesStream(w, r)
+	if err != nil {
+		return err
+	}
+	return fn(s)
+}
@@ -0,0 +1,98 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type syncRecorder struct {
+	mu sync.Mutex
+	*httptest.ResponseRecorder
+}
+
+func (r *syncRecorder) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ResponseRecorder.Write(b)
+}
+
+func (r *syncRecorder) body() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Body.String()
+}
+
+func TestSSEStreamSend(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/events", nil)
+	r.Header.Set("Last-Event-ID", "41")
+
+	err := ServeSSE(w, r, 0, func(s *SSEStream) error {
+		assert.Equal(t, "41", s.LastEventID())
+		assert.Nil(t, s.Send(SSEEvent{ID: "42", Event: "progress", Data: "line1\nline2", Retry: 3 * time.Second}))
+		assert.Nil(t, s.SendData(map[string]int{"done": 1}))
+		return s.Comment("bye")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, ContentTypeEventStream, w.Header().Get("Content-Type"))
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+	assert.True(t, w.Flushed)
+	assert.Equal(t, "id: 42\nevent: progress\nretry: 3000\ndata: line1\ndata: line2\n\n"+
+		"data: {\"done\":1}\n\n"+
+		": bye\n\n", w.Body.String())
+}
+
+func TestSSEStreamHeartbeatAndDisconnect(t *testing.T) {
+	w := &syncRecorder{ResponseRecorder: httptest.NewRecorder()}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/events", nil).WithContext(ctx)
+
+	err := ServeSSE(w, r, 5*time.Millisecond, func(s *SSEStream) error {
+		assert.Eventually(t, func() bool {
+			return strings.Contains(w.body(), ": heartbeat\n\n")
+		}, time.Second, 5*time.Millisecond)
+		cancel()
+		<-s.Context().Done()
+		return s.SendData("lost")
+	})
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.NotContains(t, w.body(), "lost")
+}
+
+func TestJSONLinesStream(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/jobs", nil)
+	err := ServeJSONLines(w, r, func(s *JSONLinesStream) error {
+		for i := 1; i <= 2; i++ {
+			if err := s.Send(map[string]int{"step": i}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.True(t, IsStreaming(w.Header().Get("Content-Type")))
+	assert.Equal(t, "{\"step\":1}\n{\"step\":2}\n", w.Body.String())
+}
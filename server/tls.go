@@ -0,0 +1,269 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/xlog"
+)
+
+var (
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
+	clientAuthTypes = map[string]tls.ClientAuthType{
+		"none":               tls.NoClientCert,
+		"request":            tls.RequestClientCert,
+		"require":            tls.RequireAnyClientCert,
+		"verify_if_given":    tls.VerifyClientCertIfGiven,
+		"require_and_verify": tls.RequireAndVerifyClientCert,
+	}
+)
+
+// TLSConfig 是server的TLS配置，所有server contrib共用
+type TLSConfig struct {
+	// 是否开启TLS
+	Enabled bool
+
+	// 服务端证书和私钥文件（PEM格式）
+	CertFile string
+	KeyFile  string
+
+	// 客户端CA证书文件，配置后开启mTLS
+	ClientCAFile string
+
+	// 客户端证书校验方式，可选none、request、require、verify_if_given、require_and_verify。
+	// 配置了ClientCAFile时默认为require_and_verify
+	ClientAuth string
+
+	// 最低TLS版本，可选1.0、1.1、1.2、1.3，默认为1.2
+	MinVersion string
+
+	// 允许的加密套件，名称与crypto/tls中定义的一致，例如TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256。
+	// 为空时使用Go的默认值，TLS1.3的套件不可配置
+	CipherSuites []string
+
+	// 证书文件变化的检测间隔，为0时不自动重载
+	ReloadInterval time.Duration
+}
+
+// CertReloader 持有当前使用的证书，并在证书文件变化时自动重新加载，无需重启服务
+type CertReloader struct {
+	config *TLSConfig
+	base   *tls.Config
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// NewCertReloader 加载证书并构造CertReloader。如果配置了ReloadInterval会启动后台协程检测文件变化，
+// 使用完毕后需要调用Stop
+func NewCertReloader(config *TLSConfig) (*CertReloader, error) {
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, fmt.Errorf("tls cert file and key file can not be empty")
+	}
+	base, err := newBaseTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	r := &CertReloader{
+		config:   config,
+		base:     base,
+		modTimes: make(map[string]time.Time),
+		stop:     make(chan struct{}),
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	if config.ReloadInterval > 0 {
+		go r.watch(config.ReloadInterval)
+	}
+	return r, nil
+}
+
+func newBaseTLSConfig(config *TLSConfig) (*tls.Config, error) {
+	c := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// GetConfigForClient返回的配置会代替http.Server填充的配置，需要显式声明ALPN协议，否则无法协商h2
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	if config.MinVersion != "" {
+		v, ok := tlsVersions[config.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported tls version %s", config.MinVersion)
+		}
+		c.MinVersion = v
+	}
+	if len(config.CipherSuites) > 0 {
+		suites, err := parseCipherSuites(config.CipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		c.CipherSuites = suites
+	}
+	if config.ClientCAFile != "" {
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if config.ClientAuth != "" {
+		t, ok := clientAuthTypes[strings.ToLower(config.ClientAuth)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported tls client auth type %s", config.ClientAuth)
+		}
+		c.ClientAuth = t
+	}
+	return c, nil
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	all := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		all[s.Name] = s.ID
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		all[s.Name] = s.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := all[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported tls cipher suite %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// TLSConfig 返回用于http.Server的tls.Config，每次握手都会使用最新加载的证书
+func (r *CertReloader) TLSConfig() *tls.Config {
+	c := r.base.Clone()
+	c.GetCertificate = r.getCertificate
+	c.GetConfigForClient = r.getConfigForClient
+	return c
+}
+
+func (r *CertReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// getConfigForClient 保证mTLS使用最新加载的客户端CA
+func (r *CertReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	if r.config.ClientCAFile == "" {
+		return nil, nil
+	}
+	c := r.base.Clone()
+	c.GetCertificate = r.getCertificate
+	r.mu.RLock()
+	c.ClientCAs = r.clientCAs
+	r.mu.RUnlock()
+	return c, nil
+}
+
+// Reload 重新读取证书文件，读取失败时继续使用旧证书
+func (r *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load tls key pair failed: %w", err)
+	}
+	var pool *x509.CertPool
+	if r.config.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("read tls client ca failed: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no valid certificate found in %s", r.config.ClientCAFile)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.clientCAs = pool
+	for _, f := range r.files() {
+		if info, err := os.Stat(f); err == nil {
+			r.modTimes[f] = info.ModTime()
+		}
+	}
+	return nil
+}
+
+func (r *CertReloader) files() []string {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if r.config.ClientCAFile != "" {
+		files = append(files, r.config.ClientCAFile)
+	}
+	return files
+}
+
+// changed 判断证书文件在上次成功加载后是否发生了变化
+func (r *CertReloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, f := range r.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(r.modTimes[f]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *CertReloader) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				xlog.Errorf("reload tls certificate failed: %v", err)
+				continue
+			}
+			xlog.Infof("tls certificate reloaded from %s", r.config.CertFile)
+		}
+	}
+}
+
+// Stop 停止检测证书文件变化
+func (r *CertReloader) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
@@ -0,0 +1,191 @@
+// Copyright 2022 NetEase Media Technology（Beijing）Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NetEase-Media/easy-ngo/xlog"
+	"github.com/NetEase-Media/easy-ngo/xlog/contrib/xstdout"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	xlog.WithVendor(xstdout.New())
+}
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600))
+	if keyFile == "" {
+		return
+	}
+	der, err := x509.MarshalECPrivateKey(c.key)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+func TestCertReloaderReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	ca := newTestCert(t, "ca", nil)
+	newTestCert(t, "first", ca).write(t, certFile, keyFile)
+
+	r, err := NewCertReloader(&TLSConfig{
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		ReloadInterval: 10 * time.Millisecond,
+	})
+	assert.Nil(t, err)
+	defer r.Stop()
+
+	cert, err := r.getCertificate(nil)
+	assert.Nil(t, err)
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	assert.Equal(t, "first", leaf.Subject.CommonName)
+
+	second := newTestCert(t, "second", ca)
+	second.write(t, certFile, keyFile)
+	// 保证修改时间一定发生变化
+	future := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(certFile, future, future))
+
+	assert.Eventually(t, func() bool {
+		cert, _ := r.getCertificate(nil)
+		leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+		return leaf.Subject.CommonName == "second"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCertReloaderInvalidConfig(t *testing.T) {
+	_, err := NewCertReloader(&TLSConfig{})
+	assert.NotNil(t, err)
+
+	_, err = newBaseTLSConfig(&TLSConfig{MinVersion: "2.0"})
+	assert.NotNil(t, err)
+
+	_, err = newBaseTLSConfig(&TLSConfig{CipherSuites: []string{"TLS_NOT_EXISTS"}})
+	assert.NotNil(t, err)
+
+	c, err := newBaseTLSConfig(&TLSConfig{
+		MinVersion:   "1.3",
+		ClientCAFile: "ca.crt",
+		CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), c.MinVersion)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, c.ClientAuth)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, c.CipherSuites)
+}
+
+func TestServeHttpMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	ca := newTestCert(t, "ca", nil)
+	ca.write(t, caFile, "")
+	newTestCert(t, "server", ca).write(t, certFile, keyFile)
+
+	certs, err := NewCertReloader(&TLSConfig{
+		Enabled:      true,
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: caFile,
+	})
+	assert.Nil(t, err)
+	defer certs.Stop()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	s := NewHttpServer(listener.Addr().String(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}), &HttpConfig{ReadTimeout: time.Second, MaxHeaderBytes: 4096}, certs)
+	assert.Equal(t, time.Second, s.ReadTimeout)
+	go ServeHttp(s, listener)
+	defer s.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	url := "https://" + listener.Addr().String()
+
+	noCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	_, err = noCert.Get(url)
+	assert.NotNil(t, err)
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{
+			RootCAs:      pool,
+			Certificates: []tls.Certificate{newTestCert(t, "client", ca).tlsCertificate()},
+		},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, err := client.Get(url)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "client", string(body))
+	// mTLS同样可以协商h2
+	assert.Equal(t, "h2", resp.TLS.NegotiatedProtocol)
+	assert.Equal(t, 2, resp.ProtoMajor)
+}